@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	infrahost "prism/internal/infra/host"
+	"prism/internal/infra/paths"
+)
+
+// runHostAutoboot is the host-autoboot LaunchDaemon: it bootstraps the user
+// daemons, then runs the metrics and auto-update loops and the optional
+// status endpoint until SIGINT or SIGTERM. SIGHUP makes both loops re-read prism.json without restarting.
+// It exits cleanly when another host-autoboot process is already running.
+func runHostAutoboot() {
+	release, err := infrahost.AcquireAutobootLock(paths.OutputDir())
+	if errors.Is(err, infrahost.ErrAutobootRunning) {
+		log.Printf("[host-autoboot] %v; exiting", err)
+		return
+	}
+	if err != nil {
+		log.Printf("[host-autoboot] %v", err)
+		os.Exit(exitFailure)
+	}
+	defer release()
+
+	// Set up signal handling for graceful shutdown
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sigCh)
+
+	go func() {
+		<-sigCh
+		log.Println("[host-autoboot] received shutdown signal")
+		cancel()
+	}()
+
+	// Bootstrap all user LaunchDaemons (safety net, they should already be running via RunAtLoad)
+	infrahost.RunAutoboot(ctx, paths.StatePath())
+
+	hupCh := make(chan os.Signal, 1)
+	signal.Notify(hupCh, syscall.SIGHUP)
+	defer signal.Stop(hupCh)
+
+	updateReload := make(chan struct{}, 1)
+	metricsReload := make(chan struct{}, 1)
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-hupCh:
+				log.Println("[host-autoboot] received SIGHUP; reloading config")
+				notifyReload(updateReload)
+				notifyReload(metricsReload)
+			}
+		}
+	}()
+
+	// Keep the Prometheus textfile fresh when globals.metrics is configured
+	go infrahost.RunMetricsLoop(ctx, infrahost.MetricsLoopConfig{
+		OutputDir:  paths.OutputDir(),
+		ConfigPath: paths.ConfigPath(),
+		StatePath:  paths.StatePath(),
+		Reload:     metricsReload,
+	})
+
+	// Serve read-only status JSON when globals.status_listen is configured
+	go infrahost.RunStatusServer(ctx, infrahost.StatusServerConfig{
+		OutputDir:  paths.OutputDir(),
+		ConfigPath: paths.ConfigPath(),
+		StatePath:  paths.StatePath(),
+	})
+
+	// Start the auto-update loop (runs forever until context is cancelled)
+	auCfg := infrahost.AutoUpdateConfig{
+		CheckInterval: 1 * time.Hour,
+		OutputDir:     paths.OutputDir(),
+		ConfigPath:    paths.ConfigPath(),
+		StatePath:     paths.StatePath(),
+		Reload:        updateReload,
+	}
+	infrahost.RunAutoUpdateLoop(ctx, auCfg)
+}
+
+// notifyReload queues a reload on ch unless one is already pending.
+func notifyReload(ch chan<- struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
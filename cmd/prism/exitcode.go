@@ -0,0 +1,60 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"log"
+	"net"
+	"os"
+
+	"prism/internal/control/host"
+	"prism/internal/infra/config"
+)
+
+// Exit codes of the non-interactive modes. Scripts branch on these, so the
+// values are part of the CLI contract documented in the README.
+const (
+	exitOK            = 0
+	exitFailure       = 1 // any failure not listed below
+	exitInvalidConfig = 2 // invalid prism.json or command-line arguments
+	exitNeedsSudo     = 3 // missing privileges, usually not run with sudo
+	exitNetwork       = 4 // download or other network failure
+	exitPartial       = 6 // the command finished but failed for some users
+)
+
+var (
+	// errUsage marks invalid command-line arguments.
+	errUsage = errors.New("invalid arguments")
+	// errPartial marks a command that succeeded for some users only.
+	errPartial = errors.New("failed for one or more users")
+)
+
+// exitCode maps an error returned by a mode to its exit code.
+func exitCode(err error) int {
+	var netErr net.Error
+	switch {
+	case err == nil, errors.Is(err, flag.ErrHelp):
+		return exitOK
+	case errors.Is(err, errPartial):
+		return exitPartial
+	case errors.Is(err, host.ErrPermissionDenied):
+		return exitNeedsSudo
+	case errors.Is(err, config.ErrInvalid), errors.Is(err, errUsage):
+		return exitInvalidConfig
+	case errors.Is(err, host.ErrDownloadFailed), errors.As(err, &netErr):
+		return exitNetwork
+	default:
+		return exitFailure
+	}
+}
+
+// exitOnError logs err for mode and exits with its exit code. It returns
+// when err is nil.
+func exitOnError(mode string, err error) {
+	code := exitCode(err)
+	if code == exitOK {
+		return
+	}
+	log.New(os.Stderr, "", log.LstdFlags).Printf("Prism %s failed: %v", mode, err)
+	os.Exit(code)
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"prism/internal/control/host"
+	"prism/internal/infra/paths"
+)
+
+// runFastLoginStatusCommand reports which Prism users the fast-login script
+// actually logged in, from its result log, as a list or as JSON with --json.
+func runFastLoginStatusCommand(args []string) error {
+	fs := flag.NewFlagSet("fast-login-status", flag.ContinueOnError)
+	jsonOut := fs.Bool("json", false, "print the results as JSON")
+	if err := fs.Parse(args); err != nil {
+		return fmt.Errorf("%w: %w", errUsage, err)
+	}
+
+	init := host.NewInitializer(paths.ConfigPath(), paths.StatePath())
+	results, err := init.FastLoginResults(context.Background())
+	if err != nil {
+		return err
+	}
+
+	if *jsonOut {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(results)
+	}
+
+	for _, r := range results {
+		switch {
+		case r.Time.IsZero():
+			fmt.Printf("  ----  %s: no fast-login record\n", r.User)
+		case r.OK:
+			fmt.Printf("  OK    %s: session at %s (attempt %d)\n", r.User, r.Time.Local().Format("2006-01-02 15:04"), r.Attempts)
+		default:
+			fmt.Printf("  FAIL  %s: %s at %s after %d attempts\n", r.User, r.Reason, r.Time.Local().Format("2006-01-02 15:04"), r.Attempts)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"prism/internal/control/host"
+	"prism/internal/infra/paths"
+)
+
+// runFindCommand maps a port, subdomain (or full domain/URL) or friendlyName
+// fragment back to the Prism user it belongs to, as a human-readable list or
+// as JSON with --json.
+func runFindCommand(args []string) error {
+	const usage = "usage: prism find [--json] <port | subdomain | friendlyName>"
+	fs := flag.NewFlagSet("find", flag.ContinueOnError)
+	jsonOut := fs.Bool("json", false, "print the matching users as JSON")
+	if err := fs.Parse(args); err != nil {
+		return fmt.Errorf("%w: %w", errUsage, err)
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("%w: %s", errUsage, usage)
+	}
+	query := fs.Arg(0)
+
+	init := host.NewInitializer(paths.ConfigPath(), paths.StatePath())
+	matches, err := init.FindUsers(context.Background(), query)
+	if err != nil {
+		return err
+	}
+
+	if *jsonOut {
+		if matches == nil {
+			matches = []host.UserMatch{}
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(matches); err != nil {
+			return err
+		}
+	} else {
+		for _, m := range matches {
+			line := fmt.Sprintf("%s  port %d  subdomain %s", m.User.Name, m.User.Port, m.User.Subdomain)
+			if m.FriendlyName != "" {
+				line += fmt.Sprintf("  friendlyName %q", m.FriendlyName)
+			}
+			fmt.Printf("%s  (matched by %s)\n", line, strings.ReplaceAll(m.MatchedBy, "_", " "))
+		}
+	}
+	if len(matches) == 0 {
+		return errors.New("no Prism user matches " + query)
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strings"
+
+	"prism/internal/control/host"
+	"prism/internal/infra/paths"
+	"prism/internal/infra/state"
+)
+
+// runLabelCommand sets (key=value) or removes (key-) labels on one Prism user
+// and prints the resulting labels.
+func runLabelCommand(args []string) error {
+	const usage = "usage: prism label --user NAME key=value... [key-...]"
+	fs := flag.NewFlagSet("label", flag.ContinueOnError)
+	user := fs.String("user", "", "the Prism user to label")
+	if err := fs.Parse(args); err != nil {
+		return fmt.Errorf("%w: %w", errUsage, err)
+	}
+	if strings.TrimSpace(*user) == "" || fs.NArg() == 0 {
+		return fmt.Errorf("%w: %s", errUsage, usage)
+	}
+
+	set := make(map[string]string)
+	var remove []string
+	for _, arg := range fs.Args() {
+		if key, value, ok := strings.Cut(arg, "="); ok {
+			set[key] = value
+			continue
+		}
+		if key, ok := strings.CutSuffix(arg, "-"); ok {
+			remove = append(remove, key)
+			continue
+		}
+		return fmt.Errorf("%w: %q is neither key=value nor key-; %s", errUsage, arg, usage)
+	}
+
+	init := host.NewInitializer(paths.ConfigPath(), paths.StatePath())
+	u, err := init.SetUserLabels(context.Background(), *user, set, remove)
+	if err != nil {
+		return err
+	}
+	if labels := state.FormatLabels(u.Labels); labels != "" {
+		fmt.Printf("%s: %s\n", u.Name, labels)
+	} else {
+		fmt.Printf("%s: no labels\n", u.Name)
+	}
+	return nil
+}
@@ -1,66 +1,181 @@
 package main
 
 import (
-	"context"
+	"cmp"
+	"errors"
+	"fmt"
 	"log"
 	"os"
-	"os/signal"
-	"syscall"
-	"time"
+	"strings"
 
 	tea "github.com/charmbracelet/bubbletea"
 
 	"prism/internal/infra/env"
-	infrahost "prism/internal/infra/host"
 	"prism/internal/infra/paths"
 	"prism/internal/ui/root"
 	userui "prism/internal/ui/user"
 )
 
-// main is the Prism entrypoint. It supports three modes:
+// main is the Prism entrypoint. It supports these modes:
 // 1) "host-autoboot" for the LaunchDaemon-managed headless host daemon.
-// 2) "user" for the interactive TUI for a single local user.
-// 3) default host-side root TUI for initializing the host and managing Prism users.
+// 2) "user" for the interactive TUI for a single local user ("user prewarm"
+// runs the permission prewarm non-interactively, "user refresh-name"
+// re-detects the frpc friendly name, "user status" prints the service state).
+// 3) "users" for printing the Prism user inventory (optionally as JSON).
+// 4) "prewarm-users" for prewarming permissions of every Prism user.
+// 5) "selftest" for validating the host end to end with a throwaway user.
+// 6) "report" for exporting every user's public URL as CSV or JSON.
+// 7) "plan" for previewing the users the next setup or add-users run creates.
+// 8) "validate-config" for checking prism.json without side effects.
+// 9) "update-code" for updating every user's service code (optionally without
+// restarting), and "restart-users" (or "restart") for restarting their
+// services, optionally only the unhealthy ones with --unhealthy.
+// 10) "release-diff" for comparing the deployed bundle with the latest release.
+// 11) "metrics" for writing the Prometheus textfile gauges once.
+// 12) "repair-users" for retrying LaunchDaemon bootstrap of half-provisioned
+// users.
+// 13) "open-session" for opening one user's Screen Sharing session, and
+// "fast-login-status" for reporting which users fast login logged in.
+// 14) "maintenance" for stopping every user's daemons across reboots and
+// resuming them.
+// 15) "label" for tagging a user with key=value labels shown in the
+// inventory.
+// 16) "find" for mapping a port, subdomain or friendlyName back to its user.
+// 17) "reseed-version" for recording the latest release as the deployed
+// version when the version file was lost.
+// 18) default host-side root TUI for initializing the host and managing Prism users.
+//
+// The global --config and --state flags go before the mode and take
+// precedence over PRISM_CONFIG and PRISM_STATE in every mode.
+// Likewise --quiet makes the TUI modes print plain output instead ("users" for
+// the root TUI, "user status" for the user TUI), as they do automatically when
+// stdout is not a terminal; see usageText.
+// Non-interactive modes exit with the codes defined in exitcode.go.
 func main() {
 	env.Load()
 
+	args, flags, err := extractGlobalFlags(os.Args[1:])
+	if err != nil {
+		log.New(os.Stderr, "", log.LstdFlags).Printf("Prism: %v", err)
+		os.Exit(exitInvalidConfig)
+	}
+	paths.SetOverrides(flags.configPath, flags.statePath)
+
 	mode := ""
-	if len(os.Args) > 1 {
-		mode = os.Args[1]
+	if len(args) > 0 {
+		mode = args[0]
 	}
 
 	switch mode {
+	case "-h", "-help", "--help", "help":
+		fmt.Print(usageText)
+		return
+
 	case "host-autoboot":
-		// Bootstrap all user LaunchDaemons (safety net, they should already be running via RunAtLoad)
-		infrahost.RunAutoboot(paths.StatePath())
-
-		// Set up signal handling for graceful shutdown
-		ctx, cancel := context.WithCancel(context.Background())
-		defer cancel()
-
-		sigCh := make(chan os.Signal, 1)
-		signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
-
-		go func() {
-			<-sigCh
-			log.Println("[host-autoboot] received shutdown signal")
-			cancel()
-		}()
-
-		// Start the auto-update loop (runs forever until context is cancelled)
-		auCfg := infrahost.AutoUpdateConfig{
-			CheckInterval: 1 * time.Hour,
-			OutputDir:     paths.OutputDir(),
-			ConfigPath:    paths.ConfigPath(),
-			StatePath:     paths.StatePath(),
+		// host-autoboot has no flags of its own. LaunchDaemons written by
+		// earlier versions pass --config and --state after the mode.
+		if len(args) > 1 {
+			legacy, legacyFlags, err := extractGlobalFlags(args[1:])
+			if err != nil || len(legacy) > 0 {
+				log.New(os.Stderr, "", log.LstdFlags).Printf("Prism host-autoboot: unexpected arguments %q", args[1:])
+				os.Exit(exitInvalidConfig)
+			}
+			paths.SetOverrides(cmp.Or(legacyFlags.configPath, flags.configPath), cmp.Or(legacyFlags.statePath, flags.statePath))
 		}
-		infrahost.RunAutoUpdateLoop(ctx, auCfg)
+		runHostAutoboot()
+		return
+
+	case "users":
+		exitOnError("users", runUsersCommand(args[1:]))
+		return
+
+	case "plan":
+		exitOnError("plan", runPlanCommand(args[1:]))
+		return
+
+	case "report":
+		exitOnError("report", runReportCommand(args[1:]))
+		return
+
+	case "validate-config":
+		exitOnError("validate-config", runValidateConfigCommand())
+		return
+
+	case "update-code":
+		exitOnError("update-code", runUpdateCodeCommand(args[1:]))
+		return
+
+	case "restart-users", "restart":
+		exitOnError(mode, runRestartUsersCommand(args[1:]))
+		return
+
+	case "release-diff":
+		exitOnError("release-diff", runReleaseDiffCommand(args[1:]))
+		return
+
+	case "metrics":
+		exitOnError("metrics", runMetricsCommand(args[1:]))
+		return
+
+	case "repair-users":
+		exitOnError("repair-users", runRepairUsersCommand(args[1:]))
+		return
+
+	case "open-session":
+		exitOnError("open-session", runOpenSessionCommand(args[1:]))
+		return
+
+	case "fast-login-status":
+		exitOnError("fast-login-status", runFastLoginStatusCommand(args[1:]))
+		return
+
+	case "maintenance":
+		exitOnError("maintenance", runMaintenanceCommand(args[1:]))
+		return
+
+	case "label":
+		exitOnError("label", runLabelCommand(args[1:]))
+		return
+
+	case "find":
+		exitOnError("find", runFindCommand(args[1:]))
+		return
+
+	case "reseed-version":
+		exitOnError("reseed-version", runReseedVersionCommand(args[1:]))
+		return
+
+	case "prewarm-users":
+		exitOnError("prewarm-users", runPrewarmUsersCommand())
+		return
 
-		// Stop signal notification to avoid race with defer cancel()
-		signal.Stop(sigCh)
+	case "selftest":
+		exitOnError("selftest", runSelfTestCommand())
 		return
 
 	case "user":
+		if len(args) > 1 && args[1] == "prewarm" {
+			if err := runUserPrewarmCommand(); err != nil {
+				os.Exit(exitCode(err))
+			}
+			return
+		}
+		if len(args) > 1 && args[1] == "refresh-name" {
+			exitOnError("user refresh-name", runUserRefreshNameCommand(args[2:]))
+			return
+		}
+		if len(args) > 1 && args[1] == "status" {
+			exitOnError("user status", runUserStatusCommand(args[2:]))
+			return
+		}
+		if plainOutput(flags) {
+			if !flags.quiet {
+				plainOutputNote("the user status")
+			}
+			exitOnError("user status", runUserStatusCommand(nil))
+			return
+		}
+
 		model := userui.New()
 		p := tea.NewProgram(model)
 
@@ -72,6 +187,14 @@ func main() {
 		return
 
 	default:
+		if plainOutput(flags) {
+			if !flags.quiet {
+				plainOutputNote("the user inventory")
+			}
+			exitOnError("users", runUsersCommand(nil))
+			return
+		}
+
 		model := root.New()
 		p := tea.NewProgram(model)
 
@@ -83,3 +206,75 @@ func main() {
 		return
 	}
 }
+
+// usageText is printed by "prism --help".
+const usageText = `usage: prism [--config path] [--state path] [--quiet] [mode [args]]
+
+Without a mode prism starts the host TUI; "prism user" starts the user TUI.
+Run "prism <mode> -h" for the flags of a non-interactive mode, e.g. users,
+plan, report, validate-config, update-code, restart-users, selftest.
+
+Global flags go before the mode:
+  --config path  prism.json to use (overrides PRISM_CONFIG)
+  --state path   state.json to use (overrides PRISM_STATE)
+  --quiet        print plain output instead of starting a TUI
+
+The TUI modes print plain output instead of the TUI ("users" for prism,
+"user status" for prism user) when --quiet is given; otherwise when stdout or
+stdin is not a terminal or TERM is "dumb". There is no flag to force the TUI.
+`
+
+// globalFlags are the flags accepted before the mode.
+type globalFlags struct {
+	configPath string
+	statePath  string
+	quiet      bool
+}
+
+// extractGlobalFlags parses the global flags in front of the mode: --config
+// and --state (in "--flag value" or "--flag=value" form) and --quiet, each
+// with one or two dashes. It stops at the first other
+// argument and returns it with everything after it, so a flag of the same
+// name after the mode belongs to that mode. A "--" ends the global flags and
+// is dropped.
+func extractGlobalFlags(args []string) (rest []string, flags globalFlags, err error) {
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			rest = args[i+1:]
+			break
+		}
+		if !strings.HasPrefix(arg, "-") {
+			rest = args[i:]
+			break
+		}
+		name, value, hasValue := strings.Cut(strings.TrimPrefix(strings.TrimPrefix(arg, "-"), "-"), "=")
+		if name == "quiet" {
+			if hasValue {
+				return nil, globalFlags{}, errors.New("flag --quiet takes no value")
+			}
+			flags.quiet = true
+			continue
+		}
+		if name != "config" && name != "state" {
+			rest = args[i:]
+			break
+		}
+		if !hasValue {
+			if i+1 >= len(args) {
+				return nil, globalFlags{}, fmt.Errorf("flag --%s needs a value", name)
+			}
+			i++
+			value = args[i]
+		}
+		if strings.TrimSpace(value) == "" {
+			return nil, globalFlags{}, fmt.Errorf("flag --%s needs a non-empty value", name)
+		}
+		if name == "config" {
+			flags.configPath = value
+		} else {
+			flags.statePath = value
+		}
+	}
+	return rest, flags, nil
+}
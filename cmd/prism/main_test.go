@@ -0,0 +1,54 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestExtractGlobalFlags(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    string
+		rest    string
+		flags   globalFlags
+		wantErr string
+	}{
+		{name: "no args"},
+		{name: "mode only", args: "users --json", rest: "users --json"},
+		{
+			name:  "flags before the mode",
+			args:  "--config /tmp/p.json -state=/tmp/s.json --quiet users --json",
+			rest:  "users --json",
+			flags: globalFlags{configPath: "/tmp/p.json", statePath: "/tmp/s.json", quiet: true},
+		},
+		{
+			name:  "same names after the mode belong to it",
+			args:  "--state /tmp/s.json user refresh-name --config x --quiet",
+			rest:  "user refresh-name --config x --quiet",
+			flags: globalFlags{statePath: "/tmp/s.json"},
+		},
+		{name: "unknown flag ends global flags", args: "-h --config x", rest: "-h --config x"},
+		{name: "double dash", args: "--quiet -- --config x", rest: "--config x", flags: globalFlags{quiet: true}},
+		{name: "verbose is not global", args: "--verbose users", rest: "--verbose users"},
+		{name: "missing value", args: "--config", wantErr: "needs a value"},
+		{name: "empty value", args: "--state=", wantErr: "needs a non-empty value"},
+		{name: "value on switch", args: "--quiet=yes users", wantErr: "takes no value"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rest, flags, err := extractGlobalFlags(strings.Fields(tt.args))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("extractGlobalFlags(%q) error = %v, want %q", tt.args, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("extractGlobalFlags(%q) error = %v", tt.args, err)
+			}
+			if strings.Join(rest, " ") != tt.rest || flags != tt.flags {
+				t.Errorf("extractGlobalFlags(%q) = %q, %+v; want %q, %+v", tt.args, rest, flags, tt.rest, tt.flags)
+			}
+		})
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	"prism/internal/control/host"
+	"prism/internal/infra/paths"
+)
+
+// runMaintenanceCommand enters or leaves maintenance mode, in which every
+// user's daemons are stopped and stay stopped across reboots, or reports the
+// current mode when no action is given.
+func runMaintenanceCommand(args []string) error {
+	const usage = "usage: prism maintenance [status | enter [--reason text] | exit]"
+	action := "status"
+	if len(args) > 0 {
+		action, args = args[0], args[1:]
+	}
+
+	init := host.NewInitializer(paths.ConfigPath(), paths.StatePath())
+	switch action {
+	case "status":
+		if len(args) > 0 {
+			return fmt.Errorf("%w: %s", errUsage, usage)
+		}
+		info, err := init.Maintenance(context.Background())
+		if err != nil {
+			return err
+		}
+		fmt.Println(info)
+		return nil
+
+	case "enter":
+		fs := flag.NewFlagSet("maintenance enter", flag.ContinueOnError)
+		reason := fs.String("reason", "", "why the host is in maintenance, shown in status")
+		if err := fs.Parse(args); err != nil {
+			return fmt.Errorf("%w: %w", errUsage, err)
+		}
+		if fs.NArg() > 0 {
+			return fmt.Errorf("%w: %s", errUsage, usage)
+		}
+		if err := init.EnterMaintenance(context.Background(), *reason); err != nil {
+			return err
+		}
+		fmt.Println("Entered maintenance: every user's daemons are stopped and stay stopped across reboots.")
+		fmt.Println("Resume with: sudo ./prism maintenance exit")
+		return nil
+
+	case "exit":
+		if len(args) > 0 {
+			return fmt.Errorf("%w: %s", errUsage, usage)
+		}
+		if err := init.ExitMaintenance(context.Background()); err != nil {
+			return err
+		}
+		fmt.Println("Left maintenance: every user's daemons were started again.")
+		return nil
+
+	default:
+		return fmt.Errorf("%w: %s", errUsage, usage)
+	}
+}
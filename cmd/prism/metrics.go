@@ -0,0 +1,28 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	"prism/internal/control/host"
+	"prism/internal/infra/paths"
+)
+
+// runMetricsCommand writes the Prometheus textfile gauges once, to --output or
+// globals.metrics.textfile_path. host-autoboot does the same on an interval.
+func runMetricsCommand(args []string) error {
+	fs := flag.NewFlagSet("metrics", flag.ContinueOnError)
+	output := fs.String("output", "", "write the .prom file here instead of globals.metrics.textfile_path")
+	if err := fs.Parse(args); err != nil {
+		return fmt.Errorf("%w: %w", errUsage, err)
+	}
+
+	init := host.NewInitializer(paths.ConfigPath(), paths.StatePath())
+	path, err := init.WriteMetrics(context.Background(), *output)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Wrote metrics to %s\n", path)
+	return nil
+}
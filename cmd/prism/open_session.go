@@ -0,0 +1,23 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"prism/internal/control/host"
+	"prism/internal/infra/paths"
+)
+
+// runOpenSessionCommand opens the Screen Sharing session of one Prism user
+// through the admin's Fast Login tunnel.
+func runOpenSessionCommand(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("%w: usage: prism open-session <user>", errUsage)
+	}
+	init := host.NewInitializer(paths.ConfigPath(), paths.StatePath())
+	if err := init.OpenUserSession(context.Background(), args[0]); err != nil {
+		return err
+	}
+	fmt.Printf("Opened Screen Sharing session for %s.\n", args[0])
+	return nil
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// plainOutput reports whether the interactive modes should print plain lines
+// instead of starting the TUI: always with --quiet, and otherwise when stdout
+// or stdin is not a terminal or TERM is "dumb", as over a non-interactive SSH
+// session or when piped into a log.
+func plainOutput(flags globalFlags) bool {
+	if flags.quiet {
+		return true
+	}
+	return !isTerminal(os.Stdout) || !isTerminal(os.Stdin) || os.Getenv("TERM") == "dumb"
+}
+
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// plainOutputNote tells a user who expected the TUI why they got plain output.
+func plainOutputNote(fallback string) {
+	fmt.Fprintf(os.Stderr, "Prism: no interactive terminal, printing %s instead of the TUI (run it from an interactive terminal for the TUI).\n", fallback)
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"prism/internal/control/host"
+	"prism/internal/infra/paths"
+)
+
+// runPlanCommand prints the users that the next setup or add-users run would
+// create, without touching the host.
+func runPlanCommand(args []string) error {
+	fs := flag.NewFlagSet("plan", flag.ContinueOnError)
+	count := fs.Int("users", 0, "number of users to plan")
+	jsonOut := fs.Bool("json", false, "print the plan as JSON")
+	if err := fs.Parse(args); err != nil {
+		return fmt.Errorf("%w: %w", errUsage, err)
+	}
+	if *count <= 0 {
+		return fmt.Errorf("%w: --users must be a positive number", errUsage)
+	}
+
+	init := host.NewInitializer(paths.ConfigPath(), paths.StatePath())
+	plan, summary, err := init.PlanUsersSummary(context.Background(), *count)
+	if err != nil {
+		return err
+	}
+
+	if *jsonOut {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(plan)
+	}
+
+	conflicts := 0
+	fmt.Printf("Plan for %d Prism users named %s (subdomains are generated at creation):\n", summary.Count, summary.UsernameTemplate)
+	if summary.Count > 0 {
+		fmt.Printf("  ports %d-%d, URLs %s://<random>.%s\n", summary.FirstPort, summary.LastPort, summary.Scheme, summary.DomainSuffix)
+	}
+	for _, p := range plan {
+		line := fmt.Sprintf("  %s  port %d", p.Name, p.Port)
+		if p.UID > 0 {
+			line += fmt.Sprintf("  uid %d", p.UID)
+		}
+		if p.Conflict != "" {
+			line += "  CONFLICT: " + p.Conflict
+			conflicts++
+		}
+		fmt.Println(line)
+	}
+	if conflicts > 0 {
+		return fmt.Errorf("%d planned users have conflicts", conflicts)
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"prism/internal/control/host"
+	infrahost "prism/internal/infra/host"
+	"prism/internal/infra/paths"
+	userinfra "prism/internal/infra/user"
+)
+
+// runUserPrewarmCommand runs the permission prewarm non-interactively for the
+// current user. It is invoked by the host via `prism-host user prewarm`.
+func runUserPrewarmCommand() error {
+	status := userinfra.PrewarmPermissions()
+	fmt.Println(status)
+	if strings.HasPrefix(status, "Permission prewarm failed") {
+		return fmt.Errorf("prewarm failed")
+	}
+	return nil
+}
+
+// runPrewarmUsersCommand prewarms permissions for every Prism user from the
+// host side and prints per-user results.
+func runPrewarmUsersCommand() error {
+	init := host.NewInitializer(paths.ConfigPath(), paths.StatePath())
+	results, err := init.PrewarmAllUsers(context.Background())
+	if err != nil {
+		return err
+	}
+
+	fmt.Print(infrahost.FormatPrewarmResults(results))
+	for _, r := range results {
+		if !r.OK {
+			return fmt.Errorf("prewarm: %w", errPartial)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"time"
+
+	userinfra "prism/internal/infra/user"
+)
+
+// runUserRefreshNameCommand re-detects the current user's iMessage identity
+// and updates the frpc friendlyName when it changed. The keepalive agent runs
+// it with --if-due on every cycle.
+func runUserRefreshNameCommand(args []string) error {
+	fs := flag.NewFlagSet("user refresh-name", flag.ContinueOnError)
+	ifDue := fs.Bool("if-due", false, "only refresh when globals.frpc.friendly_name_refresh_minutes has elapsed")
+	if err := fs.Parse(args); err != nil {
+		return fmt.Errorf("%w: %w", errUsage, err)
+	}
+
+	line, err := userinfra.RefreshFriendlyName(*ifDue)
+	if err != nil {
+		return fmt.Errorf("refresh friendly name: %w", err)
+	}
+	if line != "" {
+		fmt.Printf("[%s] %s\n", time.Now().Format("2006-01-02 15:04:05"), line)
+	}
+	return nil
+}
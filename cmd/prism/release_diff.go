@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"prism/internal/control/host"
+	"prism/internal/infra/paths"
+)
+
+// runReleaseDiffCommand prints the version delta between the deployed service
+// bundle and the latest release, with the release notes, so an operator can
+// review an update before running update-code. --json prints it as JSON.
+func runReleaseDiffCommand(args []string) error {
+	fs := flag.NewFlagSet("release-diff", flag.ContinueOnError)
+	jsonOut := fs.Bool("json", false, "print the comparison as JSON")
+	if err := fs.Parse(args); err != nil {
+		return fmt.Errorf("%w: %w", errUsage, err)
+	}
+
+	init := host.NewInitializer(paths.ConfigPath(), paths.StatePath())
+	p, err := init.PreviewUpdate(context.Background())
+	if err != nil {
+		return err
+	}
+
+	if *jsonOut {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(p)
+	}
+
+	fmt.Println(p.Summary())
+	if !p.UpdateAvailable() {
+		return nil
+	}
+	title := p.ReleaseName
+	if title == "" {
+		title = p.Latest
+	}
+	fmt.Printf("\n%s", title)
+	if p.PublishedAt != "" {
+		fmt.Printf(" (published %s)", p.PublishedAt)
+	}
+	fmt.Println()
+	if p.URL != "" {
+		fmt.Println(p.URL)
+	}
+	if p.Notes == "" {
+		fmt.Println("\nNo release notes.")
+	} else {
+		fmt.Printf("\n%s\n", p.Notes)
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"prism/internal/control/host"
+	"prism/internal/infra/paths"
+)
+
+// runRepairUsersCommand retries bootstrapping the LaunchDaemons of the given
+// Prism users, or of every user whose bootstrap failed during provisioning
+// when none are given. Accounts and files are left as they are.
+func runRepairUsersCommand(args []string) error {
+	init := host.NewInitializer(paths.ConfigPath(), paths.StatePath())
+	ctx := context.Background()
+
+	names := args
+	if len(names) == 0 {
+		inv, err := init.Inventory(ctx)
+		if err != nil {
+			return err
+		}
+		for _, u := range inv.Users {
+			if u.DaemonsPending {
+				names = append(names, u.Name)
+			}
+		}
+		if len(names) == 0 {
+			fmt.Println("No Prism users need repair.")
+			return nil
+		}
+	}
+
+	var errs []error
+	for _, name := range names {
+		if _, err := init.RepairUser(ctx, name); err != nil {
+			if errors.Is(err, host.ErrMaintenance) {
+				return err
+			}
+			fmt.Printf("  FAIL  %s: %v\n", name, err)
+			errs = append(errs, err)
+			continue
+		}
+		fmt.Printf("  OK    %s\n", name)
+	}
+	switch {
+	case len(errs) == len(names):
+		return errors.Join(errs...)
+	case len(errs) > 0:
+		return fmt.Errorf("%w: %d of %d users not repaired", errPartial, len(errs), len(names))
+	}
+	return nil
+}
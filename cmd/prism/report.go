@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"prism/internal/control/host"
+	infrahost "prism/internal/infra/host"
+	"prism/internal/infra/paths"
+)
+
+// runReportCommand exports every user's public URL details as CSV (default)
+// or JSON, to stdout or to the file given with --output.
+func runReportCommand(args []string) error {
+	fs := flag.NewFlagSet("report", flag.ContinueOnError)
+	format := fs.String("format", "csv", "report format: csv or json")
+	output := fs.String("output", "", "write the report to this file instead of stdout")
+	if err := fs.Parse(args); err != nil {
+		return fmt.Errorf("%w: %w", errUsage, err)
+	}
+	if *format != "csv" && *format != "json" {
+		return fmt.Errorf("%w: unknown format %q (want csv or json)", errUsage, *format)
+	}
+
+	init := host.NewInitializer(paths.ConfigPath(), paths.StatePath())
+	rows, err := init.Report(context.Background())
+	if err != nil {
+		return err
+	}
+
+	out := os.Stdout
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			return fmt.Errorf("create %s: %w", *output, err)
+		}
+		defer func() { _ = f.Close() }()
+		out = f
+	}
+
+	if *format == "json" {
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(rows)
+	}
+	return infrahost.WriteUserReportCSV(out, rows)
+}
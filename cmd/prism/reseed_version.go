@@ -0,0 +1,25 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"prism/internal/control/host"
+	"prism/internal/infra/paths"
+)
+
+// runReseedVersionCommand records the latest release as the deployed bundle
+// version, e.g. after output/cache/current_version.txt was deleted and
+// auto-update stopped.
+func runReseedVersionCommand(args []string) error {
+	if len(args) != 0 {
+		return fmt.Errorf("%w: usage: prism reseed-version", errUsage)
+	}
+	init := host.NewInitializer(paths.ConfigPath(), paths.StatePath())
+	tag, err := init.ReseedVersion(context.Background())
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Recorded %s as the deployed version; auto-update compares new releases against it.\n", tag)
+	return nil
+}
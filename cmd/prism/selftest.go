@@ -0,0 +1,29 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"prism/internal/control/host"
+	infrahost "prism/internal/infra/host"
+	"prism/internal/infra/paths"
+)
+
+// runSelfTestCommand provisions and removes a throwaway user to validate the
+// host end to end, printing per-step results.
+func runSelfTestCommand() error {
+	init := host.NewInitializer(paths.ConfigPath(), paths.StatePath())
+	prismPath, _ := os.Executable()
+	res, err := init.SelfTest(context.Background(), prismPath)
+	if err != nil {
+		return err
+	}
+
+	fmt.Print(infrahost.FormatSelfTestResult(res))
+	if !res.Passed() {
+		return errors.New("selftest failed")
+	}
+	return nil
+}
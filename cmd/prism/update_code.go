@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"prism/internal/control/host"
+	"prism/internal/infra/paths"
+)
+
+// runUpdateCodeCommand rolls the latest service bundle out to every Prism user
+// (or those given with --users), like "Update user code" in the TUI, and
+// prints each user's outcome. With --skip-restart the new code is staged and
+// the users left running the old code are listed.
+func runUpdateCodeCommand(args []string) error {
+	fs := flag.NewFlagSet("update-code", flag.ContinueOnError)
+	skipRestart := fs.Bool("skip-restart", false, "stage the new code without restarting running services")
+	refreshWrapper := fs.Bool("refresh-wrapper", false, "also refresh each user's prism-host copy from this binary")
+	users := fs.String("users", "", "comma-separated users to update instead of all users")
+	if err := fs.Parse(args); err != nil {
+		return fmt.Errorf("%w: %w", errUsage, err)
+	}
+
+	opts := host.UpdateOptions{SkipRestart: *skipRestart}
+	for _, name := range strings.Split(*users, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			opts.Users = append(opts.Users, name)
+		}
+	}
+	if *refreshWrapper {
+		prismPath, err := os.Executable()
+		if err != nil {
+			return fmt.Errorf("locate prism binary: %w", err)
+		}
+		opts.PrismPath = prismPath
+	}
+
+	init := host.NewInitializer(paths.ConfigPath(), paths.StatePath())
+	res, err := init.UpdateUserCode(context.Background(), opts)
+	if err != nil && !errors.Is(err, host.ErrPartialUpdate) {
+		return err
+	}
+
+	names := make([]string, 0, len(res.UpdateResults))
+	for name := range res.UpdateResults {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if uerr := res.UpdateResults[name]; uerr != nil {
+			fmt.Printf("  FAIL  %s: %v\n", name, uerr)
+		} else {
+			fmt.Printf("  OK    %s\n", name)
+		}
+	}
+	if len(res.NotRestarted) > 0 {
+		fmt.Printf("Not restarted, still running the previous code: %s\n", strings.Join(res.NotRestarted, ", "))
+		fmt.Println("Restart them with: sudo ./prism restart-users [user...]")
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %w", errPartial, err)
+	}
+	return nil
+}
+
+// runRestartUsersCommand restarts the services of the given Prism users, or of
+// every user when none are given. With --unhealthy only the users failing
+// their service check are restarted.
+func runRestartUsersCommand(args []string) error {
+	fs := flag.NewFlagSet("restart-users", flag.ContinueOnError)
+	unhealthy := fs.Bool("unhealthy", false, "restart only users whose service check is not healthy")
+	if err := fs.Parse(args); err != nil {
+		return fmt.Errorf("%w: %w", errUsage, err)
+	}
+	args = fs.Args()
+
+	init := host.NewInitializer(paths.ConfigPath(), paths.StatePath())
+	if *unhealthy {
+		if len(args) > 0 {
+			return fmt.Errorf("%w: --unhealthy does not take user names", errUsage)
+		}
+		return restartUnhealthyUsers(init)
+	}
+	if err := init.RestartUsers(context.Background(), args); err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		fmt.Println("Restarted the services of every Prism user.")
+	} else {
+		fmt.Printf("Restarted the services of %s.\n", strings.Join(args, ", "))
+	}
+	return nil
+}
+
+// restartUnhealthyUsers restarts the unhealthy users and prints each one's
+// outcome.
+func restartUnhealthyUsers(init *host.Initializer) error {
+	res, err := init.RestartUnhealthyUsers(context.Background())
+	if res.Restarted == nil {
+		return err
+	}
+
+	if len(res.Restarted) == 0 {
+		fmt.Printf("All %d Prism users are healthy; nothing restarted.\n", len(res.Healthy))
+		return nil
+	}
+	names := make([]string, 0, len(res.Restarted))
+	for name := range res.Restarted {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	ok := 0
+	for _, name := range names {
+		if rerr := res.Restarted[name]; rerr != nil {
+			fmt.Printf("  FAIL  %s: %v\n", name, rerr)
+		} else {
+			fmt.Printf("  OK    %s\n", name)
+			ok++
+		}
+	}
+	fmt.Printf("Restarted %d of %d unhealthy users; left %d healthy users running.\n", ok, len(res.Restarted), len(res.Healthy))
+	if err != nil {
+		return fmt.Errorf("%w: %w", errPartial, err)
+	}
+	return nil
+}
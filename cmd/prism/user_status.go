@@ -0,0 +1,41 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	userinfra "prism/internal/infra/user"
+)
+
+// runUserStatusCommand prints the current user's keepalive state and the
+// tail of the server and frpc error logs. It is what "user" prints when the
+// TUI cannot be used.
+func runUserStatusCommand(args []string) error {
+	fs := flag.NewFlagSet("user status", flag.ContinueOnError)
+	if err := fs.Parse(args); err != nil {
+		return fmt.Errorf("%w: %w", errUsage, err)
+	}
+
+	fmt.Println(userinfra.KeepaliveStatus())
+	tails := userinfra.RecentErrors()
+	fmt.Println(userinfra.RecentErrorsStatus(tails))
+	for _, t := range tails {
+		fmt.Printf("\n%s (%s)\n", t.Name, t.Path)
+		switch {
+		case t.Err != "":
+			fmt.Printf("  could not read the log: %s\n", t.Err)
+		case t.Missing:
+			fmt.Println("  (no log yet)")
+		case len(t.Lines) == 0:
+			fmt.Println("  (empty)")
+		}
+		for _, l := range t.Lines {
+			mark := " "
+			if l.Error {
+				mark = "!"
+			}
+			fmt.Printf("%s %s\n", mark, l.Text)
+		}
+	}
+	return nil
+}
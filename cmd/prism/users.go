@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"prism/internal/control/host"
+	"prism/internal/infra/paths"
+	"prism/internal/infra/state"
+)
+
+// runUsersCommand prints the Prism user inventory, either as a human-readable
+// list or as pretty JSON when --json is given.
+func runUsersCommand(args []string) error {
+	fs := flag.NewFlagSet("users", flag.ContinueOnError)
+	jsonOut := fs.Bool("json", false, "print the user inventory as JSON")
+	if err := fs.Parse(args); err != nil {
+		return fmt.Errorf("%w: %w", errUsage, err)
+	}
+
+	init := host.NewInitializer(paths.ConfigPath(), paths.StatePath())
+	inv, err := init.Inventory(context.Background())
+	if err != nil {
+		return err
+	}
+
+	if *jsonOut {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(inv)
+	}
+
+	if inv.SecretsPath != "" {
+		fmt.Printf("%d Prism users (passwords: %s)\n", len(inv.Users), inv.SecretsPath)
+	} else {
+		fmt.Printf("%d Prism users (passwords not stored)\n", len(inv.Users))
+	}
+	for _, u := range inv.Users {
+		fmt.Printf("  %s  port %d  %s", u.Name, u.Port, u.URL)
+		if labels := state.FormatLabels(u.Labels); labels != "" {
+			fmt.Printf("  [%s]", labels)
+		}
+		if u.DaemonsPending {
+			fmt.Print("  (daemons not bootstrapped; run repair-users)")
+		}
+		fmt.Println()
+	}
+	return nil
+}
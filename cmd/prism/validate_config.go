@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+
+	"prism/internal/infra/config"
+	"prism/internal/infra/paths"
+)
+
+// runValidateConfigCommand loads and validates prism.json without touching
+// the host, then prints a summary of the key fields and any warnings.
+func runValidateConfigCommand() error {
+	path := paths.ConfigPath()
+	cfg, err := config.Load(path)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	g := cfg.Globals
+	first, last := g.Service.UserPortRange()
+	fmt.Printf("OK: %s\n", path)
+	fmt.Printf("  machine_id     %s\n", g.MachineID)
+	fmt.Printf("  domain_suffix  %s\n", g.DomainSuffix)
+	fmt.Printf("  frpc           %s:%d\n", g.FRPC.ServerAddr, g.FRPC.ServerPort)
+	fmt.Printf("  nexus          %s\n", g.Nexus.BaseURL)
+	fmt.Printf("  archive_url    %s\n", g.Service.ArchiveURL)
+	fmt.Printf("  user ports     %d-%d\n", first, last)
+	for _, d := range g.Services {
+		fmt.Printf("  service %-6s port %d\n", d.Name, d.StartPort)
+	}
+
+	for _, w := range cfg.Warnings() {
+		fmt.Printf("warning: %s\n", w)
+	}
+	return nil
+}
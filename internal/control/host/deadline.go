@@ -0,0 +1,48 @@
+package host
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"prism/internal/infra/config"
+	infrahost "prism/internal/infra/host"
+)
+
+// runDeadline bounds one provisioning run by
+// globals.service.provision_timeout_minutes and tracks its current step.
+type runDeadline struct {
+	timeout time.Duration
+	steps   *infrahost.StepRecorder
+	cancel  context.CancelFunc
+}
+
+// startDeadline returns a context carrying the run's deadline (if configured)
+// and step recorder. Callers must call stop when the run ends.
+func startDeadline(ctx context.Context, cfg config.Config) (context.Context, *runDeadline) {
+	d := &runDeadline{timeout: cfg.Globals.Service.ProvisionTimeout(), cancel: func() {}}
+	if d.timeout > 0 {
+		ctx, d.cancel = context.WithTimeout(ctx, d.timeout)
+	}
+	ctx, d.steps = infrahost.WithStepRecorder(ctx)
+	return ctx, d
+}
+
+// step records a step that the control layer itself runs.
+func (d *runDeadline) step(name string) { d.steps.Set(name) }
+
+func (d *runDeadline) stop() { d.cancel() }
+
+// wrap turns an error caused by the deadline into an ErrTimeout that names the
+// last step started; other errors are returned unchanged.
+func (d *runDeadline) wrap(err error) error {
+	if err == nil || d.timeout <= 0 || !errors.Is(err, context.DeadlineExceeded) {
+		return err
+	}
+	last := d.steps.Last()
+	if last == "" {
+		last = "unknown"
+	}
+	return fmt.Errorf("%w after %s (last step: %s): %w", ErrTimeout, d.timeout, last, err)
+}
@@ -23,37 +23,150 @@ import (
 type Initializer struct {
 	ConfigPath string
 	StatePath  string
-
+	// AllowReboot lets the preflight step reboot the host automatically after
+	// changing boot settings. Only interactive callers should set it.
+	AllowReboot bool
+	// AutobootArgs are extra arguments for the host-autoboot LaunchDaemon,
+	// such as --config/--state overrides given on the command line.
+	AutobootArgs []string
+	// OnDownloadProgress, when set, is called with the progress of any
+	// service bundle download made by Provision, AddUsers or UpdateUserCode.
+	OnDownloadProgress func(DownloadProgress)
+
+	geteuid    func() int
 	loadConfig func(string) (config.Config, error)
 	loadState  func(string) (state.State, error)
 	saveState  func(string, state.State) error
 
-	preflight  func(context.Context) (macos.PreflightResult, error)
+	preflight  func(context.Context, macos.PreflightOptions) (macos.PreflightResult, error)
 	ensureDeps func(context.Context) (deps.Result, error)
 
-	provisionUsers func(ctx context.Context, cfg config.Config, st state.State, userCount int, outputDir, prismPath string) (state.State, string, error)
-	addUsers       func(ctx context.Context, cfg config.Config, st state.State, userCount int, outputDir, prismPath string) (state.State, string, error)
-	removeUser     func(ctx context.Context, cfg config.Config, st state.State, username, outputDir string) (state.State, error)
+	provisionUsers func(ctx context.Context, cfg config.Config, st state.State, userCount int, outputDir, prismPath string) (state.State, infrahost.ProvisionSecrets, error)
+	addUsers       func(ctx context.Context, cfg config.Config, st state.State, userCount int, outputDir, prismPath string) (state.State, infrahost.ProvisionSecrets, error)
+	removeUser     func(ctx context.Context, cfg config.Config, st state.State, username, outputDir string, keepAccount bool) (state.State, error)
+	planRemoveUser func(ctx context.Context, cfg config.Config, st state.State, username string) (infrahost.RemovalPlan, error)
+	repairUser     func(ctx context.Context, st state.State, username string) (state.State, error)
+	planUsers      func(ctx context.Context, cfg config.Config, st state.State, userCount int) ([]infrahost.PlannedUser, error)
+	findUsers      func(cfg config.Config, st state.State, query string) []infrahost.UserMatch
 
 	checkServices        func(ctx context.Context, cfg config.Config, st state.State) ([]infrahost.UserServiceStatus, error)
-	ensureAutobootDaemon func(ctx context.Context, prismPath, workingDir string) error
-	ensureFastLogin      func(infrahost.FastLoginConfig) error
+	probeTunnels         func(ctx context.Context, cfg config.Config, statuses []infrahost.UserServiceStatus)
+	prewarmUsers         func(ctx context.Context, st state.State) []infrahost.UserPrewarmResult
+	selfTest             func(ctx context.Context, cfg config.Config, outputDir, prismPath string) infrahost.SelfTestResult
+	buildReport          func(cfg config.Config, st state.State) []infrahost.UserReportRow
+	readVersionInfo      func(outputDir string) (infrahost.VersionInfo, error)
+	reseedVersion        func(ctx context.Context, cfg config.Config, outputDir string) (string, error)
+	previewUpdate        func(ctx context.Context, cfg config.Config, outputDir string) (infrahost.UpdatePreview, error)
+	writeMetrics         func(ctx context.Context, cfg config.Config, st state.State, outputDir, path string) error
+	ensureAutobootDaemon func(ctx context.Context, prismPath, workingDir string, extraArgs []string) error
+	ensureFastLogin      func(context.Context, infrahost.FastLoginConfig) error
+	openUserSession      func(ctx context.Context, adminUser, username string) error
+	readFastLoginResults func(adminUser string) ([]infrahost.FastLoginResult, error)
+	restartUser          func(ctx context.Context, username string) error
+	refreshFRPC          func(ctx context.Context, cfg config.Config, st state.State) (infrahost.FRPCRefreshResult, error)
+	restartUnhealthy     func(ctx context.Context, cfg config.Config, st state.State) (infrahost.UnhealthyRestartResult, error)
+	readMaintenance      func(outputDir string) (infrahost.MaintenanceInfo, error)
+	enterMaintenance     func(ctx context.Context, st state.State, outputDir, reason string) error
+	exitMaintenance      func(ctx context.Context, st state.State, outputDir string) error
 }
 
 // ServiceStatus is an alias for infrahost.UserServiceStatus.
 type ServiceStatus = infrahost.UserServiceStatus
 
+// PlannedUser is an alias for infrahost.PlannedUser.
+type PlannedUser = infrahost.PlannedUser
+
+// UserMatch is an alias for infrahost.UserMatch.
+type UserMatch = infrahost.UserMatch
+
+// RemovalPlan is an alias for infrahost.RemovalPlan.
+type RemovalPlan = infrahost.RemovalPlan
+
+// PlanSummary is an alias for infrahost.PlanSummary.
+type PlanSummary = infrahost.PlanSummary
+
+// UserPassword is an alias for infrahost.UserPassword.
+type UserPassword = infrahost.UserPassword
+
+// DownloadProgress is an alias for infrahost.DownloadProgress.
+type DownloadProgress = infrahost.DownloadProgress
+
+// SysadminctlError is an alias for infrahost.SysadminctlError.
+type SysadminctlError = infrahost.SysadminctlError
+
+// UpdateOptions is an alias for infrahost.UpdateOptions.
+type UpdateOptions = infrahost.UpdateOptions
+
+// VersionInfo is an alias for infrahost.VersionInfo.
+type VersionInfo = infrahost.VersionInfo
+
+// UpdatePreview is an alias for infrahost.UpdatePreview.
+type UpdatePreview = infrahost.UpdatePreview
+
+// FastLoginResult is an alias for infrahost.FastLoginResult.
+type FastLoginResult = infrahost.FastLoginResult
+
+// FRPCRefreshResult is an alias for infrahost.FRPCRefreshResult.
+type FRPCRefreshResult = infrahost.FRPCRefreshResult
+
+// UnhealthyRestartResult is an alias for infrahost.UnhealthyRestartResult.
+type UnhealthyRestartResult = infrahost.UnhealthyRestartResult
+
+// MaintenanceInfo is an alias for infrahost.MaintenanceInfo.
+type MaintenanceInfo = infrahost.MaintenanceInfo
+
+// Provisioning errors from infrahost, for callers that map them to friendly
+// messages with errors.Is.
+var (
+	ErrUserExists         = infrahost.ErrUserExists
+	ErrDownloadFailed     = infrahost.ErrDownloadFailed
+	ErrPermissionDenied   = infrahost.ErrPermissionDenied
+	ErrFRPCMissing        = infrahost.ErrFRPCMissing
+	ErrPartialUpdate      = infrahost.ErrPartialUpdate
+	ErrPartialFRPCRefresh = infrahost.ErrPartialFRPCRefresh
+	ErrPasswordPolicy     = infrahost.ErrPasswordPolicy
+	ErrHomeDirFailed      = infrahost.ErrHomeDirFailed
+	ErrTimeout            = infrahost.ErrTimeout
+	ErrPortsInUse         = infrahost.ErrPortsInUse
+	ErrInvalidBundle      = infrahost.ErrInvalidBundle
+	ErrMaintenance        = infrahost.ErrMaintenance
+)
+
 // Result describes the outcome of the host check flow.
 type Result struct {
 	AlreadyInitialized bool
-	Preflight          macos.PreflightResult
-	Deps               deps.Result
+	// Resume is set instead of AlreadyInitialized when a previous Setup
+	// stopped partway; Provision with Resume.UsersTarget picks it up.
+	Resume    *SetupResume
+	Preflight macos.PreflightResult
+	Deps      deps.Result
+}
+
+// SetupResume describes an unfinished Setup found by Run.
+type SetupResume struct {
+	// UsersDone of UsersTarget users were provisioned.
+	UsersDone   int
+	UsersTarget int
+	// DaemonsPending lists users whose LaunchDaemons are not bootstrapped;
+	// resuming retries them.
+	DaemonsPending []string
 }
 
 // ProvisionResult describes the outcome of user provisioning.
 type ProvisionResult struct {
 	State       state.State
 	SecretsPath string
+	// Passwords holds the new users' passwords when they are not stored
+	// (globals.service.store_secrets is false). They cannot be recovered
+	// later, so it is also set when provisioning fails partway.
+	Passwords []UserPassword
+	// NotRestarted lists users whose updated code is staged but not yet
+	// running because UpdateUserCode was asked to skip the restart.
+	NotRestarted []string
+	// UpdateResults maps each user UpdateUserCode worked on to the error
+	// that stopped its update, or nil. With ErrPartialUpdate the result is
+	// returned alongside the error.
+	UpdateResults map[string]error
 }
 
 // NewInitializer constructs an Initializer with default implementations.
@@ -61,6 +174,7 @@ func NewInitializer(configPath, statePath string) *Initializer {
 	return &Initializer{
 		ConfigPath:           configPath,
 		StatePath:            statePath,
+		geteuid:              os.Geteuid,
 		loadConfig:           config.Load,
 		loadState:            state.Load,
 		saveState:            state.Save,
@@ -69,9 +183,29 @@ func NewInitializer(configPath, statePath string) *Initializer {
 		provisionUsers:       infrahost.ProvisionUsers,
 		addUsers:             infrahost.AddUsers,
 		removeUser:           infrahost.RemoveUser,
+		planRemoveUser:       infrahost.PlanRemoveUser,
+		repairUser:           infrahost.RepairUser,
+		planUsers:            infrahost.PlanUsers,
+		findUsers:            infrahost.FindUsers,
 		checkServices:        infrahost.CheckUserServices,
+		probeTunnels:         infrahost.ProbeUserTunnels,
+		prewarmUsers:         infrahost.PrewarmAllUsers,
+		selfTest:             infrahost.RunSelfTest,
+		buildReport:          infrahost.BuildUserReport,
+		readVersionInfo:      infrahost.ReadVersionInfo,
+		reseedVersion:        infrahost.ReseedVersion,
+		previewUpdate:        infrahost.PreviewUpdate,
+		writeMetrics:         infrahost.WriteMetrics,
 		ensureAutobootDaemon: infrahost.EnsureHostAutobootDaemon,
 		ensureFastLogin:      infrahost.EnsureFastLoginService,
+		openUserSession:      infrahost.OpenUserSession,
+		readFastLoginResults: infrahost.ReadFastLoginResults,
+		restartUser:          infrahost.RestartUserDaemons,
+		refreshFRPC:          infrahost.RefreshFRPCConfigs,
+		restartUnhealthy:     infrahost.RestartUnhealthyUsers,
+		readMaintenance:      infrahost.ReadMaintenance,
+		enterMaintenance:     infrahost.EnterMaintenance,
+		exitMaintenance:      infrahost.ExitMaintenance,
 	}
 }
 
@@ -81,7 +215,16 @@ func (i *Initializer) Run(ctx context.Context) (Result, error) {
 		return Result{}, err
 	}
 
-	pfRes, err := i.preflight(ctx)
+	if err := i.requireRoot(); err != nil {
+		return Result{}, err
+	}
+
+	// An unreadable config runs every check; it is reported after deps.
+	var skip []string
+	if cfg, err := i.loadConfig(i.ConfigPath); err == nil {
+		skip = cfg.Globals.Preflight.Skip
+	}
+	pfRes, err := i.preflight(ctx, macos.PreflightOptions{AllowReboot: i.AllowReboot, Skip: skip})
 	if err != nil {
 		return Result{Preflight: pfRes}, fmt.Errorf("preflight: %w", err)
 	}
@@ -96,6 +239,16 @@ func (i *Initializer) Run(ctx context.Context) (Result, error) {
 		return Result{Preflight: pfRes, Deps: depsRes}, fmt.Errorf("load state: %w", err)
 	}
 
+	if st.SetupPending > 0 {
+		resume := &SetupResume{UsersDone: len(st.Users), UsersTarget: st.SetupPending}
+		for _, u := range st.Users {
+			if u.DaemonsPending {
+				resume.DaemonsPending = append(resume.DaemonsPending, u.Name)
+			}
+		}
+		return Result{Resume: resume, Preflight: pfRes, Deps: depsRes}, nil
+	}
+
 	if st.Initialized || len(st.Users) > 0 {
 		return Result{AlreadyInitialized: true, Preflight: pfRes, Deps: depsRes}, nil
 	}
@@ -108,12 +261,19 @@ func (i *Initializer) Run(ctx context.Context) (Result, error) {
 }
 
 // Provisioning flows.
-// Provision creates users and prepares per-user service bundles.
+// Provision creates users and prepares per-user service bundles. userCount is
+// the total number of users; when a previous Setup stopped partway (see
+// Result.Resume), Provision resumes it instead of failing on the users it
+// left behind, and finishes the host steps it did not reach.
 func (i *Initializer) Provision(ctx context.Context, userCount int, prismPath string) (ProvisionResult, error) {
 	if err := i.validate(); err != nil {
 		return ProvisionResult{}, err
 	}
 
+	if err := i.requireRoot(); err != nil {
+		return ProvisionResult{}, err
+	}
+
 	if userCount <= 0 {
 		return ProvisionResult{}, errors.New("userCount must be positive")
 	}
@@ -129,25 +289,89 @@ func (i *Initializer) Provision(ctx context.Context, userCount int, prismPath st
 	}
 
 	outputDir := filepath.Dir(i.StatePath)
-	newState, secretsPath, err := i.provisionUsers(ctx, cfg, st, userCount, outputDir, prismPath)
+	ctx = infrahost.WithDownloadProgress(ctx, i.OnDownloadProgress)
+	ctx, deadline := startDeadline(ctx, cfg)
+	defer deadline.stop()
+	ctx = infrahost.WithStateSaver(ctx, func(s state.State) error { return i.saveState(i.StatePath, s) })
+	newState, secrets, err := i.provisionUsers(ctx, cfg, st, userCount, outputDir, prismPath)
 	if err != nil {
-		return ProvisionResult{}, fmt.Errorf("provision users: %w", err)
+		if i.savePartialState(st, newState) {
+			// Later runs use AddUsers, which does not install the autoboot daemon.
+			if err := i.ensureAutobootDaemon(context.WithoutCancel(ctx), prismPath, filepath.Dir(prismPath), i.AutobootArgs); err != nil {
+				fmt.Printf("[WARN] Failed to install the host autoboot daemon: %v\n", err)
+			}
+		}
+		return ProvisionResult{Passwords: secrets.Passwords}, fmt.Errorf("provision users: %w", deadline.wrap(err))
 	}
 
 	if err := i.saveState(i.StatePath, newState); err != nil {
-		return ProvisionResult{}, fmt.Errorf("save state: %w", err)
+		return ProvisionResult{Passwords: secrets.Passwords}, fmt.Errorf("save state: %w", err)
 	}
 
-	if err := i.ensureAutobootDaemon(ctx, prismPath, filepath.Dir(prismPath)); err != nil {
-		return ProvisionResult{}, fmt.Errorf("ensure host autoboot daemon: %w", err)
+	// The state saved above keeps SetupPending, so when a step below fails
+	// the next Setup run resumes from it.
+	deadline.step("install host autoboot daemon")
+	if err := i.ensureAutobootDaemon(ctx, prismPath, filepath.Dir(prismPath), i.AutobootArgs); err != nil {
+		return ProvisionResult{Passwords: secrets.Passwords}, fmt.Errorf("ensure host autoboot daemon: %w", deadline.wrap(err))
 	}
 
 	// Setup Fast Login for GUI sessions
-	if err := i.setupFastLogin(newState); err != nil {
-		return ProvisionResult{}, fmt.Errorf("setup fast login: %w", err)
+	deadline.step("set up fast login")
+	if err := i.setupFastLogin(ctx, cfg, newState); err != nil {
+		return ProvisionResult{Passwords: secrets.Passwords}, fmt.Errorf("setup fast login: %w", deadline.wrap(err))
+	}
+
+	newState.SetupPending = 0
+	if err := i.saveState(i.StatePath, newState); err != nil {
+		return ProvisionResult{Passwords: secrets.Passwords}, fmt.Errorf("save state: %w", err)
+	}
+
+	return ProvisionResult{State: newState, SecretsPath: secrets.Path, Passwords: secrets.Passwords}, nil
+}
+
+// PlanUsers returns the users the next Provision or AddUsers call would
+// create, without changing anything on the host.
+func (i *Initializer) PlanUsers(ctx context.Context, userCount int) ([]PlannedUser, error) {
+	plan, _, err := i.PlanUsersSummary(ctx, userCount)
+	return plan, err
+}
+
+// PlanUsersSummary is PlanUsers plus an overview of the plan (username
+// template, port range and domain suffix) to confirm before provisioning.
+func (i *Initializer) PlanUsersSummary(ctx context.Context, userCount int) ([]PlannedUser, PlanSummary, error) {
+	if err := i.validate(); err != nil {
+		return nil, PlanSummary{}, err
+	}
+
+	cfg, err := i.loadConfig(i.ConfigPath)
+	if err != nil {
+		return nil, PlanSummary{}, fmt.Errorf("load config: %w", err)
+	}
+
+	st, err := i.loadState(i.StatePath)
+	if err != nil {
+		return nil, PlanSummary{}, fmt.Errorf("load state: %w", err)
+	}
+
+	plan, err := i.planUsers(ctx, cfg, st, userCount)
+	if err != nil {
+		return nil, PlanSummary{}, err
 	}
+	return plan, infrahost.SummarizePlan(cfg, plan), nil
+}
 
-	return ProvisionResult{State: newState, SecretsPath: secretsPath}, nil
+// savePartialState saves newState when a failed provisioning run still
+// finished some users, so they are not orphaned even if a save after one of
+// them failed, and reports whether it did.
+func (i *Initializer) savePartialState(old, newState state.State) bool {
+	if len(newState.Users) <= len(old.Users) {
+		return false
+	}
+	if err := i.saveState(i.StatePath, newState); err != nil {
+		fmt.Printf("[WARN] Failed to save the users that were provisioned: %v\n", err)
+		return false
+	}
+	return true
 }
 
 func (i *Initializer) validate() error {
@@ -166,13 +390,19 @@ func (i *Initializer) validate() error {
 	return nil
 }
 
-// setupFastLogin configures the Fast Login spawner for GUI session activation.
-func (i *Initializer) setupFastLogin(st state.State) error {
-	// Determine AdminUser first
-	adminUser := strings.TrimSpace(os.Getenv("SUDO_USER"))
-	if adminUser == "" || adminUser == "root" {
-		adminUser = os.Getenv("USER")
+// requireRoot fails before a flow touches the host when Prism is not running
+// as root, instead of letting a chown or plist write fail halfway through.
+// Read-only flows (inventory, status, plan) do not call it.
+func (i *Initializer) requireRoot() error {
+	if i.geteuid() != 0 {
+		return fmt.Errorf("%w: this operation needs root, re-run with sudo", ErrPermissionDenied)
 	}
+	return nil
+}
+
+// setupFastLogin configures the Fast Login spawner for GUI session activation.
+func (i *Initializer) setupFastLogin(ctx context.Context, cfg config.Config, st state.State) error {
+	adminUser := fastLoginAdminUser()
 
 	// Filter out AdminUser from targets to avoid "You cannot control your own screen" error
 	var targetUsers []string
@@ -183,16 +413,33 @@ func (i *Initializer) setupFastLogin(st state.State) error {
 	}
 
 	fastLoginCfg := infrahost.FastLoginConfig{
-		AdminUser:   adminUser,
-		TargetUsers: targetUsers,
-		Password:    "Photon2025",
+		AdminUser:      adminUser,
+		TargetUsers:    targetUsers,
+		Password:       "Photon2025",
+		ConnectWait:    cfg.Globals.FastLogin.ConnectWait(),
+		KeystrokeDelay: cfg.Globals.FastLogin.KeystrokeDelay(),
+		LoginWait:      cfg.Globals.FastLogin.LoginWait(),
+		Attempts:       cfg.Globals.FastLogin.Attempts,
+	}
+	return i.ensureFastLogin(ctx, fastLoginCfg)
+}
+
+// fastLoginAdminUser returns the admin account whose GUI session runs the
+// Fast Login script: the sudo caller, or $USER when not run through sudo.
+func fastLoginAdminUser() string {
+	adminUser := strings.TrimSpace(os.Getenv("SUDO_USER"))
+	if adminUser == "" || adminUser == "root" {
+		adminUser = os.Getenv("USER")
 	}
-	return i.ensureFastLogin(fastLoginCfg)
+	return adminUser
 }
 
 // User management flows.
 // UserServiceStatuses returns runtime status for each Prism-managed user.
-func (i *Initializer) UserServiceStatuses(ctx context.Context) ([]ServiceStatus, error) {
+// With probeTunnels, each user's public health endpoint is also requested
+// through the frp tunnel (see infrahost.ProbeUserTunnels); leave it off for
+// periodic refreshes.
+func (i *Initializer) UserServiceStatuses(ctx context.Context, probeTunnels bool) ([]ServiceStatus, error) {
 	if err := i.validate(); err != nil {
 		return nil, err
 	}
@@ -211,16 +458,98 @@ func (i *Initializer) UserServiceStatuses(ctx context.Context) ([]ServiceStatus,
 	if err != nil {
 		return nil, fmt.Errorf("check services: %w", err)
 	}
+	if probeTunnels {
+		i.probeTunnels(ctx, cfg, statuses)
+	}
 
 	return statuses, nil
 }
 
-// RemoveUser deletes a Prism-managed user and updates state.
-func (i *Initializer) RemoveUser(ctx context.Context, username string) (state.State, error) {
+// PrewarmAllUsers triggers the permission prewarm for every Prism user and
+// returns per-user results.
+func (i *Initializer) PrewarmAllUsers(ctx context.Context) ([]infrahost.UserPrewarmResult, error) {
+	if err := i.validate(); err != nil {
+		return nil, err
+	}
+
+	if err := i.requireRoot(); err != nil {
+		return nil, err
+	}
+
+	st, err := i.loadState(i.StatePath)
+	if err != nil {
+		return nil, fmt.Errorf("load state: %w", err)
+	}
+
+	if len(st.Users) == 0 {
+		return nil, errors.New("no existing users in state; nothing to prewarm")
+	}
+
+	return i.prewarmUsers(ctx, st), nil
+}
+
+// SelfTest provisions, verifies and removes a throwaway user to validate the
+// host end to end. It does not touch state or existing users.
+func (i *Initializer) SelfTest(ctx context.Context, prismPath string) (infrahost.SelfTestResult, error) {
+	if err := i.validate(); err != nil {
+		return infrahost.SelfTestResult{}, err
+	}
+
+	if err := i.requireRoot(); err != nil {
+		return infrahost.SelfTestResult{}, err
+	}
+
+	cfg, err := i.loadConfig(i.ConfigPath)
+	if err != nil {
+		return infrahost.SelfTestResult{}, fmt.Errorf("load config: %w", err)
+	}
+
+	st, err := i.loadState(i.StatePath)
+	if err != nil {
+		return infrahost.SelfTestResult{}, fmt.Errorf("load state: %w", err)
+	}
+	reserved := infrahost.SelfTestUsername(cfg)
+	for _, u := range st.Users {
+		if u.Name == reserved {
+			return infrahost.SelfTestResult{}, fmt.Errorf("reserved selftest user %s is a provisioned user; refusing to run", reserved)
+		}
+	}
+
+	return i.selfTest(ctx, cfg, filepath.Dir(i.StatePath), prismPath), nil
+}
+
+// PlanRemoveUser returns what RemoveUser would delete for username, without
+// changing anything on the host.
+func (i *Initializer) PlanRemoveUser(ctx context.Context, username string) (RemovalPlan, error) {
+	if err := i.validate(); err != nil {
+		return RemovalPlan{}, err
+	}
+
+	cfg, err := i.loadConfig(i.ConfigPath)
+	if err != nil {
+		return RemovalPlan{}, fmt.Errorf("load config: %w", err)
+	}
+
+	st, err := i.loadState(i.StatePath)
+	if err != nil {
+		return RemovalPlan{}, fmt.Errorf("load state: %w", err)
+	}
+
+	return i.planRemoveUser(ctx, cfg, st, username)
+}
+
+// RemoveUser deletes a Prism-managed user and updates state. With keepAccount
+// it only deprovisions the user's services and keeps the macOS account and
+// home directory.
+func (i *Initializer) RemoveUser(ctx context.Context, username string, keepAccount bool) (state.State, error) {
 	if err := i.validate(); err != nil {
 		return state.State{}, err
 	}
 
+	if err := i.requireRoot(); err != nil {
+		return state.State{}, err
+	}
+
 	if strings.TrimSpace(username) == "" {
 		return state.State{}, errors.New("username is empty")
 	}
@@ -236,7 +565,7 @@ func (i *Initializer) RemoveUser(ctx context.Context, username string) (state.St
 	}
 
 	outputDir := filepath.Dir(i.StatePath)
-	newState, err := i.removeUser(ctx, cfg, st, username, outputDir)
+	newState, err := i.removeUser(ctx, cfg, st, username, outputDir, keepAccount)
 	if err != nil {
 		return state.State{}, fmt.Errorf("remove user: %w", err)
 	}
@@ -246,7 +575,7 @@ func (i *Initializer) RemoveUser(ctx context.Context, username string) (state.St
 	}
 
 	// Update Fast Login after user removal
-	if err := i.setupFastLogin(newState); err != nil {
+	if err := i.setupFastLogin(ctx, cfg, newState); err != nil {
 		// Log but don't fail - user was already removed
 		fmt.Printf("[WARN] Failed to update Fast Login configuration: %v\n", err)
 	}
@@ -260,6 +589,10 @@ func (i *Initializer) AddUsers(ctx context.Context, userCount int, prismPath str
 		return ProvisionResult{}, err
 	}
 
+	if err := i.requireRoot(); err != nil {
+		return ProvisionResult{}, err
+	}
+
 	if userCount <= 0 {
 		return ProvisionResult{}, errors.New("userCount must be positive")
 	}
@@ -275,28 +608,41 @@ func (i *Initializer) AddUsers(ctx context.Context, userCount int, prismPath str
 	}
 
 	outputDir := filepath.Dir(i.StatePath)
-	newState, secretsPath, err := i.addUsers(ctx, cfg, st, userCount, outputDir, prismPath)
+	ctx = infrahost.WithDownloadProgress(ctx, i.OnDownloadProgress)
+	ctx, deadline := startDeadline(ctx, cfg)
+	defer deadline.stop()
+	ctx = infrahost.WithStateSaver(ctx, func(s state.State) error { return i.saveState(i.StatePath, s) })
+	newState, secrets, err := i.addUsers(ctx, cfg, st, userCount, outputDir, prismPath)
 	if err != nil {
-		return ProvisionResult{}, fmt.Errorf("add users: %w", err)
+		i.savePartialState(st, newState)
+		return ProvisionResult{Passwords: secrets.Passwords}, fmt.Errorf("add users: %w", deadline.wrap(err))
 	}
 
 	if err := i.saveState(i.StatePath, newState); err != nil {
-		return ProvisionResult{}, fmt.Errorf("save state: %w", err)
+		return ProvisionResult{Passwords: secrets.Passwords}, fmt.Errorf("save state: %w", err)
 	}
 
 	// Update Fast Login for GUI sessions
-	if err := i.setupFastLogin(newState); err != nil {
-		return ProvisionResult{}, fmt.Errorf("setup fast login: %w", err)
+	deadline.step("set up fast login")
+	if err := i.setupFastLogin(ctx, cfg, newState); err != nil {
+		return ProvisionResult{Passwords: secrets.Passwords}, fmt.Errorf("setup fast login: %w", deadline.wrap(err))
 	}
 
-	return ProvisionResult{State: newState, SecretsPath: secretsPath}, nil
+	return ProvisionResult{State: newState, SecretsPath: secrets.Path, Passwords: secrets.Passwords}, nil
 }
 
-func (i *Initializer) UpdateUserCode(ctx context.Context) (ProvisionResult, error) {
+// UpdateUserCode rolls the latest service bundle out to every user (or the
+// users in opts.Users), as tuned by opts (see infrahost.UpdateOptions). One
+// user's failure does not stop the others; see ProvisionResult.UpdateResults.
+func (i *Initializer) UpdateUserCode(ctx context.Context, opts UpdateOptions) (ProvisionResult, error) {
 	if err := i.validate(); err != nil {
 		return ProvisionResult{}, err
 	}
 
+	if err := i.requireRoot(); err != nil {
+		return ProvisionResult{}, err
+	}
+
 	cfg, err := i.loadConfig(i.ConfigPath)
 	if err != nil {
 		return ProvisionResult{}, fmt.Errorf("load config: %w", err)
@@ -307,20 +653,201 @@ func (i *Initializer) UpdateUserCode(ctx context.Context) (ProvisionResult, erro
 		return ProvisionResult{}, fmt.Errorf("load state: %w", err)
 	}
 
+	if err := i.requireNoMaintenance(); err != nil {
+		return ProvisionResult{}, err
+	}
+
 	outputDir := filepath.Dir(i.StatePath)
-	newState, err := infrahost.UpdateUserCode(ctx, cfg, st, outputDir)
-	if err != nil {
-		return ProvisionResult{}, fmt.Errorf("update user code: %w", err)
+	ctx = infrahost.WithDownloadProgress(ctx, i.OnDownloadProgress)
+	ctx, deadline := startDeadline(ctx, cfg)
+	defer deadline.stop()
+	newState, res, updateErr := infrahost.UpdateUserCode(ctx, cfg, st, outputDir, opts)
+	if updateErr != nil && !errors.Is(updateErr, ErrPartialUpdate) {
+		return ProvisionResult{}, fmt.Errorf("update user code: %w", deadline.wrap(updateErr))
 	}
+	result := ProvisionResult{State: newState, NotRestarted: res.NotRestarted, UpdateResults: res.Users}
 
 	if err := i.saveState(i.StatePath, newState); err != nil {
-		return ProvisionResult{}, fmt.Errorf("save state: %w", err)
+		return result, fmt.Errorf("save state: %w", err)
 	}
 
 	// Update Fast Login for GUI sessions
-	if err := i.setupFastLogin(newState); err != nil {
-		return ProvisionResult{}, fmt.Errorf("setup fast login: %w", err)
+	deadline.step("set up fast login")
+	if err := i.setupFastLogin(ctx, cfg, newState); err != nil {
+		return result, fmt.Errorf("setup fast login: %w", deadline.wrap(err))
+	}
+
+	if updateErr != nil {
+		return result, fmt.Errorf("update user code: %w", updateErr)
+	}
+	return result, nil
+}
+
+// RefreshFRPCConfigs rewrites every user's frpc.toml from the current
+// globals.frpc settings and restarts the frpc daemons that are running, e.g.
+// after the frps address or transport changed. With ErrPartialFRPCRefresh
+// the result is returned alongside the error.
+func (i *Initializer) RefreshFRPCConfigs(ctx context.Context) (FRPCRefreshResult, error) {
+	if err := i.validate(); err != nil {
+		return FRPCRefreshResult{}, err
 	}
 
-	return ProvisionResult{State: newState}, nil
+	if err := i.requireRoot(); err != nil {
+		return FRPCRefreshResult{}, err
+	}
+
+	cfg, err := i.loadConfig(i.ConfigPath)
+	if err != nil {
+		return FRPCRefreshResult{}, fmt.Errorf("load config: %w", err)
+	}
+
+	st, err := i.loadState(i.StatePath)
+	if err != nil {
+		return FRPCRefreshResult{}, fmt.Errorf("load state: %w", err)
+	}
+
+	res, err := i.refreshFRPC(ctx, cfg, st)
+	if err != nil {
+		return res, fmt.Errorf("refresh frpc configs: %w", err)
+	}
+	return res, nil
+}
+
+// RestartUsers restarts the services of the named Prism users, or of every
+// user when names is empty, e.g. after UpdateUserCode skipped the restart.
+// It attempts every user and returns the combined failures.
+func (i *Initializer) RestartUsers(ctx context.Context, names []string) error {
+	if err := i.validate(); err != nil {
+		return err
+	}
+
+	if err := i.requireRoot(); err != nil {
+		return err
+	}
+
+	if err := i.requireNoMaintenance(); err != nil {
+		return err
+	}
+
+	st, err := i.loadState(i.StatePath)
+	if err != nil {
+		return fmt.Errorf("load state: %w", err)
+	}
+
+	known := make(map[string]bool, len(st.Users))
+	for _, u := range st.Users {
+		known[u.Name] = true
+	}
+	if len(names) == 0 {
+		for _, u := range st.Users {
+			names = append(names, u.Name)
+		}
+	}
+
+	var errs []error
+	for _, name := range names {
+		if !known[name] {
+			errs = append(errs, fmt.Errorf("%s is not a Prism user", name))
+			continue
+		}
+		if err := i.restartUser(ctx, name); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// RestartUnhealthyUsers restarts the services of only the users whose
+// service check is not healthy, leaving healthy users running. The result is
+// returned alongside an error listing the users whose restart failed.
+func (i *Initializer) RestartUnhealthyUsers(ctx context.Context) (UnhealthyRestartResult, error) {
+	if err := i.validate(); err != nil {
+		return UnhealthyRestartResult{}, err
+	}
+
+	if err := i.requireRoot(); err != nil {
+		return UnhealthyRestartResult{}, err
+	}
+
+	cfg, err := i.loadConfig(i.ConfigPath)
+	if err != nil {
+		return UnhealthyRestartResult{}, fmt.Errorf("load config: %w", err)
+	}
+
+	st, err := i.loadState(i.StatePath)
+	if err != nil {
+		return UnhealthyRestartResult{}, fmt.Errorf("load state: %w", err)
+	}
+
+	if err := i.requireNoMaintenance(); err != nil {
+		return UnhealthyRestartResult{}, err
+	}
+
+	return i.restartUnhealthy(ctx, cfg, st)
+}
+
+// RepairUser retries bootstrapping the LaunchDaemons of a user whose account
+// exists but whose daemons did not start (state.User.DaemonsPending), without
+// recreating the account. It returns the saved state.
+func (i *Initializer) RepairUser(ctx context.Context, username string) (state.State, error) {
+	if err := i.validate(); err != nil {
+		return state.State{}, err
+	}
+
+	if err := i.requireRoot(); err != nil {
+		return state.State{}, err
+	}
+
+	st, err := i.loadState(i.StatePath)
+	if err != nil {
+		return state.State{}, fmt.Errorf("load state: %w", err)
+	}
+
+	if err := i.requireNoMaintenance(); err != nil {
+		return state.State{}, err
+	}
+
+	newState, err := i.repairUser(ctx, st, username)
+	if err != nil {
+		return state.State{}, fmt.Errorf("repair user: %w", err)
+	}
+
+	if err := i.saveState(i.StatePath, newState); err != nil {
+		return state.State{}, fmt.Errorf("save state: %w", err)
+	}
+	return newState, nil
+}
+
+// OpenUserSession runs the Fast Login flow for a single Prism user, opening
+// their Screen Sharing session over the admin's existing SSH tunnel.
+func (i *Initializer) OpenUserSession(ctx context.Context, username string) error {
+	if err := i.validate(); err != nil {
+		return err
+	}
+
+	if err := i.requireRoot(); err != nil {
+		return err
+	}
+
+	st, err := i.loadState(i.StatePath)
+	if err != nil {
+		return fmt.Errorf("load state: %w", err)
+	}
+
+	found := false
+	for _, u := range st.Users {
+		if u.Name == username {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return fmt.Errorf("%s is not a Prism user", username)
+	}
+
+	adminUser := fastLoginAdminUser()
+	if adminUser == username {
+		return fmt.Errorf("%s is the admin user; cannot open a Screen Sharing session to it", username)
+	}
+	return i.openUserSession(ctx, adminUser, username)
 }
@@ -0,0 +1,237 @@
+package host
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	infrahost "prism/internal/infra/host"
+	"prism/internal/infra/paths"
+)
+
+// InventoryUser describes a single Prism-managed user in machine-readable form.
+type InventoryUser struct {
+	Name       string `json:"name"`
+	Port       int    `json:"port"`
+	Subdomain  string `json:"subdomain"`
+	FullDomain string `json:"full_domain"`
+	URL        string `json:"url"`
+	// DaemonsPending means the user's LaunchDaemons still need bootstrapping;
+	// see Initializer.RepairUser.
+	DaemonsPending bool              `json:"daemons_pending,omitempty"`
+	Labels         map[string]string `json:"labels,omitempty"`
+}
+
+// Inventory is the machine-readable view of all Prism users on this host.
+type Inventory struct {
+	Initialized bool `json:"initialized"`
+	// SetupPending is the user count of an unfinished Setup; see
+	// Result.Resume.
+	SetupPending int             `json:"setup_pending,omitempty"`
+	Users        []InventoryUser `json:"users"`
+	// SecretsPath is the password file, or empty when
+	// globals.service.store_secrets is false.
+	SecretsPath string `json:"secrets_path"`
+}
+
+// Inventory loads state and enriches each user with its public domain.
+func (i *Initializer) Inventory(ctx context.Context) (Inventory, error) {
+	if err := i.validate(); err != nil {
+		return Inventory{}, err
+	}
+
+	cfg, err := i.loadConfig(i.ConfigPath)
+	if err != nil {
+		return Inventory{}, fmt.Errorf("load config: %w", err)
+	}
+
+	st, err := i.loadState(i.StatePath)
+	if err != nil {
+		return Inventory{}, fmt.Errorf("load state: %w", err)
+	}
+
+	inv := Inventory{
+		Initialized:  st.Initialized,
+		SetupPending: st.SetupPending,
+		Users:        make([]InventoryUser, 0, len(st.Users)),
+	}
+	if cfg.Globals.Service.StoresSecrets() {
+		inv.SecretsPath = paths.SecretsPathIn(filepath.Dir(i.StatePath))
+	}
+	suffix := strings.Trim(strings.TrimSpace(cfg.Globals.DomainSuffix), ".")
+	for _, u := range st.Users {
+		item := InventoryUser{
+			Name:           u.Name,
+			Port:           u.Port,
+			Subdomain:      u.Subdomain,
+			DaemonsPending: u.DaemonsPending,
+			Labels:         u.Labels,
+		}
+		if u.Subdomain != "" && suffix != "" {
+			item.FullDomain = u.Subdomain + "." + suffix
+			item.URL = cfg.Globals.PublicURL(item.FullDomain)
+		}
+		inv.Users = append(inv.Users, item)
+	}
+
+	return inv, nil
+}
+
+// FindUsers returns the users that query identifies: a port, a username, a
+// subdomain (also as full domain or URL) or a fragment of the friendlyName.
+// It is read-only; friendlyNames are only readable as root.
+func (i *Initializer) FindUsers(ctx context.Context, query string) ([]UserMatch, error) {
+	if err := i.validate(); err != nil {
+		return nil, err
+	}
+
+	if strings.TrimSpace(query) == "" {
+		return nil, errors.New("query is empty")
+	}
+
+	cfg, err := i.loadConfig(i.ConfigPath)
+	if err != nil {
+		return nil, fmt.Errorf("load config: %w", err)
+	}
+
+	st, err := i.loadState(i.StatePath)
+	if err != nil {
+		return nil, fmt.Errorf("load state: %w", err)
+	}
+
+	return i.findUsers(cfg, st, query), nil
+}
+
+// Report returns the public URL report for every Prism user. It is read-only.
+func (i *Initializer) Report(ctx context.Context) ([]infrahost.UserReportRow, error) {
+	if err := i.validate(); err != nil {
+		return nil, err
+	}
+
+	cfg, err := i.loadConfig(i.ConfigPath)
+	if err != nil {
+		return nil, fmt.Errorf("load config: %w", err)
+	}
+
+	st, err := i.loadState(i.StatePath)
+	if err != nil {
+		return nil, fmt.Errorf("load state: %w", err)
+	}
+
+	return i.buildReport(cfg, st), nil
+}
+
+// LastUpdate returns the deployed bundle version and when it was rolled out.
+// The error wraps os.ErrNotExist when no version has been recorded yet.
+func (i *Initializer) LastUpdate(ctx context.Context) (VersionInfo, error) {
+	if err := i.validate(); err != nil {
+		return VersionInfo{}, err
+	}
+
+	return i.readVersionInfo(filepath.Dir(i.StatePath))
+}
+
+// ReseedVersion records the latest release as the deployed bundle version so
+// auto-update has a baseline again, without touching users, and returns the
+// recorded tag.
+func (i *Initializer) ReseedVersion(ctx context.Context) (string, error) {
+	if err := i.validate(); err != nil {
+		return "", err
+	}
+
+	if err := i.requireRoot(); err != nil {
+		return "", err
+	}
+
+	cfg, err := i.loadConfig(i.ConfigPath)
+	if err != nil {
+		return "", fmt.Errorf("load config: %w", err)
+	}
+
+	return i.reseedVersion(ctx, cfg, filepath.Dir(i.StatePath))
+}
+
+// PreviewUpdate compares the deployed bundle version with the latest release
+// and returns its release notes. It is read-only.
+func (i *Initializer) PreviewUpdate(ctx context.Context) (UpdatePreview, error) {
+	if err := i.validate(); err != nil {
+		return UpdatePreview{}, err
+	}
+
+	cfg, err := i.loadConfig(i.ConfigPath)
+	if err != nil {
+		return UpdatePreview{}, fmt.Errorf("load config: %w", err)
+	}
+
+	return i.previewUpdate(ctx, cfg, filepath.Dir(i.StatePath))
+}
+
+// WriteMetrics writes the Prometheus textfile gauges to path, or to
+// globals.metrics.textfile_path when path is empty, and returns the path
+// written.
+func (i *Initializer) WriteMetrics(ctx context.Context, path string) (string, error) {
+	if err := i.validate(); err != nil {
+		return "", err
+	}
+
+	cfg, err := i.loadConfig(i.ConfigPath)
+	if err != nil {
+		return "", fmt.Errorf("load config: %w", err)
+	}
+	if path == "" {
+		path = cfg.Globals.Metrics.TextfilePath
+	}
+	if path == "" {
+		return "", errors.New("no metrics path: set globals.metrics.textfile_path or pass --output")
+	}
+
+	st, err := i.loadState(i.StatePath)
+	if err != nil {
+		return "", fmt.Errorf("load state: %w", err)
+	}
+
+	if err := i.writeMetrics(ctx, cfg, st, filepath.Dir(i.StatePath), path); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// FastLoginResults returns the latest fast-login outcome of every Prism user
+// other than the admin, in state order. A user the script has not logged in
+// yet has a zero Time. It is read-only.
+func (i *Initializer) FastLoginResults(ctx context.Context) ([]FastLoginResult, error) {
+	if err := i.validate(); err != nil {
+		return nil, err
+	}
+
+	st, err := i.loadState(i.StatePath)
+	if err != nil {
+		return nil, fmt.Errorf("load state: %w", err)
+	}
+
+	adminUser := fastLoginAdminUser()
+	recorded, err := i.readFastLoginResults(adminUser)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("read fast login results: %w", err)
+	}
+	byUser := make(map[string]FastLoginResult, len(recorded))
+	for _, r := range recorded {
+		byUser[r.User] = r
+	}
+
+	var results []FastLoginResult
+	for _, u := range st.Users {
+		if u.Name == adminUser {
+			continue
+		}
+		r, ok := byUser[u.Name]
+		if !ok {
+			r = FastLoginResult{User: u.Name}
+		}
+		results = append(results, r)
+	}
+	return results, nil
+}
@@ -0,0 +1,82 @@
+package host
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"prism/internal/infra/state"
+)
+
+// SetUserLabels sets the labels in set and deletes the keys in remove on the
+// user named username, saves state and returns the updated user. Keys must be
+// non-empty and must not contain "=", "," or whitespace.
+func (i *Initializer) SetUserLabels(ctx context.Context, username string, set map[string]string, remove []string) (state.User, error) {
+	if err := i.validate(); err != nil {
+		return state.User{}, err
+	}
+
+	if err := i.requireRoot(); err != nil {
+		return state.User{}, err
+	}
+
+	if strings.TrimSpace(username) == "" {
+		return state.User{}, errors.New("username is empty")
+	}
+	for k := range set {
+		if err := validateLabelKey(k); err != nil {
+			return state.User{}, err
+		}
+	}
+	for _, k := range remove {
+		if err := validateLabelKey(k); err != nil {
+			return state.User{}, err
+		}
+	}
+
+	st, err := i.loadState(i.StatePath)
+	if err != nil {
+		return state.User{}, fmt.Errorf("load state: %w", err)
+	}
+
+	idx := -1
+	for n, u := range st.Users {
+		if u.Name == username {
+			idx = n
+			break
+		}
+	}
+	if idx == -1 {
+		return state.User{}, fmt.Errorf("user %s not found in state", username)
+	}
+
+	u := &st.Users[idx]
+	for _, k := range remove {
+		delete(u.Labels, k)
+	}
+	for k, v := range set {
+		if u.Labels == nil {
+			u.Labels = make(map[string]string)
+		}
+		u.Labels[k] = v
+	}
+	if len(u.Labels) == 0 {
+		u.Labels = nil
+	}
+
+	if err := i.saveState(i.StatePath, st); err != nil {
+		return state.User{}, fmt.Errorf("save state: %w", err)
+	}
+	return *u, nil
+}
+
+func validateLabelKey(key string) error {
+	if key == "" {
+		return errors.New("label key is empty")
+	}
+	if strings.ContainsAny(key, "=, \t\r\n") {
+		return fmt.Errorf("label key %q must not contain '=', ',' or whitespace", key)
+	}
+	return nil
+}
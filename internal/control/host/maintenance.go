@@ -0,0 +1,74 @@
+package host
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+)
+
+// Maintenance reports whether the host is in maintenance mode. It is
+// read-only.
+func (i *Initializer) Maintenance(ctx context.Context) (MaintenanceInfo, error) {
+	if err := i.validate(); err != nil {
+		return MaintenanceInfo{}, err
+	}
+
+	return i.readMaintenance(filepath.Dir(i.StatePath))
+}
+
+// requireNoMaintenance refuses operations that would start user daemons
+// while the host is in maintenance.
+func (i *Initializer) requireNoMaintenance() error {
+	info, err := i.readMaintenance(filepath.Dir(i.StatePath))
+	if err != nil {
+		return fmt.Errorf("read maintenance marker: %w", err)
+	}
+	if info.Active {
+		return fmt.Errorf("%w; leave it first with: sudo ./prism maintenance exit", ErrMaintenance)
+	}
+	return nil
+}
+
+// EnterMaintenance stops every user's daemons and keeps them stopped, across
+// reboots as well, until ExitMaintenance. reason is recorded with the marker.
+func (i *Initializer) EnterMaintenance(ctx context.Context, reason string) error {
+	if err := i.validate(); err != nil {
+		return err
+	}
+
+	if err := i.requireRoot(); err != nil {
+		return err
+	}
+
+	st, err := i.loadState(i.StatePath)
+	if err != nil {
+		return fmt.Errorf("load state: %w", err)
+	}
+
+	if err := i.enterMaintenance(ctx, st, filepath.Dir(i.StatePath), reason); err != nil {
+		return fmt.Errorf("enter maintenance: %w", err)
+	}
+	return nil
+}
+
+// ExitMaintenance starts every user's daemons again and leaves maintenance
+// mode.
+func (i *Initializer) ExitMaintenance(ctx context.Context) error {
+	if err := i.validate(); err != nil {
+		return err
+	}
+
+	if err := i.requireRoot(); err != nil {
+		return err
+	}
+
+	st, err := i.loadState(i.StatePath)
+	if err != nil {
+		return fmt.Errorf("load state: %w", err)
+	}
+
+	if err := i.exitMaintenance(ctx, st, filepath.Dir(i.StatePath)); err != nil {
+		return fmt.Errorf("exit maintenance: %w", err)
+	}
+	return nil
+}
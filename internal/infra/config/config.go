@@ -5,7 +5,19 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io/fs"
+	"net"
+	"net/url"
 	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
 )
 
 // Config represents the static configuration loaded from prism.json.
@@ -14,37 +26,513 @@ type Config struct {
 }
 
 type Globals struct {
-	MachineID       string        `json:"machine_id"`
-	DefaultPassword string        `json:"default_password"`
-	FRPC            FRPCConfig    `json:"frpc"`
-	DomainSuffix    string        `json:"domain_suffix"`
-	Service         ServiceConfig `json:"service"`
-	Nexus           NexusConfig   `json:"nexus"`
+	MachineID       string     `json:"machine_id"`
+	DefaultPassword string     `json:"default_password"`
+	FRPC            FRPCConfig `json:"frpc"`
+	DomainSuffix    string     `json:"domain_suffix"`
+	// DomainScheme is the scheme of the public URLs Prism shows to
+	// operators: "http" or "https" (the default). It does not affect frpc.
+	DomainScheme string        `json:"domain_scheme,omitempty"`
+	Service      ServiceConfig `json:"service"`
+	// Services lists additional per-user services deployed next to the
+	// primary imsg service described by Service.
+	Services []ServiceDefinition `json:"services,omitempty"`
+	Nexus    NexusConfig         `json:"nexus"`
+	// Metrics configures the Prometheus textfile written by host-autoboot.
+	Metrics MetricsConfig `json:"metrics"`
+	// StatusListen is the address host-autoboot serves read-only status JSON
+	// on. Empty disables it; a bare port such as "9180" or ":9180" binds
+	// loopback only.
+	StatusListen string `json:"status_listen,omitempty"`
+	// Preflight tunes the host checks Setup runs first.
+	Preflight PreflightConfig `json:"preflight,omitempty"`
+	// FastLogin tunes the timing of the Fast Login script.
+	FastLogin FastLoginConfig `json:"fast_login,omitempty"`
 }
 
 type FRPCConfig struct {
 	ServerAddr string `json:"server_addr"`
 	ServerPort int    `json:"server_port"`
+	// FriendlyNameRefreshMinutes, when positive, makes each user's keepalive
+	// agent re-detect the iMessage identity this often and update the frpc
+	// friendlyName when it changed. Zero disables the refresh.
+	FriendlyNameRefreshMinutes int `json:"friendly_name_refresh_minutes,omitempty"`
+	// Transport is rendered into the [transport] section of every user's
+	// frpc.toml.
+	Transport FRPCTransportConfig `json:"transport,omitempty"`
 }
 
+// FRPCTransportConfig tunes the connection from frpc to frps. Zero values
+// keep frpc's defaults.
+type FRPCTransportConfig struct {
+	// Protocol is tcp (frpc's default), kcp, quic, websocket or wss.
+	Protocol string `json:"protocol,omitempty"`
+	// TLSEnable sets transport.tls.enable; nil keeps frpc's default, which
+	// is enabled since frp 0.50.
+	TLSEnable        *bool  `json:"tls_enable,omitempty"`
+	TLSServerName    string `json:"tls_server_name,omitempty"`
+	TLSTrustedCAFile string `json:"tls_trusted_ca_file,omitempty"`
+	// HeartbeatIntervalSeconds of -1 disables heartbeats and leaves
+	// liveness to TCP keepalive.
+	HeartbeatIntervalSeconds int `json:"heartbeat_interval_seconds,omitempty"`
+	HeartbeatTimeoutSeconds  int `json:"heartbeat_timeout_seconds,omitempty"`
+	// PoolCount is the number of connections frpc opens to frps in advance.
+	PoolCount int `json:"pool_count,omitempty"`
+}
+
+// frpsDefaultMaxPoolCount is the pool size frps grants unless its
+// transport.maxPoolCount is raised.
+const frpsDefaultMaxPoolCount = 5
+
+// frpcProtocols are the transport.protocol values frpc accepts.
+var frpcProtocols = []string{"tcp", "kcp", "quic", "websocket", "wss"}
+
 type ServiceConfig struct {
+	ArchiveURL  string `json:"archive_url"`
+	StartPort   int    `json:"start_port"`
+	HiddenUsers bool   `json:"hidden_users,omitempty"`
+	BaseUID     int    `json:"base_uid,omitempty"`
+	// PasswordNeverExpires exempts new Prism accounts from password
+	// expiration and forced password changes, which would silently break
+	// their headless services.
+	PasswordNeverExpires bool `json:"password_never_expires,omitempty"`
+	// WrapperShell is the interpreter of the per-user prism wrapper script
+	// (default /bin/zsh). WrapperEnv is exported before exec.
+	WrapperShell string            `json:"wrapper_shell,omitempty"`
+	WrapperEnv   map[string]string `json:"wrapper_env,omitempty"`
+	// MaxUsers caps the number of users and so bounds the per-user port
+	// range that Validate checks. Zero leaves both open.
+	MaxUsers int `json:"max_users,omitempty"`
+	// ArchiveStrip is the number of leading path components stripped when
+	// extracting the bundle. Nil means the default of 1.
+	ArchiveStrip *int `json:"archive_strip,omitempty"`
+	// CacheDir overrides where bundles are downloaded and extracted. Empty
+	// means <output>/cache.
+	CacheDir string `json:"cache_dir,omitempty"`
+	// KeepPreviousArchive keeps the previous bundle next to the current one
+	// after an update so it can be rolled back to.
+	KeepPreviousArchive bool `json:"keep_previous_archive,omitempty"`
+	// AutoUpdate controls whether host-autoboot checks for new releases.
+	// Nil means true. UpdateCheckMinutes is the check interval; zero means
+	// DefaultUpdateCheckInterval.
+	AutoUpdate         *bool `json:"auto_update,omitempty"`
+	UpdateCheckMinutes int   `json:"update_check_minutes,omitempty"`
+	// DownloadRateKbps caps the bundle download rate in kilobits per second.
+	// Zero means unlimited.
+	DownloadRateKbps int `json:"download_rate_kbps,omitempty"`
+	// BatchCreateMin makes setup and add-users create the accounts with one
+	// dsimport run when at least this many are created at once. Zero always
+	// uses one sysadminctl call per user.
+	BatchCreateMin int `json:"batch_create_min,omitempty"`
+	// ProvisionTimeoutMinutes bounds a whole setup, add-users or update run.
+	// Zero means no deadline.
+	ProvisionTimeoutMinutes int `json:"provision_timeout_minutes,omitempty"`
+	// BootstrapStaggerSeconds is the pause between bootstrapping the
+	// LaunchDaemons of consecutive new users during setup and add-users, so
+	// their servers do not all cold-start at once. Zero means no pause.
+	BootstrapStaggerSeconds int `json:"bootstrap_stagger_seconds,omitempty"`
+	// StoreSecrets controls whether new users' passwords are written to
+	// output/secrets/users.csv. Nil means true; when false they are only
+	// returned for one-time display.
+	StoreSecrets *bool `json:"store_secrets,omitempty"`
+	// Env is merged over the default environment of the server LaunchDaemon
+	// (NODE_ENV, PATH, ...). PORT, HOST, HOME and MACHINE_ID are managed by
+	// Prism.
+	Env map[string]string `json:"env,omitempty"`
+	// LocalIP is the address each user's server binds (its HOST) and frpc
+	// forwards to (localIP). Empty means DefaultLocalIP.
+	LocalIP string `json:"local_ip,omitempty"`
+	// HealthPath is the server endpoint that deploy and the host service
+	// check request. Empty means DefaultHealthPath.
+	HealthPath string `json:"health_path,omitempty"`
+	// RequiredPaths are paths, relative to the extracted bundle, that must
+	// exist before users are provisioned or updated from it, e.g. the app's
+	// node_modules.
+	RequiredPaths []string `json:"required_paths,omitempty"`
+	// VerifySignature runs codesign --verify on the server app of each
+	// extracted bundle and rejects the bundle when it fails.
+	VerifySignature bool `json:"verify_signature,omitempty"`
+}
+
+// reservedServerEnv lists server environment variables that Prism sets per
+// user and that globals.service.env may not override.
+var reservedServerEnv = map[string]bool{"PORT": true, "HOST": true, "HOME": true, "MACHINE_ID": true}
+
+// DefaultLocalIP is used when globals.service.local_ip is unset.
+const DefaultLocalIP = "127.0.0.1"
+
+// DefaultHealthPath is used when globals.service.health_path is unset.
+const DefaultHealthPath = "/health"
+
+// HealthCheckPath returns the server's health endpoint (default /health).
+func (s ServiceConfig) HealthCheckPath() string {
+	if p := strings.TrimSpace(s.HealthPath); p != "" {
+		return p
+	}
+	return DefaultHealthPath
+}
+
+// BindIP returns the local address user servers bind (default 127.0.0.1).
+func (s ServiceConfig) BindIP() string {
+	if ip := strings.TrimSpace(s.LocalIP); ip != "" {
+		return ip
+	}
+	return DefaultLocalIP
+}
+
+// DefaultMaxUsers is the user count assumed for the port range warnings and
+// the validate-config summary when globals.service.max_users is unset.
+// Validate does not assume it; see validatePorts.
+const DefaultMaxUsers = 100
+
+// UserPortRange returns the first and last local ports that may be assigned
+// to Prism users.
+func (s ServiceConfig) UserPortRange() (int, int) {
+	n := s.MaxUsers
+	if n <= 0 {
+		n = DefaultMaxUsers
+	}
+	return s.StartPort, s.StartPort + n - 1
+}
+
+// MaxUserPort returns the highest local port provisioning may assign: the end
+// of UserPortRange when globals.service.max_users is set, otherwise 65535, so
+// an unset max_users does not cap the number of users.
+func (s ServiceConfig) MaxUserPort() int {
+	if s.MaxUsers > 0 {
+		_, last := s.UserPortRange()
+		return last
+	}
+	return 65535
+}
+
+// ProvisionTimeout returns the deadline for a provisioning run, or zero for
+// none.
+func (s ServiceConfig) ProvisionTimeout() time.Duration {
+	return time.Duration(s.ProvisionTimeoutMinutes) * time.Minute
+}
+
+// BootstrapStagger returns the pause between bootstrapping consecutive new
+// users, or zero for none.
+func (s ServiceConfig) BootstrapStagger() time.Duration {
+	return time.Duration(s.BootstrapStaggerSeconds) * time.Second
+}
+
+// DefaultUpdateCheckInterval is used when
+// globals.service.update_check_minutes is unset.
+const DefaultUpdateCheckInterval = time.Hour
+
+// AutoUpdates reports whether host-autoboot checks for new releases.
+func (s ServiceConfig) AutoUpdates() bool {
+	return s.AutoUpdate == nil || *s.AutoUpdate
+}
+
+// UpdateCheckInterval returns how often host-autoboot checks for new
+// releases.
+func (s ServiceConfig) UpdateCheckInterval() time.Duration {
+	if s.UpdateCheckMinutes <= 0 {
+		return DefaultUpdateCheckInterval
+	}
+	return time.Duration(s.UpdateCheckMinutes) * time.Minute
+}
+
+// DefaultWrapperShell is used when globals.service.wrapper_shell is unset.
+const DefaultWrapperShell = "/bin/zsh"
+
+// StoresSecrets reports whether new users' passwords are persisted to the
+// secrets file.
+func (s ServiceConfig) StoresSecrets() bool {
+	return s.StoreSecrets == nil || *s.StoreSecrets
+}
+
+// Shell returns the configured wrapper shell (default /bin/zsh).
+func (s ServiceConfig) Shell() string {
+	if strings.TrimSpace(s.WrapperShell) == "" {
+		return DefaultWrapperShell
+	}
+	return strings.TrimSpace(s.WrapperShell)
+}
+
+var envNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// StripComponents returns the configured archive strip count (default 1).
+func (s ServiceConfig) StripComponents() int {
+	if s.ArchiveStrip == nil {
+		return 1
+	}
+	return *s.ArchiveStrip
+}
+
+// GitHubArchive is the parsed form of a gh://owner/repo/asset[@tag] archive_url.
+// An empty Tag means the latest release. Asset may be a glob such as
+// bundle-macos-arm64-*.tar.gz for version-suffixed release assets.
+type GitHubArchive struct {
+	Owner string
+	Repo  string
+	Asset string
+	Tag   string
+}
+
+// GitHubArchive parses archive_url. ok is false when it is not a gh:// URL.
+func (s ServiceConfig) GitHubArchive() (GitHubArchive, bool, error) {
+	return ParseGitHubArchive(s.ArchiveURL)
+}
+
+// ParseGitHubArchive parses a gh://owner/repo/asset[@tag] spec. ok is false
+// (with a nil error) when raw is not a gh:// URL.
+func ParseGitHubArchive(raw string) (GitHubArchive, bool, error) {
+	const ghPrefix = "gh://"
+	s := strings.TrimSpace(raw)
+	if !strings.HasPrefix(s, ghPrefix) {
+		return GitHubArchive{}, false, nil
+	}
+
+	parts := strings.Split(strings.TrimPrefix(s, ghPrefix), "/")
+	if len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+		return GitHubArchive{}, true, fmt.Errorf("invalid GitHub archive_url %q (expected gh://owner/repo/asset-name[@tag])", raw)
+	}
+
+	gh := GitHubArchive{Owner: parts[0], Repo: parts[1], Asset: parts[2]}
+	if idx := strings.Index(gh.Asset, "@"); idx >= 0 {
+		gh.Tag = strings.TrimSpace(gh.Asset[idx+1:])
+		gh.Asset = gh.Asset[:idx]
+		if gh.Asset == "" || gh.Tag == "" {
+			return GitHubArchive{}, true, fmt.Errorf("invalid GitHub archive_url %q (asset name and tag must not be empty)", raw)
+		}
+	}
+	if _, err := path.Match(gh.Asset, ""); err != nil {
+		return GitHubArchive{}, true, fmt.Errorf("invalid GitHub archive_url %q: bad asset pattern: %w", raw, err)
+	}
+	return gh, true, nil
+}
+
+// IsPattern reports whether Asset is a glob rather than an exact name.
+func (g GitHubArchive) IsPattern() bool {
+	return strings.ContainsAny(g.Asset, "*?[")
+}
+
+// MatchAsset returns the single release asset name matching Asset. It fails
+// when no asset or more than one asset matches.
+func (g GitHubArchive) MatchAsset(names []string) (string, error) {
+	var matched []string
+	for _, name := range names {
+		if ok, _ := path.Match(g.Asset, name); ok {
+			matched = append(matched, name)
+		}
+	}
+	switch len(matched) {
+	case 0:
+		return "", fmt.Errorf("no release asset matches %q", g.Asset)
+	case 1:
+		return matched[0], nil
+	default:
+		return "", fmt.Errorf("release asset pattern %q is ambiguous: matches %s", g.Asset, strings.Join(matched, ", "))
+	}
+}
+
+// PrimaryServiceName is the name of the service configured by
+// globals.service. It is reserved in globals.services.
+const PrimaryServiceName = "imsg"
+
+var serviceNamePattern = regexp.MustCompile(`^[a-z][a-z0-9-]*$`)
+
+// ServiceDefinition describes an additional per-user service. Each service is
+// installed under ~/services/<name> and runs as com.<name>.server.<user>; only
+// the primary service is exposed through frpc.
+type ServiceDefinition struct {
+	Name       string `json:"name"`
 	ArchiveURL string `json:"archive_url"`
-	StartPort  int    `json:"start_port"`
+	// ArchiveStrip is the number of leading path components stripped when
+	// extracting the bundle. Nil means the default of 1.
+	ArchiveStrip *int `json:"archive_strip,omitempty"`
+	// Binary is the server executable, relative to the extracted bundle.
+	Binary string `json:"binary"`
+	// StartPort is the local port of the first user; user N listens on
+	// StartPort+N-1, like globals.service.start_port.
+	StartPort int `json:"start_port"`
+}
+
+// ServiceConfig returns base with the bundle and port settings of d, so the
+// archive helpers used for the primary service can be reused.
+func (d ServiceDefinition) ServiceConfig(base ServiceConfig) ServiceConfig {
+	base.ArchiveURL = d.ArchiveURL
+	base.ArchiveStrip = d.ArchiveStrip
+	base.StartPort = d.StartPort
+	return base
+}
+
+func (d ServiceDefinition) validate() error {
+	if !serviceNamePattern.MatchString(d.Name) {
+		return fmt.Errorf("globals.services name %q must be lower-case letters, digits and dashes", d.Name)
+	}
+	if d.Name == PrimaryServiceName {
+		return fmt.Errorf("globals.services name %q is reserved for globals.service", d.Name)
+	}
+	if d.ArchiveURL == "" {
+		return fmt.Errorf("globals.services[%s].archive_url is required", d.Name)
+	}
+	if _, _, err := ParseGitHubArchive(d.ArchiveURL); err != nil {
+		return fmt.Errorf("globals.services[%s].archive_url: %w", d.Name, err)
+	}
+	if d.ArchiveStrip != nil && *d.ArchiveStrip < 0 {
+		return fmt.Errorf("globals.services[%s].archive_strip must not be negative", d.Name)
+	}
+	bin := filepath.Clean(d.Binary)
+	if d.Binary == "" || filepath.IsAbs(bin) || bin == ".." || strings.HasPrefix(bin, "../") {
+		return fmt.Errorf("globals.services[%s].binary must be a path inside the bundle", d.Name)
+	}
+	if d.StartPort <= 0 || d.StartPort > 65535 {
+		return fmt.Errorf("globals.services[%s].start_port must be between 1 and 65535", d.Name)
+	}
+	return nil
 }
 
 type NexusConfig struct {
-	BaseURL string `json:"base_url"`
+	BaseURL        string `json:"base_url"`
+	KeyCreatePath  string `json:"key_create_path,omitempty"`
+	TimeoutSeconds int    `json:"timeout_seconds,omitempty"`
+	// AuthToken is sent as a Bearer token on Nexus calls. The NEXUS_TOKEN
+	// environment variable overrides it.
+	AuthToken string `json:"auth_token,omitempty"`
+	// ClientCert and ClientKey enable mutual TLS; both must be set together
+	// and be readable by every Prism user.
+	ClientCert string `json:"client_cert,omitempty"`
+	ClientKey  string `json:"client_key,omitempty"`
+}
+
+// PreflightConfig tunes the preflight checks.
+type PreflightConfig struct {
+	// Skip lists checks to leave out, e.g. "sip" in a test VM where SIP
+	// cannot be disabled. Every check runs by default.
+	Skip []string `json:"skip,omitempty"`
+}
+
+// PreflightChecks are the names accepted in globals.preflight.skip, in the
+// order preflight runs them.
+var PreflightChecks = []string{"arch", "sip", "boot_args", "library_validation"}
+
+func (p PreflightConfig) validate() error {
+	for _, name := range p.Skip {
+		if !slices.Contains(PreflightChecks, name) {
+			return fmt.Errorf("globals.preflight.skip: unknown check %q (valid: %s)", name, strings.Join(PreflightChecks, ", "))
+		}
+	}
+	return nil
 }
 
+// FastLoginConfig tunes the script that logs every user into a GUI session
+// over VNC. Zero values keep the script's defaults, which suit the Screen
+// Sharing app of current macOS releases.
+type FastLoginConfig struct {
+	// ConnectWaitSeconds is how long the script waits after opening a
+	// vnc:// URL before typing into the authentication window.
+	ConnectWaitSeconds int `json:"connect_wait_seconds,omitempty"`
+	// KeystrokeDelayMs separates the keystrokes of the login.
+	KeystrokeDelayMs int `json:"keystroke_delay_ms,omitempty"`
+	// LoginWaitSeconds is how long a login may take before the script
+	// checks that the user has a GUI session.
+	LoginWaitSeconds int `json:"login_wait_seconds,omitempty"`
+	// Attempts bounds the logins tried per user.
+	Attempts int `json:"attempts,omitempty"`
+}
+
+// ConnectWait returns ConnectWaitSeconds as a duration.
+func (f FastLoginConfig) ConnectWait() time.Duration {
+	return time.Duration(f.ConnectWaitSeconds) * time.Second
+}
+
+// KeystrokeDelay returns KeystrokeDelayMs as a duration.
+func (f FastLoginConfig) KeystrokeDelay() time.Duration {
+	return time.Duration(f.KeystrokeDelayMs) * time.Millisecond
+}
+
+// LoginWait returns LoginWaitSeconds as a duration.
+func (f FastLoginConfig) LoginWait() time.Duration {
+	return time.Duration(f.LoginWaitSeconds) * time.Second
+}
+
+func (f FastLoginConfig) validate() error {
+	for _, v := range []struct {
+		name  string
+		value int
+	}{
+		{"connect_wait_seconds", f.ConnectWaitSeconds},
+		{"keystroke_delay_ms", f.KeystrokeDelayMs},
+		{"login_wait_seconds", f.LoginWaitSeconds},
+		{"attempts", f.Attempts},
+	} {
+		if v.value < 0 {
+			return fmt.Errorf("globals.fast_login.%s must not be negative", v.name)
+		}
+	}
+	return nil
+}
+
+// MetricsConfig enables a Prometheus textfile for node_exporter's textfile
+// collector. Metrics are written only when TextfilePath is set.
+type MetricsConfig struct {
+	TextfilePath string `json:"textfile_path,omitempty"`
+	// IntervalSeconds is how often host-autoboot rewrites the file. Zero
+	// means DefaultMetricsInterval.
+	IntervalSeconds int `json:"interval_seconds,omitempty"`
+}
+
+// DefaultMetricsInterval is used when globals.metrics.interval_seconds is
+// unset.
+const DefaultMetricsInterval = time.Minute
+
+// Interval returns how often the metrics textfile is rewritten.
+func (m MetricsConfig) Interval() time.Duration {
+	if m.IntervalSeconds <= 0 {
+		return DefaultMetricsInterval
+	}
+	return time.Duration(m.IntervalSeconds) * time.Second
+}
+
+func (m MetricsConfig) validate() error {
+	if m.TextfilePath != "" {
+		if !filepath.IsAbs(m.TextfilePath) {
+			return fmt.Errorf("globals.metrics.textfile_path %q must be an absolute path", m.TextfilePath)
+		}
+		if filepath.Ext(m.TextfilePath) != ".prom" {
+			return fmt.Errorf("globals.metrics.textfile_path %q must end in .prom", m.TextfilePath)
+		}
+	}
+	if m.IntervalSeconds < 0 {
+		return errors.New("globals.metrics.interval_seconds must not be negative")
+	}
+	return nil
+}
+
+// ErrInvalid matches every error returned by Load except read failures
+// caused by missing privileges, so callers can tell a bad prism.json apart
+// from other failures with errors.Is.
+var ErrInvalid = errors.New("invalid config")
+
+// invalidError marks err as ErrInvalid without changing its message.
+type invalidError struct{ err error }
+
+func (e invalidError) Error() string        { return e.err.Error() }
+func (e invalidError) Unwrap() error        { return e.err }
+func (e invalidError) Is(target error) bool { return target == ErrInvalid }
+
 // Load reads and validates configuration from the given path.
 func Load(path string) (Config, error) {
+	cfg, err := load(path)
+	if err != nil && !errors.Is(err, fs.ErrPermission) {
+		return Config{}, invalidError{err}
+	}
+	return cfg, err
+}
+
+func load(path string) (Config, error) {
 	if path == "" {
 		return Config{}, errors.New("config path is empty")
 	}
 
-	data, err := os.ReadFile(path)
+	data, err := readMergedConfig(path, map[string]bool{})
 	if err != nil {
-		return Config{}, fmt.Errorf("read config: %w", err)
+		return Config{}, err
 	}
 
 	var cfg Config
@@ -54,6 +542,10 @@ func Load(path string) (Config, error) {
 		return Config{}, fmt.Errorf("decode config: %w", err)
 	}
 
+	if err := cfg.applyEnvOverrides(); err != nil {
+		return Config{}, err
+	}
+
 	if err := cfg.Validate(); err != nil {
 		return Config{}, err
 	}
@@ -61,6 +553,166 @@ func Load(path string) (Config, error) {
 	return cfg, nil
 }
 
+// Scheme returns the scheme of user-facing URLs, defaulting to https.
+func (g Globals) Scheme() string {
+	if g.DomainScheme == "" {
+		return "https"
+	}
+	return g.DomainScheme
+}
+
+// PublicURL returns the user-facing URL for fullDomain, or "" when the domain
+// is empty.
+func (g Globals) PublicURL(fullDomain string) string {
+	if fullDomain == "" {
+		return ""
+	}
+	return g.Scheme() + "://" + fullDomain
+}
+
+// StatusAddr returns the listen address of the status endpoint, binding
+// loopback when StatusListen gives no host. It is empty when disabled.
+func (g Globals) StatusAddr() string {
+	listen := strings.TrimSpace(g.StatusListen)
+	if listen == "" {
+		return ""
+	}
+	if _, err := strconv.Atoi(listen); err == nil {
+		return net.JoinHostPort("127.0.0.1", listen)
+	}
+	host, port, err := net.SplitHostPort(listen)
+	if err != nil || host != "" {
+		return listen
+	}
+	return net.JoinHostPort("127.0.0.1", port)
+}
+
+// envOverride maps an environment variable to the config field it replaces.
+type envOverride struct {
+	name string
+	str  func(*Globals) *string
+	num  func(*Globals) *int
+}
+
+// envOverrides lists the PRISM_* variables that take precedence over
+// prism.json when set to a non-empty value.
+var envOverrides = []envOverride{
+	{name: "PRISM_MACHINE_ID", str: func(g *Globals) *string { return &g.MachineID }},
+	{name: "PRISM_DEFAULT_PASSWORD", str: func(g *Globals) *string { return &g.DefaultPassword }},
+	{name: "PRISM_DOMAIN_SUFFIX", str: func(g *Globals) *string { return &g.DomainSuffix }},
+	{name: "PRISM_DOMAIN_SCHEME", str: func(g *Globals) *string { return &g.DomainScheme }},
+	{name: "PRISM_FRPC_SERVER_ADDR", str: func(g *Globals) *string { return &g.FRPC.ServerAddr }},
+	{name: "PRISM_FRPC_SERVER_PORT", num: func(g *Globals) *int { return &g.FRPC.ServerPort }},
+	{name: "PRISM_ARCHIVE_URL", str: func(g *Globals) *string { return &g.Service.ArchiveURL }},
+	{name: "PRISM_START_PORT", num: func(g *Globals) *int { return &g.Service.StartPort }},
+	{name: "PRISM_NEXUS_BASE_URL", str: func(g *Globals) *string { return &g.Nexus.BaseURL }},
+}
+
+// applyEnvOverrides replaces config fields with the values of the set
+// PRISM_* variables in envOverrides.
+func (c *Config) applyEnvOverrides() error {
+	for _, o := range envOverrides {
+		v := strings.TrimSpace(os.Getenv(o.name))
+		if v == "" {
+			continue
+		}
+		if o.num != nil {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return fmt.Errorf("%s %q must be an integer", o.name, v)
+			}
+			*o.num(&c.Globals) = n
+			continue
+		}
+		*o.str(&c.Globals) = v
+	}
+	return nil
+}
+
+// readMergedConfig reads the config at path. When it has an "extends" field
+// naming a base config (relative paths resolve against path's directory), the
+// base is read first and each key of the local "globals" replaces the base's
+// key of the same name; nested objects such as "service" are replaced whole.
+// visiting holds the files of the current chain to detect cycles.
+func readMergedConfig(path string, visiting map[string]bool) ([]byte, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, fmt.Errorf("resolve config path %s: %w", path, err)
+	}
+	if visiting[abs] {
+		return nil, fmt.Errorf("config extends cycle: %s is already included", abs)
+	}
+	visiting[abs] = true
+
+	data, err := os.ReadFile(abs)
+	if err != nil {
+		return nil, fmt.Errorf("read config: %w", err)
+	}
+
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("decode config %s: %w", abs, err)
+	}
+	rawBase, ok := doc["extends"]
+	if !ok {
+		return data, nil
+	}
+	delete(doc, "extends")
+
+	var basePath string
+	if err := json.Unmarshal(rawBase, &basePath); err != nil || strings.TrimSpace(basePath) == "" {
+		return nil, fmt.Errorf("config %s: extends must be a non-empty path", abs)
+	}
+	if !filepath.IsAbs(basePath) {
+		basePath = filepath.Join(filepath.Dir(abs), basePath)
+	}
+	baseData, err := readMergedConfig(basePath, visiting)
+	if err != nil {
+		return nil, err
+	}
+
+	var base map[string]json.RawMessage
+	if err := json.Unmarshal(baseData, &base); err != nil {
+		return nil, fmt.Errorf("decode config %s: %w", basePath, err)
+	}
+	if base == nil {
+		base = map[string]json.RawMessage{}
+	}
+	for k, v := range doc {
+		if k != "globals" {
+			base[k] = v
+		}
+	}
+	if localGlobals, ok := doc["globals"]; ok {
+		var globals, overrides map[string]json.RawMessage
+		if raw, ok := base["globals"]; ok {
+			if err := json.Unmarshal(raw, &globals); err != nil {
+				return nil, fmt.Errorf("decode config %s: globals: %w", basePath, err)
+			}
+		}
+		if err := json.Unmarshal(localGlobals, &overrides); err != nil {
+			return nil, fmt.Errorf("decode config %s: globals: %w", abs, err)
+		}
+		if globals == nil {
+			globals = map[string]json.RawMessage{}
+		}
+		for k, v := range overrides {
+			globals[k] = v
+		}
+		merged, err := json.Marshal(globals)
+		if err != nil {
+			return nil, fmt.Errorf("merge config %s: %w", abs, err)
+		}
+		base["globals"] = merged
+	}
+
+	merged, err := json.Marshal(base)
+	if err != nil {
+		return nil, fmt.Errorf("merge config %s: %w", abs, err)
+	}
+	return merged, nil
+}
+
 func (c Config) Validate() error {
 	if c.Globals.MachineID == "" {
 		return errors.New("globals.machine_id is required")
@@ -74,17 +726,124 @@ func (c Config) Validate() error {
 		return errors.New("globals.domain_suffix is required")
 	}
 
+	switch c.Globals.DomainScheme {
+	case "", "http", "https":
+	default:
+		return fmt.Errorf("globals.domain_scheme %q must be http or https", c.Globals.DomainScheme)
+	}
+
 	if err := c.Globals.Service.validate(); err != nil {
 		return err
 	}
 
+	seen := map[string]bool{}
+	for _, d := range c.Globals.Services {
+		if err := d.validate(); err != nil {
+			return err
+		}
+		if seen[d.Name] {
+			return fmt.Errorf("globals.services name %q is used more than once", d.Name)
+		}
+		seen[d.Name] = true
+	}
+
 	if err := c.Globals.Nexus.validate(); err != nil {
 		return err
 	}
 
+	if err := c.Globals.Metrics.validate(); err != nil {
+		return err
+	}
+
+	if err := c.Globals.Preflight.validate(); err != nil {
+		return err
+	}
+
+	if err := c.Globals.FastLogin.validate(); err != nil {
+		return err
+	}
+
+	if addr := c.Globals.StatusAddr(); addr != "" {
+		_, port, err := net.SplitHostPort(addr)
+		if n, perr := strconv.Atoi(port); err != nil || perr != nil || n <= 0 || n > 65535 {
+			return fmt.Errorf("globals.status_listen %q must be host:port or a port", c.Globals.StatusListen)
+		}
+	}
+
+	if err := c.validatePorts(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// validatePorts checks that the per-user port range fits in the valid port
+// space and does not overlap the frpc server port, a local Nexus port or the
+// range of another service. The range is only known when max_users is set;
+// without it only the start ports of the services are compared, since equal
+// ones always collide.
+func (c Config) validatePorts() error {
+	if c.Globals.Service.MaxUsers <= 0 {
+		starts := map[int]string{c.Globals.Service.StartPort: "globals.service"}
+		for _, d := range c.Globals.Services {
+			name := "globals.services[" + d.Name + "]"
+			if other, ok := starts[d.StartPort]; ok {
+				return fmt.Errorf("%s.start_port %d is also the start_port of %s", name, d.StartPort, other)
+			}
+			starts[d.StartPort] = name
+		}
+		return nil
+	}
+
+	first, last := c.Globals.Service.UserPortRange()
+	if last > 65535 {
+		return fmt.Errorf("globals.service.start_port %d with max_users %d exceeds port 65535", first, last-first+1)
+	}
+
+	if p := c.Globals.FRPC.ServerPort; p >= first && p <= last {
+		return fmt.Errorf("globals.frpc.server_port %d overlaps the user port range %d-%d (globals.service.start_port)", p, first, last)
+	}
+
+	if u, err := url.Parse(c.Globals.Nexus.BaseURL); err == nil && isLoopbackHost(u.Hostname()) {
+		if p, err := strconv.Atoi(u.Port()); err == nil && p >= first && p <= last {
+			return fmt.Errorf("globals.nexus.base_url port %d overlaps the user port range %d-%d (globals.service.start_port)", p, first, last)
+		}
+	}
+
+	// Each additional service gets a range of the same size, which must not
+	// collide with the primary range, the frpc port or another service.
+	type portRange struct {
+		name        string
+		first, last int
+	}
+	ranges := []portRange{{name: "globals.service", first: first, last: last}}
+	for _, d := range c.Globals.Services {
+		r := portRange{name: "globals.services[" + d.Name + "]", first: d.StartPort, last: d.StartPort + last - first}
+		if r.last > 65535 {
+			return fmt.Errorf("%s.start_port %d with max_users %d exceeds port 65535", r.name, r.first, last-first+1)
+		}
+		if p := c.Globals.FRPC.ServerPort; p >= r.first && p <= r.last {
+			return fmt.Errorf("globals.frpc.server_port %d overlaps the %s port range %d-%d", p, r.name, r.first, r.last)
+		}
+		for _, o := range ranges {
+			if r.first <= o.last && o.first <= r.last {
+				return fmt.Errorf("%s port range %d-%d overlaps the %s port range %d-%d", r.name, r.first, r.last, o.name, o.first, o.last)
+			}
+		}
+		ranges = append(ranges, r)
+	}
+
 	return nil
 }
 
+func isLoopbackHost(host string) bool {
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
 func (c FRPCConfig) validate() error {
 	if c.ServerAddr == "" {
 		return errors.New("globals.frpc.server_addr is required")
@@ -94,18 +853,168 @@ func (c FRPCConfig) validate() error {
 		return errors.New("globals.frpc.server_port must be between 1 and 65535")
 	}
 
+	if c.FriendlyNameRefreshMinutes < 0 {
+		return errors.New("globals.frpc.friendly_name_refresh_minutes must not be negative")
+	}
+
+	return c.Transport.validate()
+}
+
+func (t FRPCTransportConfig) validate() error {
+	if t.Protocol != "" && !slices.Contains(frpcProtocols, t.Protocol) {
+		return fmt.Errorf("globals.frpc.transport.protocol %q must be one of %s", t.Protocol, strings.Join(frpcProtocols, ", "))
+	}
+
+	tlsOff := t.TLSEnable != nil && !*t.TLSEnable
+	if tlsOff && (t.TLSServerName != "" || t.TLSTrustedCAFile != "") {
+		return errors.New("globals.frpc.transport.tls_server_name and tls_trusted_ca_file need TLS, but tls_enable is false")
+	}
+	if t.TLSTrustedCAFile != "" && !filepath.IsAbs(t.TLSTrustedCAFile) {
+		return errors.New("globals.frpc.transport.tls_trusted_ca_file must be an absolute path")
+	}
+
+	if t.HeartbeatIntervalSeconds < -1 {
+		return errors.New("globals.frpc.transport.heartbeat_interval_seconds must be positive, or -1 to disable heartbeats")
+	}
+	if t.HeartbeatTimeoutSeconds < 0 {
+		return errors.New("globals.frpc.transport.heartbeat_timeout_seconds must not be negative")
+	}
+	if t.HeartbeatIntervalSeconds == -1 && t.HeartbeatTimeoutSeconds > 0 {
+		return errors.New("globals.frpc.transport.heartbeat_timeout_seconds has no effect when heartbeat_interval_seconds is -1")
+	}
+	if t.HeartbeatIntervalSeconds > 0 && t.HeartbeatTimeoutSeconds > 0 && t.HeartbeatTimeoutSeconds <= t.HeartbeatIntervalSeconds {
+		return errors.New("globals.frpc.transport.heartbeat_timeout_seconds must be greater than heartbeat_interval_seconds")
+	}
+
+	if t.PoolCount < 0 {
+		return errors.New("globals.frpc.transport.pool_count must not be negative")
+	}
+
 	return nil
 }
 
+// validateLocalIP checks that ip is an IPv4 address servers can bind and
+// frpc can forward to. Whether this host has it is only a warning (see
+// localIPAssigned), so a config can be checked on another machine.
+func validateLocalIP(ip string) error {
+	parsed := net.ParseIP(strings.TrimSpace(ip))
+	if parsed == nil {
+		return fmt.Errorf("%q is not an IP address", ip)
+	}
+	if parsed.To4() == nil {
+		return fmt.Errorf("%s is not an IPv4 address", ip)
+	}
+	if parsed.IsUnspecified() {
+		return fmt.Errorf("%s is not a bindable local address; frpc cannot forward to it", ip)
+	}
+	return nil
+}
+
+// localIPAssigned reports whether ip is a loopback address or one assigned
+// to an interface of this host.
+func localIPAssigned(ip string) bool {
+	parsed := net.ParseIP(strings.TrimSpace(ip))
+	if parsed == nil {
+		return false
+	}
+	if parsed.IsLoopback() {
+		return true
+	}
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return false
+	}
+	for _, a := range addrs {
+		if n, ok := a.(*net.IPNet); ok && n.IP.Equal(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
 func (s ServiceConfig) validate() error {
 	if s.ArchiveURL == "" {
 		return errors.New("globals.service.archive_url is required")
 	}
 
+	if _, _, err := s.GitHubArchive(); err != nil {
+		return fmt.Errorf("globals.service.archive_url: %w", err)
+	}
+
 	if s.StartPort <= 0 || s.StartPort > 65535 {
 		return errors.New("globals.service.start_port must be between 1 and 65535")
 	}
 
+	if s.BaseUID < 0 {
+		return errors.New("globals.service.base_uid must not be negative")
+	}
+
+	if sh := s.Shell(); !filepath.IsAbs(sh) {
+		return fmt.Errorf("globals.service.wrapper_shell %q must be an absolute path", sh)
+	}
+
+	for k := range s.WrapperEnv {
+		if !envNamePattern.MatchString(k) {
+			return fmt.Errorf("globals.service.wrapper_env key %q is not a valid variable name", k)
+		}
+	}
+
+	for k, v := range s.Env {
+		if !envNamePattern.MatchString(k) {
+			return fmt.Errorf("globals.service.env key %q is not a valid variable name", k)
+		}
+		if reservedServerEnv[k] {
+			return fmt.Errorf("globals.service.env key %q is managed by Prism and cannot be overridden", k)
+		}
+		if !utf8.ValidString(v) || strings.IndexFunc(v, unicode.IsControl) >= 0 {
+			return fmt.Errorf("globals.service.env value of %q must be printable UTF-8 text", k)
+		}
+	}
+
+	if s.MaxUsers < 0 {
+		return errors.New("globals.service.max_users must not be negative")
+	}
+
+	if s.ArchiveStrip != nil && *s.ArchiveStrip < 0 {
+		return errors.New("globals.service.archive_strip must not be negative")
+	}
+
+	if s.DownloadRateKbps < 0 {
+		return errors.New("globals.service.download_rate_kbps must not be negative")
+	}
+	if s.ProvisionTimeoutMinutes < 0 {
+		return errors.New("globals.service.provision_timeout_minutes must not be negative")
+	}
+	if s.UpdateCheckMinutes < 0 {
+		return errors.New("globals.service.update_check_minutes must not be negative")
+	}
+	if s.BatchCreateMin < 0 {
+		return errors.New("globals.service.batch_create_min must not be negative")
+	}
+	if s.BootstrapStaggerSeconds < 0 {
+		return errors.New("globals.service.bootstrap_stagger_seconds must not be negative")
+	}
+
+	if p := strings.TrimSpace(s.HealthPath); p != "" && !strings.HasPrefix(p, "/") {
+		return fmt.Errorf("globals.service.health_path %q must start with /", s.HealthPath)
+	}
+
+	for _, p := range s.RequiredPaths {
+		if !filepath.IsLocal(p) {
+			return fmt.Errorf("globals.service.required_paths entry %q must be a relative path inside the bundle", p)
+		}
+	}
+
+	if s.LocalIP != "" {
+		if err := validateLocalIP(s.LocalIP); err != nil {
+			return fmt.Errorf("globals.service.local_ip: %w", err)
+		}
+	}
+
+	if s.CacheDir != "" && !filepath.IsAbs(s.CacheDir) {
+		return fmt.Errorf("globals.service.cache_dir %q must be an absolute path", s.CacheDir)
+	}
+
 	return nil
 }
 
@@ -114,5 +1023,73 @@ func (n NexusConfig) validate() error {
 		return errors.New("globals.nexus.base_url is required")
 	}
 
+	u, err := url.Parse(n.BaseURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("globals.nexus.base_url %q must be an absolute http(s) URL", n.BaseURL)
+	}
+
+	if n.KeyCreatePath != "" && !strings.HasPrefix(n.KeyCreatePath, "/") {
+		return errors.New("globals.nexus.key_create_path must start with /")
+	}
+
+	if n.TimeoutSeconds < 0 {
+		return errors.New("globals.nexus.timeout_seconds must not be negative")
+	}
+
+	if (n.ClientCert == "") != (n.ClientKey == "") {
+		return errors.New("globals.nexus.client_cert and globals.nexus.client_key must be set together")
+	}
+
 	return nil
 }
+
+// Ephemeral port range macOS hands out for outgoing connections. User ports
+// inside it can be taken by an unrelated client before the server binds.
+const (
+	ephemeralPortFirst = 49152
+	ephemeralPortLast  = 65535
+)
+
+// Warnings reports values that pass Validate but are likely mistakes.
+func (c Config) Warnings() []string {
+	var warnings []string
+
+	first, last := c.Globals.Service.UserPortRange()
+	if last >= ephemeralPortFirst && first <= ephemeralPortLast {
+		warnings = append(warnings, fmt.Sprintf("user port range %d-%d overlaps the ephemeral range %d-%d (globals.service.start_port)", first, last, ephemeralPortFirst, ephemeralPortLast))
+	}
+	for _, d := range c.Globals.Services {
+		if end := d.StartPort + last - first; end >= ephemeralPortFirst {
+			warnings = append(warnings, fmt.Sprintf("globals.services[%s] port range %d-%d overlaps the ephemeral range %d-%d", d.Name, d.StartPort, end, ephemeralPortFirst, ephemeralPortLast))
+		}
+	}
+
+	if ip := c.Globals.Service.LocalIP; ip != "" && !localIPAssigned(ip) {
+		warnings = append(warnings, fmt.Sprintf("globals.service.local_ip %s is not assigned to any interface of this host", ip))
+	}
+
+	if u, err := url.Parse(c.Globals.Nexus.BaseURL); err == nil && u.Scheme == "http" && !isLoopbackHost(u.Hostname()) {
+		warnings = append(warnings, fmt.Sprintf("globals.nexus.base_url %q uses plain http to a remote host", c.Globals.Nexus.BaseURL))
+	}
+
+	if host, _, err := net.SplitHostPort(c.Globals.StatusAddr()); err == nil && !isLoopbackHost(host) {
+		warnings = append(warnings, fmt.Sprintf("globals.status_listen %q exposes host status beyond loopback", c.Globals.StatusListen))
+	}
+
+	if strings.HasPrefix(c.Globals.DomainSuffix, ".") || strings.HasSuffix(c.Globals.DomainSuffix, ".") {
+		warnings = append(warnings, fmt.Sprintf("globals.domain_suffix %q has a leading or trailing dot", c.Globals.DomainSuffix))
+	}
+
+	if t := c.Globals.FRPC.Transport; t.TLSEnable != nil && !*t.TLSEnable && t.Protocol != "wss" {
+		warnings = append(warnings, "globals.frpc.transport.tls_enable is false: tunnel traffic to frps is unencrypted")
+	}
+	if p := c.Globals.FRPC.Transport.PoolCount; p > frpsDefaultMaxPoolCount {
+		warnings = append(warnings, fmt.Sprintf("globals.frpc.transport.pool_count %d exceeds frps' default transport.maxPoolCount of %d", p, frpsDefaultMaxPoolCount))
+	}
+
+	if skip := c.Globals.Preflight.Skip; len(skip) > 0 {
+		warnings = append(warnings, fmt.Sprintf("globals.preflight.skip disables %s: setup no longer guarantees the host can run the services", strings.Join(skip, ", ")))
+	}
+
+	return warnings
+}
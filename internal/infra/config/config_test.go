@@ -0,0 +1,143 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMatchAsset(t *testing.T) {
+	names := []string{"bundle-1.2-arm64.tar.gz", "bundle-1.2-x86_64.tar.gz", "checksums.txt"}
+	tests := []struct {
+		asset   string
+		want    string
+		wantErr string
+	}{
+		{asset: "bundle-*-arm64.tar.gz", want: "bundle-1.2-arm64.tar.gz"},
+		{asset: "checksums.txt", want: "checksums.txt"},
+		{asset: "bundle-*.tar.gz", wantErr: "is ambiguous: matches bundle-1.2-arm64.tar.gz, bundle-1.2-x86_64.tar.gz"},
+		{asset: "app-*.zip", wantErr: "no release asset matches"},
+	}
+	for _, tt := range tests {
+		got, err := GitHubArchive{Asset: tt.asset}.MatchAsset(names)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("MatchAsset(%q) error = %v, want %q", tt.asset, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("MatchAsset(%q) = %q, %v; want %q", tt.asset, got, err, tt.want)
+		}
+	}
+}
+
+func TestLoadFastLogin(t *testing.T) {
+	const base = `{"globals": {
+		"machine_id": "mac",
+		"domain_suffix": "example.com",
+		"frpc": {"server_addr": "frps.example.com", "server_port": 7000},
+		"service": {"archive_url": "https://example.com/bundle.tar.gz", "start_port": 20000},
+		"nexus": {"base_url": "https://nexus.example.com"},
+		"fast_login": %s
+	}}`
+	tests := []struct {
+		name    string
+		json    string
+		want    FastLoginConfig
+		wantErr string
+	}{
+		{name: "unset", json: `{}`},
+		{
+			name: "all keys",
+			json: `{"connect_wait_seconds": 10, "keystroke_delay_ms": 800, "login_wait_seconds": 15, "attempts": 5}`,
+			want: FastLoginConfig{ConnectWaitSeconds: 10, KeystrokeDelayMs: 800, LoginWaitSeconds: 15, Attempts: 5},
+		},
+		{name: "negative delay", json: `{"keystroke_delay_ms": -1}`, wantErr: "globals.fast_login.keystroke_delay_ms must not be negative"},
+		{name: "negative attempts", json: `{"attempts": -2}`, wantErr: "globals.fast_login.attempts must not be negative"},
+		{name: "unknown key", json: `{"retries": 2}`, wantErr: `unknown field "retries"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "prism.json")
+			if err := os.WriteFile(path, []byte(fmt.Sprintf(base, tt.json)), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			cfg, err := Load(path)
+			if tt.wantErr != "" {
+				if !errors.Is(err, ErrInvalid) || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Load() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Load() error = %v", err)
+			}
+			if cfg.Globals.FastLogin != tt.want {
+				t.Errorf("FastLogin = %+v, want %+v", cfg.Globals.FastLogin, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidatePorts(t *testing.T) {
+	tests := []struct {
+		name      string
+		startPort int
+		maxUsers  int
+		frpcPort  int
+		nexusURL  string
+		services  []ServiceDefinition
+		wantErr   string
+	}{
+		{name: "unset max_users near the top", startPort: 65500},
+		{name: "unset max_users near frpc", startPort: 7000, frpcPort: 7050},
+		{name: "unset max_users near local nexus", startPort: 20000, nexusURL: "http://127.0.0.1:20010"},
+		{
+			name:      "unset max_users same service start",
+			startPort: 20000,
+			services:  []ServiceDefinition{{Name: "sidecar", StartPort: 20000}},
+			wantErr:   "globals.services[sidecar].start_port 20000 is also the start_port of globals.service",
+		},
+		{
+			name:      "unset max_users distinct service start",
+			startPort: 20000,
+			services:  []ServiceDefinition{{Name: "sidecar", StartPort: 20010}},
+		},
+		{name: "range past 65535", startPort: 65500, maxUsers: 50, wantErr: "exceeds port 65535"},
+		{name: "range overlaps frpc", startPort: 7000, maxUsers: 100, frpcPort: 7050, wantErr: "globals.frpc.server_port 7050 overlaps"},
+		{name: "range overlaps local nexus", startPort: 20000, maxUsers: 20, nexusURL: "http://127.0.0.1:20010", wantErr: "globals.nexus.base_url port 20010 overlaps"},
+		{name: "remote nexus in range", startPort: 20000, maxUsers: 20, nexusURL: "https://nexus.example.com:20010"},
+		{
+			name:      "service ranges overlap",
+			startPort: 20000,
+			maxUsers:  20,
+			services:  []ServiceDefinition{{Name: "sidecar", StartPort: 20010}},
+			wantErr:   "globals.services[sidecar] port range 20010-20029 overlaps the globals.service port range 20000-20019",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var c Config
+			c.Globals.Service.StartPort = tt.startPort
+			c.Globals.Service.MaxUsers = tt.maxUsers
+			c.Globals.FRPC.ServerPort = tt.frpcPort
+			c.Globals.Nexus.BaseURL = tt.nexusURL
+			c.Globals.Services = tt.services
+
+			err := c.validatePorts()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("validatePorts() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("validatePorts() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
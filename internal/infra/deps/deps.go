@@ -255,6 +255,15 @@ func ensureNode(ctx context.Context, r Runner, hasBrew bool) Item {
 	}
 }
 
+// EnsureFRPC installs frpc with an existing Homebrew if it is missing. Unlike
+// Ensure it never installs Homebrew itself, so it is safe to call late in
+// provisioning.
+func EnsureFRPC(ctx context.Context) Item {
+	r := newCmdRunner()
+	_, err := r.Run(ctx, "brew", "--version")
+	return ensureFRPC(ctx, r, err == nil)
+}
+
 func ensureFRPC(ctx context.Context, r Runner, hasBrew bool) Item {
 	out, err := r.Run(ctx, "frpc", "-v")
 	if err == nil && out != "" {
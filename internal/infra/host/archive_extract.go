@@ -0,0 +1,178 @@
+//go:build darwin
+
+package host
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// extractTarGz extracts a gzip-compressed tarball into destDir, dropping the
+// first strip path components of every entry like tar --strip-components.
+// File modes (including executable bits) and symlinks are preserved. Entries,
+// symlink targets and hard link targets that would escape destDir are
+// rejected, and so are entries under a directory that is a symlink.
+func extractTarGz(ctx context.Context, archivePath, destDir string, strip int) error {
+	f, err := os.Open(archivePath)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return fmt.Errorf("open gzip stream: %w", err)
+	}
+	defer func() { _ = gz.Close() }()
+
+	root, err := filepath.Abs(destDir)
+	if err != nil {
+		return err
+	}
+
+	tr := tar.NewReader(gz)
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("read tar entry: %w", err)
+		}
+
+		rel, ok := stripComponents(hdr.Name, strip)
+		if !ok {
+			continue
+		}
+		target := filepath.Join(root, filepath.FromSlash(rel))
+		if !withinDir(root, target) {
+			return fmt.Errorf("tar entry %q escapes extraction directory", hdr.Name)
+		}
+		// A symlink extracted earlier must not redirect this entry.
+		if err := checkNoSymlinkParents(root, target); err != nil {
+			return fmt.Errorf("tar entry %q: %w", hdr.Name, err)
+		}
+
+		mode := os.FileMode(hdr.Mode).Perm()
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0o755); err != nil {
+				return err
+			}
+			if err := os.Chmod(target, mode|0o700); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+				return err
+			}
+			if err := writeTarFile(tr, target, mode); err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			if filepath.IsAbs(hdr.Linkname) || !withinDir(root, filepath.Join(filepath.Dir(target), filepath.FromSlash(hdr.Linkname))) {
+				return fmt.Errorf("tar symlink %q points outside extraction directory (%s)", hdr.Name, hdr.Linkname)
+			}
+			if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+				return err
+			}
+			_ = os.Remove(target)
+			if err := os.Symlink(hdr.Linkname, target); err != nil {
+				return err
+			}
+		case tar.TypeLink:
+			linkRel, ok := stripComponents(hdr.Linkname, strip)
+			if !ok {
+				return fmt.Errorf("tar hard link %q points outside stripped tree", hdr.Name)
+			}
+			linkTarget := filepath.Join(root, filepath.FromSlash(linkRel))
+			if !withinDir(root, linkTarget) {
+				return fmt.Errorf("tar hard link %q points outside extraction directory", hdr.Name)
+			}
+			if err := checkNoSymlinkParents(root, linkTarget); err != nil {
+				return fmt.Errorf("tar hard link %q: %w", hdr.Name, err)
+			}
+			if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+				return err
+			}
+			_ = os.Remove(target)
+			if err := os.Link(linkTarget, target); err != nil {
+				return err
+			}
+		default:
+			// Device nodes, FIFOs and PAX metadata are not part of service bundles.
+		}
+	}
+}
+
+// withinDir reports whether the cleaned path p is root or inside it.
+func withinDir(root, p string) bool {
+	return p == root || strings.HasPrefix(p, root+string(os.PathSeparator))
+}
+
+// checkNoSymlinkParents fails when a directory between root and target is a
+// symlink, so entries cannot be written through a link planted by an earlier
+// entry.
+func checkNoSymlinkParents(root, target string) error {
+	rel, err := filepath.Rel(root, filepath.Dir(target))
+	if err != nil || rel == "." {
+		return err
+	}
+	dir := root
+	for _, part := range strings.Split(rel, string(os.PathSeparator)) {
+		dir = filepath.Join(dir, part)
+		fi, err := os.Lstat(dir)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if fi.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("parent %s is a symlink", strings.TrimPrefix(dir, root+string(os.PathSeparator)))
+		}
+	}
+	return nil
+}
+
+// stripComponents drops the first n slash-separated components of name and
+// reports false when nothing remains.
+func stripComponents(name string, n int) (string, bool) {
+	clean := path.Clean(strings.TrimPrefix(name, "./"))
+	if clean == "." || clean == "/" {
+		return "", false
+	}
+	parts := strings.Split(strings.TrimPrefix(clean, "/"), "/")
+	if len(parts) <= n {
+		return "", false
+	}
+	return strings.Join(parts[n:], "/"), true
+}
+
+func writeTarFile(r io.Reader, target string, mode os.FileMode) error {
+	out, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, r); err != nil {
+		_ = out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	// OpenFile honours the umask; apply the archived mode explicitly.
+	return os.Chmod(target, mode)
+}
@@ -0,0 +1,200 @@
+//go:build darwin
+
+package host
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// tarEntry is one entry of a fixture tarball.
+type tarEntry struct {
+	name     string
+	typeflag byte
+	body     string
+	linkname string
+	mode     int64
+}
+
+// writeFixture writes entries as a gzip-compressed tarball and returns its
+// path.
+func writeFixture(t testing.TB, entries []tarEntry) string {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for _, e := range entries {
+		mode := e.mode
+		if mode == 0 {
+			mode = 0o644
+			if e.typeflag == tar.TypeDir {
+				mode = 0o755
+			}
+		}
+		hdr := &tar.Header{Name: e.name, Typeflag: e.typeflag, Linkname: e.linkname, Mode: mode, Size: int64(len(e.body))}
+		if e.typeflag != tar.TypeReg {
+			hdr.Size = 0
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatalf("write header %s: %v", e.name, err)
+		}
+		if e.typeflag == tar.TypeReg {
+			if _, err := tw.Write([]byte(e.body)); err != nil {
+				t.Fatalf("write body %s: %v", e.name, err)
+			}
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "fixture.tar.gz")
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestExtractTarGz(t *testing.T) {
+	bundle := []tarEntry{
+		{name: "bundle/", typeflag: tar.TypeDir},
+		{name: "bundle/App.app/Contents/MacOS/server", typeflag: tar.TypeReg, body: "bin", mode: 0o755},
+		{name: "bundle/App.app/Contents/Info.plist", typeflag: tar.TypeReg, body: "plist"},
+		{name: "bundle/App.app/Contents/MacOS/server-link", typeflag: tar.TypeLink, linkname: "bundle/App.app/Contents/MacOS/server"},
+		{name: "bundle/App.app/Contents/Current", typeflag: tar.TypeSymlink, linkname: "MacOS"},
+	}
+
+	tests := []struct {
+		name    string
+		entries []tarEntry
+		strip   int
+		// files maps extracted paths to their expected content.
+		files   map[string]string
+		wantErr string
+	}{
+		{
+			name:    "strip one component",
+			entries: bundle,
+			strip:   1,
+			files: map[string]string{
+				"App.app/Contents/MacOS/server":      "bin",
+				"App.app/Contents/Info.plist":        "plist",
+				"App.app/Contents/MacOS/server-link": "bin",
+				"App.app/Contents/Current/server":    "bin",
+			},
+		},
+		{
+			name:    "no strip",
+			entries: bundle,
+			strip:   0,
+			files:   map[string]string{"bundle/App.app/Contents/MacOS/server": "bin"},
+		},
+		{
+			name:    "entry escapes",
+			entries: []tarEntry{{name: "../evil", typeflag: tar.TypeReg, body: "x"}},
+			wantErr: "escapes extraction directory",
+		},
+		{
+			name:    "absolute symlink",
+			entries: []tarEntry{{name: "etc", typeflag: tar.TypeSymlink, linkname: "/etc"}},
+			wantErr: "points outside extraction directory",
+		},
+		{
+			name:    "relative symlink escapes",
+			entries: []tarEntry{{name: "a/up", typeflag: tar.TypeSymlink, linkname: "../../.."}},
+			wantErr: "points outside extraction directory",
+		},
+		{
+			name: "write through symlinked parent",
+			entries: []tarEntry{
+				{name: "real/", typeflag: tar.TypeDir},
+				{name: "link", typeflag: tar.TypeSymlink, linkname: "real"},
+				{name: "link/file", typeflag: tar.TypeReg, body: "x"},
+			},
+			wantErr: "is a symlink",
+		},
+		{
+			name:    "hard link escapes",
+			entries: []tarEntry{{name: "passwd", typeflag: tar.TypeLink, linkname: "../etc/passwd"}},
+			wantErr: "points outside extraction directory",
+		},
+		{
+			name: "hard link outside stripped tree",
+			entries: []tarEntry{
+				{name: "bundle/file", typeflag: tar.TypeLink, linkname: "top"},
+			},
+			strip:   1,
+			wantErr: "points outside stripped tree",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			archive := writeFixture(t, tt.entries)
+			dest := t.TempDir()
+			err := extractTarGz(context.Background(), archive, dest, tt.strip)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("extractTarGz() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("extractTarGz() error = %v", err)
+			}
+			for rel, want := range tt.files {
+				got, err := os.ReadFile(filepath.Join(dest, rel))
+				if err != nil {
+					t.Fatalf("read %s: %v", rel, err)
+				}
+				if string(got) != want {
+					t.Errorf("%s = %q, want %q", rel, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestExtractTarGzKeepsMode(t *testing.T) {
+	archive := writeFixture(t, []tarEntry{{name: "bin/server", typeflag: tar.TypeReg, body: "x", mode: 0o755}})
+	dest := t.TempDir()
+	if err := extractTarGz(context.Background(), archive, dest, 0); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(filepath.Join(dest, "bin", "server"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0o755 {
+		t.Errorf("mode = %v, want 0755", fi.Mode().Perm())
+	}
+}
+
+func TestStripComponents(t *testing.T) {
+	tests := []struct {
+		name   string
+		n      int
+		want   string
+		wantOK bool
+	}{
+		{"bundle/app/file", 1, "app/file", true},
+		{"./bundle/app/file", 1, "app/file", true},
+		{"bundle/", 1, "", false},
+		{"bundle/app", 2, "", false},
+		{"file", 0, "file", true},
+	}
+	for _, tt := range tests {
+		got, ok := stripComponents(tt.name, tt.n)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("stripComponents(%q, %d) = %q, %v; want %q, %v", tt.name, tt.n, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
@@ -7,7 +7,6 @@ import (
 	"errors"
 	"fmt"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
 )
@@ -18,39 +17,29 @@ const (
 	hostAutobootPlistPath  = "/Library/LaunchDaemons/" + hostAutobootLabel + ".plist"
 	hostAutobootLogPath    = "/var/log/prism-host-autoboot.log"
 	hostAutobootErrLogPath = "/var/log/prism-host-autoboot.err.log"
+
+	hostAutobootBootstrapRetries = 4
 )
 
-const hostAutobootPlistTemplate = `<?xml version="1.0" encoding="UTF-8"?>
-<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
-<plist version="1.0">
-  <dict>
-    <key>Label</key>
-    <string>%s</string>
-    <key>ProgramArguments</key>
-    <array>
-      <string>%s</string>
-      <string>%s</string>
-    </array>
-    <key>RunAtLoad</key>
-    <true/>
-    <key>KeepAlive</key>
-    <dict>
-      <key>SuccessfulExit</key>
-      <false/>
-    </dict>
-    <key>WorkingDirectory</key>
-    <string>%s</string>
-    <key>StandardOutPath</key>
-    <string>%s</string>
-    <key>StandardErrorPath</key>
-    <string>%s</string>
-  </dict>
-</plist>
-`
+// hostAutobootJob is the system-wide host-autoboot LaunchDaemon.
+func hostAutobootJob(prismPath, workingDir string, extraArgs []string) launchdJob {
+	return launchdJob{
+		Label:            hostAutobootLabel,
+		ProgramArguments: append(append([]string{prismPath}, extraArgs...), hostAutobootProgramArg),
+		WorkingDirectory: workingDir,
+		RunAtLoad:        true,
+		// Restart only on failure; a clean exit means autoboot finished.
+		KeepAlive:         plistDict{{Key: "SuccessfulExit", Value: plistBool(false)}},
+		StandardOutPath:   hostAutobootLogPath,
+		StandardErrorPath: hostAutobootErrLogPath,
+	}
+}
 
 // EnsureHostAutobootDaemon installs the system-wide host-autoboot LaunchDaemon.
 // workingDir should point to the directory containing .env for godotenv.Load().
-func EnsureHostAutobootDaemon(ctx context.Context, prismPath, workingDir string) error {
+// extraArgs are global flags passed before the mode, e.g. --config/--state
+// overrides.
+func EnsureHostAutobootDaemon(ctx context.Context, prismPath, workingDir string, extraArgs []string) error {
 	if strings.TrimSpace(prismPath) == "" {
 		return errors.New("prismPath is empty")
 	}
@@ -59,16 +48,7 @@ func EnsureHostAutobootDaemon(ctx context.Context, prismPath, workingDir string)
 		workingDir = filepath.Dir(prismPath)
 	}
 
-	plist := fmt.Sprintf(hostAutobootPlistTemplate,
-		hostAutobootLabel,
-		prismPath,
-		hostAutobootProgramArg,
-		workingDir,
-		hostAutobootLogPath,
-		hostAutobootErrLogPath,
-	)
-
-	if err := os.WriteFile(hostAutobootPlistPath, []byte(plist), 0o644); err != nil {
+	if err := os.WriteFile(hostAutobootPlistPath, hostAutobootJob(prismPath, workingDir, extraArgs).Marshal(), 0o644); err != nil {
 		if os.IsPermission(err) {
 			return nil
 		}
@@ -76,22 +56,16 @@ func EnsureHostAutobootDaemon(ctx context.Context, prismPath, workingDir string)
 	}
 
 	// Try to bootout first to ensure we reload the config if it changed
-	_ = exec.CommandContext(ctx, "launchctl", "bootout", "system/"+hostAutobootLabel).Run()
+	_, _ = runner.Run(ctx, "launchctl", "bootout", "system/"+hostAutobootLabel)
 
-	cmd := exec.CommandContext(ctx, "launchctl", "bootstrap", "system", hostAutobootPlistPath)
-	out, err := cmd.CombinedOutput()
-	if err != nil {
-		output := strings.TrimSpace(string(out))
-		if output != "" && (strings.Contains(output, "already bootstrapped") || strings.Contains(output, "EEXIST")) {
-			return nil
-		}
-		if strings.Contains(strings.ToLower(output), "operation not permitted") || strings.Contains(strings.ToLower(output), "permission denied") {
-			return nil
-		}
-		if strings.Contains(err.Error(), "exit status 5") {
+	// bootout completes asynchronously, so an immediate bootstrap commonly
+	// fails with "5: Input/output error"; retry instead of ignoring it.
+	if err := bootstrapWithRetry(ctx, hostAutobootPlistPath, hostAutobootBootstrapRetries); err != nil {
+		lower := strings.ToLower(err.Error())
+		if strings.Contains(lower, "operation not permitted") || strings.Contains(lower, "permission denied") {
 			return nil
 		}
-		return fmt.Errorf("launchctl bootstrap system: %w (output=%s)", err, output)
+		return fmt.Errorf("launchctl bootstrap system: %w", err)
 	}
 
 	return nil
@@ -0,0 +1,54 @@
+//go:build darwin
+
+package host
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+const autobootLockName = "host-autoboot.lock"
+
+// ErrAutobootRunning means another host-autoboot process holds the lock.
+var ErrAutobootRunning = errors.New("host-autoboot is already running")
+
+// AcquireAutobootLock takes an exclusive flock on outputDir/host-autoboot.lock
+// so only one host-autoboot process runs the update loop at a time. The lock
+// is released by the returned func or when the process exits, so a crashed
+// instance never leaves a stale lock. The file records the holder's PID.
+func AcquireAutobootLock(outputDir string) (func(), error) {
+	if err := os.MkdirAll(outputDir, 0o755); err != nil {
+		return nil, err
+	}
+	path := filepath.Join(outputDir, autobootLockName)
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("open %s: %w", path, err)
+	}
+
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		holder, _ := os.ReadFile(path)
+		_ = f.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			if pid := strings.TrimSpace(string(holder)); pid != "" {
+				return nil, fmt.Errorf("%w (pid %s)", ErrAutobootRunning, pid)
+			}
+			return nil, ErrAutobootRunning
+		}
+		return nil, fmt.Errorf("lock %s: %w", path, err)
+	}
+
+	if err := f.Truncate(0); err == nil {
+		_, _ = f.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+	}
+
+	return func() {
+		_ = syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+		_ = f.Close()
+	}, nil
+}
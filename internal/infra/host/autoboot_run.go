@@ -3,15 +3,34 @@
 package host
 
 import (
+	"context"
+	"fmt"
 	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
 
 	"prism/internal/infra/state"
 )
 
 // RunAutoboot ensures all per-user LaunchDaemons are running at system startup.
 // Called by the host-autoboot LaunchDaemon. Services should already be running
-// via RunAtLoad; this is a safety net to ensure proper bootstrapping.
-func RunAutoboot(statePath string) {
+// via RunAtLoad; this is a safety net to ensure proper bootstrapping. It also
+// restores the host-autoboot daemon and the fast-login agent if they were
+// unloaded. While the host is in maintenance mode the user daemons are left
+// stopped.
+func RunAutoboot(ctx context.Context, statePath string) {
+	ensureHostAutobootLoaded(ctx)
+	ensureFastLoginLoaded(ctx)
+
+	if info, err := ReadMaintenance(filepath.Dir(statePath)); err != nil {
+		log.Printf("[host-autoboot] read maintenance marker: %v", err)
+	} else if info.Active {
+		log.Printf("[host-autoboot] host in maintenance since %s; not bootstrapping user daemons", info.Since.Format(time.RFC3339))
+		return
+	}
+
 	st, err := state.Load(statePath)
 	if err != nil {
 		log.Printf("[host-autoboot] load state: %v", err)
@@ -19,8 +38,67 @@ func RunAutoboot(statePath string) {
 	}
 
 	for _, u := range st.Users {
-		if err := BootstrapUserLaunchDaemons(u.Name); err != nil {
+		if err := BootstrapUserLaunchDaemons(ctx, u.Name); err != nil {
 			log.Printf("[host-autoboot] %s: %v", u.Name, err)
 		}
 	}
 }
+
+// launchdLoaded reports whether launchctl knows about the given domain or
+// service target (e.g. "system/com.example" or "gui/501").
+func launchdLoaded(ctx context.Context, target string) bool {
+	_, err := runner.Run(ctx, "launchctl", "print", target)
+	return err == nil
+}
+
+// ensureHostAutobootLoaded re-bootstraps the host-autoboot daemon when its
+// plist is installed but launchd no longer has it loaded.
+func ensureHostAutobootLoaded(ctx context.Context) {
+	if _, err := os.Stat(hostAutobootPlistPath); err != nil {
+		log.Printf("[host-autoboot] %s not installed; skipping", hostAutobootLabel)
+		return
+	}
+	if launchdLoaded(ctx, "system/"+hostAutobootLabel) {
+		log.Printf("[host-autoboot] %s loaded", hostAutobootLabel)
+		return
+	}
+	log.Printf("[host-autoboot] %s not loaded; bootstrapping", hostAutobootLabel)
+	if err := bootstrapWithRetry(ctx, hostAutobootPlistPath, hostAutobootBootstrapRetries); err != nil {
+		log.Printf("[host-autoboot] bootstrap %s: %v", hostAutobootLabel, err)
+	}
+}
+
+// ensureFastLoginLoaded re-bootstraps the fast-login LaunchAgent for every
+// admin user that has it installed and currently has a GUI session. Without a
+// session the agent loads at login via RunAtLoad.
+func ensureFastLoginLoaded(ctx context.Context) {
+	plists, _ := filepath.Glob(filepath.Join(usersDir, "*", "Library", "LaunchAgents", fastLoginLabel+".plist"))
+	if len(plists) == 0 {
+		log.Printf("[host-autoboot] %s not configured; skipping", fastLoginLabel)
+		return
+	}
+
+	for _, plistPath := range plists {
+		adminUser := filepath.Base(filepath.Dir(filepath.Dir(filepath.Dir(plistPath))))
+		uid, err := getUserUID(adminUser)
+		if err != nil {
+			log.Printf("[host-autoboot] %s for %s: %v", fastLoginLabel, adminUser, err)
+			continue
+		}
+
+		domain := fmt.Sprintf("gui/%d", uid)
+		if !launchdLoaded(ctx, domain) {
+			log.Printf("[host-autoboot] %s: %s has no GUI session; it will load at login", fastLoginLabel, adminUser)
+			continue
+		}
+		if launchdLoaded(ctx, domain+"/"+fastLoginLabel) {
+			log.Printf("[host-autoboot] %s loaded for %s", fastLoginLabel, adminUser)
+			continue
+		}
+
+		log.Printf("[host-autoboot] %s not loaded for %s; bootstrapping", fastLoginLabel, adminUser)
+		if out, err := runner.Run(ctx, "launchctl", "bootstrap", domain, plistPath); err != nil {
+			log.Printf("[host-autoboot] bootstrap %s for %s: %v (output=%s)", fastLoginLabel, adminUser, err, strings.TrimSpace(string(out)))
+		}
+	}
+}
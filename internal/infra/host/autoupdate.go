@@ -29,17 +29,26 @@ const (
 )
 
 // AutoUpdateConfig holds configuration for auto-update behavior.
+// CheckInterval is the fallback used while prism.json cannot be loaded;
+// otherwise globals.service.update_check_minutes applies. A value on Reload
+// makes the loop re-read prism.json and apply the new interval and
+// auto_update setting.
 type AutoUpdateConfig struct {
 	CheckInterval time.Duration
 	OutputDir     string
 	ConfigPath    string
 	StatePath     string
+	Reload        <-chan struct{}
 }
 
 // githubRelease represents the relevant fields from GitHub API response.
 type githubRelease struct {
-	TagName string `json:"tag_name"`
-	Assets  []struct {
+	TagName     string `json:"tag_name"`
+	Name        string `json:"name"`
+	Body        string `json:"body"`
+	HTMLURL     string `json:"html_url"`
+	PublishedAt string `json:"published_at"`
+	Assets      []struct {
 		Name string `json:"name"`
 	} `json:"assets"`
 }
@@ -47,29 +56,48 @@ type githubRelease struct {
 // RunAutoUpdateLoop starts the auto-update daemon loop.
 // It checks for new server releases at the configured interval and updates all users if needed.
 func RunAutoUpdateLoop(ctx context.Context, auCfg AutoUpdateConfig) {
-	// Ensure minimum interval to prevent CPU spinning
-	interval := auCfg.CheckInterval
-	if interval < time.Minute {
-		interval = time.Hour
-		log.Printf("[autoupdate] check interval too short, using default 1 hour")
+	interval, enabled, err := autoUpdateSettings(auCfg)
+	if err != nil {
+		log.Printf("[autoupdate] load config: %v; using defaults", err)
 	}
 
-	log.Printf("[autoupdate] starting auto-update loop (interval=%s)", interval)
+	log.Printf("[autoupdate] starting auto-update loop (interval=%s, enabled=%t)", interval, enabled)
 
 	// Run once immediately at startup
-	if err := checkAndUpdate(ctx, auCfg); err != nil {
-		log.Printf("[autoupdate] initial check failed: %v", err)
+	if enabled {
+		if err := checkAndUpdate(ctx, auCfg); err != nil {
+			log.Printf("[autoupdate] initial check failed: %v", err)
+		}
 	}
 
 	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
+	tick := ticker.C
+	if !enabled {
+		tick = nil
+	}
 
 	for {
 		select {
 		case <-ctx.Done():
 			log.Printf("[autoupdate] stopping auto-update loop")
 			return
-		case <-ticker.C:
+		case <-auCfg.Reload:
+			next, nextEnabled, err := autoUpdateSettings(auCfg)
+			if err != nil {
+				log.Printf("[autoupdate] reload failed, keeping interval=%s enabled=%t: %v", interval, enabled, err)
+				continue
+			}
+			if next != interval {
+				ticker.Reset(next)
+			}
+			interval, enabled = next, nextEnabled
+			tick = ticker.C
+			if !enabled {
+				tick = nil
+			}
+			log.Printf("[autoupdate] config reloaded (interval=%s, enabled=%t)", interval, enabled)
+		case <-tick:
 			if err := checkAndUpdate(ctx, auCfg); err != nil {
 				log.Printf("[autoupdate] check failed: %v", err)
 			}
@@ -77,6 +105,23 @@ func RunAutoUpdateLoop(ctx context.Context, auCfg AutoUpdateConfig) {
 	}
 }
 
+// autoUpdateSettings reads the check interval and whether auto-update is
+// enabled from prism.json. On error it returns auCfg.CheckInterval, enabled.
+func autoUpdateSettings(auCfg AutoUpdateConfig) (time.Duration, bool, error) {
+	interval, enabled := auCfg.CheckInterval, true
+	cfg, err := config.Load(auCfg.ConfigPath)
+	if err == nil {
+		interval = cfg.Globals.Service.UpdateCheckInterval()
+		enabled = cfg.Globals.Service.AutoUpdates()
+	}
+	// Ensure minimum interval to prevent CPU spinning
+	if interval < time.Minute {
+		interval = time.Hour
+		log.Printf("[autoupdate] check interval too short, using default 1 hour")
+	}
+	return interval, enabled, err
+}
+
 // checkAndUpdate checks for a new server version and updates if available.
 func checkAndUpdate(ctx context.Context, auCfg AutoUpdateConfig) error {
 	cfg, err := config.Load(auCfg.ConfigPath)
@@ -94,18 +139,27 @@ func checkAndUpdate(ctx context.Context, auCfg AutoUpdateConfig) error {
 		return nil
 	}
 
+	if info, err := ReadMaintenance(auCfg.OutputDir); err == nil && info.Active {
+		log.Printf("[autoupdate] host in maintenance; skipping update check")
+		return nil
+	}
+
 	archiveURL := strings.TrimSpace(cfg.Globals.Service.ArchiveURL)
 	if archiveURL == "" {
 		return errors.New("globals.service.archive_url is empty")
 	}
 
 	// Only support gh:// URLs for auto-update (need tag comparison)
-	if !strings.HasPrefix(archiveURL, "gh://") {
+	gh, ok, err := config.ParseGitHubArchive(archiveURL)
+	if err != nil {
+		return err
+	}
+	if !ok {
 		log.Printf("[autoupdate] archive_url is not a gh:// URL; skipping auto-update")
 		return nil
 	}
 
-	latestTag, err := fetchLatestRelease(ctx, archiveURL)
+	latestTag, err := fetchLatestRelease(ctx, gh)
 	if err != nil {
 		return fmt.Errorf("fetch latest release: %w", err)
 	}
@@ -145,9 +199,18 @@ func checkAndUpdate(ctx context.Context, auCfg AutoUpdateConfig) error {
 	}
 
 	// Save the new version
-	if err := writeCurrentVersion(auCfg.OutputDir, latestTag); err != nil {
+	if err := writeCurrentVersion(auCfg.OutputDir, latestTag, updatedCount); err != nil {
 		return fmt.Errorf("write current version: %w", err)
 	}
+	for _, u := range st.Users {
+		if err := refreshUserManifestVersion(ctx, u.Name, latestTag); err != nil {
+			log.Printf("[autoupdate] warning: failed to update manifest for %s: %v", u.Name, err)
+		}
+	}
+
+	if err := pruneServiceCaches(cfg, auCfg.OutputDir); err != nil {
+		log.Printf("[autoupdate] warning: cache cleanup failed: %v", err)
+	}
 
 	log.Printf("[autoupdate] successfully updated to version %s", latestTag)
 	return nil
@@ -156,28 +219,23 @@ func checkAndUpdate(ctx context.Context, auCfg AutoUpdateConfig) error {
 // fetchLatestRelease gets the latest release tag from GitHub with retry.
 // Returns the tag name and an error. If a fixed tag is specified in the URL,
 // returns empty string to signal that auto-update should be skipped.
-func fetchLatestRelease(ctx context.Context, ghURL string) (string, error) {
-	spec := strings.TrimPrefix(ghURL, "gh://")
-	parts := strings.SplitN(spec, "/", 3)
-	if len(parts) != 3 {
-		return "", fmt.Errorf("invalid gh:// URL: %s", ghURL)
-	}
-
-	owner, repo, assetSpec := parts[0], parts[1], parts[2]
-	assetName := assetSpec
-	fixedTag := ""
-
-	if idx := strings.Index(assetSpec, "@"); idx >= 0 {
-		assetName = assetSpec[:idx]
-		fixedTag = strings.TrimSpace(assetSpec[idx+1:])
-	}
-
+func fetchLatestRelease(ctx context.Context, gh config.GitHubArchive) (string, error) {
 	// If a fixed tag is specified, no auto-update needed
-	if fixedTag != "" {
-		log.Printf("[autoupdate] fixed tag %s specified; skipping auto-update", fixedTag)
+	if gh.Tag != "" {
+		log.Printf("[autoupdate] fixed tag %s specified; skipping auto-update", gh.Tag)
 		return "", nil
 	}
 
+	rel, err := fetchLatestGitHubRelease(ctx, gh)
+	if err != nil {
+		return "", err
+	}
+	return rel.TagName, nil
+}
+
+// fetchLatestGitHubRelease gets the latest release of gh's repository with
+// retry, ignoring any fixed tag in gh.
+func fetchLatestGitHubRelease(ctx context.Context, gh config.GitHubArchive) (githubRelease, error) {
 	var lastErr error
 	backoff := initialBackoff
 
@@ -186,7 +244,7 @@ func fetchLatestRelease(ctx context.Context, ghURL string) (string, error) {
 			log.Printf("[autoupdate] retry %d/%d after %v", attempt, maxRetries, backoff)
 			select {
 			case <-ctx.Done():
-				return "", ctx.Err()
+				return githubRelease{}, ctx.Err()
 			case <-time.After(backoff):
 			}
 			// Exponential backoff with cap
@@ -196,27 +254,27 @@ func fetchLatestRelease(ctx context.Context, ghURL string) (string, error) {
 			}
 		}
 
-		tag, retryable, err := doFetchLatestRelease(ctx, owner, repo, assetName)
+		rel, retryable, err := doFetchLatestRelease(ctx, gh)
 		if err == nil {
-			return tag, nil
+			return rel, nil
 		}
 		lastErr = err
 		if !retryable {
-			return "", err
+			return githubRelease{}, err
 		}
 	}
 
-	return "", fmt.Errorf("after %d retries: %w", maxRetries, lastErr)
+	return githubRelease{}, fmt.Errorf("after %d retries: %w", maxRetries, lastErr)
 }
 
 // doFetchLatestRelease performs a single attempt to fetch the latest release.
-// Returns (tag, retryable, error). If retryable is true, the caller may retry.
-func doFetchLatestRelease(ctx context.Context, owner, repo, assetName string) (string, bool, error) {
-	apiURL := fmt.Sprintf("https://api.github.com/repos/%s/%s/releases/latest", owner, repo)
+// Returns (release, retryable, error). If retryable is true, the caller may retry.
+func doFetchLatestRelease(ctx context.Context, gh config.GitHubArchive) (githubRelease, bool, error) {
+	apiURL := fmt.Sprintf("https://api.github.com/repos/%s/%s/releases/latest", gh.Owner, gh.Repo)
 
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, apiURL, nil)
 	if err != nil {
-		return "", false, err
+		return githubRelease{}, false, err
 	}
 
 	if token := strings.TrimSpace(os.Getenv(envGitHubTokenForUpdate)); token != "" {
@@ -228,49 +286,43 @@ func doFetchLatestRelease(ctx context.Context, owner, repo, assetName string) (s
 	resp, err := client.Do(req)
 	if err != nil {
 		// Network errors are retryable
-		return "", true, err
+		return githubRelease{}, true, err
 	}
 	defer func() { _ = resp.Body.Close() }()
 
 	// Handle rate limiting (429) and server errors (5xx) as retryable
 	if resp.StatusCode == http.StatusTooManyRequests {
-		return "", true, fmt.Errorf("GitHub API rate limited (429)")
+		return githubRelease{}, true, fmt.Errorf("GitHub API rate limited (429)")
 	}
 	if resp.StatusCode >= 500 {
-		return "", true, fmt.Errorf("GitHub API server error: %s", resp.Status)
+		return githubRelease{}, true, fmt.Errorf("GitHub API server error: %s", resp.Status)
 	}
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return "", false, fmt.Errorf("GitHub API returned status %s", resp.Status)
+		return githubRelease{}, false, fmt.Errorf("GitHub API returned status %s", resp.Status)
 	}
 
 	var rel githubRelease
 	if err := json.NewDecoder(resp.Body).Decode(&rel); err != nil {
-		return "", false, fmt.Errorf("decode release: %w", err)
+		return githubRelease{}, false, fmt.Errorf("decode release: %w", err)
 	}
 
-	// Verify the asset exists in this release
-	assetFound := false
+	// Verify exactly one asset in this release matches the spec
+	names := make([]string, 0, len(rel.Assets))
 	for _, a := range rel.Assets {
-		if a.Name == assetName {
-			assetFound = true
-			break
-		}
+		names = append(names, a.Name)
 	}
-
-	if !assetFound {
-		return "", false, fmt.Errorf("asset %q not found in release %s", assetName, rel.TagName)
+	if _, err := gh.MatchAsset(names); err != nil {
+		return githubRelease{}, false, fmt.Errorf("%w in release %s", err, rel.TagName)
 	}
 
-	return rel.TagName, false, nil
+	return rel, false, nil
 }
 
 // performUpdate downloads the new version and updates all users.
 // Returns the number of users successfully updated.
 func performUpdate(ctx context.Context, cfg config.Config, st state.State, outputDir string) (int, error) {
-	// Remove cached archive to force re-download
-	cacheDir := filepath.Join(outputDir, "cache")
-	archivePath := filepath.Join(cacheDir, "bundle-macos-arm64.tar.gz")
-	_ = os.Remove(archivePath)
+	// Retire cached archive to force re-download
+	retireCachedArchives(serviceCacheDir(cfg, outputDir))
 
 	// Download and extract new version
 	extractDir, err := ensureServiceArchive(ctx, cfg, outputDir)
@@ -294,8 +346,7 @@ func performUpdate(ctx context.Context, cfg config.Config, st state.State, outpu
 
 	// Update each user's service directory
 	for _, u := range st.Users {
-		homeDir := filepath.Join("/Users", u.Name)
-		serviceDir := filepath.Join(homeDir, "services", "imsg")
+		serviceDir := userServiceDir(u.Name, config.PrimaryServiceName)
 
 		// Check if service directory exists
 		if _, err := os.Stat(serviceDir); err != nil {
@@ -304,20 +355,20 @@ func performUpdate(ctx context.Context, cfg config.Config, st state.State, outpu
 		}
 
 		// Sync the service files (excluding config files)
-		if err := syncServiceDir(extractDir, serviceDir); err != nil {
+		if err := syncServiceDir(ctx, extractDir, serviceDir); err != nil {
 			log.Printf("[autoupdate] user %s: sync failed: %v", u.Name, err)
 			continue
 		}
 
 		// Fix ownership
-		if err := chownRecursive(u.Name, serviceDir); err != nil {
+		if err := chownRecursive(ctx, u.Name, serviceDir); err != nil {
 			log.Printf("[autoupdate] user %s: chown failed: %v", u.Name, err)
 			continue
 		}
 
 		// Only restart if the user's service is actually running (port is listening)
 		if stItem, ok := statusByUser[u.Name]; ok && stItem.ServiceDirOK && stItem.PortListening {
-			if err := RestartUserDaemons(u.Name); err != nil {
+			if err := RestartUserDaemons(ctx, u.Name); err != nil {
 				log.Printf("[autoupdate] user %s: restart failed: %v", u.Name, err)
 				continue
 			}
@@ -332,64 +383,126 @@ func performUpdate(ctx context.Context, cfg config.Config, st state.State, outpu
 	return updatedCount, nil
 }
 
-// readCurrentVersion reads the currently deployed version tag from file.
-func readCurrentVersion(outputDir string) (string, error) {
+// VersionInfo is the content of the version file: the deployed bundle tag and
+// when it was last rolled out.
+type VersionInfo struct {
+	Tag          string `json:"tag"`
+	UpdatedAt    string `json:"updated_at,omitempty"`
+	UsersUpdated int    `json:"users_updated,omitempty"`
+}
+
+// ReadVersionInfo reads the version file in outputDir. A legacy plain-text file
+// holding only the tag is accepted; its modification time is used as UpdatedAt.
+func ReadVersionInfo(outputDir string) (VersionInfo, error) {
 	versionFile := filepath.Join(outputDir, "cache", versionFileName)
 	data, err := os.ReadFile(versionFile)
+	if err != nil {
+		return VersionInfo{}, err
+	}
+
+	trimmed := strings.TrimSpace(string(data))
+	if !strings.HasPrefix(trimmed, "{") {
+		info := VersionInfo{Tag: trimmed}
+		if fi, err := os.Stat(versionFile); err == nil {
+			info.UpdatedAt = fi.ModTime().UTC().Format(time.RFC3339)
+		}
+		return info, nil
+	}
+
+	var info VersionInfo
+	if err := json.Unmarshal(data, &info); err != nil {
+		return VersionInfo{}, fmt.Errorf("parse %s: %w", versionFile, err)
+	}
+	info.Tag = strings.TrimSpace(info.Tag)
+	return info, nil
+}
+
+// readCurrentVersion reads the currently deployed version tag from file.
+func readCurrentVersion(outputDir string) (string, error) {
+	info, err := ReadVersionInfo(outputDir)
 	if err != nil {
 		return "", err
 	}
-	return strings.TrimSpace(string(data)), nil
+	return info.Tag, nil
 }
 
-// writeCurrentVersion saves the deployed version tag to file.
-func writeCurrentVersion(outputDir string, tag string) error {
+// writeCurrentVersion saves the deployed version tag to file together with the
+// update time and how many users received it.
+func writeCurrentVersion(outputDir string, tag string, usersUpdated int) error {
 	cacheDir := filepath.Join(outputDir, "cache")
 	if err := os.MkdirAll(cacheDir, 0o755); err != nil {
 		return err
 	}
+	info := VersionInfo{
+		Tag:          tag,
+		UpdatedAt:    time.Now().UTC().Format(time.RFC3339),
+		UsersUpdated: usersUpdated,
+	}
+	data, err := json.MarshalIndent(&info, "", "  ")
+	if err != nil {
+		return err
+	}
 	versionFile := filepath.Join(cacheDir, versionFileName)
-	return os.WriteFile(versionFile, []byte(tag+"\n"), 0o644)
+	return writeFileAtomic(versionFile, append(data, '\n'), 0o644)
 }
 
-// RecordInitialVersion fetches and records the current version after provisioning.
-// This allows auto-update to know the baseline version for future updates.
-func RecordInitialVersion(ctx context.Context, cfg config.Config, outputDir string) error {
+// ReseedVersion re-establishes the baseline auto-update compares against,
+// e.g. after the version file was deleted, by recording the latest release
+// (or the pinned @tag) as deployed. It does not touch users; a host whose users
+// may run an older bundle should run UpdateUserCode afterwards.
+func ReseedVersion(ctx context.Context, cfg config.Config, outputDir string) (string, error) {
+	tag, err := recordLatestVersion(ctx, cfg, outputDir)
+	if err != nil {
+		return "", err
+	}
+	if tag == "" {
+		return "", errors.New("globals.service.archive_url is not a gh:// URL; there is no version to track")
+	}
+	log.Printf("[autoupdate] reseeded version: %s", tag)
+	return tag, nil
+}
+
+// recordLatestVersion writes the latest release tag of a gh:// archive_url,
+// or its pinned tag, as the deployed version and returns it. It returns ""
+// when archive_url does not support version tracking.
+func recordLatestVersion(ctx context.Context, cfg config.Config, outputDir string) (string, error) {
+	tag, err := latestVersion(ctx, cfg)
+	if err != nil || tag == "" {
+		return "", err
+	}
+	if err := writeCurrentVersion(outputDir, tag, 0); err != nil {
+		return "", fmt.Errorf("write version file: %w", err)
+	}
+	return tag, nil
+}
+
+// latestVersion returns the latest release tag of a gh:// archive_url, or its
+// pinned tag, without recording it. Setup and update-code record it only once
+// their users got the bundle. It returns "" when archive_url does not support
+// version tracking.
+func latestVersion(ctx context.Context, cfg config.Config) (string, error) {
 	archiveURL := strings.TrimSpace(cfg.Globals.Service.ArchiveURL)
 	if archiveURL == "" {
-		return errors.New("globals.service.archive_url is empty")
+		return "", errors.New("globals.service.archive_url is empty")
 	}
 
 	// Only gh:// URLs support version tracking
-	if !strings.HasPrefix(archiveURL, "gh://") {
-		log.Printf("[autoupdate] archive_url is not a gh:// URL; skipping version recording")
-		return nil
+	gh, ok, err := config.ParseGitHubArchive(archiveURL)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return "", nil
 	}
 
-	tag, err := fetchLatestRelease(ctx, archiveURL)
+	tag, err := fetchLatestRelease(ctx, gh)
 	if err != nil {
-		return fmt.Errorf("fetch release version: %w", err)
+		return "", fmt.Errorf("fetch release version: %w", err)
 	}
 
 	// Empty tag means fixed version specified, record that instead
 	if tag == "" {
-		spec := strings.TrimPrefix(archiveURL, "gh://")
-		parts := strings.SplitN(spec, "/", 3)
-		if len(parts) == 3 {
-			if idx := strings.Index(parts[2], "@"); idx >= 0 {
-				tag = strings.TrimSpace(parts[2][idx+1:])
-			}
-		}
+		tag = gh.Tag
 	}
-
-	if tag == "" {
-		return nil
-	}
-
-	if err := writeCurrentVersion(outputDir, tag); err != nil {
-		return fmt.Errorf("write version file: %w", err)
-	}
-
-	log.Printf("[autoupdate] recorded initial version: %s", tag)
-	return nil
+	return tag, nil
 }
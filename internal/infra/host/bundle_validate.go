@@ -0,0 +1,29 @@
+//go:build darwin
+
+package host
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"prism/internal/infra/config"
+)
+
+// verifyBundleSignature runs codesign --verify on the server app of an
+// extracted primary bundle when svc.VerifySignature is set, before any daemon
+// is created or updated from it. globals.service.required_paths is checked by
+// ensureBundleArchive. Failures wrap ErrInvalidBundle.
+func verifyBundleSignature(ctx context.Context, svc config.ServiceConfig, extractDir string) error {
+	if !svc.VerifySignature {
+		return nil
+	}
+	setStep(ctx, "verify service bundle signature")
+	app := filepath.Join(extractDir, serverAppName)
+	out, err := runner.Run(ctx, "codesign", "--verify", "--deep", "--strict", app)
+	if err != nil {
+		return fmt.Errorf("%w: codesign --verify %s: %w (output=%s)", ErrInvalidBundle, serverAppName, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
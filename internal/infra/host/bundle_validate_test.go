@@ -0,0 +1,61 @@
+//go:build darwin
+
+package host
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEnsureServiceArchive(t *testing.T) {
+	tests := []struct {
+		name      string
+		required  []string
+		verify    bool
+		fail      map[string]string
+		wantErr   error
+		wantCache bool
+	}{
+		{
+			name:      "valid bundle",
+			required:  []string{serverBinRelPath},
+			verify:    true,
+			wantCache: true,
+		},
+		{
+			name:     "missing required path",
+			required: []string{"iMessageKitServer.app/Contents/Resources/app/node_modules"},
+			wantErr:  ErrInvalidBundle,
+		},
+		{
+			name:    "signature rejected",
+			verify:  true,
+			fail:    map[string]string{"codesign --verify": "code object is not signed at all"},
+			wantErr: ErrInvalidBundle,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, outputDir := testHost(t)
+			cfg.Globals.Service.RequiredPaths = tt.required
+			cfg.Globals.Service.VerifySignature = tt.verify
+			fake := newFakeRunner(t)
+			for k, v := range tt.fail {
+				fake.fail[k] = v
+			}
+
+			_, err := ensureServiceArchive(context.Background(), cfg, outputDir)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ensureServiceArchive() error = %v, want %v", err, tt.wantErr)
+			}
+			_, statErr := os.Stat(filepath.Join(outputDir, "cache", defaultArchiveName))
+			if (statErr == nil) != tt.wantCache {
+				t.Errorf("cached archive exists = %v, want %v", statErr == nil, tt.wantCache)
+			}
+		})
+	}
+}
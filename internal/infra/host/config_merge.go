@@ -0,0 +1,259 @@
+//go:build darwin
+
+package host
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	toml "github.com/pelletier/go-toml"
+
+	"prism/internal/infra/config"
+)
+
+// mergeBundleConfigs adds keys that a bundle's config.json and frpc.toml
+// templates define but the user's copies (which syncServiceDir never
+// overwrites) lack. Values already in the user's files, such as the
+// subdomain, ports and friendlyName, are kept.
+func mergeBundleConfigs(bundleDir, serviceDir string) error {
+	if err := mergeFile(bundleDir, serviceDir, "config.json", mergeJSONConfig); err != nil {
+		return err
+	}
+	return mergeFile(bundleDir, serviceDir, "frpc.toml", mergeTOMLConfig)
+}
+
+// mergeFile applies merge to the template and user copies of name and writes
+// the result back when keys were added. It does nothing unless both exist.
+func mergeFile(bundleDir, serviceDir, name string, merge func(template, user []byte) ([]byte, bool, error)) error {
+	template, err := os.ReadFile(filepath.Join(bundleDir, name))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	userPath := filepath.Join(serviceDir, name)
+	fi, err := os.Stat(userPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	user, err := os.ReadFile(userPath)
+	if err != nil {
+		return err
+	}
+
+	merged, changed, err := merge(template, user)
+	if err != nil {
+		return fmt.Errorf("merge %s: %w", name, err)
+	}
+	if !changed {
+		return nil
+	}
+	return writeFileAtomic(userPath, merged, fi.Mode().Perm())
+}
+
+func mergeJSONConfig(template, user []byte) ([]byte, bool, error) {
+	var t, u map[string]any
+	if err := json.Unmarshal(template, &t); err != nil {
+		return nil, false, fmt.Errorf("parse bundle template: %w", err)
+	}
+	if err := json.Unmarshal(user, &u); err != nil {
+		return nil, false, err
+	}
+	if !addMissingKeys(t, u) {
+		return nil, false, nil
+	}
+	out, err := json.MarshalIndent(u, "", "  ")
+	if err != nil {
+		return nil, false, err
+	}
+	return out, true, nil
+}
+
+// addMissingKeys copies keys of template that dst lacks into dst, descending
+// into objects present in both, and reports whether dst changed.
+func addMissingKeys(template, dst map[string]any) bool {
+	changed := false
+	for k, tv := range template {
+		dv, ok := dst[k]
+		if !ok {
+			dst[k] = tv
+			changed = true
+			continue
+		}
+		tm, tIsMap := tv.(map[string]any)
+		dm, dIsMap := dv.(map[string]any)
+		if tIsMap && dIsMap && addMissingKeys(tm, dm) {
+			changed = true
+		}
+	}
+	return changed
+}
+
+// mergeTOMLConfig adds the template's missing top-level keys and tables.
+// Proxies are per user, so arrays of tables are never merged. The user's
+// file is kept byte for byte, comments and order included: missing keys are
+// inserted before its first table and missing tables appended at the end.
+func mergeTOMLConfig(template, user []byte) ([]byte, bool, error) {
+	t, err := toml.LoadBytes(template)
+	if err != nil {
+		return nil, false, fmt.Errorf("parse bundle template: %w", err)
+	}
+	u, err := toml.LoadBytes(user)
+	if err != nil {
+		return nil, false, err
+	}
+	keys, err := toml.TreeFromMap(map[string]interface{}{})
+	if err != nil {
+		return nil, false, err
+	}
+	tables, err := toml.TreeFromMap(map[string]interface{}{})
+	if err != nil {
+		return nil, false, err
+	}
+	changed := false
+	for _, k := range t.Keys() {
+		if u.Has(k) {
+			continue
+		}
+		switch v := t.Get(k).(type) {
+		case []*toml.Tree:
+			continue
+		case *toml.Tree:
+			tables.Set(k, v)
+		default:
+			keys.Set(k, v)
+		}
+		changed = true
+	}
+	if !changed {
+		return nil, false, nil
+	}
+
+	keyText, err := keys.ToTomlString()
+	if err != nil {
+		return nil, false, err
+	}
+	tableText, err := tables.ToTomlString()
+	if err != nil {
+		return nil, false, err
+	}
+	at := firstTOMLTable(user)
+	var out bytes.Buffer
+	out.Write(user[:at])
+	if at > 0 && user[at-1] != '\n' {
+		out.WriteByte('\n')
+	}
+	out.WriteString(keyText)
+	if at < len(user) && keyText != "" {
+		out.WriteByte('\n')
+	}
+	out.Write(user[at:])
+	if tableText != "" {
+		if b := out.Bytes(); len(b) > 0 && b[len(b)-1] != '\n' {
+			out.WriteByte('\n')
+		}
+		out.WriteByte('\n')
+		out.WriteString(tableText)
+	}
+	return out.Bytes(), true, nil
+}
+
+// tomlTableHeader matches a [table] or [[array]] header line.
+var tomlTableHeader = regexp.MustCompile(`^\s*\[\[?\s*[A-Za-z0-9_."' -]+\s*\]\]?\s*(#.*)?$`)
+
+// firstTOMLTable returns the offset of the first table header line in data,
+// or len(data) when it has none.
+func firstTOMLTable(data []byte) int {
+	offset := 0
+	for _, line := range bytes.SplitAfter(data, []byte("\n")) {
+		if tomlTableHeader.Match(bytes.TrimRight(line, "\r\n")) {
+			return offset
+		}
+		offset += len(line)
+	}
+	return len(data)
+}
+
+// setFriendlyNameRefresh records globals.frpc.friendly_name_refresh_minutes
+// in the user's config.json, so an update applies a changed prism.json to
+// existing users. Zero removes the key.
+func setFriendlyNameRefresh(serviceDir string, minutes int) error {
+	path := filepath.Join(serviceDir, "config.json")
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var u map[string]any
+	if err := json.Unmarshal(data, &u); err != nil {
+		return fmt.Errorf("parse config.json: %w", err)
+	}
+
+	const key = "friendly_name_refresh_minutes"
+	old, had := u[key]
+	switch {
+	case minutes > 0:
+		if had && old == float64(minutes) {
+			return nil
+		}
+		u[key] = minutes
+	case !had:
+		return nil
+	default:
+		delete(u, key)
+	}
+
+	out, err := json.MarshalIndent(u, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, out, fi.Mode().Perm())
+}
+
+// setServiceEndpoints records the bind address and health path of svc in the
+// user's config.json, which deploy reads to verify the server.
+func setServiceEndpoints(serviceDir string, svc config.ServiceConfig) error {
+	path := filepath.Join(serviceDir, "config.json")
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var u map[string]any
+	if err := json.Unmarshal(data, &u); err != nil {
+		return fmt.Errorf("parse config.json: %w", err)
+	}
+
+	want := map[string]string{"local_ip": svc.BindIP(), "health_path": svc.HealthCheckPath()}
+	changed := false
+	for k, v := range want {
+		if u[k] != v {
+			u[k] = v
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+
+	out, err := json.MarshalIndent(u, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, out, fi.Mode().Perm())
+}
@@ -0,0 +1,81 @@
+//go:build darwin
+
+package host
+
+import (
+	"strings"
+	"testing"
+
+	toml "github.com/pelletier/go-toml"
+)
+
+func TestMergeTOMLConfig(t *testing.T) {
+	user := `# managed by Prism
+serverAddr = "frps.example.com"
+serverPort = 7000
+
+[[proxies]]
+name = "mac-1"
+subdomain = "abc123"
+`
+	tests := []struct {
+		name        string
+		template    string
+		wantChanged bool
+		// wantKeys must be set in the merged file.
+		wantKeys []string
+	}{
+		{
+			name:     "nothing missing",
+			template: "serverAddr = \"x\"\nserverPort = 1\n",
+		},
+		{
+			name:        "missing key",
+			template:    "serverAddr = \"x\"\nloginFailExit = false\n",
+			wantChanged: true,
+			wantKeys:    []string{"loginFailExit"},
+		},
+		{
+			name:        "missing table",
+			template:    "[transport]\nprotocol = \"tcp\"\n",
+			wantChanged: true,
+			wantKeys:    []string{"transport.protocol"},
+		},
+		{
+			name:     "array of tables",
+			template: "[[visitors]]\nname = \"v\"\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, changed, err := mergeTOMLConfig([]byte(tt.template), []byte(user))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if changed != tt.wantChanged {
+				t.Fatalf("changed = %v, want %v", changed, tt.wantChanged)
+			}
+			if !changed {
+				return
+			}
+			got := string(out)
+			for _, line := range strings.Split(strings.TrimSpace(user), "\n") {
+				if !strings.Contains(got, line+"\n") {
+					t.Errorf("merged file lost line %q:\n%s", line, got)
+				}
+			}
+			tree, err := toml.Load(got)
+			if err != nil {
+				t.Fatalf("merged file does not parse: %v\n%s", err, got)
+			}
+			for _, k := range tt.wantKeys {
+				if !tree.Has(k) {
+					t.Errorf("merged file has no %s:\n%s", k, got)
+				}
+			}
+			if sub, _ := tree.Get("proxies").([]*toml.Tree); len(sub) != 1 || sub[0].Get("subdomain") != "abc123" {
+				t.Errorf("proxies changed:\n%s", got)
+			}
+		})
+	}
+}
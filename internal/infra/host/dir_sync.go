@@ -0,0 +1,110 @@
+//go:build darwin
+
+package host
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+)
+
+// rsyncAvailable reports whether rsync is on PATH. copyDir and
+// syncServiceDir prefer it and fall back to copyTree without it.
+func rsyncAvailable() bool {
+	_, err := exec.LookPath("rsync")
+	return err == nil
+}
+
+// copyTree copies the contents of src into dst like "rsync -a src/ dst/":
+// directories are created, regular files and symlinks are replaced, modes and
+// modification times are kept, and nothing in dst is deleted. Entries whose
+// name is in exclude are skipped at any depth.
+func copyTree(ctx context.Context, src, dst string, exclude []string) error {
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		if rel != "." && slices.Contains(exclude, d.Name()) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		target := filepath.Join(dst, rel)
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		switch {
+		case d.IsDir():
+			if err := os.MkdirAll(target, 0o755); err != nil {
+				return err
+			}
+			if err := os.Chmod(target, info.Mode().Perm()); err != nil {
+				return err
+			}
+		case d.Type()&fs.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			if err := os.RemoveAll(target); err != nil {
+				return err
+			}
+			if err := os.Symlink(link, target); err != nil {
+				return err
+			}
+		case d.Type().IsRegular():
+			if err := copyFileAtomic(path, target, info); err != nil {
+				return fmt.Errorf("copy %s: %w", rel, err)
+			}
+		}
+		return nil
+	})
+}
+
+// copyFileAtomic copies src to dst through a temp file and rename, so a
+// running executable at dst is replaced rather than rewritten in place.
+func copyFileAtomic(src, dst string, info fs.FileInfo) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = in.Close() }()
+
+	tmpPath := dst + ".tmp"
+	// Clean up tmp file if rename fails
+	defer func() { _ = os.Remove(tmpPath) }()
+
+	out, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		_ = out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmpPath, info.Mode().Perm()); err != nil {
+		return err
+	}
+	if err := os.Chtimes(tmpPath, info.ModTime(), info.ModTime()); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, dst)
+}
@@ -0,0 +1,88 @@
+//go:build darwin
+
+package host
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"strings"
+)
+
+// Provisioning failures that callers map to friendly messages. Match them
+// with errors.Is; the wrapping error carries the details.
+var (
+	// ErrUserExists means a macOS account with the planned name already exists.
+	ErrUserExists = errors.New("user already exists")
+	// ErrDownloadFailed means the service bundle could not be resolved or
+	// downloaded.
+	ErrDownloadFailed = errors.New("download archive")
+	// ErrPermissionDenied means Prism lacks the privileges it needs, usually
+	// because it was not started with sudo. It is fs.ErrPermission, so failed
+	// file operations match it as well.
+	ErrPermissionDenied = fs.ErrPermission
+	// ErrFRPCMissing means frpc is not installed and could not be installed.
+	ErrFRPCMissing = errors.New("frpc binary not found")
+	// ErrPartialUpdate means UpdateUserCode updated some users but not all.
+	ErrPartialUpdate = errors.New("user code update failed for some users")
+	// ErrPartialFRPCRefresh means RefreshFRPCConfigs rewrote the frpc.toml
+	// of some users but not all.
+	ErrPartialFRPCRefresh = errors.New("frpc config refresh failed for some users")
+	// ErrPasswordPolicy means macOS rejected the account password under the
+	// local password policy.
+	ErrPasswordPolicy = errors.New("password rejected by the password policy")
+	// ErrHomeDirFailed means macOS could not create the account's home
+	// directory.
+	ErrHomeDirFailed = errors.New("home directory could not be created")
+	// ErrTimeout means a provisioning run exceeded
+	// globals.service.provision_timeout_minutes.
+	ErrTimeout = errors.New("provisioning timed out")
+	// ErrPortsInUse means ports the new users would take are already bound
+	// by other software.
+	ErrPortsInUse = errors.New("ports already in use")
+	// ErrInvalidBundle means an extracted service bundle lacks a required
+	// path, such as the server binary or one of
+	// globals.service.required_paths, or failed its code signature check.
+	ErrInvalidBundle = errors.New("service bundle failed validation")
+	// ErrMaintenance means the host is in maintenance mode, so operations
+	// that would start user daemons again are refused.
+	ErrMaintenance = errors.New("host is in maintenance")
+)
+
+// SysadminctlError is a failed sysadminctl call for a user. Kind is one of the
+// errors above when the output matched a known failure, and then stands in
+// for the noisy output in the message; Output always keeps the raw text for
+// logs.
+type SysadminctlError struct {
+	Op       string // "create" or "delete"
+	Username string
+	Kind     error
+	Output   string
+	Err      error
+}
+
+func (e *SysadminctlError) Error() string {
+	if e.Kind != nil {
+		return fmt.Sprintf("%s user %s: %v", e.Op, e.Username, e.Kind)
+	}
+	return fmt.Sprintf("%s user %s: %v (output=%s)", e.Op, e.Username, e.Err, e.Output)
+}
+
+func (e *SysadminctlError) Unwrap() []error {
+	if e.Kind == nil {
+		return []error{e.Err}
+	}
+	return []error{e.Kind, e.Err}
+}
+
+// isPermissionOutput reports whether command output describes a privilege
+// failure rather than a problem with the arguments.
+func isPermissionOutput(out string) bool {
+	lower := strings.ToLower(out)
+	for _, s := range []string{"permission denied", "operation not permitted", "must be run as root", "requires root", "not authorized"} {
+		if strings.Contains(lower, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,140 @@
+//go:build darwin
+
+package host
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"prism/internal/infra/config"
+)
+
+// extraServicesCacheDir is the directory inside the service cache that holds
+// one subdirectory per service from globals.services.
+const extraServicesCacheDir = "services"
+
+// extraServiceBundle is the extracted bundle of one service from
+// globals.services.
+type extraServiceBundle struct {
+	def        config.ServiceDefinition
+	extractDir string
+}
+
+// userServiceDir returns ~/services/<name> for username.
+func userServiceDir(username, name string) string {
+	return filepath.Join(usersDir, username, "services", name)
+}
+
+// serviceServerLabel returns the server LaunchDaemon label of a service. For
+// the primary service this equals launchDaemonServerLabel.
+func serviceServerLabel(name, username string) string {
+	return fmt.Sprintf("com.%s.server.%s", name, username)
+}
+
+// extraServicePort maps a user's primary port to the port of def, so user N
+// listens on def.StartPort+N-1.
+func extraServicePort(cfg config.Config, def config.ServiceDefinition, primaryPort int) int {
+	return def.StartPort + primaryPort - cfg.Globals.Service.StartPort
+}
+
+func extraServiceCacheDir(cfg config.Config, outputDir, name string) string {
+	return filepath.Join(serviceCacheDir(cfg, outputDir), extraServicesCacheDir, name)
+}
+
+// ensureExtraServiceBundles downloads (or reuses) and extracts the bundle of
+// every service in globals.services.
+func ensureExtraServiceBundles(ctx context.Context, cfg config.Config, outputDir string) ([]extraServiceBundle, error) {
+	bundles := make([]extraServiceBundle, 0, len(cfg.Globals.Services))
+	for _, def := range cfg.Globals.Services {
+		dir, _, err := ensureBundleArchive(ctx,
+			def.ServiceConfig(cfg.Globals.Service),
+			extraServiceCacheDir(cfg, outputDir, def.Name),
+			extractDirName,
+			[]string{def.Binary},
+		)
+		if err != nil {
+			return nil, fmt.Errorf("service %s: %w", def.Name, err)
+		}
+		bundles = append(bundles, extraServiceBundle{def: def, extractDir: dir})
+	}
+	return bundles, nil
+}
+
+// refreshExtraServiceBundles forces a fresh download of every extra service
+// bundle, like refreshServiceArchive does for the primary one.
+func refreshExtraServiceBundles(ctx context.Context, cfg config.Config, outputDir string) ([]extraServiceBundle, error) {
+	for _, def := range cfg.Globals.Services {
+		retireCachedArchives(extraServiceCacheDir(cfg, outputDir, def.Name))
+	}
+	return ensureExtraServiceBundles(ctx, cfg, outputDir)
+}
+
+// ensureExtraUserService installs one extra service for username, writes its
+// server LaunchDaemon and records its paths in manifest (which may be nil).
+// The caller bootstraps the daemon.
+func ensureExtraUserService(ctx context.Context, cfg config.Config, username string, primaryPort int, nexusAddr string, b extraServiceBundle, manifest *UserManifest) error {
+	homeDir := filepath.Join(usersDir, username)
+	serviceDir := userServiceDir(username, b.def.Name)
+	if err := copyDir(ctx, b.extractDir, serviceDir); err != nil {
+		return fmt.Errorf("service %s: %w", b.def.Name, err)
+	}
+	if err := chownRecursive(ctx, username, serviceDir); err != nil {
+		return fmt.Errorf("service %s: %w", b.def.Name, err)
+	}
+
+	label := serviceServerLabel(b.def.Name, username)
+	logName := b.def.Name + "-server"
+	serverBin := filepath.Join(serviceDir, b.def.Binary)
+	if _, err := writeServerLaunchDaemon(ctx, serverDaemonConfig{
+		Label:      label,
+		Username:   username,
+		HomeDir:    homeDir,
+		ServiceDir: serviceDir,
+		ServerBin:  serverBin,
+		Port:       extraServicePort(cfg, b.def, primaryPort),
+		Host:       cfg.Globals.Service.BindIP(),
+		MachineID:  cfg.Globals.MachineID,
+		NexusAddr:  nexusAddr,
+		Env:        cfg.Globals.Service.Env,
+		LogName:    logName,
+	}); err != nil {
+		return err
+	}
+	plistPath := filepath.Join(launchDaemonsDir, label+".plist")
+
+	if manifest != nil {
+		logsDir := filepath.Join(homeDir, "Library", "Logs")
+		manifest.LaunchDaemons = append(manifest.LaunchDaemons, plistPath)
+		manifest.Files = append(manifest.Files, serverBin)
+		manifest.Logs = append(manifest.Logs,
+			filepath.Join(logsDir, logName+".log"),
+			filepath.Join(logsDir, logName+".err"),
+		)
+	}
+	return nil
+}
+
+// updateExtraUserService syncs a refreshed extra service bundle into the
+// user's service directory, installing the service if the user predates it.
+func updateExtraUserService(ctx context.Context, cfg config.Config, username string, primaryPort int, b extraServiceBundle) error {
+	serviceDir := userServiceDir(username, b.def.Name)
+	if _, err := os.Stat(serviceDir); os.IsNotExist(err) {
+		if err := ensureExtraUserService(ctx, cfg, username, primaryPort, userNexusAddr(cfg, username), b, nil); err != nil {
+			return err
+		}
+		label := serviceServerLabel(b.def.Name, username)
+		if err := bootstrapWithRetry(ctx, filepath.Join(launchDaemonsDir, label+".plist"), 3); err != nil {
+			return fmt.Errorf("bootstrap %s: %w", label, err)
+		}
+		return nil
+	}
+	if err := syncServiceDir(ctx, b.extractDir, serviceDir); err != nil {
+		return fmt.Errorf("service %s: %w", b.def.Name, err)
+	}
+	if err := chownRecursive(ctx, username, serviceDir); err != nil {
+		return fmt.Errorf("service %s: %w", b.def.Name, err)
+	}
+	return nil
+}
@@ -3,26 +3,91 @@
 package host
 
 import (
+	"bufio"
+	"context"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 )
 
 const (
 	fastLoginLabel          = "com.prism.fast-login"
 	fastLoginScriptFilename = "prism-fast-login.sh"
+	fastLoginResultsFile    = "prism-fast-login.results.log"
 )
 
-// fastLoginScriptTemplate spawns VNC sessions for sub-users to activate their GUI.
+// Defaults for the FastLoginConfig timing fields. They suit the Screen
+// Sharing app of current macOS releases; slower hosts may need more.
+const (
+	defaultFastLoginConnectWait    = 5 * time.Second
+	defaultFastLoginKeystrokeDelay = 500 * time.Millisecond
+	defaultFastLoginLoginWait      = 5 * time.Second
+	defaultFastLoginAttempts       = 3
+)
+
+// fastLoginScriptTemplate spawns VNC sessions for sub-users to activate their
+// GUI. Run without arguments it logs in every user; given usernames it logs in
+// only those, reusing a running SSH tunnel and leaving their windows visible.
+// Each login is retried up to MAX_ATTEMPTS times and its outcome is appended
+// to RESULT_LOG in the format parseFastLoginResults reads.
 const fastLoginScriptTemplate = `#!/bin/bash
 # Prism Fast Login - activates sub-user GUI sessions via VNC loopback with SSH Tunnel
 #
 # PREREQUISITE: "Remote Login" must be enabled in System Settings -> General -> Sharing
+#
+# Usage: prism-fast-login.sh [user...]   (no users: log in every Prism user)
 
 ALL_USERS=(%s)
 PASSWORD="%s"
 TUNNEL_PORT=5901
 LOG_FILE="/tmp/prism_tunnel.log"
+RESULT_LOG="%s"
+CONNECT_WAIT=%s
+KEYSTROKE_DELAY=%s
+LOGIN_WAIT=%s
+MAX_ATTEMPTS=%d
+
+# Keep the result log bounded; one rotation is enough to investigate a boot
+if [ -f "$RESULT_LOG" ] && [ "$(stat -f %%z "$RESULT_LOG")" -gt 1048576 ]; then
+    mv -f "$RESULT_LOG" "$RESULT_LOG.1"
+fi
+
+# log_result USER ATTEMPT STATUS [REASON]
+# STATUS is ok, retry or failed; REASON runs to the end of the line.
+log_result() {
+    local line
+    line="$(date -u +%%Y-%%m-%%dT%%H:%%M:%%SZ) user=$1 attempt=$2 status=$3"
+    if [ -n "$4" ]; then
+        line="$line reason=$4"
+    fi
+    echo "$line" >> "$RESULT_LOG"
+    echo "$line"
+}
+
+TARGETS=("$@")
+FULL_RUN=0
+if [ ${#TARGETS[@]} -eq 0 ]; then
+    TARGETS=("${ALL_USERS[@]}")
+    FULL_RUN=1
+fi
+
+# Print the tunnel port forwarded for a user, or fail if it is not a Prism user
+user_port() {
+    local i=0
+    for u in "${ALL_USERS[@]}"; do
+        if [ "$u" = "$1" ]; then
+            echo $((TUNNEL_PORT + i))
+            return 0
+        fi
+        ((i++))
+    done
+    return 1
+}
 
 # Function to start SSH tunnel
 start_tunnel() {
@@ -68,23 +133,31 @@ start_tunnel() {
 EOF
 }
 
-# Start the tunnel before looping users
-start_tunnel
-# Sleep 5s to allow SSH auth to complete
-sleep 5
+# Start the tunnel before looping users; a targeted run reuses a live one
+if [ $FULL_RUN -eq 1 ] || ! lsof -i :$TUNNEL_PORT >/dev/null; then
+    start_tunnel
+    # Sleep 5s to allow SSH auth to complete
+    sleep 5
+else
+    echo "Reusing SSH tunnel on port $TUNNEL_PORT"
+fi
 
+# spawn_session USER PORT logs USER in over the tunnel once. On failure it
+# sets REASON and returns non-zero.
 spawn_session() {
     local target_user=$1
     local port=$2
+    local out
+    REASON=""
     echo "Spawning session for $target_user on port $port..."
 
     # Connect (No -n, reuse app to simplify scripting)
     open "vnc://127.0.0.1:$port"
 
     # Wait for app launch and connection handshake
-    sleep 5
+    sleep $CONNECT_WAIT
 
-    osascript <<EOF
+    if ! out=$(osascript 2>&1 <<EOF
       tell application "Screen Sharing" to activate
       delay 1
       tell application "System Events"
@@ -101,24 +174,40 @@ spawn_session() {
              log "Found window: " & (get name of window 1)
              tell window 1
                -- Ensure we are typing into the window
-               delay 0.5
+               delay ${KEYSTROKE_DELAY}
                keystroke "${target_user}"
-               delay 0.5
+               delay ${KEYSTROKE_DELAY}
                keystroke tab
-               delay 0.5
+               delay ${KEYSTROKE_DELAY}
                keystroke "${PASSWORD}"
-               delay 0.5
+               delay ${KEYSTROKE_DELAY}
                keystroke return
              end tell
           else
-             log "No window found. Visible windows: " & (get name of every window)
+             error "no authentication window (visible windows: " & (get name of every window) & ")"
           end if
         end tell
       end tell
 EOF
+    ); then
+        echo "$out"
+        REASON="osascript: $(echo "$out" | tail -n 1)"
+        return 1
+    fi
+    echo "$out"
 
-    # Extra delay to allow login to proceed before next iteration
-    sleep 5
+    # Allow the login to proceed before checking for the session
+    sleep $LOGIN_WAIT
+
+    if ! pgrep -u "$target_user" -x Dock >/dev/null; then
+        REASON="no GUI session after login"
+        return 1
+    fi
+
+    # A targeted run is for debugging; keep the session window in view
+    if [ $FULL_RUN -eq 0 ]; then
+        return 0
+    fi
 
     osascript <<EOF
       -- Attempt to handle "Log in as..." or subsequent dialogs
@@ -137,52 +226,107 @@ EOF
         end tell
       end try
 EOF
+    return 0
 }
 
-i=0
-for user in "${ALL_USERS[@]}"; do
-    port=$((TUNNEL_PORT + i))
-    spawn_session "$user" "$port"
-    ((i++))
+# login_user USER PORT retries spawn_session up to MAX_ATTEMPTS times and logs
+# the outcome of every attempt.
+login_user() {
+    local attempt
+    for ((attempt = 1; attempt <= MAX_ATTEMPTS; attempt++)); do
+        if spawn_session "$1" "$2"; then
+            log_result "$1" "$attempt" ok
+            return 0
+        fi
+        if [ $attempt -lt $MAX_ATTEMPTS ]; then
+            log_result "$1" "$attempt" retry "$REASON"
+            # Close the failed connection before trying again
+            osascript -e 'tell application "Screen Sharing" to close front window' >/dev/null 2>&1 || true
+            sleep $CONNECT_WAIT
+        fi
+    done
+    log_result "$1" "$MAX_ATTEMPTS" failed "$REASON"
+    return 1
+}
+
+status=0
+for user in "${TARGETS[@]}"; do
+    if ! port=$(user_port "$user"); then
+        echo "$user is not a Prism user; skipping"
+        status=1
+        continue
+    fi
+    if ! login_user "$user" "$port"; then
+        status=1
+    fi
     sleep 5
 done
 
 # Final cleanup: Close Screen Sharing app to clean up the desktop
 # The sub-user sessions will remain active in the background.
-sleep 5
-killall "Screen Sharing" || true
-`
-
-const fastLoginPlistTemplate = `<?xml version="1.0" encoding="UTF-8"?>
-<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
-<plist version="1.0">
-<dict>
-    <key>Label</key>
-    <string>com.prism.fast-login</string>
-    <key>ProgramArguments</key>
-    <array>
-        <string>%s</string>
-    </array>
-    <key>RunAtLoad</key>
-    <true/>
-    <key>StandardOutPath</key>
-    <string>%s</string>
-    <key>StandardErrorPath</key>
-    <string>%s</string>
-</dict>
-</plist>
+if [ $FULL_RUN -eq 1 ]; then
+    sleep 5
+    killall "Screen Sharing" || true
+fi
+exit $status
 `
 
-// FastLoginConfig holds configuration for the Fast Login spawner.
+// FastLoginConfig holds configuration for the Fast Login spawner. Zero
+// timing fields use the defaults above.
 type FastLoginConfig struct {
 	AdminUser   string
 	TargetUsers []string
 	Password    string
+
+	// ConnectWait is how long the script waits after opening a vnc:// URL
+	// before typing into the authentication window.
+	ConnectWait time.Duration
+	// KeystrokeDelay separates the keystrokes of the login.
+	KeystrokeDelay time.Duration
+	// LoginWait is how long the login may take before the script checks
+	// that the user has a GUI session.
+	LoginWait time.Duration
+	// Attempts bounds the logins tried per user.
+	Attempts int
+}
+
+func (c FastLoginConfig) withDefaults() FastLoginConfig {
+	if c.ConnectWait <= 0 {
+		c.ConnectWait = defaultFastLoginConnectWait
+	}
+	if c.KeystrokeDelay <= 0 {
+		c.KeystrokeDelay = defaultFastLoginKeystrokeDelay
+	}
+	if c.LoginWait <= 0 {
+		c.LoginWait = defaultFastLoginLoginWait
+	}
+	if c.Attempts <= 0 {
+		c.Attempts = defaultFastLoginAttempts
+	}
+	return c
+}
+
+// scriptSeconds formats d for sleep(1) and AppleScript's delay.
+func scriptSeconds(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'f', -1, 64)
+}
+
+// fastLoginJob is the admin's LaunchAgent that runs the fast-login script
+// once per GUI login.
+func fastLoginJob(scriptPath, logsDir string) launchdJob {
+	return launchdJob{
+		Label:             fastLoginLabel,
+		ProgramArguments:  []string{scriptPath},
+		RunAtLoad:         true,
+		StandardOutPath:   filepath.Join(logsDir, "prism-fast-login.log"),
+		StandardErrorPath: filepath.Join(logsDir, "prism-fast-login.err.log"),
+	}
 }
 
 // EnsureFastLoginService installs the spawner script and LaunchAgent for the admin user.
-func EnsureFastLoginService(cfg FastLoginConfig) error {
-	homeDir := filepath.Join("/Users", cfg.AdminUser)
+func EnsureFastLoginService(ctx context.Context, cfg FastLoginConfig) error {
+	cfg = cfg.withDefaults()
+	homeDir := filepath.Join(usersDir, cfg.AdminUser)
 	scriptPath := filepath.Join(homeDir, fastLoginScriptFilename)
 	launchAgentsDir := filepath.Join(homeDir, "Library", "LaunchAgents")
 	plistPath := filepath.Join(launchAgentsDir, fastLoginLabel+".plist")
@@ -202,13 +346,13 @@ func EnsureFastLoginService(cfg FastLoginConfig) error {
 	if err := os.MkdirAll(launchAgentsDir, 0o755); err != nil {
 		return fmt.Errorf("create LaunchAgents dir: %w", err)
 	}
-	if err := chownRecursive(cfg.AdminUser, launchAgentsDir); err != nil {
+	if err := chownRecursive(ctx, cfg.AdminUser, launchAgentsDir); err != nil {
 		return fmt.Errorf("chown LaunchAgents dir: %w", err)
 	}
 	if err := os.MkdirAll(logsDir, 0o755); err != nil {
 		return fmt.Errorf("create Logs dir: %w", err)
 	}
-	if err := chownRecursive(cfg.AdminUser, logsDir); err != nil {
+	if err := chownRecursive(ctx, cfg.AdminUser, logsDir); err != nil {
 		return fmt.Errorf("chown Logs dir: %w", err)
 	}
 
@@ -217,23 +361,136 @@ func EnsureFastLoginService(cfg FastLoginConfig) error {
 		usersStr += fmt.Sprintf("\"%s\" ", u)
 	}
 
-	scriptContent := fmt.Sprintf(fastLoginScriptTemplate, usersStr, cfg.Password)
+	scriptContent := fmt.Sprintf(fastLoginScriptTemplate, usersStr, cfg.Password,
+		filepath.Join(logsDir, fastLoginResultsFile), scriptSeconds(cfg.ConnectWait),
+		scriptSeconds(cfg.KeystrokeDelay), scriptSeconds(cfg.LoginWait), cfg.Attempts)
 	if err := os.WriteFile(scriptPath, []byte(scriptContent), 0o700); err != nil {
 		return fmt.Errorf("write script: %w", err)
 	}
-	if err := chownRecursive(cfg.AdminUser, scriptPath); err != nil {
+	if err := chownRecursive(ctx, cfg.AdminUser, scriptPath); err != nil {
 		return fmt.Errorf("chown script: %w", err)
 	}
 
-	stdoutLog := filepath.Join(logsDir, "prism-fast-login.log")
-	stderrLog := filepath.Join(logsDir, "prism-fast-login.err.log")
-	plistContent := fmt.Sprintf(fastLoginPlistTemplate, scriptPath, stdoutLog, stderrLog)
-	if err := os.WriteFile(plistPath, []byte(plistContent), 0o644); err != nil {
+	if err := os.WriteFile(plistPath, fastLoginJob(scriptPath, logsDir).Marshal(), 0o644); err != nil {
 		return fmt.Errorf("write plist: %w", err)
 	}
-	if err := chownRecursive(cfg.AdminUser, plistPath); err != nil {
+	if err := chownRecursive(ctx, cfg.AdminUser, plistPath); err != nil {
 		return fmt.Errorf("chown plist: %w", err)
 	}
 
 	return nil
 }
+
+// OpenUserSession runs the fast-login flow for a single user: it logs
+// username in over the VNC loopback from adminUser's GUI session, reusing the
+// SSH tunnel the spawner started, and leaves the Screen Sharing window open.
+func OpenUserSession(ctx context.Context, adminUser, username string) error {
+	scriptPath := filepath.Join(usersDir, adminUser, fastLoginScriptFilename)
+	if _, err := os.Stat(scriptPath); err != nil {
+		return fmt.Errorf("fast login is not installed for %s (run setup first): %w", adminUser, err)
+	}
+
+	uid, err := getUserUID(adminUser)
+	if err != nil {
+		return err
+	}
+	if !launchdLoaded(ctx, fmt.Sprintf("gui/%d", uid)) {
+		return fmt.Errorf("%s has no GUI session; log in to it (e.g. via Screen Sharing) first", adminUser)
+	}
+
+	if out, err := runner.Run(ctx, "launchctl", "asuser", strconv.Itoa(uid), "sudo", "-u", adminUser, scriptPath, username); err != nil {
+		return fmt.Errorf("open session for %s: %w (output=%s)", username, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// FastLoginResult is the latest fast-login outcome recorded for a user.
+type FastLoginResult struct {
+	User     string    `json:"user"`
+	Time     time.Time `json:"time"`
+	Attempts int       `json:"attempts"`
+	// OK means the user had a GUI session after the login.
+	OK     bool   `json:"ok"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// FastLoginResultsPath returns the result log the fast-login script of
+// adminUser appends to.
+func FastLoginResultsPath(adminUser string) string {
+	return filepath.Join(usersDir, adminUser, "Library", "Logs", fastLoginResultsFile)
+}
+
+// ReadFastLoginResults reads the result log of adminUser's fast-login script
+// and returns the latest final outcome of each user, sorted by name.
+func ReadFastLoginResults(adminUser string) ([]FastLoginResult, error) {
+	f, err := os.Open(FastLoginResultsPath(adminUser))
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+	return parseFastLoginResults(f)
+}
+
+// parseFastLoginResults parses lines of the form
+//
+//	2024-05-01T10:30:00Z user=prism1 attempt=2 status=failed reason=...
+//
+// Retry lines and malformed lines are skipped; later outcomes replace earlier
+// ones.
+func parseFastLoginResults(r io.Reader) ([]FastLoginResult, error) {
+	latest := map[string]FastLoginResult{}
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		res, ok := parseFastLoginLine(sc.Text())
+		if ok {
+			latest[res.User] = res
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+
+	results := make([]FastLoginResult, 0, len(latest))
+	for _, res := range latest {
+		results = append(results, res)
+	}
+	sort.Slice(results, func(a, b int) bool { return results[a].User < results[b].User })
+	return results, nil
+}
+
+func parseFastLoginLine(line string) (FastLoginResult, bool) {
+	var res FastLoginResult
+	line, reason, _ := strings.Cut(line, " reason=")
+	res.Reason = strings.TrimSpace(reason)
+
+	fields := strings.Fields(line)
+	if len(fields) != 4 {
+		return res, false
+	}
+	t, err := time.Parse(time.RFC3339, fields[0])
+	if err != nil {
+		return res, false
+	}
+	res.Time = t
+
+	var status string
+	for _, f := range fields[1:] {
+		k, v, _ := strings.Cut(f, "=")
+		switch k {
+		case "user":
+			res.User = v
+		case "attempt":
+			res.Attempts, _ = strconv.Atoi(v)
+		case "status":
+			status = v
+		}
+	}
+	switch status {
+	case "ok":
+		res.OK = true
+	case "failed":
+	default:
+		return res, false
+	}
+	return res, res.User != ""
+}
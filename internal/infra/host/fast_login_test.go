@@ -0,0 +1,51 @@
+//go:build darwin
+
+package host
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseFastLoginResults(t *testing.T) {
+	log := strings.Join([]string{
+		"2024-05-01T10:30:00Z user=prism1 attempt=1 status=retry reason=no window",
+		"2024-05-01T10:30:10Z user=prism1 attempt=2 status=ok",
+		"2024-05-01T10:30:20Z user=prism2 attempt=3 status=failed reason=no GUI session after login",
+		"2024-05-01T10:30:30Z user=prism3 attempt=1 status=failed reason=tunnel down",
+		"2024-05-01T10:40:00Z user=prism3 attempt=1 status=ok",
+		"2024-05-01T10:40:05Z user=prism4 attempt=1 status=retry",
+		"not a result line",
+		"yesterday user=prism5 attempt=1 status=ok",
+		"2024-05-01T10:41:00Z user=prism6 attempt=1 status=unknown",
+		"2024-05-01T10:41:00Z attempt=1 status=ok extra=field",
+		"",
+	}, "\n")
+
+	got, err := parseFastLoginResults(strings.NewReader(log))
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := func(s string) time.Time {
+		t.Helper()
+		v, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+	want := []FastLoginResult{
+		{User: "prism1", Time: at("2024-05-01T10:30:10Z"), Attempts: 2, OK: true},
+		{User: "prism2", Time: at("2024-05-01T10:30:20Z"), Attempts: 3, Reason: "no GUI session after login"},
+		{User: "prism3", Time: at("2024-05-01T10:40:00Z"), Attempts: 1, OK: true},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d results %+v, want %d", len(got), got, len(want))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("result %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
@@ -0,0 +1,102 @@
+//go:build darwin
+
+package host
+
+import (
+	"bytes"
+	"os"
+	"strings"
+
+	toml "github.com/pelletier/go-toml"
+
+	"prism/internal/infra/config"
+)
+
+// frpcFile is the frpc.toml written for each user. Field order is kept when
+// encoding so the file reads like frp's own examples.
+type frpcFile struct {
+	ServerAddr string         `toml:"serverAddr"`
+	ServerPort int            `toml:"serverPort"`
+	Auth       *frpcAuth      `toml:"auth,omitempty"`
+	Transport  *frpcTransport `toml:"transport,omitempty"`
+	Proxies    []frpcProxy    `toml:"proxies"`
+}
+
+type frpcAuth struct {
+	Token string `toml:"token"`
+}
+
+type frpcTransport struct {
+	Protocol          string   `toml:"protocol,omitempty"`
+	PoolCount         int      `toml:"poolCount,omitempty"`
+	HeartbeatInterval int      `toml:"heartbeatInterval,omitempty"`
+	HeartbeatTimeout  int      `toml:"heartbeatTimeout,omitempty"`
+	TLS               *frpcTLS `toml:"tls,omitempty"`
+}
+
+type frpcTLS struct {
+	Enable        *bool  `toml:"enable,omitempty"`
+	ServerName    string `toml:"serverName,omitempty"`
+	TrustedCaFile string `toml:"trustedCaFile,omitempty"`
+}
+
+type frpcProxy struct {
+	Name      string            `toml:"name"`
+	Type      string            `toml:"type"`
+	LocalIP   string            `toml:"localIP"`
+	LocalPort int               `toml:"localPort"`
+	Subdomain string            `toml:"subdomain"`
+	Metadatas map[string]string `toml:"metadatas"`
+}
+
+// renderFRPCConfig encodes the frpc.toml of username from cfg. metadatas
+// become the proxy's metadatas table; the user side fills in friendlyName.
+// The auth token comes from FRPC_TOKEN, or is existingToken (the token of the
+// file being replaced) when that is unset, and is omitted when both are empty.
+func renderFRPCConfig(cfg config.Config, username string, localPort int, subdomain string, metadatas map[string]string, existingToken string) ([]byte, error) {
+	f := frpcFile{
+		ServerAddr: cfg.Globals.FRPC.ServerAddr,
+		ServerPort: cfg.Globals.FRPC.ServerPort,
+		Transport:  frpcTransportFor(cfg.Globals.FRPC.Transport),
+		Proxies: []frpcProxy{{
+			Name:      username + "-imsg",
+			Type:      "http",
+			LocalIP:   cfg.Globals.Service.BindIP(),
+			LocalPort: localPort,
+			Subdomain: subdomain,
+			Metadatas: metadatas,
+		}},
+	}
+	token := strings.TrimSpace(os.Getenv(envFRPCToken))
+	if token == "" {
+		// sudo usually strips FRPC_TOKEN; keep the token the user already has.
+		token = strings.TrimSpace(existingToken)
+	}
+	if token != "" {
+		f.Auth = &frpcAuth{Token: token}
+	}
+
+	var buf bytes.Buffer
+	if err := toml.NewEncoder(&buf).Order(toml.OrderPreserve).Indentation("").Encode(f); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// frpcTransportFor maps globals.frpc.transport to the [transport] section,
+// or nil when nothing is set.
+func frpcTransportFor(t config.FRPCTransportConfig) *frpcTransport {
+	out := &frpcTransport{
+		Protocol:          t.Protocol,
+		PoolCount:         t.PoolCount,
+		HeartbeatInterval: t.HeartbeatIntervalSeconds,
+		HeartbeatTimeout:  t.HeartbeatTimeoutSeconds,
+	}
+	if t.TLSEnable != nil || t.TLSServerName != "" || t.TLSTrustedCAFile != "" {
+		out.TLS = &frpcTLS{Enable: t.TLSEnable, ServerName: t.TLSServerName, TrustedCaFile: t.TLSTrustedCAFile}
+	}
+	if *out == (frpcTransport{}) {
+		return nil
+	}
+	return out
+}
@@ -0,0 +1,51 @@
+//go:build darwin
+
+package host
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"prism/internal/infra/config"
+)
+
+func TestRenderFRPCConfigKeepsExistingToken(t *testing.T) {
+	cfg := config.Config{}
+	cfg.Globals.FRPC.ServerAddr = "frps.example.com"
+	cfg.Globals.FRPC.ServerPort = 7000
+
+	tests := []struct {
+		name     string
+		env      string
+		existing string
+		want     string
+	}{
+		{"env wins", "from-env", "old", "from-env"},
+		{"existing kept", "", "old", "old"},
+		{"none", "", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(envFRPCToken, tt.env)
+			data, err := renderFRPCConfig(cfg, "alice", 8001, "abc123", map[string]string{"friendlyName": "x"}, tt.existing)
+			if err != nil {
+				t.Fatal(err)
+			}
+			path := filepath.Join(t.TempDir(), "frpc.toml")
+			if err := os.WriteFile(path, data, 0o600); err != nil {
+				t.Fatal(err)
+			}
+			info, err := readFRPCProxy(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Token != tt.want {
+				t.Errorf("token = %q, want %q", info.Token, tt.want)
+			}
+			if info.Subdomain != "abc123" || info.Metadatas["friendlyName"] != "x" {
+				t.Errorf("proxy = %+v", info)
+			}
+		})
+	}
+}
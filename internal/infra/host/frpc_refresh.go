@@ -0,0 +1,155 @@
+//go:build darwin
+
+package host
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	toml "github.com/pelletier/go-toml"
+
+	"prism/internal/infra/config"
+	"prism/internal/infra/state"
+)
+
+// FRPCRefreshResult describes the outcome of RefreshFRPCConfigs.
+type FRPCRefreshResult struct {
+	// Users maps each user to the error that stopped its refresh, or nil.
+	Users map[string]error
+	// Restarted lists users whose running frpc was restarted to load the
+	// new file.
+	Restarted []string
+}
+
+// RefreshFRPCConfigs rewrites every user's frpc.toml from the current
+// globals.frpc settings, keeping the subdomain and the friendlyName metadata
+// the user side wrote, and restarts frpc for users whose frpc is running.
+// It is the config-only counterpart of UpdateUserCode: one user's failure
+// does not stop the others, and when some fail the error wraps
+// ErrPartialFRPCRefresh and res.Users holds each user's outcome.
+func RefreshFRPCConfigs(ctx context.Context, cfg config.Config, st state.State) (FRPCRefreshResult, error) {
+	var res FRPCRefreshResult
+	if len(st.Users) == 0 {
+		return res, errors.New("no existing users in state; nothing to refresh")
+	}
+
+	res.Users = make(map[string]error, len(st.Users))
+	var failed []string
+	for _, u := range st.Users {
+		if err := ctx.Err(); err != nil {
+			return res, err
+		}
+		restarted, err := refreshUserFRPCConfig(ctx, cfg, u)
+		res.Users[u.Name] = err
+		if err != nil {
+			failed = append(failed, u.Name)
+			continue
+		}
+		if restarted {
+			res.Restarted = append(res.Restarted, u.Name)
+		}
+	}
+
+	if len(failed) > 0 {
+		return res, fmt.Errorf("%w: %d of %d users (%s)", ErrPartialFRPCRefresh, len(failed), len(st.Users), strings.Join(failed, ", "))
+	}
+	return res, nil
+}
+
+// refreshUserFRPCConfig rewrites the frpc.toml of u and restarts its frpc if
+// it was running. It reports whether frpc was restarted.
+func refreshUserFRPCConfig(ctx context.Context, cfg config.Config, u state.User) (bool, error) {
+	path := filepath.Join(userServiceDir(u.Name, config.PrimaryServiceName), "frpc.toml")
+	existing, err := readFRPCProxy(path)
+	if err != nil {
+		return false, fmt.Errorf("read %s: %w", path, err)
+	}
+	subdomain, metadatas := existing.Subdomain, existing.Metadatas
+	if subdomain == "" {
+		subdomain = u.Subdomain
+	}
+	if subdomain == "" {
+		return false, fmt.Errorf("no subdomain for user %s in %s or state", u.Name, path)
+	}
+	if _, ok := metadatas["friendlyName"]; !ok {
+		metadatas["friendlyName"] = ""
+	}
+
+	data, err := renderFRPCConfig(cfg, u.Name, u.Port, subdomain, metadatas, existing.Token)
+	if err != nil {
+		return false, fmt.Errorf("render frpc.toml: %w", err)
+	}
+	if err := writeFileAtomic(path, data, 0o600); err != nil {
+		return false, fmt.Errorf("write %s: %w", path, err)
+	}
+	if err := chownRecursive(ctx, u.Name, path); err != nil {
+		return false, err
+	}
+
+	label := fmt.Sprintf(launchDaemonFRPCLabel, u.Name)
+	if !launchDaemonRunning(ctx, label) {
+		return false, nil
+	}
+	if out, err := runner.Run(ctx, "launchctl", "kickstart", "-k", "system/"+label); err != nil {
+		return false, fmt.Errorf("restart frpc: %w (output=%s)", err, strings.TrimSpace(string(out)))
+	}
+	return true, nil
+}
+
+// frpcProxyInfo is what readFRPCProxy keeps from an existing frpc.toml.
+type frpcProxyInfo struct {
+	// Subdomain and Metadatas (string values only) are those of the first
+	// proxy.
+	Subdomain string
+	Metadatas map[string]string
+	// Token is auth.token, empty when the file has none.
+	Token string
+}
+
+// readFRPCProxy reads the frpc config at path. A missing file yields empty
+// values.
+func readFRPCProxy(path string) (frpcProxyInfo, error) {
+	info := frpcProxyInfo{Metadatas: make(map[string]string)}
+	tree, err := toml.LoadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return info, nil
+		}
+		return frpcProxyInfo{}, err
+	}
+	token, _ := tree.Get("auth.token").(string)
+	info.Token = strings.TrimSpace(token)
+	proxies, ok := tree.Get("proxies").([]*toml.Tree)
+	if !ok || len(proxies) == 0 || proxies[0] == nil {
+		return info, nil
+	}
+	subdomain, _ := proxies[0].Get("subdomain").(string)
+	info.Subdomain = strings.TrimSpace(subdomain)
+	if meta, ok := proxies[0].Get("metadatas").(*toml.Tree); ok {
+		for _, key := range meta.Keys() {
+			if val, ok := meta.Get(key).(string); ok {
+				info.Metadatas[key] = val
+			}
+		}
+	}
+	return info, nil
+}
+
+// launchDaemonRunning reports whether the system LaunchDaemon label is loaded
+// and its process is running.
+func launchDaemonRunning(ctx context.Context, label string) bool {
+	out, err := runner.Run(ctx, "launchctl", "print", "system/"+label)
+	if err != nil {
+		return false
+	}
+	for _, line := range strings.Split(string(out), "\n") {
+		if strings.TrimSpace(line) == "state = running" {
+			return true
+		}
+	}
+	return false
+}
@@ -3,24 +3,26 @@
 package host
 
 import (
+	"context"
 	"fmt"
 	"os"
-	"os/exec"
 	osuser "os/user"
 	"path/filepath"
 	"strconv"
+	"strings"
 )
 
-const (
-	keepaliveLabel    = "com.imessage.keepalive"
-	keepaliveInterval = 600 // 10 minutes
-)
+// KeepaliveLabel is the launchd label of the per-user keepalive LaunchAgent.
+const KeepaliveLabel = "com.imessage.keepalive"
+
+const keepaliveInterval = 600 // 10 minutes
 
 // Keepalive shell script template that reads chat.db and triggers imagent XPC.
 const keepaliveScriptTemplate = `#!/bin/bash
 # iMessage Keepalive Script - Auto-generated by Prism
 
 LOG_FILE="$HOME/Library/Logs/imessage-keepalive.log"
+PRISM="$HOME/services/imsg/prism"
 
 log() {
     echo "[$(date '+%%Y-%%m-%%d %%H:%%M:%%S')] $1" >> "$LOG_FILE"
@@ -40,6 +42,11 @@ keepalive() {
     /bin/launchctl print gui/$(id -u)/com.apple.imagent > /dev/null 2>&1
     log "XPC trigger complete"
 
+    # 3. Re-detect the friendly name when globals.frpc.friendly_name_refresh_minutes is set
+    if [ -x "$PRISM" ]; then
+        "$PRISM" refresh-name --if-due >> "$LOG_FILE" 2>&1
+    fi
+
     log "====== Cycle complete ======"
 }
 
@@ -51,37 +58,26 @@ while true; do
 done
 `
 
-// LaunchAgent plist template for keepalive service.
-// Must be LaunchAgent (not LaunchDaemon) because it needs GUI session.
-const keepaliveLaunchAgentTemplate = `<?xml version="1.0" encoding="UTF-8"?>
-<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
-<plist version="1.0">
-<dict>
-    <key>Label</key>
-    <string>com.imessage.keepalive</string>
-    <key>ProgramArguments</key>
-    <array>
-        <string>%s</string>
-    </array>
-    <key>RunAtLoad</key>
-    <true/>
-    <key>KeepAlive</key>
-    <true/>
-    <key>StandardOutPath</key>
-    <string>%s</string>
-    <key>StandardErrorPath</key>
-    <string>%s</string>
-</dict>
-</plist>
-`
+// keepaliveJob is the keepalive LaunchAgent. It must be a LaunchAgent (not a
+// LaunchDaemon) because it needs the GUI session.
+func keepaliveJob(scriptPath, logsDir string) launchdJob {
+	return launchdJob{
+		Label:             KeepaliveLabel,
+		ProgramArguments:  []string{scriptPath},
+		RunAtLoad:         true,
+		KeepAlive:         plistBool(true),
+		StandardOutPath:   filepath.Join(logsDir, "imessage-keepalive-stdout.log"),
+		StandardErrorPath: filepath.Join(logsDir, "imessage-keepalive-stderr.log"),
+	}
+}
 
 // EnsureKeepaliveService deploys the keepalive script and LaunchAgent for a user.
 // This is idempotent - it will overwrite existing files to ensure latest version.
-func EnsureKeepaliveService(username string) error {
-	homeDir := filepath.Join("/Users", username)
+func EnsureKeepaliveService(ctx context.Context, username string) error {
+	homeDir := filepath.Join(usersDir, username)
 	scriptPath := filepath.Join(homeDir, "imessage-keepalive.sh")
 	launchAgentsDir := filepath.Join(homeDir, "Library", "LaunchAgents")
-	plistPath := filepath.Join(launchAgentsDir, keepaliveLabel+".plist")
+	plistPath := filepath.Join(launchAgentsDir, KeepaliveLabel+".plist")
 	logsDir := filepath.Join(homeDir, "Library", "Logs")
 
 	// Get user UID for launchctl
@@ -94,14 +90,14 @@ func EnsureKeepaliveService(username string) error {
 	if err := os.MkdirAll(launchAgentsDir, 0o755); err != nil {
 		return fmt.Errorf("create LaunchAgents dir: %w", err)
 	}
-	if err := chownRecursive(username, launchAgentsDir); err != nil {
+	if err := chownRecursive(ctx, username, launchAgentsDir); err != nil {
 		return fmt.Errorf("chown LaunchAgents dir: %w", err)
 	}
 
 	if err := os.MkdirAll(logsDir, 0o755); err != nil {
 		return fmt.Errorf("create Logs dir: %w", err)
 	}
-	if err := chownRecursive(username, logsDir); err != nil {
+	if err := chownRecursive(ctx, username, logsDir); err != nil {
 		return fmt.Errorf("chown Logs dir: %w", err)
 	}
 
@@ -112,32 +108,29 @@ func EnsureKeepaliveService(username string) error {
 	}
 
 	// Fix ownership
-	if err := chownRecursive(username, scriptPath); err != nil {
+	if err := chownRecursive(ctx, username, scriptPath); err != nil {
 		return fmt.Errorf("chown script: %w", err)
 	}
 
 	// Write LaunchAgent plist
-	stdoutLog := filepath.Join(logsDir, "imessage-keepalive-stdout.log")
-	stderrLog := filepath.Join(logsDir, "imessage-keepalive-stderr.log")
-	plistContent := fmt.Sprintf(keepaliveLaunchAgentTemplate, scriptPath, stdoutLog, stderrLog)
-	if err := os.WriteFile(plistPath, []byte(plistContent), 0o644); err != nil {
+	if err := os.WriteFile(plistPath, keepaliveJob(scriptPath, logsDir).Marshal(), 0o644); err != nil {
 		return fmt.Errorf("write keepalive plist: %w", err)
 	}
 
 	// Fix ownership of plist
-	if err := chownRecursive(username, plistPath); err != nil {
+	if err := chownRecursive(ctx, username, plistPath); err != nil {
 		return fmt.Errorf("chown plist: %w", err)
 	}
 
 	// Bootstrap the LaunchAgent if not already loaded
 	// Note: This requires the user to have an active GUI session
 	domain := fmt.Sprintf("gui/%d", uid)
-	serviceTarget := fmt.Sprintf("%s/%s", domain, keepaliveLabel)
+	serviceTarget := fmt.Sprintf("%s/%s", domain, KeepaliveLabel)
 
 	// Bootout first to ensure reload
-	_ = exec.Command("launchctl", "bootout", serviceTarget).Run()
+	_, _ = runner.Run(ctx, "launchctl", "bootout", serviceTarget)
 
-	if err := exec.Command("launchctl", "bootstrap", domain, plistPath).Run(); err != nil {
+	if _, err := runner.Run(ctx, "launchctl", "bootstrap", domain, plistPath); err != nil {
 		// Not an error - user might not have GUI session yet
 		// Service will start automatically when user logs in (RunAtLoad)
 		return nil
@@ -146,6 +139,57 @@ func EnsureKeepaliveService(username string) error {
 	return nil
 }
 
+// KeepaliveStatus is the state of a user's keepalive LaunchAgent.
+type KeepaliveStatus struct {
+	// Installed means the LaunchAgent plist exists.
+	Installed bool `json:"installed"`
+	// Loaded means launchd has the job in the user's GUI domain, which
+	// requires a GUI session.
+	Loaded  bool `json:"loaded"`
+	Running bool `json:"running"`
+}
+
+// Summary is a one-line description of the keepalive state.
+func (k KeepaliveStatus) Summary() string {
+	switch {
+	case !k.Installed:
+		return "keepalive not installed"
+	case !k.Loaded:
+		return "keepalive not loaded (no GUI session?)"
+	case !k.Running:
+		return "keepalive loaded but not running"
+	default:
+		return "keepalive running"
+	}
+}
+
+// CheckKeepalive reports whether the keepalive LaunchAgent of username is
+// installed, loaded and running. It changes nothing.
+func CheckKeepalive(ctx context.Context, username string) (KeepaliveStatus, error) {
+	var ks KeepaliveStatus
+	plistPath := filepath.Join(usersDir, username, "Library", "LaunchAgents", KeepaliveLabel+".plist")
+	if _, err := os.Stat(plistPath); err == nil {
+		ks.Installed = true
+	}
+
+	uid, err := getUserUID(username)
+	if err != nil {
+		return ks, err
+	}
+	out, err := runner.Run(ctx, "launchctl", "print", fmt.Sprintf("gui/%d/%s", uid, KeepaliveLabel))
+	if err != nil {
+		return ks, nil
+	}
+	ks.Loaded = true
+	for _, line := range strings.Split(string(out), "\n") {
+		if strings.TrimSpace(line) == "state = running" {
+			ks.Running = true
+			break
+		}
+	}
+	return ks, nil
+}
+
 // getUserUID returns the numeric UID for a username.
 func getUserUID(username string) (int, error) {
 	u, err := osuser.Lookup(username)
@@ -158,3 +202,13 @@ func getUserUID(username string) (int, error) {
 	}
 	return uid, nil
 }
+
+// removeKeepaliveService unloads the keepalive LaunchAgent of a user and
+// deletes its plist. The user's GUI session may not be running.
+func removeKeepaliveService(ctx context.Context, username string) {
+	homeDir := filepath.Join(usersDir, username)
+	if uid, err := getUserUID(username); err == nil {
+		_, _ = runner.Run(ctx, "launchctl", "bootout", fmt.Sprintf("gui/%d/%s", uid, KeepaliveLabel))
+	}
+	_ = os.Remove(filepath.Join(homeDir, "Library", "LaunchAgents", KeepaliveLabel+".plist"))
+}
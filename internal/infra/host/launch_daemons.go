@@ -3,97 +3,38 @@
 package host
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
 	"fmt"
+	"io/fs"
 	"log"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
+	"syscall"
 	"time"
+
+	"prism/internal/infra/config"
 )
 
+// defaultServerPath is the PATH of server LaunchDaemons unless overridden by
+// globals.service.env.
+const defaultServerPath = "/usr/local/bin:/usr/bin:/bin:/usr/sbin:/sbin:/opt/homebrew/bin:/opt/homebrew/opt/node@18/bin"
+
 const (
 	launchDaemonServerLabel = "com.imsg.server.%s"
 	launchDaemonFRPCLabel   = "com.imsg.frpc.%s"
-	launchDaemonsDir        = "/Library/LaunchDaemons"
 )
 
-// LaunchDaemon plist template for iMessage server.
-// Uses UserName key to run as specific user at boot without login.
-const serverLaunchDaemonTemplate = `<?xml version="1.0" encoding="UTF-8"?>
-<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
-<plist version="1.0">
-  <dict>
-    <key>Label</key>
-    <string>com.imsg.server.%s</string>
-    <key>UserName</key>
-    <string>%s</string>
-    <key>ProgramArguments</key>
-    <array>
-      <string>%s</string>
-    </array>
-    <key>WorkingDirectory</key>
-    <string>%s</string>
-    <key>EnvironmentVariables</key>
-    <dict>
-      <key>NODE_ENV</key>
-      <string>production</string>
-      <key>PORT</key>
-      <string>%d</string>
-      <key>MACHINE_ID</key>
-      <string>%s</string>
-      <key>NEXUS_BASE_URL</key>
-      <string>%s</string>
-      <key>PATH</key>
-      <string>/usr/local/bin:/usr/bin:/bin:/usr/sbin:/sbin:/opt/homebrew/bin:/opt/homebrew/opt/node@18/bin</string>
-      <key>HOME</key>
-      <string>%s</string>
-    </dict>
-    <key>RunAtLoad</key>
-    <true/>
-    <key>KeepAlive</key>
-    <true/>
-    <key>StandardOutPath</key>
-    <string>%s</string>
-    <key>StandardErrorPath</key>
-    <string>%s</string>
-  </dict>
-</plist>
-`
-
-// LaunchDaemon plist template for frpc tunnel.
-const frpcLaunchDaemonTemplate = `<?xml version="1.0" encoding="UTF-8"?>
-<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
-<plist version="1.0">
-  <dict>
-    <key>Label</key>
-    <string>com.imsg.frpc.%s</string>
-    <key>UserName</key>
-    <string>%s</string>
-    <key>ProgramArguments</key>
-    <array>
-      <string>%s</string>
-      <string>-c</string>
-      <string>%s</string>
-    </array>
-    <key>WorkingDirectory</key>
-    <string>%s</string>
-    <key>EnvironmentVariables</key>
-    <dict>
-      <key>HOME</key>
-      <string>%s</string>
-    </dict>
-    <key>RunAtLoad</key>
-    <true/>
-    <key>KeepAlive</key>
-    <true/>
-    <key>StandardOutPath</key>
-    <string>%s</string>
-    <key>StandardErrorPath</key>
-    <string>%s</string>
-  </dict>
-</plist>
-`
+// launchDaemonsDir and usersDir are variables so tests can point them at a
+// temporary directory.
+var (
+	launchDaemonsDir = "/Library/LaunchDaemons"
+	usersDir         = "/Users"
+)
 
 // UserLaunchDaemonConfig holds configuration for creating per-user LaunchDaemons.
 type UserLaunchDaemonConfig struct {
@@ -104,60 +45,260 @@ type UserLaunchDaemonConfig struct {
 	FRPCBin    string
 	FRPCConfig string
 	LocalPort  int
+	LocalIP    string
 	MachineID  string
 	NexusAddr  string
+	// Env is merged over the default server environment (globals.service.env).
+	Env map[string]string
 }
 
 // EnsureUserLaunchDaemons creates LaunchDaemon plist files in /Library/LaunchDaemons/.
 // Uses UserName key to run services as specific user at boot without login.
-func EnsureUserLaunchDaemons(cfg UserLaunchDaemonConfig) error {
-	log.Printf("[launch_daemons] creating for %s", cfg.Username)
+// It is idempotent: plists whose content is unchanged are not rewritten and
+// the logs dir is only chowned when it is not owned by the user.
+func EnsureUserLaunchDaemons(ctx context.Context, cfg UserLaunchDaemonConfig) error {
+	log.Printf("[launch_daemons] ensuring for %s", cfg.Username)
 
 	logsDir := filepath.Join(cfg.HomeDir, "Library", "Logs")
 	if err := os.MkdirAll(logsDir, 0o755); err != nil {
 		return fmt.Errorf("create logs dir: %w", err)
 	}
-	if err := chownRecursive(cfg.Username, logsDir); err != nil {
-		return fmt.Errorf("chown logs dir: %w", err)
+	if !ownedByUser(cfg.Username, logsDir) {
+		if err := chownRecursive(ctx, cfg.Username, logsDir); err != nil {
+			return fmt.Errorf("chown logs dir: %w", err)
+		}
 	}
 
-	serverPlist := filepath.Join(launchDaemonsDir, fmt.Sprintf(launchDaemonServerLabel+".plist", cfg.Username))
-	serverContent := fmt.Sprintf(serverLaunchDaemonTemplate,
-		cfg.Username, cfg.Username, cfg.ServerBin, cfg.ServiceDir,
-		cfg.LocalPort, cfg.MachineID, strings.TrimRight(cfg.NexusAddr, "/"), cfg.HomeDir,
-		filepath.Join(logsDir, "imsg-server.log"), filepath.Join(logsDir, "imsg-server.err"),
-	)
-	if err := os.WriteFile(serverPlist, []byte(serverContent), 0o644); err != nil {
-		return fmt.Errorf("write server plist: %w", err)
+	if _, err := writeServerLaunchDaemon(ctx, serverDaemonConfig{
+		Label:      fmt.Sprintf(launchDaemonServerLabel, cfg.Username),
+		Username:   cfg.Username,
+		HomeDir:    cfg.HomeDir,
+		ServiceDir: cfg.ServiceDir,
+		ServerBin:  cfg.ServerBin,
+		Port:       cfg.LocalPort,
+		Host:       cfg.LocalIP,
+		MachineID:  cfg.MachineID,
+		NexusAddr:  cfg.NexusAddr,
+		Env:        cfg.Env,
+		LogName:    "imsg-server",
+	}); err != nil {
+		return err
 	}
 
 	frpcPlist := filepath.Join(launchDaemonsDir, fmt.Sprintf(launchDaemonFRPCLabel+".plist", cfg.Username))
-	frpcContent := fmt.Sprintf(frpcLaunchDaemonTemplate,
-		cfg.Username, cfg.Username, cfg.FRPCBin, cfg.FRPCConfig, cfg.ServiceDir, cfg.HomeDir,
-		filepath.Join(logsDir, "frpc.log"), filepath.Join(logsDir, "frpc.err"),
-	)
-	if err := os.WriteFile(frpcPlist, []byte(frpcContent), 0o644); err != nil {
+	if _, err := writePlistIfChanged(frpcPlist, cfg.frpcJob().Marshal()); err != nil {
 		return fmt.Errorf("write frpc plist: %w", err)
 	}
 
 	return nil
 }
 
+// frpcJob is the LaunchDaemon that runs the user's frpc client.
+func (cfg UserLaunchDaemonConfig) frpcJob() launchdJob {
+	logsDir := filepath.Join(cfg.HomeDir, "Library", "Logs")
+	return launchdJob{
+		Label:                fmt.Sprintf(launchDaemonFRPCLabel, cfg.Username),
+		UserName:             cfg.Username,
+		ProgramArguments:     []string{cfg.FRPCBin, "-c", cfg.FRPCConfig},
+		WorkingDirectory:     cfg.ServiceDir,
+		EnvironmentVariables: map[string]string{"HOME": cfg.HomeDir},
+		RunAtLoad:            true,
+		KeepAlive:            plistBool(true),
+		StandardOutPath:      filepath.Join(logsDir, "frpc.log"),
+		StandardErrorPath:    filepath.Join(logsDir, "frpc.err"),
+	}
+}
+
+// writePlistIfChanged atomically writes content to path unless the file
+// already holds exactly that; changed reports whether it was written.
+func writePlistIfChanged(path string, content []byte) (changed bool, err error) {
+	old, readErr := os.ReadFile(path)
+	if readErr == nil && bytes.Equal(old, content) {
+		return false, nil
+	}
+	if err := writeFileAtomic(path, content, 0o644); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// ownedByUser reports whether path and everything below it belong to
+// username. It also reports true when not running as root, where
+// chownRecursive does nothing anyway.
+func ownedByUser(username, path string) bool {
+	if os.Geteuid() != 0 {
+		return true
+	}
+	uid, err := getUserUID(username)
+	if err != nil {
+		return false
+	}
+	owned := true
+	_ = filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			owned = false
+			return fs.SkipAll
+		}
+		info, err := d.Info()
+		if err != nil {
+			owned = false
+			return fs.SkipAll
+		}
+		if st, ok := info.Sys().(*syscall.Stat_t); !ok || int(st.Uid) != uid {
+			owned = false
+			return fs.SkipAll
+		}
+		return nil
+	})
+	return owned
+}
+
+// serverDaemonConfig describes one server LaunchDaemon. LogName is the base
+// name of the .log/.err files in ~/Library/Logs.
+type serverDaemonConfig struct {
+	Label      string
+	Username   string
+	HomeDir    string
+	ServiceDir string
+	ServerBin  string
+	Port       int
+	Host       string
+	MachineID  string
+	NexusAddr  string
+	Env        map[string]string
+	LogName    string
+}
+
+// serverEnv returns the server environment: the defaults, then cfg.Env, then
+// the per-user values Prism manages.
+func (cfg serverDaemonConfig) serverEnv() map[string]string {
+	env := map[string]string{
+		"NODE_ENV":       "production",
+		"NEXUS_BASE_URL": strings.TrimRight(cfg.NexusAddr, "/"),
+		"PATH":           defaultServerPath,
+	}
+	for k, v := range cfg.Env {
+		env[k] = v
+	}
+	env["PORT"] = strconv.Itoa(cfg.Port)
+	if cfg.Host != "" {
+		env["HOST"] = cfg.Host
+	}
+	env["MACHINE_ID"] = cfg.MachineID
+	env["HOME"] = cfg.HomeDir
+	return env
+}
+
+// job is the server LaunchDaemon. UserName runs the server as the sub-user
+// at boot without a login.
+func (cfg serverDaemonConfig) job() launchdJob {
+	logsDir := filepath.Join(cfg.HomeDir, "Library", "Logs")
+	return launchdJob{
+		Label:                cfg.Label,
+		UserName:             cfg.Username,
+		ProgramArguments:     []string{cfg.ServerBin},
+		WorkingDirectory:     cfg.ServiceDir,
+		EnvironmentVariables: cfg.serverEnv(),
+		RunAtLoad:            true,
+		KeepAlive:            plistBool(true),
+		StandardOutPath:      filepath.Join(logsDir, cfg.LogName+".log"),
+		StandardErrorPath:    filepath.Join(logsDir, cfg.LogName+".err"),
+	}
+}
+
+// writeServerLaunchDaemon writes the plist for a server LaunchDaemon. When a
+// loaded daemon's plist changes it is booted out so the next bootstrap picks
+// up the new definition; reloaded reports whether that happened.
+func writeServerLaunchDaemon(ctx context.Context, cfg serverDaemonConfig) (reloaded bool, err error) {
+	plistPath := filepath.Join(launchDaemonsDir, cfg.Label+".plist")
+	_, statErr := os.Stat(plistPath)
+	changed, err := writePlistIfChanged(plistPath, cfg.job().Marshal())
+	if err != nil {
+		return false, fmt.Errorf("write %s plist: %w", cfg.Label, err)
+	}
+	if changed && statErr == nil && launchdLoaded(ctx, "system/"+cfg.Label) {
+		_, _ = runner.Run(ctx, "launchctl", "bootout", "system/"+cfg.Label)
+		return true, nil
+	}
+	return false, nil
+}
+
+// userNexusAddr returns the nexus_addr of username's primary config.json,
+// which may differ from globals.nexus.base_url, or the latter when it is
+// unset.
+func userNexusAddr(cfg config.Config, username string) string {
+	var ucfg struct {
+		NexusAddr string `json:"nexus_addr"`
+	}
+	path := filepath.Join(userServiceDir(username, config.PrimaryServiceName), "config.json")
+	if data, err := os.ReadFile(path); err == nil && json.Unmarshal(data, &ucfg) == nil && strings.TrimSpace(ucfg.NexusAddr) != "" {
+		return ucfg.NexusAddr
+	}
+	return strings.TrimRight(cfg.Globals.Nexus.BaseURL, "/")
+}
+
+// refreshServerLaunchDaemon re-renders the primary server LaunchDaemon of an
+// existing user from the current config, so changes such as
+// globals.service.env reach daemons created by an earlier run.
+func refreshServerLaunchDaemon(ctx context.Context, cfg config.Config, username string, port int) error {
+	serviceDir := userServiceDir(username, config.PrimaryServiceName)
+	nexusAddr := userNexusAddr(cfg, username)
+
+	label := fmt.Sprintf(launchDaemonServerLabel, username)
+	reloaded, err := writeServerLaunchDaemon(ctx, serverDaemonConfig{
+		Label:      label,
+		Username:   username,
+		HomeDir:    filepath.Join(usersDir, username),
+		ServiceDir: serviceDir,
+		ServerBin:  filepath.Join(serviceDir, serverBinRelPath),
+		Port:       port,
+		Host:       cfg.Globals.Service.BindIP(),
+		MachineID:  cfg.Globals.MachineID,
+		NexusAddr:  nexusAddr,
+		Env:        cfg.Globals.Service.Env,
+		LogName:    "imsg-server",
+	})
+	if err != nil || !reloaded {
+		return err
+	}
+	// Only daemons that were running are loaded again; stopped ones stay stopped.
+	return bootstrapWithRetry(ctx, filepath.Join(launchDaemonsDir, label+".plist"), 3)
+}
+
+// userServerLabels returns the server LaunchDaemon labels installed for
+// username: the primary com.imsg.server.<user> first, then one per service
+// from globals.services (com.<service>.server.<user>).
+func userServerLabels(username string) []string {
+	primary := fmt.Sprintf(launchDaemonServerLabel, username)
+	labels := []string{primary}
+	matches, _ := filepath.Glob(filepath.Join(launchDaemonsDir, "com.*.server."+username+".plist"))
+	sort.Strings(matches)
+	for _, m := range matches {
+		if label := strings.TrimSuffix(filepath.Base(m), ".plist"); label != primary {
+			labels = append(labels, label)
+		}
+	}
+	return labels
+}
+
 // BootstrapUserLaunchDaemons loads LaunchDaemons into system domain.
-// Includes retry logic for boot-time when launchd may not be fully ready.
-func BootstrapUserLaunchDaemons(username string) error {
-	serverPlist := filepath.Join(launchDaemonsDir, fmt.Sprintf(launchDaemonServerLabel+".plist", username))
+// Includes retry logic for boot-time when launchd may not be fully ready;
+// cancelling ctx stops the retries.
+func BootstrapUserLaunchDaemons(ctx context.Context, username string) error {
 	frpcPlist := filepath.Join(launchDaemonsDir, fmt.Sprintf(launchDaemonFRPCLabel+".plist", username))
-
 	if _, err := os.Stat(frpcPlist); err == nil {
-		if err := bootstrapWithRetry(frpcPlist, 3); err != nil {
+		if err := bootstrapWithRetry(ctx, frpcPlist, 3); err != nil {
 			return fmt.Errorf("bootstrap frpc: %w", err)
 		}
 	}
 
-	if _, err := os.Stat(serverPlist); err == nil {
-		if err := bootstrapWithRetry(serverPlist, 3); err != nil {
-			return fmt.Errorf("bootstrap server: %w", err)
+	for _, label := range userServerLabels(username) {
+		serverPlist := filepath.Join(launchDaemonsDir, label+".plist")
+		if _, err := os.Stat(serverPlist); err != nil {
+			continue
+		}
+		if err := bootstrapWithRetry(ctx, serverPlist, 3); err != nil {
+			return fmt.Errorf("bootstrap %s: %w", label, err)
 		}
 	}
 
@@ -166,29 +307,28 @@ func BootstrapUserLaunchDaemons(username string) error {
 }
 
 // RemoveUserLaunchDaemons unloads and deletes LaunchDaemon files for a user.
-func RemoveUserLaunchDaemons(username string) error {
-	serverLabel := fmt.Sprintf(launchDaemonServerLabel, username)
-	frpcLabel := fmt.Sprintf(launchDaemonFRPCLabel, username)
-
-	_ = exec.Command("launchctl", "bootout", "system/"+serverLabel).Run()
-	_ = exec.Command("launchctl", "bootout", "system/"+frpcLabel).Run()
-	_ = os.Remove(filepath.Join(launchDaemonsDir, serverLabel+".plist"))
-	_ = os.Remove(filepath.Join(launchDaemonsDir, frpcLabel+".plist"))
+func RemoveUserLaunchDaemons(ctx context.Context, username string) error {
+	labels := append(userServerLabels(username), fmt.Sprintf(launchDaemonFRPCLabel, username))
+	for _, label := range labels {
+		_, _ = runner.Run(ctx, "launchctl", "bootout", "system/"+label)
+		_ = os.Remove(filepath.Join(launchDaemonsDir, label+".plist"))
+	}
 
 	return nil
 }
 
-// RestartUserDaemons restarts both server and frpc daemons for a user.
-func RestartUserDaemons(username string) error {
-	serverLabel := fmt.Sprintf(launchDaemonServerLabel, username)
+// RestartUserDaemons restarts the frpc daemon and every server daemon of a user.
+func RestartUserDaemons(ctx context.Context, username string) error {
 	frpcLabel := fmt.Sprintf(launchDaemonFRPCLabel, username)
 
 	var errs []string
-	if out, err := exec.Command("launchctl", "kickstart", "-k", "system/"+frpcLabel).CombinedOutput(); err != nil {
+	if out, err := runner.Run(ctx, "launchctl", "kickstart", "-k", "system/"+frpcLabel); err != nil {
 		errs = append(errs, fmt.Sprintf("frpc: %v (%s)", err, strings.TrimSpace(string(out))))
 	}
-	if out, err := exec.Command("launchctl", "kickstart", "-k", "system/"+serverLabel).CombinedOutput(); err != nil {
-		errs = append(errs, fmt.Sprintf("server: %v (%s)", err, strings.TrimSpace(string(out))))
+	for _, label := range userServerLabels(username) {
+		if out, err := runner.Run(ctx, "launchctl", "kickstart", "-k", "system/"+label); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v (%s)", label, err, strings.TrimSpace(string(out))))
+		}
 	}
 
 	if len(errs) > 0 {
@@ -197,23 +337,35 @@ func RestartUserDaemons(username string) error {
 	return nil
 }
 
-func bootstrapWithRetry(plistPath string, retries int) error {
+// bootstrapWithRetry bootstraps plistPath, retrying with exponential backoff
+// (1s, 2s, 4s, ...) while launchd is not ready, e.g. early at boot or right
+// after a bootout of the same label. It gives up early when ctx is done.
+func bootstrapWithRetry(ctx context.Context, plistPath string, retries int) error {
 	var lastErr error
+	backoff := time.Second
 	for i := 0; i <= retries; i++ {
 		if i > 0 {
-			time.Sleep(time.Duration(i) * time.Second)
+			log.Printf("[launch_daemons] bootstrap %s failed (attempt %d/%d): %v; retrying in %s",
+				filepath.Base(plistPath), i, retries+1, lastErr, backoff)
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(backoff):
+			}
+			backoff *= 2
 		}
-		if err := bootstrapDaemon(plistPath); err == nil {
+		if err := bootstrapDaemon(ctx, plistPath); err == nil {
 			return nil
 		} else {
 			lastErr = err
 		}
 	}
+	log.Printf("[launch_daemons] bootstrap %s failed after %d attempts: %v", filepath.Base(plistPath), retries+1, lastErr)
 	return lastErr
 }
 
-func bootstrapDaemon(plistPath string) error {
-	out, err := exec.Command("launchctl", "bootstrap", "system", plistPath).CombinedOutput()
+func bootstrapDaemon(ctx context.Context, plistPath string) error {
+	out, err := runner.Run(ctx, "launchctl", "bootstrap", "system", plistPath)
 	if err != nil {
 		output := strings.TrimSpace(string(out))
 		if strings.Contains(output, "already bootstrapped") || strings.Contains(output, "EEXIST") {
@@ -223,6 +375,6 @@ func bootstrapDaemon(plistPath string) error {
 	}
 
 	label := strings.TrimSuffix(filepath.Base(plistPath), ".plist")
-	_ = exec.Command("launchctl", "enable", "system/"+label).Run()
+	_, _ = runner.Run(ctx, "launchctl", "enable", "system/"+label)
 	return nil
 }
@@ -0,0 +1,123 @@
+//go:build darwin
+
+package host
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"prism/internal/infra/state"
+)
+
+// maintenanceFile is the marker, in the output directory, that keeps every
+// user daemon stopped until ExitMaintenance removes it.
+const maintenanceFile = "maintenance.json"
+
+// MaintenanceInfo describes the host's maintenance mode. Active is false and
+// the other fields are zero when the host is not in maintenance.
+type MaintenanceInfo struct {
+	Active bool      `json:"-"`
+	Since  time.Time `json:"since"`
+	Reason string    `json:"reason,omitempty"`
+}
+
+// String describes info in one line, for the CLI and the TUI header.
+func (info MaintenanceInfo) String() string {
+	if !info.Active {
+		return "Not in maintenance."
+	}
+	line := "In maintenance"
+	if !info.Since.IsZero() {
+		line += " since " + info.Since.Local().Format("2006-01-02 15:04")
+	}
+	if info.Reason != "" {
+		line += ": " + info.Reason
+	}
+	return line + ". User daemons are stopped."
+}
+
+// ReadMaintenance reports whether the host in outputDir is in maintenance
+// mode.
+func ReadMaintenance(outputDir string) (MaintenanceInfo, error) {
+	var info MaintenanceInfo
+	data, err := os.ReadFile(filepath.Join(outputDir, maintenanceFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return info, nil
+	}
+	if err != nil {
+		return info, err
+	}
+	// A marker that cannot be parsed still means maintenance.
+	_ = json.Unmarshal(data, &info)
+	info.Active = true
+	return info, nil
+}
+
+// EnterMaintenance writes the maintenance marker, then disables and boots
+// out the frpc and server LaunchDaemons of every user in st. Disabled
+// daemons stay stopped across reboots, and RunAutoboot does not bootstrap
+// them while the marker exists. It attempts every user and returns the
+// combined failures.
+func EnterMaintenance(ctx context.Context, st state.State, outputDir, reason string) error {
+	info := MaintenanceInfo{Since: time.Now().UTC(), Reason: strings.TrimSpace(reason)}
+	data, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(outputDir, 0o755); err != nil {
+		return err
+	}
+	if err := writeFileAtomic(filepath.Join(outputDir, maintenanceFile), append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("write maintenance marker: %w", err)
+	}
+
+	var errs []error
+	for _, u := range st.Users {
+		for _, label := range userDaemonLabels(u.Name) {
+			if out, err := runner.Run(ctx, "launchctl", "disable", "system/"+label); err != nil {
+				errs = append(errs, fmt.Errorf("%s: disable %s: %w (output=%s)", u.Name, label, err, strings.TrimSpace(string(out))))
+				continue
+			}
+			// bootout fails when the daemon is not loaded, which is fine here.
+			_, _ = runner.Run(ctx, "launchctl", "bootout", "system/"+label)
+		}
+	}
+	log.Printf("[maintenance] entered for %d users", len(st.Users))
+	return errors.Join(errs...)
+}
+
+// ExitMaintenance enables and bootstraps the LaunchDaemons of every user in
+// st again and removes the maintenance marker. It attempts every user and
+// returns the combined failures; the marker is removed either way so the next
+// host-autoboot run retries the users that failed.
+func ExitMaintenance(ctx context.Context, st state.State, outputDir string) error {
+	var errs []error
+	for _, u := range st.Users {
+		for _, label := range userDaemonLabels(u.Name) {
+			if out, err := runner.Run(ctx, "launchctl", "enable", "system/"+label); err != nil {
+				errs = append(errs, fmt.Errorf("%s: enable %s: %w (output=%s)", u.Name, label, err, strings.TrimSpace(string(out))))
+			}
+		}
+		if err := BootstrapUserLaunchDaemons(ctx, u.Name); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", u.Name, err))
+		}
+	}
+
+	if err := os.Remove(filepath.Join(outputDir, maintenanceFile)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		errs = append(errs, fmt.Errorf("remove maintenance marker: %w", err))
+	}
+	log.Printf("[maintenance] exited for %d users", len(st.Users))
+	return errors.Join(errs...)
+}
+
+// userDaemonLabels returns the frpc label and every server label of username.
+func userDaemonLabels(username string) []string {
+	return append([]string{fmt.Sprintf(launchDaemonFRPCLabel, username)}, userServerLabels(username)...)
+}
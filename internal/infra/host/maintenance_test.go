@@ -0,0 +1,25 @@
+//go:build darwin
+
+package host
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMaintenanceInfoString(t *testing.T) {
+	since := time.Date(2026, 1, 2, 3, 4, 0, 0, time.Local)
+	tests := []struct {
+		info MaintenanceInfo
+		want string
+	}{
+		{MaintenanceInfo{}, "Not in maintenance."},
+		{MaintenanceInfo{Active: true}, "In maintenance. User daemons are stopped."},
+		{MaintenanceInfo{Active: true, Since: since, Reason: "OS update"}, "In maintenance since 2026-01-02 03:04: OS update. User daemons are stopped."},
+	}
+	for _, tt := range tests {
+		if got := tt.info.String(); got != tt.want {
+			t.Errorf("String() = %q, want %q", got, tt.want)
+		}
+	}
+}
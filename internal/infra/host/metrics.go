@@ -0,0 +1,148 @@
+//go:build darwin
+
+package host
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"prism/internal/infra/config"
+	"prism/internal/infra/state"
+)
+
+// MetricsLoopConfig holds the paths the metrics loop reloads on every run. A
+// value on Reload makes it re-read globals.metrics.
+type MetricsLoopConfig struct {
+	OutputDir  string
+	ConfigPath string
+	StatePath  string
+	Reload     <-chan struct{}
+}
+
+// RunMetricsLoop rewrites the Prometheus textfile configured in
+// globals.metrics every globals.metrics.interval_seconds until ctx is
+// cancelled. Nothing is written while no textfile_path is configured.
+func RunMetricsLoop(ctx context.Context, mCfg MetricsLoopConfig) {
+	metrics, err := loadMetricsConfig(mCfg)
+	if err != nil {
+		log.Printf("[metrics] load config: %v; metrics disabled until reload", err)
+	}
+	if metrics.TextfilePath != "" {
+		log.Printf("[metrics] writing %s every %s", metrics.TextfilePath, metrics.Interval())
+	}
+
+	ticker := time.NewTicker(metrics.Interval())
+	defer ticker.Stop()
+
+	for {
+		if metrics.TextfilePath != "" {
+			if err := writeMetricsOnce(ctx, mCfg); err != nil {
+				log.Printf("[metrics] %v", err)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-mCfg.Reload:
+			next, err := loadMetricsConfig(mCfg)
+			if err != nil {
+				log.Printf("[metrics] reload failed, keeping previous settings: %v", err)
+				continue
+			}
+			if next.Interval() != metrics.Interval() {
+				ticker.Reset(next.Interval())
+			}
+			metrics = next
+			if metrics.TextfilePath == "" {
+				log.Printf("[metrics] config reloaded; metrics disabled")
+			} else {
+				log.Printf("[metrics] config reloaded; writing %s every %s", metrics.TextfilePath, metrics.Interval())
+			}
+		case <-ticker.C:
+		}
+	}
+}
+
+func loadMetricsConfig(mCfg MetricsLoopConfig) (config.MetricsConfig, error) {
+	cfg, err := config.Load(mCfg.ConfigPath)
+	if err != nil {
+		return config.MetricsConfig{}, err
+	}
+	return cfg.Globals.Metrics, nil
+}
+
+// writeMetricsOnce reloads config and state and rewrites the textfile, so
+// users added since the loop started are included.
+func writeMetricsOnce(ctx context.Context, mCfg MetricsLoopConfig) error {
+	cfg, err := config.Load(mCfg.ConfigPath)
+	if err != nil {
+		return fmt.Errorf("load config: %w", err)
+	}
+	st, err := state.Load(mCfg.StatePath)
+	if err != nil {
+		return fmt.Errorf("load state: %w", err)
+	}
+	return WriteMetrics(ctx, cfg, st, mCfg.OutputDir, cfg.Globals.Metrics.TextfilePath)
+}
+
+// WriteMetrics computes the host gauges from CheckUserServices and the
+// version file and atomically writes them to path in the Prometheus text
+// format, as node_exporter's textfile collector requires.
+func WriteMetrics(ctx context.Context, cfg config.Config, st state.State, outputDir, path string) error {
+	statuses, err := CheckUserServices(ctx, cfg, st)
+	if err != nil {
+		return fmt.Errorf("check services: %w", err)
+	}
+	info, err := ReadVersionInfo(outputDir)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("read version: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return writeFileAtomic(path, []byte(formatMetrics(statuses, info)), 0o644)
+}
+
+// formatMetrics renders the gauges in the Prometheus text exposition format.
+// prism_last_update_timestamp is omitted until an update has been recorded.
+func formatMetrics(statuses []UserServiceStatus, info VersionInfo) string {
+	healthy := 0
+	for _, s := range statuses {
+		if s.Healthy() {
+			healthy++
+		}
+	}
+
+	var b strings.Builder
+	writeGauge := func(name, help string) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s gauge\n", name, help, name)
+	}
+
+	writeGauge("prism_users_total", "Number of Prism users on this host.")
+	fmt.Fprintf(&b, "prism_users_total %d\n", len(statuses))
+
+	writeGauge("prism_users_healthy", "Number of Prism users whose services are all up.")
+	fmt.Fprintf(&b, "prism_users_healthy %d\n", healthy)
+
+	if t, err := time.Parse(time.RFC3339, info.UpdatedAt); err == nil {
+		writeGauge("prism_last_update_timestamp", "Unix time the service bundle was last rolled out.")
+		fmt.Fprintf(&b, "prism_last_update_timestamp %d\n", t.Unix())
+	}
+
+	writeGauge("prism_service_up", "Whether every service of a Prism user is up (1) or not (0).")
+	for _, s := range statuses {
+		up := 0
+		if s.Healthy() {
+			up = 1
+		}
+		fmt.Fprintf(&b, "prism_service_up{user=%q} %d\n", s.Name, up)
+	}
+	return b.String()
+}
@@ -13,8 +13,9 @@ import (
 	"net/http"
 	"net/url"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"slices"
+	"sort"
 	"strings"
 	"time"
 
@@ -25,8 +26,25 @@ import (
 const (
 	envFRPCToken   = "FRPC_TOKEN"
 	envGITHUBToken = "GITHUB_TOKEN"
+	envNexusToken  = "NEXUS_TOKEN"
+
+	// defaultArchiveName is the cache filename for bundles that are not
+	// fetched from a GitHub release.
+	defaultArchiveName = "bundle-macos-arm64.tar.gz"
 )
 
+// serverAppName is the server app at the top of an extracted service bundle.
+const serverAppName = "iMessageKitServer.app"
+
+// serverBinRelPath is the server executable inside an extracted service bundle.
+var serverBinRelPath = filepath.Join(serverAppName, "Contents", "MacOS", "iMessageKitServer")
+
+// requiredBundleFiles lists paths that must exist in an extracted bundle.
+var requiredBundleFiles = []string{
+	serverBinRelPath,
+	filepath.Join(serverAppName, "Contents", "Info.plist"),
+}
+
 // generateSubdomain returns a random lower-case alpha-numeric string of the
 // given length, suitable for use as a subdomain prefix. Ambiguous characters
 // (0/1 and i/l/o) are excluded to improve readability.
@@ -47,53 +65,155 @@ func generateSubdomain(n int) (string, error) {
 	return string(b), nil
 }
 
-// ensureServiceArchive downloads (or reuses cached) service bundle and
-// extracts it into output/cache/imsg.
+// serviceCacheDir returns the bundle cache directory: globals.service.cache_dir
+// when set, otherwise output/cache.
+func serviceCacheDir(cfg config.Config, outputDir string) string {
+	if dir := strings.TrimSpace(cfg.Globals.Service.CacheDir); dir != "" {
+		return dir
+	}
+	return filepath.Join(outputDir, "cache")
+}
+
+// ensureServiceArchive downloads (or reuses cached) service bundle,
+// extracts it into <cache>/imsg and checks it against requiredBundleFiles,
+// globals.service.required_paths and, with verify_signature, codesign. A
+// bundle that fails is removed from the cache so the next run downloads it
+// again.
 func ensureServiceArchive(ctx context.Context, cfg config.Config, outputDir string) (string, error) {
-	cacheDir := filepath.Join(outputDir, "cache")
-	if err := os.MkdirAll(cacheDir, 0o755); err != nil {
+	svc := cfg.Globals.Service
+	required := append(slices.Clone(requiredBundleFiles), svc.RequiredPaths...)
+	extractDir, archivePath, err := ensureBundleArchive(ctx, svc, serviceCacheDir(cfg, outputDir), extractDirName, required)
+	if err != nil {
 		return "", err
 	}
-	archivePath := filepath.Join(cacheDir, "bundle-macos-arm64.tar.gz")
+	if err := verifyBundleSignature(ctx, svc, extractDir); err != nil {
+		_ = os.Remove(archivePath)
+		return "", err
+	}
+	return extractDir, nil
+}
+
+// ensureBundleArchive downloads (or reuses) the bundle described by svc in
+// cacheDir, extracts it into cacheDir/extractName and checks that every path
+// in required exists. It returns the extraction directory and the cached
+// archive. A bundle missing a required path is removed from the cache and
+// reported as ErrInvalidBundle.
+func ensureBundleArchive(ctx context.Context, svc config.ServiceConfig, cacheDir, extractName string, required []string) (string, string, error) {
+	if err := os.MkdirAll(cacheDir, 0o755); err != nil {
+		return "", "", err
+	}
+	archivePath := filepath.Join(cacheDir, defaultArchiveName)
+	resolvedURL := ""
+	gh, isGH, err := svc.GitHubArchive()
+	if err != nil {
+		return "", "", err
+	}
+	if isGH {
+		if gh.IsPattern() {
+			// The cache name is only known once the pattern is matched
+			// against the release assets, unless a matching asset is
+			// already cached; refreshServiceArchive retires it to force a
+			// new lookup.
+			if name, ok := cachedAsset(cacheDir, gh); ok {
+				archivePath = filepath.Join(cacheDir, name)
+			} else {
+				u, assetName, err := resolveArchiveURL(ctx, svc.ArchiveURL)
+				if err != nil {
+					return "", "", fmt.Errorf("%w: %w", ErrDownloadFailed, err)
+				}
+				resolvedURL = u
+				archivePath = filepath.Join(cacheDir, assetName)
+			}
+		} else {
+			archivePath = filepath.Join(cacheDir, gh.Asset)
+		}
+	}
 	if _, err := os.Stat(archivePath); err != nil {
 		if !errors.Is(err, os.ErrNotExist) {
-			return "", err
+			return "", "", err
 		}
-		resolvedURL, err := resolveArchiveURL(ctx, cfg.Globals.Service.ArchiveURL)
-		if err != nil {
-			return "", err
+		if resolvedURL == "" {
+			resolvedURL, _, err = resolveArchiveURL(ctx, svc.ArchiveURL)
+			if err != nil {
+				return "", "", fmt.Errorf("%w: %w", ErrDownloadFailed, err)
+			}
 		}
-		if err := downloadArchive(ctx, resolvedURL, archivePath); err != nil {
-			return "", err
+		setStep(ctx, "download %s", resolvedURL)
+		if err := downloadArchive(ctx, resolvedURL, archivePath, svc.DownloadRateKbps); err != nil {
+			return "", "", fmt.Errorf("%w: %w", ErrDownloadFailed, err)
 		}
 	}
 
-	extractDir := filepath.Join(cacheDir, "imsg")
+	extractDir := filepath.Join(cacheDir, extractName)
 	_ = os.RemoveAll(extractDir)
 	if err := os.MkdirAll(extractDir, 0o755); err != nil {
-		return "", err
+		return "", "", err
 	}
-	cmd := exec.CommandContext(ctx, "tar", "-xzf", archivePath, "-C", extractDir, "--strip-components=1")
-	if out, err := cmd.CombinedOutput(); err != nil {
-		return "", fmt.Errorf("extract archive: %w (output=%s)", err, strings.TrimSpace(string(out)))
+	strip := svc.StripComponents()
+	setStep(ctx, "extract %s", filepath.Base(archivePath))
+	if err := extractTarGz(ctx, archivePath, extractDir, strip); err != nil {
+		return "", "", fmt.Errorf("extract archive: %w", err)
 	}
-	return extractDir, nil
+	if err := checkExtractedBundle(extractDir, required); err != nil {
+		_ = os.Remove(archivePath)
+		return "", "", fmt.Errorf("%w: extract archive (strip=%d): %w", ErrInvalidBundle, strip, err)
+	}
+	return extractDir, archivePath, nil
+}
+
+// checkExtractedBundle verifies that the extracted bundle has the expected
+// layout, listing every missing path so a wrong strip count is obvious.
+func checkExtractedBundle(extractDir string, required []string) error {
+	var missing []string
+	for _, rel := range required {
+		if _, err := os.Stat(filepath.Join(extractDir, rel)); err != nil {
+			missing = append(missing, rel)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+
+	var top []string
+	if entries, err := os.ReadDir(extractDir); err == nil {
+		for _, e := range entries {
+			top = append(top, e.Name())
+		}
+	}
+	return fmt.Errorf("bundle is missing %s (top-level entries: %s); check archive_strip and required_paths",
+		strings.Join(missing, ", "), strings.Join(top, ", "))
 }
 
 func refreshServiceArchive(ctx context.Context, cfg config.Config, outputDir string) (string, error) {
 	if strings.TrimSpace(outputDir) == "" {
 		return "", errors.New("outputDir is empty")
 	}
-	cacheDir := filepath.Join(outputDir, "cache")
-	archivePath := filepath.Join(cacheDir, "bundle-macos-arm64.tar.gz")
-	_ = os.Remove(archivePath)
+	retireCachedArchives(serviceCacheDir(cfg, outputDir))
 	return ensureServiceArchive(ctx, cfg, outputDir)
 }
 
-func downloadArchive(ctx context.Context, urlStr, dest string) error {
+// downloadArchive fetches urlStr (or copies a local archive) to dest. A
+// positive rateKbps caps the transfer rate in kilobits per second.
+func downloadArchive(ctx context.Context, urlStr, dest string, rateKbps int) error {
 	if strings.TrimSpace(urlStr) == "" {
 		return errors.New("globals.service.archive_url is empty")
 	}
+	if localPath, ok := localArchivePath(urlStr); ok {
+		return copyLocalArchive(localPath, dest)
+	}
+	client := &http.Client{Timeout: 5 * time.Minute}
+	var idle *time.Timer
+	if rateKbps > 0 {
+		// A throttled download can legitimately take longer than the
+		// overall timeout, so it only fails once no data has arrived for
+		// downloadIdleTimeout; ctx still bounds it.
+		client.Timeout = 0
+		var cancel context.CancelCauseFunc
+		ctx, cancel = context.WithCancelCause(ctx)
+		defer cancel(nil)
+		idle = time.AfterFunc(downloadIdleTimeout, func() { cancel(errDownloadStalled) })
+		defer idle.Stop()
+	}
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, urlStr, nil)
 	if err != nil {
 		return err
@@ -111,30 +231,183 @@ func downloadArchive(ctx context.Context, urlStr, dest string) error {
 			}
 		}
 	}
-	client := &http.Client{Timeout: 5 * time.Minute}
 	resp, err := client.Do(req)
 	if err != nil {
-		return err
+		return downloadErr(ctx, err)
 	}
 	defer func() { _ = resp.Body.Close() }()
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return fmt.Errorf("download archive: unexpected status %s", resp.Status)
+		return fmt.Errorf("unexpected status %s", resp.Status)
 	}
 	f, err := os.Create(dest)
 	if err != nil {
 		return err
 	}
 	defer func() { _ = f.Close() }()
-	if _, err := io.Copy(f, resp.Body); err != nil {
-		return err
+	var body io.Reader = resp.Body
+	if rateKbps > 0 {
+		body = &throttledReader{ctx: ctx, r: resp.Body, bytesPerSec: rateKbps * 1000 / 8, start: time.Now(), idle: idle}
+	}
+	if fn := downloadProgressFunc(ctx); fn != nil {
+		// ContentLength is -1 when the server does not send one.
+		body = &progressReader{r: body, total: resp.ContentLength, report: fn}
+	}
+	if _, err := io.Copy(f, body); err != nil {
+		// Don't leave a truncated archive behind to be reused as the cache.
+		_ = f.Close()
+		_ = os.Remove(dest)
+		return downloadErr(ctx, err)
 	}
 	return nil
 }
 
+// downloadIdleTimeout is how long a throttled download may go without
+// receiving data before it is abandoned.
+var downloadIdleTimeout = time.Minute
+
+var errDownloadStalled = errors.New("download stalled: no data received")
+
+// downloadErr reports a stalled download as such rather than as the context
+// cancellation that stopped it.
+func downloadErr(ctx context.Context, err error) error {
+	if cause := context.Cause(ctx); errors.Is(cause, errDownloadStalled) {
+		return fmt.Errorf("%w for %s", cause, downloadIdleTimeout)
+	}
+	return err
+}
+
+// throttledReader limits the average rate of reads from r to bytesPerSec.
+// Every read that returns data resets idle to downloadIdleTimeout.
+type throttledReader struct {
+	ctx         context.Context
+	r           io.Reader
+	bytesPerSec int
+	start       time.Time
+	read        int64
+	idle        *time.Timer
+}
+
+func (t *throttledReader) Read(p []byte) (int, error) {
+	// Read at most one second's worth so the pauses stay short.
+	if len(p) > t.bytesPerSec {
+		p = p[:t.bytesPerSec]
+	}
+	n, err := t.r.Read(p)
+	t.read += int64(n)
+	if n > 0 && t.idle != nil {
+		t.idle.Reset(downloadIdleTimeout)
+	}
+
+	due := time.Duration(float64(t.read) / float64(t.bytesPerSec) * float64(time.Second))
+	if wait := due - time.Since(t.start); wait > 0 {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		select {
+		case <-t.ctx.Done():
+			return n, t.ctx.Err()
+		case <-timer.C:
+		}
+	}
+	return n, err
+}
+
+// DownloadProgress describes how much of a service bundle has been
+// downloaded. Total is -1 when the server did not report a size.
+type DownloadProgress struct {
+	Done  int64
+	Total int64
+}
+
+type downloadProgressKey struct{}
+
+// WithDownloadProgress returns a context that makes bundle downloads started
+// with it report their progress to fn. fn is called from the downloading
+// goroutine, at most a few times per second and once more when the download
+// completes.
+func WithDownloadProgress(ctx context.Context, fn func(DownloadProgress)) context.Context {
+	if fn == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, downloadProgressKey{}, fn)
+}
+
+func downloadProgressFunc(ctx context.Context) func(DownloadProgress) {
+	fn, _ := ctx.Value(downloadProgressKey{}).(func(DownloadProgress))
+	return fn
+}
+
+// progressInterval is the minimum time between progress reports.
+const progressInterval = 200 * time.Millisecond
+
+// progressReader reports the bytes read from r through report.
+type progressReader struct {
+	r        io.Reader
+	total    int64
+	done     int64
+	report   func(DownloadProgress)
+	reported time.Time
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	p.done += int64(n)
+	if err == io.EOF || time.Since(p.reported) >= progressInterval {
+		p.reported = time.Now()
+		p.report(DownloadProgress{Done: p.done, Total: p.total})
+	}
+	return n, err
+}
+
+// localArchivePath reports whether urlStr refers to a local archive, either as
+// a file:// URL or as a bare filesystem path, and returns that path.
+func localArchivePath(urlStr string) (string, bool) {
+	s := strings.TrimSpace(urlStr)
+	if strings.HasPrefix(s, "file://") {
+		parsed, err := url.Parse(s)
+		if err != nil || parsed.Path == "" {
+			return strings.TrimPrefix(s, "file://"), true
+		}
+		return parsed.Path, true
+	}
+	if s == "" || strings.Contains(s, "://") {
+		return "", false
+	}
+	return s, true
+}
+
+// copyLocalArchive copies an on-disk service bundle into the cache so that
+// offline provisioning follows the same extraction path as downloads. It
+// copies through a temp file and rename, so a failed copy never leaves a
+// truncated archive in the cache.
+func copyLocalArchive(src, dest string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("open local archive: %w", err)
+	}
+	defer func() { _ = in.Close() }()
+
+	tmpPath := dest + ".tmp"
+	// Clean up tmp file if rename fails
+	defer func() { _ = os.Remove(tmpPath) }()
+
+	out, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		_ = out.Close()
+		return fmt.Errorf("copy local archive: %w", err)
+	}
+	if err := out.Close(); err != nil {
+		return fmt.Errorf("copy local archive: %w", err)
+	}
+	return os.Rename(tmpPath, dest)
+}
+
 // chownRecursive sets the ownership of the given path (recursively) to the
 // specified username when running as root. In non-root environments (for
 // example, tests) it becomes a no-op.
-func chownRecursive(username, path string) error {
+func chownRecursive(ctx context.Context, username, path string) error {
 	if strings.TrimSpace(username) == "" || strings.TrimSpace(path) == "" {
 		return nil
 	}
@@ -143,8 +416,7 @@ func chownRecursive(username, path string) error {
 		return nil
 	}
 
-	cmd := exec.Command("chown", "-R", username, path)
-	out, err := cmd.CombinedOutput()
+	out, err := runner.Run(ctx, "chown", "-R", username, path)
 	if err != nil {
 		lower := strings.ToLower(string(out))
 		if strings.Contains(lower, "operation not permitted") || strings.Contains(lower, "permission denied") {
@@ -159,30 +431,24 @@ func chownRecursive(username, path string) error {
 }
 
 // resolveArchiveURL resolves archive URL (supports gh://owner/repo/asset shorthand).
-func resolveArchiveURL(ctx context.Context, urlStr string) (string, error) {
+// Local paths and file:// URLs are returned unchanged. For gh:// URLs it also
+// returns the matched release asset name.
+func resolveArchiveURL(ctx context.Context, urlStr string) (string, string, error) {
 	s := strings.TrimSpace(urlStr)
 	if s == "" {
-		return "", errors.New("globals.service.archive_url is empty")
+		return "", "", errors.New("globals.service.archive_url is empty")
 	}
 
-	const ghPrefix = "gh://"
-	if !strings.HasPrefix(s, ghPrefix) {
+	gh, ok, err := config.ParseGitHubArchive(s)
+	if err != nil {
+		return "", "", err
+	}
+	if !ok {
 		// Normal URL; use as-is.
-		return s, nil
+		return s, "", nil
 	}
 
-	spec := strings.TrimPrefix(s, ghPrefix)
-	parts := strings.SplitN(spec, "/", 3)
-	if len(parts) != 3 {
-		return "", fmt.Errorf("invalid GitHub archive_url %q (expected gh://owner/repo/asset-name)", urlStr)
-	}
-	owner, repo, assetSpec := parts[0], parts[1], parts[2]
-	assetName := assetSpec
-	tag := ""
-	if idx := strings.Index(assetSpec, "@"); idx >= 0 {
-		assetName = assetSpec[:idx]
-		tag = strings.TrimSpace(assetSpec[idx+1:])
-	}
+	owner, repo, tag := gh.Owner, gh.Repo, gh.Tag
 	var apiURL string
 	if tag == "" {
 		apiURL = fmt.Sprintf("https://api.github.com/repos/%s/%s/releases/latest", owner, repo)
@@ -192,7 +458,7 @@ func resolveArchiveURL(ctx context.Context, urlStr string) (string, error) {
 
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, apiURL, nil)
 	if err != nil {
-		return "", err
+		return "", "", err
 	}
 
 	token := strings.TrimSpace(os.Getenv("GITHUB_TOKEN"))
@@ -203,11 +469,11 @@ func resolveArchiveURL(ctx context.Context, urlStr string) (string, error) {
 	client := &http.Client{Timeout: 30 * time.Second}
 	resp, err := client.Do(req)
 	if err != nil {
-		return "", err
+		return "", "", err
 	}
 	defer func() { _ = resp.Body.Close() }()
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return "", fmt.Errorf("resolve GitHub release: unexpected status %s", resp.Status)
+		return "", "", fmt.Errorf("resolve GitHub release: unexpected status %s", resp.Status)
 	}
 
 	var rel struct {
@@ -219,7 +485,19 @@ func resolveArchiveURL(ctx context.Context, urlStr string) (string, error) {
 		} `json:"assets"`
 	}
 	if err := json.NewDecoder(resp.Body).Decode(&rel); err != nil {
-		return "", err
+		return "", "", err
+	}
+
+	names := make([]string, 0, len(rel.Assets))
+	for _, a := range rel.Assets {
+		names = append(names, a.Name)
+	}
+	assetName, err := gh.MatchAsset(names)
+	if err != nil {
+		if tag == "" {
+			return "", "", fmt.Errorf("resolve GitHub release: %w in latest release", err)
+		}
+		return "", "", fmt.Errorf("resolve GitHub release: %w in release %q", err, tag)
 	}
 
 	for _, a := range rel.Assets {
@@ -230,28 +508,28 @@ func resolveArchiveURL(ctx context.Context, urlStr string) (string, error) {
 			if u == "" {
 				break
 			}
-			return u, nil
+			return u, assetName, nil
 		}
 	}
 
-	if tag == "" {
-		return "", fmt.Errorf("resolve GitHub release: asset %q not found in latest release", assetName)
-	}
-	return "", fmt.Errorf("resolve GitHub release: asset %q not found in release %q", assetName, tag)
+	return "", "", fmt.Errorf("resolve GitHub release: asset %q has no download URL", assetName)
 }
 
 // ensurePerUserFiles prepares the per-user services/imsg directory, including
-// config.json, frpc.toml and the per-user prism wrapper.
+// config.json, frpc.toml, the per-user prism wrapper and manifest.json, and
+// writes the user's LaunchDaemons. Shared inputs come from
+// prepareProvisionAssets. The daemons are bootstrapped separately by
+// bootstrapNewUser, so a failure there leaves a complete, repairable user.
 func ensurePerUserFiles(
+	ctx context.Context,
 	cfg config.Config,
 	username string,
 	localPort int,
-	extractDir string,
-	prismPath string,
+	assets provisionAssets,
 ) (state.User, error) {
-	homeDir := filepath.Join("/Users", username)
-	serviceDir := filepath.Join(homeDir, "services", "imsg")
-	if err := copyDir(extractDir, serviceDir); err != nil {
+	homeDir := filepath.Join(usersDir, username)
+	serviceDir := userServiceDir(username, config.PrimaryServiceName)
+	if err := copyDir(ctx, assets.extractDir, serviceDir); err != nil {
 		return state.User{}, err
 	}
 
@@ -260,10 +538,20 @@ func ensurePerUserFiles(
 		Username   string `json:"username"`
 		MachineID  string `json:"machine_id"`
 		LocalPort  int    `json:"local_port"`
+		LocalIP    string `json:"local_ip,omitempty"`
+		HealthPath string `json:"health_path,omitempty"`
 		Subdomain  string `json:"subdomain"`
 		FullDomain string `json:"full_domain"`
 		FRPCConfig string `json:"frpc_config"`
 		NexusAddr  string `json:"nexus_addr"`
+
+		NexusKeyCreatePath  string `json:"nexus_key_create_path,omitempty"`
+		NexusTimeoutSeconds int    `json:"nexus_timeout_seconds,omitempty"`
+		NexusAuthToken      string `json:"nexus_auth_token,omitempty"`
+		NexusClientCert     string `json:"nexus_client_cert,omitempty"`
+		NexusClientKey      string `json:"nexus_client_key,omitempty"`
+
+		FriendlyNameRefreshMinutes int `json:"friendly_name_refresh_minutes,omitempty"`
 	}
 	if data, err := os.ReadFile(configPath); err == nil {
 		_ = json.Unmarshal(data, &ucfg)
@@ -282,96 +570,108 @@ func ensurePerUserFiles(
 	ucfg.Username = username
 	ucfg.MachineID = cfg.Globals.MachineID
 	ucfg.LocalPort = localPort
+	ucfg.LocalIP = cfg.Globals.Service.BindIP()
+	ucfg.HealthPath = cfg.Globals.Service.HealthCheckPath()
 	ucfg.Subdomain = subdomain
 	ucfg.FullDomain = fullDomain
 	ucfg.FRPCConfig = filepath.Join(serviceDir, "frpc.toml")
 	if strings.TrimSpace(ucfg.NexusAddr) == "" {
 		ucfg.NexusAddr = strings.TrimRight(cfg.Globals.Nexus.BaseURL, "/")
 	}
+	ucfg.NexusKeyCreatePath = cfg.Globals.Nexus.KeyCreatePath
+	ucfg.NexusTimeoutSeconds = cfg.Globals.Nexus.TimeoutSeconds
+	// NEXUS_TOKEN overrides nexus.auth_token, as it overrides config.json
+	// on the user side.
+	ucfg.NexusAuthToken = strings.TrimSpace(os.Getenv(envNexusToken))
+	if ucfg.NexusAuthToken == "" {
+		ucfg.NexusAuthToken = strings.TrimSpace(cfg.Globals.Nexus.AuthToken)
+	}
+	ucfg.NexusClientCert = cfg.Globals.Nexus.ClientCert
+	ucfg.NexusClientKey = cfg.Globals.Nexus.ClientKey
+	ucfg.FriendlyNameRefreshMinutes = cfg.Globals.FRPC.FriendlyNameRefreshMinutes
 
 	data, err := json.MarshalIndent(&ucfg, "", "  ")
 	if err != nil {
 		return state.User{}, err
 	}
-	if err := os.WriteFile(configPath, data, 0o600); err != nil {
+	// config.json carries the Nexus token and frpc.toml the frps token.
+	// Both are rewritten atomically so a copy from the bundle or an older
+	// provision that was group- or world-readable is narrowed to 0600 too.
+	if err := writeFileAtomic(configPath, data, 0o600); err != nil {
 		return state.User{}, err
 	}
 
-	frpcToml := fmt.Sprintf("serverAddr = \"%s\"\nserverPort = %d\n",
-		cfg.Globals.FRPC.ServerAddr,
-		cfg.Globals.FRPC.ServerPort,
-	)
-
-	if token := strings.TrimSpace(os.Getenv(envFRPCToken)); token != "" {
-		frpcToml += fmt.Sprintf("\nauth.token = \"%s\"\n", token)
+	// A re-provisioned user keeps its token when FRPC_TOKEN is not set.
+	existing, _ := readFRPCProxy(ucfg.FRPCConfig)
+	frpcToml, err := renderFRPCConfig(cfg, username, localPort, subdomain, map[string]string{"friendlyName": ""}, existing.Token)
+	if err != nil {
+		return state.User{}, fmt.Errorf("render frpc.toml: %w", err)
 	}
-
-	frpcToml += fmt.Sprintf("\n[[proxies]]\nname = \"%s-imsg\"\ntype = \"http\"\nlocalIP = \"127.0.0.1\"\nlocalPort = %d\nsubdomain = \"%s\"\nmetadatas = { friendlyName = \"\" }\n",
-		username,
-		localPort,
-		subdomain,
-	)
-	if err := os.WriteFile(ucfg.FRPCConfig, []byte(frpcToml), 0o600); err != nil {
+	if err := writeFileAtomic(ucfg.FRPCConfig, frpcToml, 0o600); err != nil {
 		return state.User{}, err
 	}
 
-	if prismPath != "" {
-		localBin := filepath.Join(serviceDir, "prism-host")
-		if err := copyExecutable(prismPath, localBin); err != nil {
-			return state.User{}, err
-		}
-
-		wrapper := fmt.Sprintf("#!/bin/zsh\nexec \"%s\" user \"$@\"\n", localBin)
-		wrapperPath := filepath.Join(serviceDir, "prism")
-		if err := os.WriteFile(wrapperPath, []byte(wrapper), 0o755); err != nil {
+	if assets.prismBinary != nil {
+		if err := installPrismWrapper(cfg.Globals.Service, serviceDir, assets.prismBinary); err != nil {
 			return state.User{}, err
 		}
 	}
 
-	if err := chownRecursive(username, serviceDir); err != nil {
+	if err := chownRecursive(ctx, username, serviceDir); err != nil {
 		return state.User{}, err
 	}
 
-	// Create LaunchDaemons for headless service startup at boot
-	// Find frpc binary
-	frpcBin, err := exec.LookPath("frpc")
-	if err != nil {
-		// Try common paths
-		for _, p := range []string{"/opt/homebrew/bin/frpc", "/usr/local/bin/frpc"} {
-			if _, err := os.Stat(p); err == nil {
-				frpcBin = p
-				break
-			}
-		}
-		if frpcBin == "" {
-			return state.User{}, fmt.Errorf("frpc binary not found")
-		}
-	}
-
-	// Find server binary
-	serverBin := filepath.Join(serviceDir, "iMessageKitServer.app", "Contents", "MacOS", "iMessageKitServer")
-	if _, err := os.Stat(serverBin); err != nil {
-		return state.User{}, fmt.Errorf("server binary not found: %w", err)
-	}
+	// Create LaunchDaemons for headless service startup at boot. The server
+	// binary was validated in the bundle by prepareProvisionAssets.
+	serverBin := filepath.Join(serviceDir, serverBinRelPath)
 
 	daemonCfg := UserLaunchDaemonConfig{
 		Username:   username,
 		HomeDir:    homeDir,
 		ServiceDir: serviceDir,
 		ServerBin:  serverBin,
-		FRPCBin:    frpcBin,
+		FRPCBin:    assets.frpcBin,
 		FRPCConfig: ucfg.FRPCConfig,
 		LocalPort:  localPort,
+		LocalIP:    ucfg.LocalIP,
 		MachineID:  cfg.Globals.MachineID,
 		NexusAddr:  ucfg.NexusAddr,
+		Env:        cfg.Globals.Service.Env,
 	}
-	if err := EnsureUserLaunchDaemons(daemonCfg); err != nil {
+	if err := EnsureUserLaunchDaemons(ctx, daemonCfg); err != nil {
 		return state.User{}, fmt.Errorf("create LaunchDaemons: %w", err)
 	}
 
-	// Bootstrap the daemons so they start running
-	if err := BootstrapUserLaunchDaemons(username); err != nil {
-		return state.User{}, fmt.Errorf("bootstrap LaunchDaemons: %w", err)
+	logsDir := filepath.Join(homeDir, "Library", "Logs")
+	manifest := UserManifest{
+		Username:      username,
+		BundleVersion: assets.bundleVersion,
+		ServiceDir:    serviceDir,
+		LaunchDaemons: []string{
+			filepath.Join(launchDaemonsDir, fmt.Sprintf(launchDaemonServerLabel+".plist", username)),
+			filepath.Join(launchDaemonsDir, fmt.Sprintf(launchDaemonFRPCLabel+".plist", username)),
+		},
+		Files: []string{configPath, ucfg.FRPCConfig, serverBin},
+		Logs: []string{
+			filepath.Join(logsDir, "imsg-server.log"),
+			filepath.Join(logsDir, "imsg-server.err"),
+			filepath.Join(logsDir, "frpc.log"),
+			filepath.Join(logsDir, "frpc.err"),
+		},
+	}
+	if assets.prismBinary != nil {
+		manifest.Files = append(manifest.Files,
+			filepath.Join(serviceDir, "prism-host"),
+			filepath.Join(serviceDir, "prism"),
+		)
+	}
+	for _, b := range assets.extras {
+		if err := ensureExtraUserService(ctx, cfg, username, localPort, ucfg.NexusAddr, b, &manifest); err != nil {
+			return state.User{}, err
+		}
+	}
+	if err := writeUserManifest(ctx, manifest); err != nil {
+		return state.User{}, fmt.Errorf("write manifest: %w", err)
 	}
 
 	return state.User{
@@ -381,53 +681,104 @@ func ensurePerUserFiles(
 	}, nil
 }
 
-func syncServiceDir(src, dst string) error {
-	if err := os.MkdirAll(dst, 0o755); err != nil {
+// installPrismWrapper writes binary to serviceDir/prism-host and the prism
+// wrapper script that runs it. The caller chowns serviceDir afterwards.
+func installPrismWrapper(svc config.ServiceConfig, serviceDir string, binary []byte) error {
+	localBin := filepath.Join(serviceDir, "prism-host")
+	if err := writeFileAtomic(localBin, binary, 0o755); err != nil {
+		return fmt.Errorf("write per-user prism binary: %w", err)
+	}
+
+	wrapper, err := renderWrapper(svc, localBin)
+	if err != nil {
 		return err
 	}
-	args := []string{
-		"-a",
-		"--exclude", "config.json",
-		"--exclude", "frpc.toml",
-		"--exclude", "prism-host",
-		"--exclude", "prism",
-		src + "/",
-		dst + "/",
-	}
-	cmd := exec.Command("rsync", args...)
-	if out, err := cmd.CombinedOutput(); err != nil {
-		return fmt.Errorf("rsync %s -> %s: %w (output=%s)", src, dst, err, strings.TrimSpace(string(out)))
+	wrapperPath := filepath.Join(serviceDir, "prism")
+	if err := writeFileAtomic(wrapperPath, []byte(wrapper), 0o755); err != nil {
+		return fmt.Errorf("write prism wrapper: %w", err)
 	}
 	return nil
 }
 
-func copyDir(src, dst string) error {
+// renderWrapper builds the per-user prism wrapper script that execs the local
+// binary in user mode with the configured shell and exported environment.
+func renderWrapper(svc config.ServiceConfig, localBin string) (string, error) {
+	shell := svc.Shell()
+	if fi, err := os.Stat(shell); err != nil || fi.IsDir() || fi.Mode()&0o111 == 0 {
+		return "", fmt.Errorf("wrapper shell %s does not exist or is not executable", shell)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "#!%s\n", shell)
+	keys := make([]string, 0, len(svc.WrapperEnv))
+	for k := range svc.WrapperEnv {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(&b, "export %s=%s\n", k, shellSingleQuote(svc.WrapperEnv[k]))
+	}
+	fmt.Fprintf(&b, "exec %s user \"$@\"\n", shellSingleQuote(localBin))
+	return b.String(), nil
+}
+
+func shellSingleQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'"'"'`) + "'"
+}
+
+// writeFileAtomic writes data to a temp file next to path and renames it into
+// place, so readers never observe a partially written file.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmpPath := path + ".tmp"
+	// Clean up tmp file if rename fails
+	defer func() { _ = os.Remove(tmpPath) }()
+
+	if err := os.WriteFile(tmpPath, data, perm); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmpPath, perm); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// syncExcludes are the per-user files syncServiceDir never overwrites. Like
+// rsync --exclude patterns without a slash, they match names at any depth.
+var syncExcludes = []string{"config.json", "frpc.toml", "prism-host", "prism", userManifestName}
+
+// syncServiceDir copies a bundle over an existing service directory, keeping
+// the per-user files in syncExcludes; keys new in the bundle's config
+// templates are then merged into them (see mergeBundleConfigs).
+func syncServiceDir(ctx context.Context, src, dst string) error {
 	if err := os.MkdirAll(dst, 0o755); err != nil {
 		return err
 	}
-	cmd := exec.Command("rsync", "-a", src+"/", dst+"/")
-	if out, err := cmd.CombinedOutput(); err != nil {
+	if !rsyncAvailable() {
+		if err := copyTree(ctx, src, dst, syncExcludes); err != nil {
+			return err
+		}
+		return mergeBundleConfigs(src, dst)
+	}
+	args := []string{"-a"}
+	for _, name := range syncExcludes {
+		args = append(args, "--exclude", name)
+	}
+	args = append(args, src+"/", dst+"/")
+	if out, err := runner.Run(ctx, "rsync", args...); err != nil {
 		return fmt.Errorf("rsync %s -> %s: %w (output=%s)", src, dst, err, strings.TrimSpace(string(out)))
 	}
-	return nil
+	return mergeBundleConfigs(src, dst)
 }
 
-func copyExecutable(src, dst string) error {
-	in, err := os.Open(src)
-	if err != nil {
-		return fmt.Errorf("open prism binary: %w", err)
+func copyDir(ctx context.Context, src, dst string) error {
+	if err := os.MkdirAll(dst, 0o755); err != nil {
+		return err
 	}
-	defer func() { _ = in.Close() }()
-
-	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o755)
-	if err != nil {
-		return fmt.Errorf("create per-user prism binary: %w", err)
+	if !rsyncAvailable() {
+		return copyTree(ctx, src, dst, nil)
 	}
-	defer func() { _ = out.Close() }()
-
-	if _, err := io.Copy(out, in); err != nil {
-		return fmt.Errorf("copy prism binary: %w", err)
+	if out, err := runner.Run(ctx, "rsync", "-a", src+"/", dst+"/"); err != nil {
+		return fmt.Errorf("rsync %s -> %s: %w (output=%s)", src, dst, err, strings.TrimSpace(string(out)))
 	}
-
 	return nil
 }
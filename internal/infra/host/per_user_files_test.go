@@ -0,0 +1,125 @@
+//go:build darwin
+
+package host
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDownloadArchiveThrottledStall(t *testing.T) {
+	prev := downloadIdleTimeout
+	t.Cleanup(func() { downloadIdleTimeout = prev })
+	downloadIdleTimeout = 200 * time.Millisecond
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("partial"))
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+
+	dest := filepath.Join(t.TempDir(), "bundle.tar.gz")
+	done := make(chan error, 1)
+	go func() { done <- downloadArchive(context.Background(), srv.URL, dest, 1000) }()
+	select {
+	case err := <-done:
+		if !errors.Is(err, errDownloadStalled) {
+			t.Fatalf("downloadArchive() error = %v, want %v", err, errDownloadStalled)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("stalled throttled download did not time out")
+	}
+	if _, err := os.Stat(dest); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("truncated archive left behind: %v", err)
+	}
+}
+
+func TestLocalArchivePath(t *testing.T) {
+	tests := []struct {
+		url    string
+		want   string
+		wantOK bool
+	}{
+		{"file:///srv/bundles/imsg.tar.gz", "/srv/bundles/imsg.tar.gz", true},
+		{"/srv/bundles/imsg.tar.gz", "/srv/bundles/imsg.tar.gz", true},
+		{"  bundles/imsg.tar.gz ", "bundles/imsg.tar.gz", true},
+		{"https://example.com/imsg.tar.gz", "", false},
+		{"gh://org/repo/imsg.tar.gz", "", false},
+		{"", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := localArchivePath(tt.url)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("localArchivePath(%q) = %q, %v; want %q, %v", tt.url, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestEnsureServiceArchiveFromLocalPath(t *testing.T) {
+	cfg, outputDir := testHost(t)
+	// Drop the bundle testHost cached, so the archive comes from the local
+	// path.
+	if err := os.Remove(filepath.Join(outputDir, "cache", defaultArchiveName)); err != nil {
+		t.Fatal(err)
+	}
+	fixture := writeFixture(t, []tarEntry{
+		{name: "bundle/" + serverBinRelPath, typeflag: tar.TypeReg, body: "local-bin", mode: 0o755},
+		{name: "bundle/" + serverAppName + "/Contents/Info.plist", typeflag: tar.TypeReg, body: "local-plist"},
+	})
+	cfg.Globals.Service.ArchiveURL = "file://" + fixture
+
+	extractDir, err := ensureServiceArchive(context.Background(), cfg, outputDir)
+	if err != nil {
+		t.Fatalf("ensureServiceArchive() error = %v", err)
+	}
+	for rel, want := range map[string]string{
+		serverBinRelPath: "local-bin",
+		filepath.Join(serverAppName, "Contents", "Info.plist"): "local-plist",
+	} {
+		got, err := os.ReadFile(filepath.Join(extractDir, rel))
+		if err != nil {
+			t.Fatalf("read %s: %v", rel, err)
+		}
+		if string(got) != want {
+			t.Errorf("%s = %q, want %q", rel, got, want)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(outputDir, "cache", defaultArchiveName+".tmp")); !os.IsNotExist(err) {
+		t.Errorf("temp copy left in the cache: %v", err)
+	}
+}
+
+func TestCopyLocalArchiveMissingSourceKeepsCache(t *testing.T) {
+	dest := filepath.Join(t.TempDir(), defaultArchiveName)
+	if err := copyLocalArchive(filepath.Join(t.TempDir(), "missing.tar.gz"), dest); err == nil {
+		t.Fatal("copyLocalArchive() succeeded for a missing source")
+	}
+	if _, err := os.Stat(dest); !os.IsNotExist(err) {
+		t.Errorf("archive written despite the failed copy: %v", err)
+	}
+}
+
+func TestWriteFileAtomicNarrowsMode(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte("{}"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeFileAtomic(path, []byte(`{"nexus_auth_token":"x"}`), 0o600); err != nil {
+		t.Fatalf("writeFileAtomic() error = %v", err)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fi.Mode().Perm(); got != 0o600 {
+		t.Errorf("mode = %o, want 600", got)
+	}
+}
@@ -0,0 +1,146 @@
+//go:build darwin
+
+package host
+
+import (
+	"encoding/xml"
+	"sort"
+	"strings"
+)
+
+const plistHeader = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+`
+
+// plistValue is a value in a property list. The concrete types below cover
+// what Prism's launchd jobs need.
+type plistValue interface {
+	writePlist(b *strings.Builder, indent string)
+}
+
+type (
+	plistString string
+	plistBool   bool
+	plistArray  []plistValue
+	// plistDict keeps its entries in order so generated files are stable.
+	plistDict []plistEntry
+)
+
+type plistEntry struct {
+	Key   string
+	Value plistValue
+}
+
+func (s plistString) writePlist(b *strings.Builder, indent string) {
+	b.WriteString(indent + "<string>" + xmlEscape(string(s)) + "</string>\n")
+}
+
+func (v plistBool) writePlist(b *strings.Builder, indent string) {
+	if v {
+		b.WriteString(indent + "<true/>\n")
+	} else {
+		b.WriteString(indent + "<false/>\n")
+	}
+}
+
+func (a plistArray) writePlist(b *strings.Builder, indent string) {
+	b.WriteString(indent + "<array>\n")
+	for _, v := range a {
+		v.writePlist(b, indent+"  ")
+	}
+	b.WriteString(indent + "</array>\n")
+}
+
+func (d plistDict) writePlist(b *strings.Builder, indent string) {
+	b.WriteString(indent + "<dict>\n")
+	for _, e := range d {
+		b.WriteString(indent + "  <key>" + xmlEscape(e.Key) + "</key>\n")
+		e.Value.writePlist(b, indent+"  ")
+	}
+	b.WriteString(indent + "</dict>\n")
+}
+
+// stringArray converts ss to a plist array of strings.
+func stringArray(ss []string) plistArray {
+	a := make(plistArray, 0, len(ss))
+	for _, s := range ss {
+		a = append(a, plistString(s))
+	}
+	return a
+}
+
+// stringDict converts m to a plist dict of strings, sorted by key.
+func stringDict(m map[string]string) plistDict {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	d := make(plistDict, 0, len(keys))
+	for _, k := range keys {
+		d = append(d, plistEntry{Key: k, Value: plistString(m[k])})
+	}
+	return d
+}
+
+// marshalPlist renders root as an XML property list document.
+func marshalPlist(root plistDict) []byte {
+	var b strings.Builder
+	b.WriteString(plistHeader)
+	root.writePlist(&b, "  ")
+	b.WriteString("</plist>\n")
+	return []byte(b.String())
+}
+
+// launchdJob is the subset of launchd.plist(5) keys Prism writes. Empty
+// fields are omitted; KeepAlive may be a plistBool or a plistDict of
+// conditions.
+type launchdJob struct {
+	Label                string
+	UserName             string
+	ProgramArguments     []string
+	WorkingDirectory     string
+	EnvironmentVariables map[string]string
+	RunAtLoad            bool
+	KeepAlive            plistValue
+	StandardOutPath      string
+	StandardErrorPath    string
+}
+
+// Marshal renders the job as a plist launchd can load.
+func (j launchdJob) Marshal() []byte {
+	d := plistDict{{Key: "Label", Value: plistString(j.Label)}}
+	if j.UserName != "" {
+		d = append(d, plistEntry{Key: "UserName", Value: plistString(j.UserName)})
+	}
+	d = append(d, plistEntry{Key: "ProgramArguments", Value: stringArray(j.ProgramArguments)})
+	if j.WorkingDirectory != "" {
+		d = append(d, plistEntry{Key: "WorkingDirectory", Value: plistString(j.WorkingDirectory)})
+	}
+	if len(j.EnvironmentVariables) > 0 {
+		d = append(d, plistEntry{Key: "EnvironmentVariables", Value: stringDict(j.EnvironmentVariables)})
+	}
+	if j.RunAtLoad {
+		d = append(d, plistEntry{Key: "RunAtLoad", Value: plistBool(true)})
+	}
+	if j.KeepAlive != nil {
+		d = append(d, plistEntry{Key: "KeepAlive", Value: j.KeepAlive})
+	}
+	if j.StandardOutPath != "" {
+		d = append(d, plistEntry{Key: "StandardOutPath", Value: plistString(j.StandardOutPath)})
+	}
+	if j.StandardErrorPath != "" {
+		d = append(d, plistEntry{Key: "StandardErrorPath", Value: plistString(j.StandardErrorPath)})
+	}
+	return marshalPlist(d)
+}
+
+// xmlEscape escapes s for a plist <key> or <string>, so values containing &,
+// < or quotes still produce a plist launchd can load.
+func xmlEscape(s string) string {
+	var b strings.Builder
+	_ = xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
@@ -0,0 +1,215 @@
+//go:build darwin
+
+package host
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestXMLEscape(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"plain", "plain"},
+		{"a&b", "a&amp;b"},
+		{"<script>", "&lt;script&gt;"},
+		{`say "hi" it's`, "say &#34;hi&#34; it&#39;s"},
+		{"/Users/mac-1/services/imsg", "/Users/mac-1/services/imsg"},
+	}
+	for _, tt := range tests {
+		if got := xmlEscape(tt.in); got != tt.want {
+			t.Errorf("xmlEscape(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestPlistEscapesValues(t *testing.T) {
+	var b strings.Builder
+	plistDict{{Key: "A&B", Value: plistString(`x<y & "z"`)}}.writePlist(&b, "")
+	want := "<dict>\n  <key>A&amp;B</key>\n  <string>x&lt;y &amp; &#34;z&#34;</string>\n</dict>\n"
+	if b.String() != want {
+		t.Errorf("plist = %q, want %q", b.String(), want)
+	}
+}
+
+func TestLaunchdJobMarshal(t *testing.T) {
+	const header = plistHeader + "  <dict>\n"
+	const footer = "  </dict>\n</plist>\n"
+	tests := []struct {
+		name string
+		job  launchdJob
+		want string
+	}{
+		{
+			name: "server",
+			job: serverDaemonConfig{
+				Label:      "com.imsg.server.mac-1",
+				Username:   "mac-1",
+				HomeDir:    "/Users/mac-1",
+				ServiceDir: "/Users/mac-1/services/imsg",
+				ServerBin:  "/Users/mac-1/services/imsg/server",
+				Port:       20000,
+				MachineID:  "mac",
+				NexusAddr:  "https://nexus.example.com/",
+				Env:        map[string]string{"LOG_LEVEL": "debug"},
+				LogName:    "imsg-server",
+			}.job(),
+			want: `    <key>Label</key>
+    <string>com.imsg.server.mac-1</string>
+    <key>UserName</key>
+    <string>mac-1</string>
+    <key>ProgramArguments</key>
+    <array>
+      <string>/Users/mac-1/services/imsg/server</string>
+    </array>
+    <key>WorkingDirectory</key>
+    <string>/Users/mac-1/services/imsg</string>
+    <key>EnvironmentVariables</key>
+    <dict>
+      <key>HOME</key>
+      <string>/Users/mac-1</string>
+      <key>LOG_LEVEL</key>
+      <string>debug</string>
+      <key>MACHINE_ID</key>
+      <string>mac</string>
+      <key>NEXUS_BASE_URL</key>
+      <string>https://nexus.example.com</string>
+      <key>NODE_ENV</key>
+      <string>production</string>
+      <key>PATH</key>
+      <string>` + defaultServerPath + `</string>
+      <key>PORT</key>
+      <string>20000</string>
+    </dict>
+    <key>RunAtLoad</key>
+    <true/>
+    <key>KeepAlive</key>
+    <true/>
+    <key>StandardOutPath</key>
+    <string>/Users/mac-1/Library/Logs/imsg-server.log</string>
+    <key>StandardErrorPath</key>
+    <string>/Users/mac-1/Library/Logs/imsg-server.err</string>
+`,
+		},
+		{
+			name: "frpc",
+			job: UserLaunchDaemonConfig{
+				Username:   "mac-1",
+				HomeDir:    "/Users/mac-1",
+				ServiceDir: "/Users/mac-1/services/imsg",
+				FRPCBin:    "/opt/homebrew/bin/frpc",
+				FRPCConfig: "/Users/mac-1/services/imsg/frpc.toml",
+			}.frpcJob(),
+			want: `    <key>Label</key>
+    <string>com.imsg.frpc.mac-1</string>
+    <key>UserName</key>
+    <string>mac-1</string>
+    <key>ProgramArguments</key>
+    <array>
+      <string>/opt/homebrew/bin/frpc</string>
+      <string>-c</string>
+      <string>/Users/mac-1/services/imsg/frpc.toml</string>
+    </array>
+    <key>WorkingDirectory</key>
+    <string>/Users/mac-1/services/imsg</string>
+    <key>EnvironmentVariables</key>
+    <dict>
+      <key>HOME</key>
+      <string>/Users/mac-1</string>
+    </dict>
+    <key>RunAtLoad</key>
+    <true/>
+    <key>KeepAlive</key>
+    <true/>
+    <key>StandardOutPath</key>
+    <string>/Users/mac-1/Library/Logs/frpc.log</string>
+    <key>StandardErrorPath</key>
+    <string>/Users/mac-1/Library/Logs/frpc.err</string>
+`,
+		},
+		{
+			name: "keepalive",
+			job:  keepaliveJob("/Users/mac-1/imessage-keepalive.sh", "/Users/mac-1/Library/Logs"),
+			want: `    <key>Label</key>
+    <string>com.imessage.keepalive</string>
+    <key>ProgramArguments</key>
+    <array>
+      <string>/Users/mac-1/imessage-keepalive.sh</string>
+    </array>
+    <key>RunAtLoad</key>
+    <true/>
+    <key>KeepAlive</key>
+    <true/>
+    <key>StandardOutPath</key>
+    <string>/Users/mac-1/Library/Logs/imessage-keepalive-stdout.log</string>
+    <key>StandardErrorPath</key>
+    <string>/Users/mac-1/Library/Logs/imessage-keepalive-stderr.log</string>
+`,
+		},
+		{
+			name: "fast-login omits KeepAlive",
+			job:  fastLoginJob("/Users/admin/prism-fast-login.sh", "/Users/admin/Library/Logs"),
+			want: `    <key>Label</key>
+    <string>com.prism.fast-login</string>
+    <key>ProgramArguments</key>
+    <array>
+      <string>/Users/admin/prism-fast-login.sh</string>
+    </array>
+    <key>RunAtLoad</key>
+    <true/>
+    <key>StandardOutPath</key>
+    <string>/Users/admin/Library/Logs/prism-fast-login.log</string>
+    <key>StandardErrorPath</key>
+    <string>/Users/admin/Library/Logs/prism-fast-login.err.log</string>
+`,
+		},
+		{
+			name: "host-autoboot KeepAlive dict",
+			job:  hostAutobootJob("/opt/prism/prism", "/opt/prism", []string{"--config", "/etc/prism.json"}),
+			want: `    <key>Label</key>
+    <string>com.prism.host-autoboot</string>
+    <key>ProgramArguments</key>
+    <array>
+      <string>/opt/prism/prism</string>
+      <string>--config</string>
+      <string>/etc/prism.json</string>
+      <string>host-autoboot</string>
+    </array>
+    <key>WorkingDirectory</key>
+    <string>/opt/prism</string>
+    <key>RunAtLoad</key>
+    <true/>
+    <key>KeepAlive</key>
+    <dict>
+      <key>SuccessfulExit</key>
+      <false/>
+    </dict>
+    <key>StandardOutPath</key>
+    <string>/var/log/prism-host-autoboot.log</string>
+    <key>StandardErrorPath</key>
+    <string>/var/log/prism-host-autoboot.err.log</string>
+`,
+		},
+		{
+			name: "empty fields omitted",
+			job:  launchdJob{Label: "com.example.job", ProgramArguments: []string{"/bin/true"}},
+			want: `    <key>Label</key>
+    <string>com.example.job</string>
+    <key>ProgramArguments</key>
+    <array>
+      <string>/bin/true</string>
+    </array>
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := string(tt.job.Marshal())
+			if want := header + tt.want + footer; got != want {
+				t.Errorf("Marshal() =\n%s\nwant\n%s", got, want)
+			}
+		})
+	}
+}
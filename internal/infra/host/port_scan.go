@@ -0,0 +1,93 @@
+//go:build darwin
+
+package host
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"prism/internal/infra/config"
+)
+
+// PortInUse is a port Prism wants to assign that something already listens
+// on. Owner is the listening process as "command (pid N)" when lsof could
+// identify it.
+type PortInUse struct {
+	Port  int    `json:"port"`
+	Owner string `json:"owner,omitempty"`
+}
+
+func (p PortInUse) String() string {
+	if p.Owner == "" {
+		return fmt.Sprintf("port %d", p.Port)
+	}
+	return fmt.Sprintf("port %d (%s)", p.Port, p.Owner)
+}
+
+// userPorts returns the local ports that count new users from index first on
+// take: the primary service's ports from start_port and those of each
+// globals.services entry.
+func userPorts(cfg config.Config, first, count int) []int {
+	starts := []int{cfg.Globals.Service.StartPort}
+	for _, d := range cfg.Globals.Services {
+		starts = append(starts, d.StartPort)
+	}
+	ports := make([]int, 0, len(starts)*count)
+	for _, start := range starts {
+		for i := first; i < first+count; i++ {
+			ports = append(ports, start+i-1)
+		}
+	}
+	return ports
+}
+
+// checkPortsFree fails with ErrPortsInUse, listing every conflict, when a
+// port of the count new users from index first on is already bound.
+func checkPortsFree(ctx context.Context, cfg config.Config, first, count int) error {
+	inUse := ScanPorts(ctx, cfg.Globals.Service.BindIP(), userPorts(cfg, first, count))
+	if len(inUse) == 0 {
+		return nil
+	}
+	list := make([]string, 0, len(inUse))
+	for _, p := range inUse {
+		list = append(list, p.String())
+	}
+	return fmt.Errorf("%w: %s", ErrPortsInUse, strings.Join(list, ", "))
+}
+
+// ScanPorts dials each port on ip, the address the servers will bind
+// (globals.service.local_ip), and returns those already accepting
+// connections, in the order given.
+func ScanPorts(ctx context.Context, ip string, ports []int) []PortInUse {
+	var inUse []PortInUse
+	for _, port := range ports {
+		if dialLocalPort(ctx, ip, port) != nil {
+			continue
+		}
+		inUse = append(inUse, PortInUse{Port: port, Owner: portOwner(ctx, port)})
+	}
+	return inUse
+}
+
+// portOwner asks lsof which process listens on port. It returns "" when lsof
+// is missing or reports nothing.
+func portOwner(ctx context.Context, port int) string {
+	out, err := runner.Run(ctx, "lsof", "-nP", fmt.Sprintf("-iTCP:%d", port), "-sTCP:LISTEN", "-Fcp")
+	if err != nil {
+		return ""
+	}
+	var pid, command string
+	for _, line := range strings.Split(string(out), "\n") {
+		switch {
+		case strings.HasPrefix(line, "p") && pid == "":
+			pid = strings.TrimPrefix(line, "p")
+		case strings.HasPrefix(line, "c") && command == "":
+			command = strings.TrimPrefix(line, "c")
+		}
+	}
+	if command == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s (pid %s)", command, pid)
+}
@@ -0,0 +1,91 @@
+//go:build darwin
+
+package host
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"prism/internal/infra/config"
+	"prism/internal/infra/state"
+)
+
+// prewarmPerUserTimeout bounds a single user's prewarm run; osascript can block
+// on an unanswered TCC prompt.
+const prewarmPerUserTimeout = 60 * time.Second
+
+// UserPrewarmResult describes the outcome of a host-triggered permission
+// prewarm for one Prism user.
+type UserPrewarmResult struct {
+	Name     string   `json:"name"`
+	OK       bool     `json:"ok"`
+	Warnings []string `json:"warnings,omitempty"`
+	Output   string   `json:"output,omitempty"`
+}
+
+// PrewarmAllUsers runs the user-mode permission prewarm inside each Prism
+// user's launchd session via `launchctl asuser`, so TCC prompts are raised in
+// the GUI session activated by Fast Login.
+func PrewarmAllUsers(ctx context.Context, st state.State) []UserPrewarmResult {
+	results := make([]UserPrewarmResult, 0, len(st.Users))
+	for _, u := range st.Users {
+		results = append(results, prewarmUser(ctx, u.Name))
+	}
+	return results
+}
+
+func prewarmUser(ctx context.Context, username string) UserPrewarmResult {
+	res := UserPrewarmResult{Name: username}
+
+	uid, err := getUserUID(username)
+	if err != nil {
+		res.Output = err.Error()
+		return res
+	}
+
+	bin := filepath.Join(userServiceDir(username, config.PrimaryServiceName), "prism-host")
+	ctx, cancel := context.WithTimeout(ctx, prewarmPerUserTimeout)
+	defer cancel()
+
+	out, err := runner.Run(ctx, "launchctl", "asuser", strconv.Itoa(uid),
+		"sudo", "-u", username, "-H", bin, "user", "prewarm",
+	)
+	res.Output = strings.TrimSpace(string(out))
+	if err != nil {
+		if res.Output == "" {
+			res.Output = err.Error()
+		}
+		return res
+	}
+
+	res.OK = true
+	for _, line := range strings.Split(res.Output, "\n") {
+		if w, ok := strings.CutPrefix(line, "- "); ok {
+			res.Warnings = append(res.Warnings, w)
+		}
+	}
+	return res
+}
+
+// FormatPrewarmResults renders per-user prewarm results as plain text.
+func FormatPrewarmResults(results []UserPrewarmResult) string {
+	var b strings.Builder
+	for _, r := range results {
+		switch {
+		case !r.OK:
+			fmt.Fprintf(&b, "[!] %s: prewarm failed: %s\n", r.Name, r.Output)
+		case len(r.Warnings) > 0:
+			fmt.Fprintf(&b, "[~] %s: %d item(s) need attention\n", r.Name, len(r.Warnings))
+			for _, w := range r.Warnings {
+				fmt.Fprintf(&b, "    - %s\n", w)
+			}
+		default:
+			fmt.Fprintf(&b, "[✓] %s: permissions prewarmed\n", r.Name)
+		}
+	}
+	return b.String()
+}
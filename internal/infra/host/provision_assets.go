@@ -0,0 +1,96 @@
+//go:build darwin
+
+package host
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+
+	"prism/internal/infra/config"
+	"prism/internal/infra/deps"
+)
+
+// provisionAssets holds the inputs that are identical for every user in a
+// provisioning run. They are resolved and validated once by
+// prepareProvisionAssets so the per-user loop only writes per-user files.
+type provisionAssets struct {
+	extractDir    string
+	frpcBin       string
+	prismBinary   []byte // nil when no per-user prism wrapper is installed
+	bundleVersion string
+	extras        []extraServiceBundle
+}
+
+// prepareProvisionAssets validates the extracted bundle, resolves frpc, loads
+// the prism binary at prismPath (if any) into memory and extracts the bundles
+// of globals.services.
+func prepareProvisionAssets(ctx context.Context, cfg config.Config, outputDir, extractDir, prismPath, bundleVersion string) (provisionAssets, error) {
+	if err := checkExtractedBundle(extractDir, requiredBundleFiles); err != nil {
+		return provisionAssets{}, err
+	}
+
+	frpcBin, err := resolveFRPCBinary(ctx)
+	if err != nil {
+		return provisionAssets{}, err
+	}
+
+	assets := provisionAssets{
+		extractDir:    extractDir,
+		frpcBin:       frpcBin,
+		bundleVersion: bundleVersion,
+	}
+	if prismPath != "" {
+		data, err := os.ReadFile(prismPath)
+		if err != nil {
+			return provisionAssets{}, fmt.Errorf("read prism binary: %w", err)
+		}
+		assets.prismBinary = data
+	}
+
+	extras, err := ensureExtraServiceBundles(ctx, cfg, outputDir)
+	if err != nil {
+		return provisionAssets{}, err
+	}
+	assets.extras = extras
+	return assets, nil
+}
+
+// frpcFallbackPaths are checked when frpc is not on PATH, which is common when
+// Prism runs under sudo with a reduced PATH.
+var frpcFallbackPaths = []string{"/opt/homebrew/bin/frpc", "/usr/local/bin/frpc"}
+
+// lookupFRPCBinary returns the path of an installed frpc binary.
+func lookupFRPCBinary() (string, error) {
+	if p, err := exec.LookPath("frpc"); err == nil {
+		return p, nil
+	}
+	for _, p := range frpcFallbackPaths {
+		if _, err := os.Stat(p); err == nil {
+			return p, nil
+		}
+	}
+	return "", ErrFRPCMissing
+}
+
+// resolveFRPCBinary finds frpc, installing it through Homebrew if it is
+// missing. It runs before any account is created so a missing frpc fails
+// early instead of after the first user exists.
+func resolveFRPCBinary(ctx context.Context) (string, error) {
+	if p, err := lookupFRPCBinary(); err == nil {
+		return p, nil
+	}
+
+	fmt.Printf("[provision] frpc not found; trying to install it with Homebrew\n")
+	item := deps.EnsureFRPC(ctx)
+	if !item.OK {
+		return "", fmt.Errorf("%w and could not be installed: %s\nInstall it with `brew install frpc` (or re-run Setup so the Dependencies step installs it), then retry", ErrFRPCMissing, item.Detail)
+	}
+
+	p, err := lookupFRPCBinary()
+	if err != nil {
+		return "", fmt.Errorf("%w: installed but not in PATH or %v", ErrFRPCMissing, frpcFallbackPaths)
+	}
+	return p, nil
+}
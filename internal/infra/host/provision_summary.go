@@ -0,0 +1,120 @@
+//go:build darwin
+
+package host
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"prism/internal/infra/config"
+	"prism/internal/infra/state"
+)
+
+const (
+	provisionSummaryTextName = "provision-summary.txt"
+	provisionSummaryJSONName = "provision-summary.json"
+)
+
+// ProvisionSummary is a durable record of one provisioning run. It points at
+// the secrets file but never contains passwords.
+type ProvisionSummary struct {
+	Timestamp     string                 `json:"timestamp"`
+	Action        string                 `json:"action"`
+	BundleVersion string                 `json:"bundle_version,omitempty"`
+	SecretsPath   string                 `json:"secrets_path"`
+	Users         []ProvisionSummaryUser `json:"users"`
+}
+
+// ProvisionSummaryUser is one row of the per-user table in a summary.
+type ProvisionSummaryUser struct {
+	Name       string `json:"name"`
+	Port       int    `json:"port"`
+	Subdomain  string `json:"subdomain"`
+	FullDomain string `json:"full_domain"`
+	URL        string `json:"url"`
+}
+
+// writeProvisionSummary appends a summary of st to provision-summary.txt and
+// provision-summary.json in outputDir.
+func writeProvisionSummary(cfg config.Config, st state.State, outputDir, action, secretsPath string) error {
+	version, _ := readCurrentVersion(outputDir)
+	sum := ProvisionSummary{
+		Timestamp:     time.Now().UTC().Format(time.RFC3339),
+		Action:        action,
+		BundleVersion: version,
+		SecretsPath:   secretsPath,
+		Users:         make([]ProvisionSummaryUser, 0, len(st.Users)),
+	}
+	suffix := strings.Trim(strings.TrimSpace(cfg.Globals.DomainSuffix), ".")
+	for _, u := range st.Users {
+		row := ProvisionSummaryUser{Name: u.Name, Port: u.Port, Subdomain: u.Subdomain}
+		if u.Subdomain != "" && suffix != "" {
+			row.FullDomain = u.Subdomain + "." + suffix
+			row.URL = cfg.Globals.PublicURL(row.FullDomain)
+		}
+		sum.Users = append(sum.Users, row)
+	}
+
+	if err := appendProvisionSummaryText(filepath.Join(outputDir, provisionSummaryTextName), sum); err != nil {
+		return err
+	}
+	return appendProvisionSummaryJSON(filepath.Join(outputDir, provisionSummaryJSONName), sum)
+}
+
+func appendProvisionSummaryText(path string, sum ProvisionSummary) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("open %s: %w", path, err)
+	}
+	defer func() { _ = f.Close() }()
+
+	version := sum.BundleVersion
+	if version == "" {
+		version = "unknown"
+	}
+	fmt.Fprintf(f, "=== %s %s ===\n", sum.Timestamp, sum.Action)
+	fmt.Fprintf(f, "Bundle version: %s\n", version)
+	fmt.Fprintf(f, "Users: %d\n", len(sum.Users))
+	secrets := sum.SecretsPath
+	if secrets == "" {
+		secrets = "not stored (globals.service.store_secrets is false)"
+	}
+	fmt.Fprintf(f, "Passwords: %s\n\n", secrets)
+
+	tw := tabwriter.NewWriter(f, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "USER\tPORT\tSUBDOMAIN\tURL")
+	for _, u := range sum.Users {
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%s\n", u.Name, u.Port, u.Subdomain, u.URL)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(f)
+	return err
+}
+
+func appendProvisionSummaryJSON(path string, sum ProvisionSummary) error {
+	var all []ProvisionSummary
+	data, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		if err := json.Unmarshal(data, &all); err != nil {
+			return fmt.Errorf("parse %s: %w", path, err)
+		}
+	case !errors.Is(err, os.ErrNotExist):
+		return err
+	}
+
+	all = append(all, sum)
+	out, err := json.MarshalIndent(all, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, append(out, '\n'), 0o644)
+}
@@ -0,0 +1,91 @@
+//go:build darwin
+
+package host
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"prism/internal/infra/config"
+)
+
+// UpdatePreview compares the deployed service bundle with the latest release,
+// so an operator can see what an update would change before running it.
+type UpdatePreview struct {
+	// Current is the deployed tag; empty when no version has been recorded.
+	Current string `json:"current"`
+	// Latest is the newest release tag, or the fixed tag when Pinned.
+	Latest string `json:"latest"`
+	// Pinned is set when archive_url names a fixed tag. No release is
+	// fetched then, and no update is available.
+	Pinned      bool   `json:"pinned"`
+	ReleaseName string `json:"release_name,omitempty"`
+	Notes       string `json:"notes,omitempty"`
+	URL         string `json:"url,omitempty"`
+	PublishedAt string `json:"published_at,omitempty"`
+}
+
+// UpdateAvailable reports whether the latest release differs from the
+// deployed one.
+func (p UpdatePreview) UpdateAvailable() bool {
+	return !p.Pinned && p.Latest != "" && p.Latest != p.Current
+}
+
+// Summary is a one-line description of the version delta.
+func (p UpdatePreview) Summary() string {
+	current := p.Current
+	if current == "" {
+		current = "unknown"
+	}
+	switch {
+	case p.Pinned:
+		return fmt.Sprintf("Pinned to %s, no update available.", p.Latest)
+	case !p.UpdateAvailable():
+		return fmt.Sprintf("Already on the latest release %s.", current)
+	default:
+		return fmt.Sprintf("Update available: %s -> %s", current, p.Latest)
+	}
+}
+
+// PreviewUpdate reads the deployed version from outputDir and fetches the
+// latest release of the gh:// archive_url, including its release notes.
+// It changes nothing on the host.
+func PreviewUpdate(ctx context.Context, cfg config.Config, outputDir string) (UpdatePreview, error) {
+	archiveURL := strings.TrimSpace(cfg.Globals.Service.ArchiveURL)
+	if archiveURL == "" {
+		return UpdatePreview{}, errors.New("globals.service.archive_url is empty")
+	}
+	gh, ok, err := config.ParseGitHubArchive(archiveURL)
+	if err != nil {
+		return UpdatePreview{}, err
+	}
+	if !ok {
+		return UpdatePreview{}, errors.New("archive_url is not a gh:// URL; release information is unavailable")
+	}
+
+	var p UpdatePreview
+	p.Current, err = readCurrentVersion(outputDir)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return UpdatePreview{}, fmt.Errorf("read current version: %w", err)
+	}
+
+	if gh.Tag != "" {
+		p.Latest = gh.Tag
+		p.Pinned = true
+		return p, nil
+	}
+
+	rel, err := fetchLatestGitHubRelease(ctx, gh)
+	if err != nil {
+		return UpdatePreview{}, fmt.Errorf("fetch latest release: %w", err)
+	}
+	p.Latest = rel.TagName
+	p.ReleaseName = strings.TrimSpace(rel.Name)
+	p.Notes = strings.TrimSpace(rel.Body)
+	p.URL = rel.HTMLURL
+	p.PublishedAt = rel.PublishedAt
+	return p, nil
+}
@@ -0,0 +1,41 @@
+//go:build darwin
+
+package host
+
+import (
+	"context"
+	"os/exec"
+)
+
+// Runner abstracts command execution so the account and LaunchDaemon steps of
+// provisioning can run against a fake instead of a real Mac.
+type Runner interface {
+	// Run executes name with args and returns its combined stdout and
+	// stderr. A non-zero exit is reported as an *exec.ExitError.
+	Run(ctx context.Context, name string, args ...string) ([]byte, error)
+}
+
+// RunnerFunc adapts a function to the Runner interface.
+type RunnerFunc func(ctx context.Context, name string, args ...string) ([]byte, error)
+
+func (f RunnerFunc) Run(ctx context.Context, name string, args ...string) ([]byte, error) {
+	return f(ctx, name, args...)
+}
+
+type execRunner struct{}
+
+func (execRunner) Run(ctx context.Context, name string, args ...string) ([]byte, error) {
+	return exec.CommandContext(ctx, name, args...).CombinedOutput()
+}
+
+// runner runs every external command of this package, such as sysadminctl,
+// dsimport, dscl, rsync and launchctl.
+var runner Runner = execRunner{}
+
+// SetRunner replaces the Runner used by this package and returns a func that
+// restores the previous one. It is meant for tests.
+func SetRunner(r Runner) (restore func()) {
+	prev := runner
+	runner = r
+	return func() { runner = prev }
+}
@@ -0,0 +1,159 @@
+//go:build darwin
+
+package host
+
+import (
+	"archive/tar"
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"prism/internal/infra/config"
+)
+
+// fakeRunner stands in for the macOS tools. It keeps a set of accounts that
+// sysadminctl -addUser/-deleteUser and dsimport change and id consults, and
+// succeeds silently for everything else unless fail matches.
+type fakeRunner struct {
+	mu       sync.Mutex
+	accounts map[string]bool
+	calls    []string
+	// fail maps a command line prefix, e.g. "sysadminctl -addUser mac-2",
+	// to the output of a run that exits non-zero.
+	fail map[string]string
+	// output maps a command line prefix to the output of a successful run.
+	output map[string]string
+}
+
+// exitError is a non-zero exit as reported by exec.
+func exitError() error {
+	return &exec.ExitError{}
+}
+
+// newFakeRunner installs a fakeRunner for the duration of the test with the
+// given accounts already present.
+func newFakeRunner(t testing.TB, accounts ...string) *fakeRunner {
+	t.Helper()
+	f := &fakeRunner{accounts: map[string]bool{}, fail: map[string]string{}, output: map[string]string{}}
+	for _, a := range accounts {
+		f.accounts[a] = true
+	}
+	t.Cleanup(SetRunner(f))
+	return f
+}
+
+func (f *fakeRunner) Run(ctx context.Context, name string, args ...string) ([]byte, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	line := strings.Join(append([]string{name}, args...), " ")
+	f.calls = append(f.calls, line)
+	for prefix, out := range f.fail {
+		if strings.HasPrefix(line, prefix) {
+			return []byte(out), exitError()
+		}
+	}
+	for prefix, out := range f.output {
+		if strings.HasPrefix(line, prefix) {
+			return []byte(out), nil
+		}
+	}
+
+	switch name {
+	case "id":
+		if !f.accounts[args[len(args)-1]] {
+			return []byte("id: no such user"), exitError()
+		}
+		return []byte("501\n"), nil
+	case "sysadminctl":
+		switch args[0] {
+		case "-addUser":
+			f.accounts[args[1]] = true
+		case "-deleteUser":
+			delete(f.accounts, args[1])
+		}
+	case "dsimport":
+		data, err := os.ReadFile(args[0])
+		if err != nil {
+			return nil, err
+		}
+		lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+		for _, l := range lines[1:] {
+			f.accounts[strings.SplitN(l, ":", 2)[0]] = true
+		}
+	case "dseditgroup":
+		// checkmember: not an administrator.
+		return nil, exitError()
+	case "launchctl":
+		if args[0] == "print" {
+			return nil, exitError()
+		}
+	}
+	return nil, nil
+}
+
+// called reports whether a command line starting with prefix was run.
+func (f *fakeRunner) called(prefix string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, c := range f.calls {
+		if strings.HasPrefix(c, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasAccount reports whether the fake directory service holds username.
+func (f *fakeRunner) hasAccount(username string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.accounts[username]
+}
+
+// testHost points the package at temporary user and LaunchDaemon
+// directories and returns a config whose service bundle is already cached in
+// the returned output directory, so provisioning needs no network.
+func testHost(t testing.TB) (config.Config, string) {
+	t.Helper()
+	root := t.TempDir()
+	prevUsers, prevDaemons, prevFRPC := usersDir, launchDaemonsDir, frpcFallbackPaths
+	t.Cleanup(func() { usersDir, launchDaemonsDir, frpcFallbackPaths = prevUsers, prevDaemons, prevFRPC })
+	usersDir = filepath.Join(root, "Users")
+	launchDaemonsDir = filepath.Join(root, "LaunchDaemons")
+	for _, dir := range []string{usersDir, launchDaemonsDir} {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	frpc := filepath.Join(root, "frpc")
+	if err := os.WriteFile(frpc, []byte("#!/bin/sh\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	frpcFallbackPaths = []string{frpc}
+
+	outputDir := filepath.Join(root, "output")
+	cacheDir := filepath.Join(outputDir, "cache")
+	if err := os.MkdirAll(cacheDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	archive := writeFixture(t, []tarEntry{
+		{name: "bundle/" + serverBinRelPath, typeflag: tar.TypeReg, body: "bin", mode: 0o755},
+		{name: "bundle/" + serverAppName + "/Contents/Info.plist", typeflag: tar.TypeReg, body: "plist"},
+	})
+	if err := os.Rename(archive, filepath.Join(cacheDir, defaultArchiveName)); err != nil {
+		t.Fatal(err)
+	}
+
+	var cfg config.Config
+	cfg.Globals.MachineID = "mac"
+	cfg.Globals.DomainSuffix = "example.com"
+	cfg.Globals.FRPC.ServerAddr = "frps.example.com"
+	cfg.Globals.FRPC.ServerPort = 7000
+	cfg.Globals.Service.ArchiveURL = "https://example.com/bundle.tar.gz"
+	cfg.Globals.Service.StartPort = 20000
+	return cfg, outputDir
+}
@@ -0,0 +1,251 @@
+//go:build darwin
+
+package host
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"prism/internal/infra/config"
+)
+
+const (
+	selfTestUserSuffix  = "selftest"
+	selfTestWaitTimeout = 30 * time.Second
+)
+
+// SelfTestStep is the outcome of a single self-test step.
+type SelfTestStep struct {
+	Name   string `json:"name"`
+	OK     bool   `json:"ok"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// SelfTestResult aggregates all steps of a self-test run.
+type SelfTestResult struct {
+	Username string         `json:"username"`
+	Steps    []SelfTestStep `json:"steps"`
+}
+
+// Passed reports whether every step succeeded.
+func (r SelfTestResult) Passed() bool {
+	for _, s := range r.Steps {
+		if !s.OK {
+			return false
+		}
+	}
+	return len(r.Steps) > 0
+}
+
+// checkBundleRunnable goes beyond the presence checks of extraction: the
+// server binary must be an executable file and Info.plist must parse.
+func checkBundleRunnable(ctx context.Context, extractDir string) error {
+	bin := filepath.Join(extractDir, serverBinRelPath)
+	fi, err := os.Stat(bin)
+	if err != nil {
+		return err
+	}
+	if !fi.Mode().IsRegular() || fi.Mode().Perm()&0o111 == 0 {
+		return fmt.Errorf("server binary %s is not executable (mode %v)", bin, fi.Mode())
+	}
+	plist := filepath.Join(extractDir, serverAppName, "Contents", "Info.plist")
+	if out, err := runner.Run(ctx, "plutil", "-lint", plist); err != nil {
+		return fmt.Errorf("%s does not parse: %w (output=%s)", plist, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// SelfTestUsername returns the reserved account name used by RunSelfTest.
+func SelfTestUsername(cfg config.Config) string {
+	return fmt.Sprintf("%s-%s", strings.TrimSpace(cfg.Globals.MachineID), selfTestUserSuffix)
+}
+
+// RunSelfTest provisions a throwaway user through the regular provisioning
+// code path, verifies its services end to end and removes it again. The user
+// is never written to state or the secrets file, and removal runs even when
+// an earlier step fails.
+func RunSelfTest(ctx context.Context, cfg config.Config, outputDir, prismPath string) (res SelfTestResult) {
+	username := SelfTestUsername(cfg)
+	res.Username = username
+	step := func(name string, err error) bool {
+		s := SelfTestStep{Name: name, OK: err == nil}
+		if err != nil {
+			s.Detail = err.Error()
+		}
+		res.Steps = append(res.Steps, s)
+		return err == nil
+	}
+
+	exists, err := systemUserExists(ctx, username)
+	if err == nil && exists {
+		err = fmt.Errorf("reserved %w: %s; remove it manually before running selftest", ErrUserExists, username)
+	}
+	if !step("reserved user is free", err) {
+		return res
+	}
+
+	extractDir, err := ensureServiceArchive(ctx, cfg, outputDir)
+	if !step("download and extract service bundle", err) {
+		return res
+	}
+	if !step("service bundle is runnable", checkBundleRunnable(ctx, extractDir)) {
+		return res
+	}
+
+	assets, err := prepareProvisionAssets(ctx, cfg, outputDir, extractDir, prismPath, "")
+	if !step("locate frpc and prism binaries", err) {
+		return res
+	}
+
+	port, err := freeLocalPort()
+	if !step("allocate local port", err) {
+		return res
+	}
+
+	password, err := generatePassword("")
+	if err == nil {
+		err = createSystemUser(ctx, username, password, systemUserOptions{Hidden: true})
+	}
+	if !step("create user account", err) {
+		return res
+	}
+	defer func() {
+		// Use a fresh context so cleanup still runs if ctx was cancelled.
+		cleanupCtx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+		defer cancel()
+		step("remove user account", deleteSystemUser(cleanupCtx, username))
+	}()
+
+	_, err = ensurePerUserFiles(ctx, cfg, username, port, assets)
+	if !step("provision service files and LaunchDaemons", err) {
+		return res
+	}
+	if !step("bootstrap LaunchDaemons", BootstrapUserLaunchDaemons(ctx, username)) {
+		return res
+	}
+
+	serviceDir := userServiceDir(username, config.PrimaryServiceName)
+	if fi, err := os.Stat(serviceDir); err != nil {
+		step("service directory", err)
+	} else if !fi.IsDir() {
+		step("service directory", fmt.Errorf("%s is not a directory", serviceDir))
+	} else {
+		step("service directory", nil)
+	}
+
+	step("LaunchDaemons loaded", checkUserDaemonsLoaded(ctx, username))
+	bindIP := cfg.Globals.Service.BindIP()
+	if !step("port listening", waitForPort(ctx, bindIP, port, selfTestWaitTimeout)) {
+		return res
+	}
+	healthPath := cfg.Globals.Service.HealthCheckPath()
+	step(healthPath+" responds", waitForHTTP(ctx, "http://"+net.JoinHostPort(bindIP, strconv.Itoa(port))+healthPath, selfTestWaitTimeout))
+
+	return res
+}
+
+// FormatSelfTestResult renders a self-test result as plain text.
+func FormatSelfTestResult(r SelfTestResult) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Prism selftest (user %s)\n", r.Username)
+	for _, s := range r.Steps {
+		mark := "[✓]"
+		if !s.OK {
+			mark = "[!]"
+		}
+		fmt.Fprintf(&b, "  %s %s\n", mark, s.Name)
+		if s.Detail != "" {
+			fmt.Fprintf(&b, "      %s\n", s.Detail)
+		}
+	}
+	if r.Passed() {
+		b.WriteString("PASS\n")
+	} else {
+		b.WriteString("FAIL\n")
+	}
+	return b.String()
+}
+
+func freeLocalPort() (int, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = l.Close() }()
+	return l.Addr().(*net.TCPAddr).Port, nil
+}
+
+func checkUserDaemonsLoaded(ctx context.Context, username string) error {
+	var missing []string
+	for _, label := range []string{
+		fmt.Sprintf(launchDaemonServerLabel, username),
+		fmt.Sprintf(launchDaemonFRPCLabel, username),
+	} {
+		if _, err := runner.Run(ctx, "launchctl", "print", "system/"+label); err != nil {
+			missing = append(missing, label)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("not loaded: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+func waitForPort(ctx context.Context, ip string, port int, timeout time.Duration) error {
+	addr := net.JoinHostPort(ip, strconv.Itoa(port))
+	deadline := time.Now().Add(timeout)
+	dialer := &net.Dialer{Timeout: 500 * time.Millisecond}
+	for {
+		conn, err := dialer.DialContext(ctx, "tcp", addr)
+		if err == nil {
+			_ = conn.Close()
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("no listener on %s after %s: %w", addr, timeout, err)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(500 * time.Millisecond):
+		}
+	}
+}
+
+func waitForHTTP(ctx context.Context, url string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	client := &http.Client{Timeout: 2 * time.Second}
+	var lastErr error
+	for {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := client.Do(req)
+		if err == nil {
+			_ = resp.Body.Close()
+			if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+				return nil
+			}
+			lastErr = fmt.Errorf("status %s", resp.Status)
+		} else {
+			lastErr = err
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%s did not become healthy after %s: %w", url, timeout, lastErr)
+		}
+		select {
+		case <-ctx.Done():
+			return errors.Join(ctx.Err(), lastErr)
+		case <-time.After(500 * time.Millisecond):
+		}
+	}
+}
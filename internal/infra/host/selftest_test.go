@@ -0,0 +1,51 @@
+//go:build darwin
+
+package host
+
+import (
+	"archive/tar"
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestCheckBundleRunnable(t *testing.T) {
+	plist := tarEntry{name: serverAppName + "/Contents/Info.plist", typeflag: tar.TypeReg, body: "plist"}
+	tests := []struct {
+		name    string
+		binMode int64
+		fail    map[string]string
+		wantErr string
+	}{
+		{name: "runnable", binMode: 0o755},
+		{name: "binary not executable", binMode: 0o644, wantErr: "is not executable"},
+		{name: "plist does not parse", binMode: 0o755, fail: map[string]string{"plutil -lint": "Info.plist: Encountered unexpected character"}, wantErr: "does not parse"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := newFakeRunner(t)
+			for k, v := range tt.fail {
+				fake.fail[k] = v
+			}
+			archive := writeFixture(t, []tarEntry{
+				{name: serverBinRelPath, typeflag: tar.TypeReg, body: "bin", mode: tt.binMode},
+				plist,
+			})
+			dir := t.TempDir()
+			if err := extractTarGz(context.Background(), archive, dir, 0); err != nil {
+				t.Fatal(err)
+			}
+
+			err := checkBundleRunnable(context.Background(), dir)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("checkBundleRunnable() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("checkBundleRunnable() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
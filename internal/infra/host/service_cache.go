@@ -0,0 +1,135 @@
+//go:build darwin
+
+package host
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"prism/internal/infra/config"
+)
+
+const (
+	// extractDirName is the directory inside a service cache holding the
+	// currently extracted bundle (named after the primary service).
+	extractDirName = "imsg"
+	// previousArchiveSuffix marks an archive retired by a refresh.
+	previousArchiveSuffix = ".prev"
+)
+
+// retireCachedArchives renames cached bundles to <name>.prev so the next
+// ensureServiceArchive downloads a fresh copy while the old one stays
+// available until cleanupServiceCache runs.
+func retireCachedArchives(cacheDir string) {
+	entries, err := os.ReadDir(cacheDir)
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		name := e.Name()
+		if !e.Type().IsRegular() || name == versionFileName || strings.HasSuffix(name, previousArchiveSuffix) {
+			continue
+		}
+		p := filepath.Join(cacheDir, name)
+		_ = os.Rename(p, p+previousArchiveSuffix)
+	}
+}
+
+// cachedAsset returns the one cached archive in cacheDir whose name matches
+// the asset pattern of gh. Retired and partial downloads do not count, and
+// more than one match means the release has to be asked again.
+func cachedAsset(cacheDir string, gh config.GitHubArchive) (string, bool) {
+	entries, err := os.ReadDir(cacheDir)
+	if err != nil {
+		return "", false
+	}
+	var names []string
+	for _, e := range entries {
+		name := e.Name()
+		if e.Type().IsRegular() && !strings.HasSuffix(name, previousArchiveSuffix) && !strings.HasSuffix(name, ".tmp") {
+			names = append(names, name)
+		}
+	}
+	name, err := gh.MatchAsset(names)
+	return name, err == nil
+}
+
+// pruneServiceCaches runs cleanupServiceCache on the primary bundle cache and
+// on that of every globals.services entry, so assets retired by an update do
+// not pile up.
+func pruneServiceCaches(cfg config.Config, outputDir string) error {
+	dirs := []string{serviceCacheDir(cfg, outputDir)}
+	for _, def := range cfg.Globals.Services {
+		dirs = append(dirs, extraServiceCacheDir(cfg, outputDir, def.Name))
+	}
+	var errs []error
+	for _, dir := range dirs {
+		if err := cleanupServiceCache(dir, cfg.Globals.Service.KeepPreviousArchive); err != nil && !errors.Is(err, os.ErrNotExist) {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// cleanupServiceCache keeps disk usage bounded: it removes stale extracted
+// directories and all archives except the newest one, plus the newest
+// retired archive when keepPrevious is set.
+func cleanupServiceCache(cacheDir string, keepPrevious bool) error {
+	entries, err := os.ReadDir(cacheDir)
+	if err != nil {
+		return err
+	}
+
+	type archive struct {
+		path    string
+		modTime int64
+	}
+	var current, previous []archive
+	var errs []error
+	for _, e := range entries {
+		name := e.Name()
+		p := filepath.Join(cacheDir, name)
+		switch {
+		case e.IsDir():
+			if name != extractDirName && name != extraServicesCacheDir {
+				errs = append(errs, os.RemoveAll(p))
+			}
+		case !e.Type().IsRegular() || name == versionFileName:
+		case strings.HasSuffix(name, ".tmp"):
+			errs = append(errs, os.Remove(p))
+		default:
+			info, err := e.Info()
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			a := archive{path: p, modTime: info.ModTime().UnixNano()}
+			if strings.HasSuffix(name, previousArchiveSuffix) {
+				previous = append(previous, a)
+			} else {
+				current = append(current, a)
+			}
+		}
+	}
+
+	newestFirst := func(list []archive) {
+		sort.Slice(list, func(i, j int) bool { return list[i].modTime > list[j].modTime })
+	}
+	newestFirst(current)
+	newestFirst(previous)
+
+	for i, a := range current {
+		if i > 0 {
+			errs = append(errs, os.Remove(a.path))
+		}
+	}
+	for i, a := range previous {
+		if !keepPrevious || i > 0 {
+			errs = append(errs, os.Remove(a.path))
+		}
+	}
+	return errors.Join(errs...)
+}
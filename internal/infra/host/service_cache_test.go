@@ -0,0 +1,80 @@
+//go:build darwin
+
+package host
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"prism/internal/infra/config"
+)
+
+func TestCachedAsset(t *testing.T) {
+	gh := config.GitHubArchive{Asset: "bundle-*.tar.gz"}
+	tests := []struct {
+		name   string
+		files  []string
+		want   string
+		wantOK bool
+	}{
+		{name: "one match", files: []string{"bundle-1.2.tar.gz", "current_version.txt"}, want: "bundle-1.2.tar.gz", wantOK: true},
+		{name: "retired and partial ignored", files: []string{"bundle-1.1.tar.gz.prev", "bundle-1.3.tar.gz.tmp"}},
+		{name: "several matches", files: []string{"bundle-1.1.tar.gz", "bundle-1.2.tar.gz"}},
+		{name: "empty", files: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for _, f := range tt.files {
+				if err := os.WriteFile(filepath.Join(dir, f), nil, 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			got, ok := cachedAsset(dir, gh)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("cachedAsset() = %q, %v; want %q, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestEnsureServiceArchiveReusesCachedPatternAsset(t *testing.T) {
+	cfg, outputDir := testHost(t)
+	newFakeRunner(t)
+	cacheDir := filepath.Join(outputDir, "cache")
+	if err := os.Rename(filepath.Join(cacheDir, defaultArchiveName), filepath.Join(cacheDir, "bundle-1.2.tar.gz")); err != nil {
+		t.Fatal(err)
+	}
+	// Resolving the pattern would need the GitHub API; the cached asset
+	// must be used instead.
+	cfg.Globals.Service.ArchiveURL = "gh://example/missing-repo/bundle-*.tar.gz"
+
+	if _, err := ensureServiceArchive(context.Background(), cfg, outputDir); err != nil {
+		t.Fatalf("ensureServiceArchive() error = %v", err)
+	}
+}
+
+func TestPruneServiceCaches(t *testing.T) {
+	cfg, outputDir := testHost(t)
+	cfg.Globals.Services = []config.ServiceDefinition{{Name: "extra"}}
+	extraDir := extraServiceCacheDir(cfg, outputDir, "extra")
+	if err := os.MkdirAll(extraDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	retired := filepath.Join(extraDir, "extra-1.0.tar.gz.prev")
+	if err := os.WriteFile(retired, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := pruneServiceCaches(cfg, outputDir); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(retired); !os.IsNotExist(err) {
+		t.Errorf("retired extra service asset was not pruned: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(outputDir, "cache", defaultArchiveName)); err != nil {
+		t.Errorf("current primary archive was pruned: %v", err)
+	}
+}
@@ -0,0 +1,74 @@
+//go:build darwin
+
+package host
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"prism/internal/infra/config"
+	"prism/internal/infra/state"
+)
+
+// UnhealthyRestartResult describes the outcome of RestartUnhealthyUsers.
+type UnhealthyRestartResult struct {
+	// Restarted maps each unhealthy user to the error of its restart, or nil.
+	Restarted map[string]error
+	// Healthy lists the users that were healthy and left untouched.
+	Healthy []string
+	// Statuses is the service check the selection was based on.
+	Statuses []UserServiceStatus
+}
+
+// RestartUnhealthyUsers checks every user's services and restarts the
+// daemons of only those with a service that is down (see servicesDown),
+// leaving the other users running. One user's failure does not stop the
+// others; when some restarts fail the error lists them and res.Restarted
+// holds each user's outcome.
+func RestartUnhealthyUsers(ctx context.Context, cfg config.Config, st state.State) (UnhealthyRestartResult, error) {
+	var res UnhealthyRestartResult
+	statuses, err := CheckUserServices(ctx, cfg, st)
+	if err != nil {
+		return res, fmt.Errorf("check services: %w", err)
+	}
+	res.Statuses = statuses
+
+	res.Restarted = make(map[string]error)
+	var failed []string
+	for _, s := range statuses {
+		if !servicesDown(s) {
+			res.Healthy = append(res.Healthy, s.Name)
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return res, err
+		}
+		err := RestartUserDaemons(ctx, s.Name)
+		res.Restarted[s.Name] = err
+		if err != nil {
+			failed = append(failed, s.Name)
+		}
+	}
+
+	if len(failed) > 0 {
+		return res, fmt.Errorf("restart failed for %d of %d unhealthy users (%s)", len(failed), len(res.Restarted), strings.Join(failed, ", "))
+	}
+	return res, nil
+}
+
+// servicesDown reports whether a service of the user, primary or from
+// globals.services, lacks its directory or listening port. A missing GUI
+// session or an unreachable tunnel is not something restarting the user's
+// daemons fixes, so neither counts.
+func servicesDown(s UserServiceStatus) bool {
+	if !s.ServiceDirOK || !s.PortListening {
+		return true
+	}
+	for _, svc := range s.Services {
+		if !svc.ServiceDirOK || !svc.PortListening {
+			return true
+		}
+	}
+	return false
+}
@@ -4,10 +4,11 @@ package host
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net"
 	"os"
-	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
@@ -16,29 +17,69 @@ import (
 )
 
 // UserServiceStatus describes the runtime status of a Prism-managed user.
+// The top-level fields cover the primary imsg service; Services covers the
+// ones from globals.services.
 type UserServiceStatus struct {
+	Name          string               `json:"name"`
+	Port          int                  `json:"port"`
+	Subdomain     string               `json:"subdomain"`
+	ServiceDirOK  bool                 `json:"service_dir_ok"`
+	PortListening bool                 `json:"port_listening"`
+	Services      []ExtraServiceStatus `json:"services,omitempty"`
+	// Keepalive is reported on its own: the agent only runs while the user
+	// has a GUI session, so it does not count towards Healthy.
+	Keepalive KeepaliveStatus `json:"keepalive"`
+	// ManifestMissing lists the paths of the user's manifest.json that no
+	// longer exist. Users provisioned before manifests have none to check.
+	ManifestMissing []string `json:"manifest_missing,omitempty"`
+	// URL is the user's public URL, empty when it has no public domain.
+	// TunnelChecked is set once ProbeUserTunnels requested it through the frp
+	// tunnel, and TunnelReachable then holds the outcome.
+	URL             string `json:"url,omitempty"`
+	TunnelChecked   bool   `json:"tunnel_checked,omitempty"`
+	TunnelReachable bool   `json:"tunnel_reachable"`
+	Detail          string `json:"detail"`
+}
+
+// ExtraServiceStatus is the status of one additional service of a user.
+type ExtraServiceStatus struct {
 	Name          string `json:"name"`
 	Port          int    `json:"port"`
-	Subdomain     string `json:"subdomain"`
 	ServiceDirOK  bool   `json:"service_dir_ok"`
 	PortListening bool   `json:"port_listening"`
-	Detail        string `json:"detail"`
 }
 
-// CheckUserServices reports runtime status for each Prism-managed user.
+// Healthy reports whether every service of the user has its directory and a
+// listening port. The tunnel is not part of it: an frps outage is not the
+// user's fault and is reported by ProbeUserTunnels instead.
+func (s UserServiceStatus) Healthy() bool {
+	if !s.ServiceDirOK || !s.PortListening {
+		return false
+	}
+	for _, svc := range s.Services {
+		if !svc.ServiceDirOK || !svc.PortListening {
+			return false
+		}
+	}
+	return true
+}
+
+// CheckUserServices reports runtime status for each Prism-managed user. It
+// only looks at the local host; see ProbeUserTunnels for the public side.
 func CheckUserServices(ctx context.Context, cfg config.Config, st state.State) ([]UserServiceStatus, error) {
+	bindIP := cfg.Globals.Service.BindIP()
 	statuses := make([]UserServiceStatus, 0, len(st.Users))
 	for _, u := range st.Users {
 		stItem := UserServiceStatus{
 			Name:      u.Name,
 			Port:      u.Port,
 			Subdomain: u.Subdomain,
+			URL:       userPublicURL(cfg, u),
 		}
 
 		var details []string
 
-		homeDir := filepath.Join("/Users", u.Name)
-		serviceDir := filepath.Join(homeDir, "services", "imsg")
+		serviceDir := userServiceDir(u.Name, config.PrimaryServiceName)
 		if fi, err := os.Stat(serviceDir); err == nil && fi.IsDir() {
 			stItem.ServiceDirOK = true
 		} else {
@@ -50,17 +91,46 @@ func CheckUserServices(ctx context.Context, cfg config.Config, st state.State) (
 		}
 
 		if u.Port > 0 {
-			addr := fmt.Sprintf("127.0.0.1:%d", u.Port)
-			dialer := &net.Dialer{Timeout: 500 * time.Millisecond}
-			conn, err := dialer.DialContext(ctx, "tcp", addr)
-			if err == nil {
+			if err := dialLocalPort(ctx, bindIP, u.Port); err == nil {
 				stItem.PortListening = true
-				_ = conn.Close()
 			} else {
-				details = append(details, fmt.Sprintf("no listener on %s: %v", addr, err))
+				details = append(details, err.Error())
 			}
 		}
 
+		for _, def := range cfg.Globals.Services {
+			svc := ExtraServiceStatus{Name: def.Name}
+			if fi, err := os.Stat(userServiceDir(u.Name, def.Name)); err == nil && fi.IsDir() {
+				svc.ServiceDirOK = true
+			} else {
+				details = append(details, fmt.Sprintf("%s: service dir missing or unreadable", def.Name))
+			}
+			if u.Port > 0 {
+				svc.Port = extraServicePort(cfg, def, u.Port)
+				if err := dialLocalPort(ctx, bindIP, svc.Port); err == nil {
+					svc.PortListening = true
+				} else {
+					details = append(details, fmt.Sprintf("%s: %v", def.Name, err))
+				}
+			}
+			stItem.Services = append(stItem.Services, svc)
+		}
+
+		if ks, err := CheckKeepalive(ctx, u.Name); err != nil {
+			details = append(details, fmt.Sprintf("keepalive: %v", err))
+		} else {
+			stItem.Keepalive = ks
+		}
+
+		if m, err := ReadUserManifest(u.Name); err == nil {
+			if missing := m.MissingPaths(); len(missing) > 0 {
+				stItem.ManifestMissing = missing
+				details = append(details, fmt.Sprintf("manifest: missing %s", strings.Join(missing, ", ")))
+			}
+		} else if !errors.Is(err, os.ErrNotExist) {
+			details = append(details, fmt.Sprintf("manifest: %v", err))
+		}
+
 		if len(details) > 0 {
 			stItem.Detail = strings.Join(details, "; ")
 		}
@@ -69,3 +139,15 @@ func CheckUserServices(ctx context.Context, cfg config.Config, st state.State) (
 	}
 	return statuses, nil
 }
+
+// dialLocalPort checks that something accepts connections on ip:port.
+func dialLocalPort(ctx context.Context, ip string, port int) error {
+	addr := net.JoinHostPort(ip, strconv.Itoa(port))
+	dialer := &net.Dialer{Timeout: 500 * time.Millisecond}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return fmt.Errorf("no listener on %s: %v", addr, err)
+	}
+	_ = conn.Close()
+	return nil
+}
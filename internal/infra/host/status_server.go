@@ -0,0 +1,185 @@
+//go:build darwin
+
+package host
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"prism/internal/infra/config"
+	"prism/internal/infra/macos"
+	"prism/internal/infra/state"
+)
+
+// HostStatus is the read-only status document served on
+// globals.status_listen. NeedsAttention lists, one line each, the failed
+// preflight checks, unhealthy users and users whose manifest paths are
+// missing, so a fleet collector can flag the host without interpreting the
+// rest.
+type HostStatus struct {
+	Hostname       string                `json:"hostname"`
+	MachineID      string                `json:"machine_id"`
+	GeneratedAt    string                `json:"generated_at"`
+	Version        *VersionInfo          `json:"version,omitempty"`
+	Preflight      macos.PreflightResult `json:"preflight"`
+	Users          []UserServiceStatus   `json:"users"`
+	NeedsAttention []string              `json:"needs_attention"`
+}
+
+// StatusServerConfig holds the paths the status server reads on every
+// request.
+type StatusServerConfig struct {
+	OutputDir  string
+	ConfigPath string
+	StatePath  string
+}
+
+// CollectHostStatus gathers the service status, deployed version and a
+// read-only preflight check. It changes nothing on the host.
+func CollectHostStatus(ctx context.Context, cfg config.Config, st state.State, outputDir string) HostStatus {
+	hs := HostStatus{
+		MachineID:      cfg.Globals.MachineID,
+		GeneratedAt:    time.Now().UTC().Format(time.RFC3339),
+		Preflight:      macos.CheckPreflight(ctx, cfg.Globals.Preflight.Skip),
+		NeedsAttention: []string{},
+	}
+	hs.Hostname, _ = os.Hostname()
+	if info, err := ReadVersionInfo(outputDir); err == nil {
+		hs.Version = &info
+	}
+
+	for _, c := range hs.Preflight.Checks {
+		if !c.OK {
+			hs.NeedsAttention = append(hs.NeedsAttention, "preflight: "+c.Name+" failed")
+		}
+	}
+
+	statuses, err := CheckUserServices(ctx, cfg, st)
+	if err != nil {
+		hs.NeedsAttention = append(hs.NeedsAttention, "check services: "+err.Error())
+	}
+	hs.Users = statuses
+	if hs.Users == nil {
+		hs.Users = []UserServiceStatus{}
+	}
+	for _, s := range statuses {
+		switch {
+		case !s.Healthy():
+			hs.NeedsAttention = append(hs.NeedsAttention, fmt.Sprintf("user %s: %s", s.Name, s.Detail))
+		case len(s.ManifestMissing) > 0:
+			hs.NeedsAttention = append(hs.NeedsAttention, fmt.Sprintf("user %s: manifest paths missing: %s", s.Name, strings.Join(s.ManifestMissing, ", ")))
+		}
+	}
+	return hs
+}
+
+// statusCacheTTL is how long a collected HostStatus is served again, so
+// frequent polls do not run the preflight and service checks each time.
+const statusCacheTTL = 5 * time.Second
+
+// statusCache holds the last encoded HostStatus. Its mutex is held while
+// collecting, so concurrent requests share one collection.
+type statusCache struct {
+	mu   sync.Mutex
+	data []byte
+	at   time.Time
+}
+
+// RunStatusServer serves HostStatus as JSON on globals.status_listen until ctx
+// is cancelled. It returns at once when status_listen is unset. Config and
+// state are reloaded when the cached status is older than statusCacheTTL; a
+// new listen address needs a restart.
+func RunStatusServer(ctx context.Context, sCfg StatusServerConfig) {
+	cfg, err := config.Load(sCfg.ConfigPath)
+	if err != nil {
+		log.Printf("[status] load config: %v; status endpoint disabled", err)
+		return
+	}
+	addr := cfg.Globals.StatusAddr()
+	if addr == "" {
+		return
+	}
+
+	cache := &statusCache{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" && r.URL.Path != "/status" {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		serveHostStatus(w, r, sCfg, cache)
+	})
+
+	srv := &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 5 * time.Second,
+		WriteTimeout:      time.Minute,
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Printf("[status] listen %s: %v", addr, err)
+		return
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(shutdownCtx)
+	}()
+
+	log.Printf("[status] serving host status on http://%s/status", addr)
+	if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Printf("[status] serve: %v", err)
+	}
+}
+
+// serveHostStatus writes the cached HostStatus, collecting it again when it
+// is stale. Failures are logged; the response only says that one happened,
+// so paths and config details do not leave the host.
+func serveHostStatus(w http.ResponseWriter, r *http.Request, sCfg StatusServerConfig, cache *statusCache) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	if cache.data == nil || time.Since(cache.at) >= statusCacheTTL {
+		data, err := collectHostStatusJSON(r.Context(), sCfg)
+		if err != nil {
+			log.Printf("[status] %v", err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		cache.data, cache.at = data, time.Now()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(cache.data)
+}
+
+// collectHostStatusJSON loads config and state and encodes CollectHostStatus.
+func collectHostStatusJSON(ctx context.Context, sCfg StatusServerConfig) ([]byte, error) {
+	cfg, err := config.Load(sCfg.ConfigPath)
+	if err != nil {
+		return nil, fmt.Errorf("load config: %w", err)
+	}
+	st, err := state.Load(sCfg.StatePath)
+	if err != nil {
+		return nil, fmt.Errorf("load state: %w", err)
+	}
+	data, err := json.MarshalIndent(CollectHostStatus(ctx, cfg, st, sCfg.OutputDir), "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("encode status: %w", err)
+	}
+	return append(data, '\n'), nil
+}
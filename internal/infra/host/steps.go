@@ -0,0 +1,46 @@
+//go:build darwin
+
+package host
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// StepRecorder remembers the last step a provisioning flow started, so a
+// timeout can name the step that got stuck.
+type StepRecorder struct {
+	mu   sync.Mutex
+	last string
+}
+
+type stepRecorderKey struct{}
+
+// WithStepRecorder returns a context whose provisioning steps are recorded in
+// the returned StepRecorder.
+func WithStepRecorder(ctx context.Context) (context.Context, *StepRecorder) {
+	r := &StepRecorder{}
+	return context.WithValue(ctx, stepRecorderKey{}, r), r
+}
+
+// Set records step as the current step.
+func (r *StepRecorder) Set(step string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.last = step
+}
+
+// Last returns the most recently started step, or "" if none was recorded.
+func (r *StepRecorder) Last() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.last
+}
+
+// setStep records the current step on ctx's StepRecorder, if it has one.
+func setStep(ctx context.Context, format string, args ...any) {
+	if r, ok := ctx.Value(stepRecorderKey{}).(*StepRecorder); ok {
+		r.Set(fmt.Sprintf(format, args...))
+	}
+}
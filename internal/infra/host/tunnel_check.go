@@ -0,0 +1,99 @@
+//go:build darwin
+
+package host
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"prism/internal/infra/config"
+	"prism/internal/infra/state"
+)
+
+// tunnelProbeTimeout bounds the public health request of each user.
+const tunnelProbeTimeout = 5 * time.Second
+
+// userPublicURL returns the public URL of u, or "" when it has no subdomain
+// or no domain suffix is configured.
+func userPublicURL(cfg config.Config, u state.User) string {
+	suffix := strings.Trim(strings.TrimSpace(cfg.Globals.DomainSuffix), ".")
+	if u.Subdomain == "" || suffix == "" {
+		return ""
+	}
+	return cfg.Globals.PublicURL(u.Subdomain + "." + suffix)
+}
+
+// ProbeUserTunnels requests the public health endpoint of every status with
+// a URL through the frp tunnel, in parallel, and records the outcome in
+// TunnelChecked, TunnelReachable and Detail. It sends one outbound request per
+// user, so it is meant for an explicit check rather than every refresh.
+func ProbeUserTunnels(ctx context.Context, cfg config.Config, statuses []UserServiceStatus) {
+	urls := make(map[string]string, len(statuses))
+	for _, s := range statuses {
+		if s.URL != "" {
+			urls[s.Name] = s.URL
+		}
+	}
+	errs := probeTunnels(ctx, urls, cfg.Globals.Service.HealthCheckPath())
+	for i := range statuses {
+		s := &statuses[i]
+		if s.URL == "" {
+			continue
+		}
+		s.TunnelChecked = true
+		err := errs[s.Name]
+		if err == nil {
+			s.TunnelReachable = true
+			continue
+		}
+		if s.Detail != "" {
+			s.Detail += "; "
+		}
+		s.Detail += err.Error()
+	}
+}
+
+// probeTunnels requests <url><healthPath> for every user in urls, in
+// parallel, through the public frp tunnel, and returns the error of each user
+// whose request failed or did not answer 2xx.
+func probeTunnels(ctx context.Context, urls map[string]string, healthPath string) map[string]error {
+	client := &http.Client{Timeout: tunnelProbeTimeout}
+	var (
+		mu   sync.Mutex
+		wg   sync.WaitGroup
+		errs = make(map[string]error)
+	)
+	for name, url := range urls {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := probeTunnel(ctx, client, url+healthPath); err != nil {
+				mu.Lock()
+				errs[name] = err
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	return errs
+}
+
+func probeTunnel(ctx context.Context, client *http.Client, url string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("tunnel unreachable: %w", err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("tunnel unreachable: GET %s returned %s", url, resp.Status)
+	}
+	return nil
+}
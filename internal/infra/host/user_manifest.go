@@ -0,0 +1,104 @@
+//go:build darwin
+
+package host
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"prism/internal/infra/config"
+)
+
+const userManifestName = "manifest.json"
+
+// UserManifest records every path Prism created for a user so cleanup and
+// diagnostics do not have to guess.
+type UserManifest struct {
+	Username      string   `json:"username"`
+	BundleVersion string   `json:"bundle_version,omitempty"`
+	UpdatedAt     string   `json:"updated_at"`
+	ServiceDir    string   `json:"service_dir"`
+	LaunchDaemons []string `json:"launch_daemons"`
+	Files         []string `json:"files"`
+	Logs          []string `json:"logs"`
+}
+
+func userManifestPath(username string) string {
+	return filepath.Join(userServiceDir(username, config.PrimaryServiceName), userManifestName)
+}
+
+// ReadUserManifest loads the manifest written during provisioning.
+func ReadUserManifest(username string) (UserManifest, error) {
+	var m UserManifest
+	data, err := os.ReadFile(userManifestPath(username))
+	if err != nil {
+		return m, err
+	}
+	if err := json.Unmarshal(data, &m); err != nil {
+		return m, fmt.Errorf("parse %s: %w", userManifestPath(username), err)
+	}
+	return m, nil
+}
+
+// MissingPaths returns the recorded paths that no longer exist on disk.
+func (m UserManifest) MissingPaths() []string {
+	var missing []string
+	for _, group := range [][]string{m.LaunchDaemons, m.Files, m.Logs} {
+		for _, p := range group {
+			if _, err := os.Stat(p); err != nil {
+				missing = append(missing, p)
+			}
+		}
+	}
+	return missing
+}
+
+func writeUserManifest(ctx context.Context, m UserManifest) error {
+	m.UpdatedAt = time.Now().UTC().Format(time.RFC3339)
+	data, err := json.MarshalIndent(&m, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := userManifestPath(m.Username)
+	if err := writeFileAtomic(path, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("write %s: %w", path, err)
+	}
+	return chownRecursive(ctx, m.Username, path)
+}
+
+// refreshUserManifestVersion updates the bundle version recorded for username.
+// A missing manifest is left alone; it is recreated on the next provisioning.
+func refreshUserManifestVersion(ctx context.Context, username, version string) error {
+	m, err := ReadUserManifest(username)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	m.BundleVersion = version
+	return writeUserManifest(ctx, m)
+}
+
+// removeManifestLaunchDaemons boots out and deletes the LaunchDaemons listed in
+// the user's manifest, covering labels that the naming convention would miss.
+func removeManifestLaunchDaemons(ctx context.Context, username string) {
+	m, err := ReadUserManifest(username)
+	if err != nil {
+		return
+	}
+	for _, plistPath := range m.LaunchDaemons {
+		if filepath.Dir(plistPath) != launchDaemonsDir || !strings.HasSuffix(plistPath, ".plist") {
+			continue
+		}
+		label := strings.TrimSuffix(filepath.Base(plistPath), ".plist")
+		_, _ = runner.Run(ctx, "launchctl", "bootout", "system/"+label)
+		_ = os.Remove(plistPath)
+	}
+}
@@ -0,0 +1,45 @@
+//go:build darwin
+
+package host
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"prism/internal/infra/config"
+	"prism/internal/infra/state"
+)
+
+func TestCheckUserServicesReportsManifestMissing(t *testing.T) {
+	cfg, _ := testHost(t)
+	newFakeRunner(t, "mac-1")
+	serviceDir := userServiceDir("mac-1", config.PrimaryServiceName)
+	if err := os.MkdirAll(serviceDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	kept := filepath.Join(serviceDir, "config.json")
+	if err := os.WriteFile(kept, []byte("{}"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	gone := filepath.Join(serviceDir, "frpc.toml")
+	m := UserManifest{Username: "mac-1", ServiceDir: serviceDir, Files: []string{kept, gone}}
+	if err := writeUserManifest(context.Background(), m); err != nil {
+		t.Fatal(err)
+	}
+
+	st := state.State{Users: []state.User{{Name: "mac-1", Port: 20000}}}
+	statuses, err := CheckUserServices(context.Background(), cfg, st)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := statuses[0]
+	if strings.Join(got.ManifestMissing, " ") != gone {
+		t.Errorf("ManifestMissing = %v, want [%s]", got.ManifestMissing, gone)
+	}
+	if !strings.Contains(got.Detail, "manifest: missing "+gone) {
+		t.Errorf("Detail = %q, want the missing manifest path", got.Detail)
+	}
+}
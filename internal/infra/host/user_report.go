@@ -0,0 +1,114 @@
+//go:build darwin
+
+package host
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	toml "github.com/pelletier/go-toml"
+
+	"prism/internal/infra/config"
+	"prism/internal/infra/state"
+)
+
+// UserReportRow is one line of the public URL report for a Prism user.
+type UserReportRow struct {
+	Username     string `json:"username"`
+	Port         int    `json:"port"`
+	Subdomain    string `json:"subdomain"`
+	FullDomain   string `json:"full_domain"`
+	URL          string `json:"url"`
+	FriendlyName string `json:"friendly_name"`
+}
+
+// BuildUserReport reads each user's config.json and frpc.toml. Users whose
+// files are missing or unreadable are still reported, with blank fields. URLs
+// use globals.domain_scheme from cfg.
+func BuildUserReport(cfg config.Config, st state.State) []UserReportRow {
+	rows := make([]UserReportRow, 0, len(st.Users))
+	for _, u := range st.Users {
+		row := UserReportRow{
+			Username:  u.Name,
+			Port:      u.Port,
+			Subdomain: u.Subdomain,
+		}
+
+		serviceDir := userServiceDir(u.Name, config.PrimaryServiceName)
+		frpcPath := filepath.Join(serviceDir, "frpc.toml")
+
+		var ucfg struct {
+			LocalPort  int    `json:"local_port"`
+			Subdomain  string `json:"subdomain"`
+			FullDomain string `json:"full_domain"`
+			FRPCConfig string `json:"frpc_config"`
+		}
+		if data, err := os.ReadFile(filepath.Join(serviceDir, "config.json")); err == nil && json.Unmarshal(data, &ucfg) == nil {
+			if ucfg.LocalPort > 0 {
+				row.Port = ucfg.LocalPort
+			}
+			if s := strings.TrimSpace(ucfg.Subdomain); s != "" {
+				row.Subdomain = s
+			}
+			row.FullDomain = strings.TrimSpace(ucfg.FullDomain)
+			row.URL = cfg.Globals.PublicURL(row.FullDomain)
+			if p := strings.TrimSpace(ucfg.FRPCConfig); p != "" {
+				frpcPath = p
+			}
+		}
+
+		row.FriendlyName = readFriendlyName(frpcPath)
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+// readFriendlyName returns the first non-empty proxy friendlyName in an frpc
+// config, or "" if there is none.
+func readFriendlyName(path string) string {
+	tree, err := toml.LoadFile(path)
+	if err != nil {
+		return ""
+	}
+	proxies, ok := tree.Get("proxies").([]*toml.Tree)
+	if !ok {
+		return ""
+	}
+	for _, proxy := range proxies {
+		if proxy == nil {
+			continue
+		}
+		meta, ok := proxy.Get("metadatas").(*toml.Tree)
+		if !ok {
+			continue
+		}
+		if val, ok := meta.Get("friendlyName").(string); ok && strings.TrimSpace(val) != "" {
+			return strings.TrimSpace(val)
+		}
+	}
+	return ""
+}
+
+// WriteUserReportCSV writes rows as CSV with a header line.
+func WriteUserReportCSV(w io.Writer, rows []UserReportRow) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write([]string{"username", "port", "subdomain", "full_domain", "url", "friendly_name"}); err != nil {
+		return err
+	}
+	for _, r := range rows {
+		port := ""
+		if r.Port > 0 {
+			port = strconv.Itoa(r.Port)
+		}
+		if err := cw.Write([]string{r.Username, port, r.Subdomain, r.FullDomain, r.URL, r.FriendlyName}); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,354 @@
+//go:build darwin
+
+package host
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"prism/internal/infra/config"
+	"prism/internal/infra/state"
+)
+
+// newAccount is a Prism account about to be created.
+type newAccount struct {
+	username string
+	password string
+	opts     systemUserOptions
+}
+
+// createAccounts creates the accounts and records their passwords. When
+// globals.service.batch_create_min is reached they are imported with one
+// dsimport run; accounts the import did not create fall back to sysadminctl.
+// The time taken is logged so both paths can be compared on a host.
+func createAccounts(ctx context.Context, cfg config.Config, accounts []newAccount, secrets *ProvisionSecrets) error {
+	start := time.Now()
+	created := map[string]bool{}
+	if min := cfg.Globals.Service.BatchCreateMin; min > 0 && len(accounts) >= min {
+		setStep(ctx, "import %d users with dsimport", len(accounts))
+		var err error
+		created, err = importSystemUsers(ctx, accounts)
+		if created == nil {
+			created = map[string]bool{}
+		}
+		if err != nil {
+			fmt.Printf("[provision] warning: dsimport failed after creating %d of %d users, creating the rest one by one: %v\n",
+				len(created), len(accounts), err)
+		} else {
+			fmt.Printf("[provision] imported %d of %d users with dsimport in %s\n",
+				len(created), len(accounts), time.Since(start).Round(time.Millisecond))
+		}
+	}
+
+	for _, a := range accounts {
+		setStep(ctx, "create user %s", a.username)
+		if created[a.username] {
+			if err := finishImportedUser(ctx, a); err != nil {
+				return err
+			}
+		} else if err := createSystemUser(ctx, a.username, a.password, a.opts); err != nil {
+			return err
+		}
+		if err := secrets.record(a.username, a.password); err != nil {
+			return err
+		}
+	}
+	fmt.Printf("[provision] created %d users in %s\n", len(accounts), time.Since(start).Round(time.Millisecond))
+	return nil
+}
+
+type stateSaverKey struct{}
+
+// WithStateSaver returns a context that makes ProvisionUsers and AddUsers
+// pass the state to fn after each user is fully provisioned, so it can be
+// saved before the run completes. A failing fn is logged and does not stop
+// provisioning.
+func WithStateSaver(ctx context.Context, fn func(state.State) error) context.Context {
+	if fn == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, stateSaverKey{}, fn)
+}
+
+func stateSaverFunc(ctx context.Context) func(state.State) error {
+	fn, _ := ctx.Value(stateSaverKey{}).(func(state.State) error)
+	return fn
+}
+
+// saveProgress passes st to the WithStateSaver callback, if any.
+func saveProgress(ctx context.Context, st state.State) {
+	save := stateSaverFunc(ctx)
+	if save == nil {
+		return
+	}
+	if err := save(st); err != nil {
+		fmt.Printf("[provision] warning: failed to save state: %v\n", err)
+	}
+}
+
+// createPendingAccounts records accounts in st.PendingUsers and saves st
+// before creating them, so a crash leaves their names for
+// reclaimPendingUsers. When creating fails, the accounts are rolled back and
+// those that could not be removed stay pending.
+func createPendingAccounts(ctx context.Context, cfg config.Config, st state.State, accounts []newAccount, secrets *ProvisionSecrets) (state.State, error) {
+	st.PendingUsers = make([]string, 0, len(accounts))
+	for _, a := range accounts {
+		st.PendingUsers = append(st.PendingUsers, a.username)
+	}
+	saveProgress(ctx, st)
+	if err := createAccounts(ctx, cfg, accounts, secrets); err != nil {
+		st.PendingUsers = rollbackAccounts(ctx, accounts, secrets)
+		saveProgress(ctx, st)
+		return st, err
+	}
+	return st, nil
+}
+
+// reclaimPendingUsers deletes the accounts in st.PendingUsers that are not
+// in st.Users: an earlier run created them and crashed before finishing
+// them. The returned st keeps only the names that could not be deleted.
+func reclaimPendingUsers(ctx context.Context, st state.State) state.State {
+	if len(st.PendingUsers) == 0 {
+		return st
+	}
+	inState := make(map[string]bool, len(st.Users))
+	for _, u := range st.Users {
+		inState[u.Name] = true
+	}
+	var accounts []newAccount
+	for _, name := range st.PendingUsers {
+		if !inState[name] {
+			accounts = append(accounts, newAccount{username: name})
+		}
+	}
+	var secrets ProvisionSecrets
+	st.PendingUsers = rollbackAccounts(ctx, accounts, &secrets)
+	saveProgress(ctx, st)
+	return st
+}
+
+// prepareNewUsers writes the service files of the created accounts,
+// bootstraps their LaunchDaemons and appends them to st, assigning ports from
+// firstPort on. Bootstraps are spaced by
+// globals.service.bootstrap_stagger_seconds so the servers do not all
+// cold-start at once. Each finished user is passed to the WithStateSaver
+// callback. When an account fails, it and the accounts after it are rolled back and st
+// is returned with the users finished so far.
+func prepareNewUsers(ctx context.Context, cfg config.Config, st state.State, accounts []newAccount, firstPort int, assets provisionAssets, secrets *ProvisionSecrets) (state.State, error) {
+	stagger := cfg.Globals.Service.BootstrapStagger()
+	fail := func(i int, err error) (state.State, error) {
+		st.PendingUsers = rollbackAccounts(ctx, accounts[i:], secrets)
+		saveProgress(ctx, st)
+		if i > 0 && st.SetupPending > 0 {
+			return st, fmt.Errorf("%w; kept the %d users that finished, run Setup again to create the rest", err, i)
+		}
+		if i > 0 {
+			return st, fmt.Errorf("%w; kept the %d users that finished, run Add users to create the rest", err, i)
+		}
+		return st, err
+	}
+	for i, a := range accounts {
+		setStep(ctx, "prepare services for %s", a.username)
+		u, err := ensurePerUserFiles(ctx, cfg, a.username, firstPort+i, assets)
+		if err != nil {
+			return fail(i, err)
+		}
+
+		if i > 0 && stagger > 0 {
+			setStep(ctx, "wait %s before bootstrapping %s", stagger, a.username)
+			select {
+			case <-ctx.Done():
+				return fail(i, ctx.Err())
+			case <-time.After(stagger):
+			}
+		}
+
+		setStep(ctx, "bootstrap LaunchDaemons for %s", a.username)
+		st.Users = append(st.Users, bootstrapNewUser(ctx, u))
+		st.Initialized = true
+		st.PendingUsers = slices.DeleteFunc(slices.Clone(st.PendingUsers), func(name string) bool { return name == a.username })
+		saveProgress(ctx, st)
+	}
+	return st, nil
+}
+
+// rollbackAccounts deletes the accounts of a failed provisioning run that
+// exist, with their home directories and LaunchDaemons, so a re-run can
+// create them again. Their passwords are dropped from secrets.Passwords; a
+// secrets CSV keeps the old lines, and the line written on re-creation wins.
+// It runs even when ctx is cancelled, e.g. by the provisioning deadline, and
+// returns the accounts that may still exist.
+func rollbackAccounts(ctx context.Context, accounts []newAccount, secrets *ProvisionSecrets) []string {
+	ctx = context.WithoutCancel(ctx)
+	removed := make(map[string]bool, len(accounts))
+	var left []string
+	for _, a := range accounts {
+		exists, err := systemUserExists(ctx, a.username)
+		if err != nil {
+			left = append(left, a.username)
+			continue
+		}
+		if !exists {
+			continue
+		}
+		if err := deleteSystemUser(ctx, a.username); err != nil {
+			fmt.Printf("[provision] warning: failed to roll back unfinished user %s: %v\n", a.username, err)
+			left = append(left, a.username)
+			continue
+		}
+		removed[a.username] = true
+		fmt.Printf("[provision] rolled back unfinished user %s\n", a.username)
+	}
+
+	kept := secrets.Passwords[:0]
+	for _, p := range secrets.Passwords {
+		if !removed[p.Username] {
+			kept = append(kept, p)
+		}
+	}
+	secrets.Passwords = kept
+	return left
+}
+
+// importSystemUsers creates accounts with a single dsimport run and returns
+// the ones that now exist, together with the dsimport error if it failed.
+// The import file holds the passwords, so it lives in a private temp
+// directory that is removed afterwards.
+func importSystemUsers(ctx context.Context, accounts []newAccount) (map[string]bool, error) {
+	uids, err := assignUIDs(ctx, accounts)
+	if err != nil {
+		return nil, err
+	}
+
+	dir, err := os.MkdirTemp("", "prism-dsimport-")
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	importPath := filepath.Join(dir, "users.txt")
+	if err := os.WriteFile(importPath, []byte(dsimportFile(accounts, uids)), 0o600); err != nil {
+		return nil, fmt.Errorf("write import file: %w", err)
+	}
+
+	// I skips records that already exist instead of modifying them.
+	out, err := runner.Run(ctx, "dsimport", importPath, "/Local/Default", "I")
+	if err != nil {
+		err = fmt.Errorf("dsimport: %w (output=%s)", err, strings.TrimSpace(string(out)))
+	}
+
+	// A failing dsimport may still have created some of the accounts; they
+	// must not be created again with sysadminctl.
+	created := map[string]bool{}
+	for _, a := range accounts {
+		if exists, existsErr := systemUserExists(ctx, a.username); existsErr == nil && exists {
+			created[a.username] = true
+		}
+	}
+	return created, err
+}
+
+// finishImportedUser creates the home directory of an imported account, which
+// dsimport does not do, and applies its attributes.
+func finishImportedUser(ctx context.Context, a newAccount) error {
+	if out, err := runner.Run(ctx, "createhomedir", "-c", "-u", a.username); err != nil {
+		return fmt.Errorf("%w: createhomedir %s: %v (output=%s)", ErrHomeDirFailed, a.username, err, strings.TrimSpace(string(out)))
+	}
+	return finishSystemUser(ctx, a.username, a.opts)
+}
+
+// assignUIDs returns the UID of each account: opts.UID when set, otherwise
+// the next free UID above every existing user account. System UIDs of 60000
+// and above do not move the next UID. An opts.UID that an existing account or
+// another account in the batch already has is an error, since dsimport would
+// create a duplicate.
+func assignUIDs(ctx context.Context, accounts []newAccount) ([]int, error) {
+	out, err := runner.Run(ctx, "dscl", ".", "-list", "/Users", "UniqueID")
+	if err != nil {
+		return nil, fmt.Errorf("dscl list UniqueID: %w (output=%s)", err, strings.TrimSpace(string(out)))
+	}
+	taken := map[int]bool{}
+	next := 501
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		uid, err := strconv.Atoi(fields[len(fields)-1])
+		if err != nil {
+			continue
+		}
+		taken[uid] = true
+		if uid >= next && uid < 60000 {
+			next = uid + 1
+		}
+	}
+	explicit := map[int]string{}
+	for _, a := range accounts {
+		if a.opts.UID <= 0 {
+			continue
+		}
+		if taken[a.opts.UID] {
+			return nil, fmt.Errorf("UID %d of %s is already used by an existing account", a.opts.UID, a.username)
+		}
+		if other, ok := explicit[a.opts.UID]; ok {
+			return nil, fmt.Errorf("UID %d is requested for both %s and %s", a.opts.UID, other, a.username)
+		}
+		explicit[a.opts.UID] = a.username
+	}
+	for uid := range explicit {
+		taken[uid] = true
+	}
+
+	uids := make([]int, len(accounts))
+	for i, a := range accounts {
+		if a.opts.UID > 0 {
+			uids[i] = a.opts.UID
+			continue
+		}
+		for taken[next] {
+			next++
+		}
+		uids[i] = next
+		taken[next] = true
+	}
+	return uids, nil
+}
+
+// dsimportFile renders a dsimport(1) user import file. The header declares
+// newline, backslash, colon and comma as the record, escape, field and value
+// separators.
+func dsimportFile(accounts []newAccount, uids []int) string {
+	var b strings.Builder
+	b.WriteString("0x0A 0x5C 0x3A 0x2C dsRecTypeStandard:Users 7 " +
+		"dsAttrTypeStandard:RecordName dsAttrTypeStandard:Password dsAttrTypeStandard:UniqueID " +
+		"dsAttrTypeStandard:PrimaryGroupID dsAttrTypeStandard:RealName " +
+		"dsAttrTypeStandard:NFSHomeDirectory dsAttrTypeStandard:UserShell\n")
+	for i, a := range accounts {
+		fields := []string{
+			a.username,
+			a.password,
+			strconv.Itoa(uids[i]),
+			"20", // staff
+			a.username,
+			filepath.Join(usersDir, a.username),
+			"/bin/zsh",
+		}
+		for j, f := range fields {
+			fields[j] = dsimportEscape(f)
+		}
+		b.WriteString(strings.Join(fields, ":") + "\n")
+	}
+	return b.String()
+}
+
+// dsimportEscape escapes the separator characters declared by dsimportFile.
+func dsimportEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, ":", `\:`, ",", `\,`, "\n", `\`+"\n").Replace(s)
+}
@@ -0,0 +1,105 @@
+//go:build darwin
+
+package host
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// BenchmarkCreateAccounts compares creating users one by one with sysadminctl
+// against a single dsimport run. The fake runner makes every command free, so
+// the result is Prism's own overhead plus the number of commands each path
+// runs (cmds/op), which dominates on a real host.
+func BenchmarkCreateAccounts(b *testing.B) {
+	for _, tt := range []struct {
+		name     string
+		batchMin int
+	}{
+		{"sysadminctl", 0},
+		{"dsimport", 1},
+	} {
+		for _, n := range []int{10, 50} {
+			b.Run(fmt.Sprintf("%s/%d", tt.name, n), func(b *testing.B) {
+				cfg, _ := testHost(b)
+				cfg.Globals.Service.BatchCreateMin = tt.batchMin
+				fake := newFakeRunner(b)
+				accounts := make([]newAccount, n)
+				for i := range accounts {
+					accounts[i] = newAccount{username: fmt.Sprintf("mac-%d", i+1), password: "pw"}
+				}
+
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					var secrets ProvisionSecrets
+					if err := createAccounts(context.Background(), cfg, accounts, &secrets); err != nil {
+						b.Fatal(err)
+					}
+				}
+				b.StopTimer()
+				b.ReportMetric(float64(len(fake.calls))/float64(b.N), "cmds/op")
+			})
+		}
+	}
+}
+
+func TestAssignUIDs(t *testing.T) {
+	const existing = "root 0\nadmin 501\nmac-1 502\nnobody -2\n_spotlight 89\nhighuser 60010\n"
+	account := func(name string, uid int) newAccount {
+		return newAccount{username: name, opts: systemUserOptions{UID: uid}}
+	}
+	tests := []struct {
+		name     string
+		accounts []newAccount
+		want     []int
+		wantErr  string
+	}{
+		{
+			name:     "after existing users",
+			accounts: []newAccount{account("mac-2", 0), account("mac-3", 0)},
+			want:     []int{503, 504},
+		},
+		{
+			name:     "skips explicit UIDs",
+			accounts: []newAccount{account("mac-2", 504), account("mac-3", 0), account("mac-4", 0)},
+			want:     []int{504, 503, 505},
+		},
+		{
+			name:     "explicit UID above system range",
+			accounts: []newAccount{account("mac-2", 0), account("mac-3", 60020)},
+			want:     []int{503, 60020},
+		},
+		{
+			name:     "explicit UID of existing account",
+			accounts: []newAccount{account("mac-2", 502)},
+			wantErr:  "UID 502 of mac-2 is already used",
+		},
+		{
+			name:     "explicit UID twice",
+			accounts: []newAccount{account("mac-2", 700), account("mac-3", 700)},
+			wantErr:  "UID 700 is requested for both mac-2 and mac-3",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := newFakeRunner(t)
+			fake.output["dscl . -list /Users UniqueID"] = existing
+
+			got, err := assignUIDs(context.Background(), tt.accounts)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("assignUIDs() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("assignUIDs() error = %v", err)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("assignUIDs() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -3,34 +3,47 @@
 package host
 
 import (
+	"bytes"
 	"context"
 	"crypto/rand"
+	"encoding/csv"
 	"errors"
 	"fmt"
+	"io"
 	"math/big"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"prism/internal/infra/config"
+	"prism/internal/infra/paths"
 )
 
+const loginWindowPrefs = "/Library/Preferences/com.apple.loginwindow"
+
+const secretsHeader = "username,password\n"
+
 func ensureSecretsFile(outputDir string) (string, error) {
-	secretsDir := filepath.Join(outputDir, "secrets")
-	if err := os.MkdirAll(secretsDir, 0o700); err != nil {
+	secretsFile := paths.SecretsPathIn(outputDir)
+	if err := os.MkdirAll(filepath.Dir(secretsFile), 0o700); err != nil {
 		return "", err
 	}
-	secretsFile := filepath.Join(secretsDir, "users.csv")
 	if _, err := os.Stat(secretsFile); err != nil {
 		if !errors.Is(err, os.ErrNotExist) {
 			return "", err
 		}
-		if err := os.WriteFile(secretsFile, []byte("username,password\n"), 0o600); err != nil {
+		if err := os.WriteFile(secretsFile, []byte(secretsHeader), 0o600); err != nil {
 			return "", err
 		}
 	} else {
 		fi, err := os.Stat(secretsFile)
 		if err == nil && fi.Size() == 0 {
-			if err := os.WriteFile(secretsFile, []byte("username,password\n"), 0o600); err != nil {
+			if err := os.WriteFile(secretsFile, []byte(secretsHeader), 0o600); err != nil {
 				return "", err
 			}
 		}
@@ -41,18 +54,126 @@ func ensureSecretsFile(outputDir string) (string, error) {
 	return secretsFile, nil
 }
 
+// UserPassword is the password of a newly created user.
+type UserPassword struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// ProvisionSecrets tells where the passwords of newly created users went.
+// Path is the secrets CSV; it is empty when globals.service.store_secrets is
+// false, and Passwords then holds them for one-time display.
+type ProvisionSecrets struct {
+	Path      string
+	Passwords []UserPassword
+}
+
+// newProvisionSecrets prepares the secrets CSV unless storing passwords is
+// disabled in cfg.
+func newProvisionSecrets(cfg config.Config, outputDir string) (ProvisionSecrets, error) {
+	if !cfg.Globals.Service.StoresSecrets() {
+		return ProvisionSecrets{}, nil
+	}
+	path, err := ensureSecretsFile(outputDir)
+	if err != nil {
+		return ProvisionSecrets{}, fmt.Errorf("ensure secrets file: %w", err)
+	}
+	return ProvisionSecrets{Path: path}, nil
+}
+
+// record saves the password of a new user to the secrets CSV, or keeps it in
+// memory when passwords are not stored.
+func (s *ProvisionSecrets) record(username, password string) error {
+	if s.Path == "" {
+		s.Passwords = append(s.Passwords, UserPassword{Username: username, Password: password})
+		return nil
+	}
+	if err := appendPassword(s.Path, username, password); err != nil {
+		return fmt.Errorf("save password for %s: %w", username, err)
+	}
+	return nil
+}
+
+// secretsMu serializes appends to the secrets CSV within this process; flock
+// on the file serializes them across Prism processes.
+var secretsMu sync.Mutex
+
+// appendPassword appends a username,password record to the secrets CSV. The
+// record is written with a single write while holding an exclusive lock. If
+// the file has lost its header, it is replaced atomically by one with the
+// header, the old records and the new one, so a crash cannot lose records.
 func appendPassword(secretsFile, username, password string) error {
-	f, err := os.OpenFile(secretsFile, os.O_APPEND|os.O_WRONLY, 0o600)
+	secretsMu.Lock()
+	defer secretsMu.Unlock()
+
+	f, err := lockSecretsFile(secretsFile)
 	if err != nil {
 		return err
 	}
+	// Closing the file releases the lock.
 	defer func() { _ = f.Close() }()
-	if _, err := fmt.Fprintf(f, "%s,%s\n", username, password); err != nil {
+
+	existing, err := io.ReadAll(f)
+	if err != nil {
+		return fmt.Errorf("read %s: %w", secretsFile, err)
+	}
+
+	var rec bytes.Buffer
+	if len(existing) > 0 && existing[len(existing)-1] != '\n' {
+		// Terminate a record left partial by an interrupted write.
+		rec.WriteByte('\n')
+	}
+	w := csv.NewWriter(&rec)
+	if err := w.Write([]string{username, password}); err != nil {
+		return err
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
 		return err
 	}
+
+	if !bytes.HasPrefix(existing, []byte(secretsHeader)) {
+		data := append([]byte(secretsHeader), existing...)
+		if err := writeFileAtomic(secretsFile, append(data, rec.Bytes()...), 0o600); err != nil {
+			return fmt.Errorf("restore header of %s: %w", secretsFile, err)
+		}
+		return nil
+	}
+	if _, err := f.Write(rec.Bytes()); err != nil {
+		return fmt.Errorf("write %s: %w", secretsFile, err)
+	}
 	return nil
 }
 
+// lockSecretsFile opens the secrets CSV for appending and takes an exclusive
+// flock on it. A file replaced by another process while this one waited for
+// the lock is opened again, so no record goes to the replaced copy.
+func lockSecretsFile(path string) (*os.File, error) {
+	for {
+		f, err := os.OpenFile(path, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0o600)
+		if err != nil {
+			return nil, err
+		}
+		if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+			_ = f.Close()
+			return nil, fmt.Errorf("lock %s: %w", path, err)
+		}
+		locked, err := f.Stat()
+		if err != nil {
+			_ = f.Close()
+			return nil, err
+		}
+		current, err := os.Stat(path)
+		if err == nil && os.SameFile(locked, current) {
+			return f, nil
+		}
+		_ = f.Close()
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+	}
+}
+
 func generatePassword(defaultPassword string) (string, error) {
 	if defaultPassword != "" {
 		return defaultPassword, nil
@@ -79,8 +200,7 @@ func generatePassword(defaultPassword string) (string, error) {
 }
 
 func systemUserExists(ctx context.Context, username string) (bool, error) {
-	cmd := exec.CommandContext(ctx, "id", "-u", username)
-	if err := cmd.Run(); err != nil {
+	if _, err := runner.Run(ctx, "id", "-u", username); err != nil {
 		var exitErr *exec.ExitError
 		if errors.As(err, &exitErr) {
 			return false, nil
@@ -90,17 +210,310 @@ func systemUserExists(ctx context.Context, username string) (bool, error) {
 	return true, nil
 }
 
-func createSystemUser(ctx context.Context, username, password string) error {
-	homeDir := filepath.Join("/Users", username)
-	cmd := exec.CommandContext(ctx, "sysadminctl",
+// systemUserOptions controls account attributes applied when creating a
+// Prism-managed macOS user.
+type systemUserOptions struct {
+	// Hidden hides the account from the login window user picker.
+	Hidden bool
+	// UID is passed to sysadminctl when positive; zero lets it auto-assign.
+	UID int
+	// PasswordNeverExpires clears password expiration and forced change for
+	// the account.
+	PasswordNeverExpires bool
+}
+
+// systemUserOptionsFor builds the account options for the user at the given
+// 1-based index. When globals.service.base_uid is set, the UID is derived from
+// it and verified to be free.
+func systemUserOptionsFor(ctx context.Context, cfg config.Config, index int) (systemUserOptions, error) {
+	opts := systemUserOptions{
+		Hidden:               cfg.Globals.Service.HiddenUsers,
+		PasswordNeverExpires: cfg.Globals.Service.PasswordNeverExpires,
+	}
+
+	if base := cfg.Globals.Service.BaseUID; base > 0 {
+		uid := base + index - 1
+		taken, err := uidInUse(ctx, uid)
+		if err != nil {
+			return opts, fmt.Errorf("check UID %d: %w", uid, err)
+		}
+		if taken {
+			return opts, fmt.Errorf("UID %d is already in use; adjust globals.service.base_uid", uid)
+		}
+		opts.UID = uid
+	}
+
+	return opts, nil
+}
+
+// uidInUse reports whether any local directory-service account owns uid.
+func uidInUse(ctx context.Context, uid int) (bool, error) {
+	out, err := runner.Run(ctx, "dscl", ".", "-list", "/Users", "UniqueID")
+	if err != nil {
+		return false, fmt.Errorf("dscl list UniqueID: %w (output=%s)", err, strings.TrimSpace(string(out)))
+	}
+	want := strconv.Itoa(uid)
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) >= 2 && fields[len(fields)-1] == want {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// createUserAttempts bounds sysadminctl -addUser retries on transient
+// directory service errors, which are common right after boot.
+const createUserAttempts = 3
+
+func createSystemUser(ctx context.Context, username, password string, opts systemUserOptions) error {
+	homeDir := filepath.Join(usersDir, username)
+	args := []string{
 		"-addUser", username,
 		"-fullName", username,
 		"-password", password,
 		"-home", homeDir,
+	}
+	if opts.UID > 0 {
+		args = append(args, "-UID", strconv.Itoa(opts.UID))
+	}
+
+	backoff := time.Second
+	for attempt := 1; ; attempt++ {
+		output, err := runner.Run(ctx, "sysadminctl", args...)
+		if err == nil {
+			break
+		}
+		out := strings.TrimSpace(string(output))
+		if kind := classifySysadminctlOutput(out); kind != nil {
+			return &SysadminctlError{Op: "create", Username: username, Kind: kind, Output: out, Err: err}
+		}
+		if !isTransientDSOutput(out) || attempt == createUserAttempts {
+			return &SysadminctlError{Op: "create", Username: username, Output: out,
+				Err: fmt.Errorf("failed after %d attempt(s): %w", attempt, err)}
+		}
+
+		fmt.Printf("[provision] creating user %s hit a directory service error (attempt %d/%d); retrying in %s\n",
+			username, attempt, createUserAttempts, backoff)
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("create user %s: %w", username, ctx.Err())
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+	return finishSystemUser(ctx, username, opts)
+}
+
+// finishSystemUser applies the account attributes of opts that the creating
+// tool does not set.
+func finishSystemUser(ctx context.Context, username string, opts systemUserOptions) error {
+	// Prism users must never be administrators, regardless of how the
+	// directory service defaults new accounts.
+	if err := ensureNonAdmin(ctx, username); err != nil {
+		return err
+	}
+
+	if opts.Hidden {
+		if err := hideSystemUser(ctx, username); err != nil {
+			return err
+		}
+	}
+
+	if opts.PasswordNeverExpires {
+		// The account works without it, so a failure is only reported.
+		if err := disablePasswordExpiry(ctx, username); err != nil {
+			fmt.Printf("[provision] warning: failed to disable password expiry for %s: %v\n", username, err)
+		}
+	}
+	return nil
+}
+
+// disablePasswordExpiry clears the account's own password policies and turns
+// off expiration and forced password change for it. Host-wide policies still
+// apply.
+func disablePasswordExpiry(ctx context.Context, username string) error {
+	for _, args := range [][]string{
+		{"-u", username, "-clearaccountpolicies"},
+		{"-u", username, "-setpolicy", "newPasswordRequired=0 maxMinutesUntilChangePassword=0"},
+	} {
+		out, err := runner.Run(ctx, "pwpolicy", args...)
+		if err != nil {
+			return fmt.Errorf("pwpolicy %s: %w (output=%s)", strings.Join(args[2:], " "), err, strings.TrimSpace(string(out)))
+		}
+	}
+	return nil
+}
+
+// classifySysadminctlOutput maps sysadminctl output to ErrPermissionDenied,
+// ErrUserExists, ErrPasswordPolicy or ErrHomeDirFailed, or returns nil when
+// the failure is not one of these. The wording differs between macOS
+// releases, so several phrasings are matched.
+func classifySysadminctlOutput(out string) error {
+	lower := strings.ToLower(out)
+	containsAny := func(subs ...string) bool {
+		for _, s := range subs {
+			if strings.Contains(lower, s) {
+				return true
+			}
+		}
+		return false
+	}
+	switch {
+	case isPermissionOutput(out) || containsAny("edspermissionerror", "edsnotauthorized"):
+		return ErrPermissionDenied
+	case isUserExistsOutput(out) || containsAny("already in use", "name is taken"):
+		return ErrUserExists
+	case containsAny("password policy", "passwordpolicy", "pwpolicy", "does not meet", "passwordqualitycheckfailed", "password is too"):
+		return ErrPasswordPolicy
+	// sysadminctl also logs "Creating home directory" while it works, so
+	// only explicit failures count.
+	case containsAny("createhomedir failed", "failed to create home", "could not create home", "unable to create home", "home directory could not"):
+		return ErrHomeDirFailed
+	}
+	return nil
+}
+
+// isUserExistsOutput reports whether sysadminctl refused because the account
+// already exists, which retrying cannot fix.
+func isUserExistsOutput(out string) bool {
+	lower := strings.ToLower(out)
+	return strings.Contains(lower, "already exists") || strings.Contains(lower, "edsrecordalreadyexists")
+}
+
+// isTransientDSOutput reports whether sysadminctl failed with a directory
+// service error that usually succeeds on retry.
+func isTransientDSOutput(out string) bool {
+	lower := strings.ToLower(out)
+	// eDS* are the DirectoryService error codes, e.g. eDSOperationFailed.
+	return strings.Contains(lower, "directory service") || strings.Contains(lower, "opendirectory") || strings.Contains(out, "eDS")
+}
+
+// deleteSystemUser unloads the user's LaunchDaemons, deletes the macOS account
+// and its home directory, and drops it from the login window HiddenUsersList.
+func deleteSystemUser(ctx context.Context, username string) error {
+	homeDir := filepath.Join(usersDir, username)
+
+	// Remove LaunchDaemons first (bootout and delete plist files), preferring
+	// the paths recorded in the user's manifest
+	removeManifestLaunchDaemons(ctx, username)
+	_ = RemoveUserLaunchDaemons(ctx, username)
+
+	output, err := runner.Run(ctx, "sysadminctl",
+		"-deleteUser", username,
+		"-home", homeDir,
 	)
-	output, err := cmd.CombinedOutput()
 	if err != nil {
-		return fmt.Errorf("create user %s: %w (output=%s)", username, err, strings.TrimSpace(string(output)))
+		out := strings.TrimSpace(string(output))
+		return &SysadminctlError{Op: "delete", Username: username, Kind: classifySysadminctlOutput(out), Output: out, Err: err}
+	}
+
+	_ = os.RemoveAll(homeDir)
+
+	if err := unhideSystemUser(ctx, username); err != nil {
+		// The account is already gone; a stale HiddenUsersList entry is harmless.
+		fmt.Printf("[remove-user] warning: failed to clean up HiddenUsersList for %s: %v\n", username, err)
+	}
+	return nil
+}
+
+// deprovisionSystemUser stops and removes a user's LaunchDaemons and keepalive
+// LaunchAgent but keeps the account and its home directory, so data such as
+// the Messages database stays available for investigation.
+func deprovisionSystemUser(ctx context.Context, username string) {
+	removeManifestLaunchDaemons(ctx, username)
+	_ = RemoveUserLaunchDaemons(ctx, username)
+	removeKeepaliveService(ctx, username)
+}
+
+// ensureNonAdmin removes the user from the admin group if it is a member.
+func ensureNonAdmin(ctx context.Context, username string) error {
+	// checkmember exits 0 only when the user is a member of the group.
+	if _, err := runner.Run(ctx, "dseditgroup", "-o", "checkmember", "-m", username, "admin"); err != nil {
+		return nil
+	}
+	out, err := runner.Run(ctx, "dseditgroup", "-o", "edit", "-d", username, "-t", "user", "admin")
+	if err != nil {
+		return fmt.Errorf("remove %s from admin group: %w (output=%s)", username, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// hideSystemUser marks the account hidden in the directory service and adds it
+// to the login window HiddenUsersList.
+func hideSystemUser(ctx context.Context, username string) error {
+	out, err := runner.Run(ctx, "dscl", ".", "-create", "/Users/"+username, "IsHidden", "1")
+	if err != nil {
+		return fmt.Errorf("hide user %s: %w (output=%s)", username, err, strings.TrimSpace(string(out)))
+	}
+
+	hidden, err := readHiddenUsersList(ctx)
+	if err != nil {
+		return fmt.Errorf("read HiddenUsersList: %w", err)
+	}
+	for _, u := range hidden {
+		if u == username {
+			return nil
+		}
+	}
+	out, err = runner.Run(ctx, "defaults", "write", loginWindowPrefs, "HiddenUsersList", "-array-add", username)
+	if err != nil {
+		return fmt.Errorf("add %s to HiddenUsersList: %w (output=%s)", username, err, strings.TrimSpace(string(out)))
 	}
 	return nil
 }
+
+// unhideSystemUser removes the user from the login window HiddenUsersList.
+// The IsHidden attribute goes away with the account record itself.
+func unhideSystemUser(ctx context.Context, username string) error {
+	hidden, err := readHiddenUsersList(ctx)
+	if err != nil {
+		return fmt.Errorf("read HiddenUsersList: %w", err)
+	}
+
+	kept := make([]string, 0, len(hidden))
+	for _, u := range hidden {
+		if u != username {
+			kept = append(kept, u)
+		}
+	}
+	if len(kept) == len(hidden) {
+		return nil
+	}
+
+	args := []string{"delete", loginWindowPrefs, "HiddenUsersList"}
+	if len(kept) > 0 {
+		args = append([]string{"write", loginWindowPrefs, "HiddenUsersList", "-array"}, kept...)
+	}
+	if out, err := runner.Run(ctx, "defaults", args...); err != nil {
+		return fmt.Errorf("update HiddenUsersList: %w (output=%s)", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// readHiddenUsersList returns the login window HiddenUsersList entries, or nil
+// if the key is not set.
+func readHiddenUsersList(ctx context.Context) ([]string, error) {
+	out, err := runner.Run(ctx, "defaults", "read", loginWindowPrefs, "HiddenUsersList")
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			// Key (or domain) does not exist yet.
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	// defaults prints arrays in the old-style plist form: ( a, b, "c-d" )
+	var users []string
+	for _, line := range strings.Split(string(out), "\n") {
+		line = strings.TrimSpace(line)
+		line = strings.TrimSuffix(line, ",")
+		line = strings.Trim(line, "\"")
+		if line == "" || line == "(" || line == ")" {
+			continue
+		}
+		users = append(users, line)
+	}
+	return users, nil
+}
@@ -0,0 +1,78 @@
+//go:build darwin
+
+package host
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAppendPasswordRestoresHeader(t *testing.T) {
+	tests := []struct {
+		name     string
+		existing string
+		want     string
+	}{
+		{"empty", "", secretsHeader + "mac-2,pw\n"},
+		{"header kept", secretsHeader + "mac-1,old\n", secretsHeader + "mac-1,old\nmac-2,pw\n"},
+		{"header lost", "mac-1,old\n", secretsHeader + "mac-1,old\nmac-2,pw\n"},
+		{"partial record", "mac-1,ol", secretsHeader + "mac-1,ol\nmac-2,pw\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "users.csv")
+			if err := os.WriteFile(path, []byte(tt.existing), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			if err := appendPassword(path, "mac-2", "pw"); err != nil {
+				t.Fatal(err)
+			}
+			got, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("secrets CSV = %q, want %q", got, tt.want)
+			}
+			fi, err := os.Stat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fi.Mode().Perm() != 0o600 {
+				t.Errorf("mode = %v, want 0600", fi.Mode().Perm())
+			}
+		})
+	}
+}
+
+func TestAppendPasswordRoundTrips(t *testing.T) {
+	passwords := []string{"a,b", `say "hi"`, "line\nbreak", " spaced ", "plain"}
+	path := filepath.Join(t.TempDir(), "users.csv")
+	for i, pw := range passwords {
+		if err := appendPassword(path, fmt.Sprintf("mac-%d", i+1), pw); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	records, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		t.Fatalf("read secrets CSV: %v", err)
+	}
+	if len(records) != len(passwords)+1 {
+		t.Fatalf("got %d records, want header plus %d", len(records), len(passwords))
+	}
+	for i, pw := range passwords {
+		rec := records[i+1]
+		if len(rec) != 2 || rec[0] != fmt.Sprintf("mac-%d", i+1) || rec[1] != pw {
+			t.Errorf("record %d = %q, want [mac-%d %q]", i+1, rec, i+1, pw)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+//go:build darwin
+
+package host
+
+import (
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"prism/internal/infra/config"
+	"prism/internal/infra/state"
+)
+
+// UserMatch is a user found by FindUsers.
+type UserMatch struct {
+	User state.User `json:"user"`
+	// FriendlyName is the friendlyName metadata of the user's frpc.toml, or
+	// empty when it is unset or unreadable.
+	FriendlyName string `json:"friendly_name,omitempty"`
+	// MatchedBy is what query matched: "name", "port", "subdomain" or
+	// "friendly_name".
+	MatchedBy string `json:"matched_by"`
+}
+
+// FindUsers returns the users in st that query identifies. A number matches
+// a port; otherwise query matches a username or subdomain exactly (a full
+// domain or URL under globals.domain_suffix is reduced to its subdomain),
+// or a case-insensitive fragment of the friendlyName in the user's
+// frpc.toml. Exact matches win: friendlyName is only searched when nothing
+// else matched.
+func FindUsers(cfg config.Config, st state.State, query string) []UserMatch {
+	query = strings.TrimSpace(query)
+	if query == "" {
+		return nil
+	}
+
+	var matches []UserMatch
+	if port, err := strconv.Atoi(query); err == nil {
+		for _, u := range st.Users {
+			if u.Port == port {
+				matches = append(matches, UserMatch{User: u, FriendlyName: userFriendlyName(u.Name), MatchedBy: "port"})
+			}
+		}
+		return matches
+	}
+
+	sub := lookupSubdomain(cfg, query)
+	for _, u := range st.Users {
+		switch {
+		case u.Name == query:
+			matches = append(matches, UserMatch{User: u, FriendlyName: userFriendlyName(u.Name), MatchedBy: "name"})
+		case u.Subdomain != "" && strings.EqualFold(u.Subdomain, sub):
+			matches = append(matches, UserMatch{User: u, FriendlyName: userFriendlyName(u.Name), MatchedBy: "subdomain"})
+		}
+	}
+	if len(matches) > 0 {
+		return matches
+	}
+
+	fragment := strings.ToLower(query)
+	for _, u := range st.Users {
+		name := userFriendlyName(u.Name)
+		if name != "" && strings.Contains(strings.ToLower(name), fragment) {
+			matches = append(matches, UserMatch{User: u, FriendlyName: name, MatchedBy: "friendly_name"})
+		}
+	}
+	return matches
+}
+
+// lookupSubdomain reduces a URL or full domain under globals.domain_suffix to
+// its subdomain and returns anything else unchanged.
+func lookupSubdomain(cfg config.Config, query string) string {
+	host := query
+	if _, rest, ok := strings.Cut(host, "://"); ok {
+		host = rest
+	}
+	if i := strings.IndexAny(host, "/:"); i >= 0 {
+		host = host[:i]
+	}
+	host = strings.TrimSuffix(host, ".")
+	suffix := strings.Trim(strings.TrimSpace(cfg.Globals.DomainSuffix), ".")
+	if suffix != "" {
+		if sub, ok := strings.CutSuffix(strings.ToLower(host), "."+strings.ToLower(suffix)); ok {
+			return sub
+		}
+	}
+	return host
+}
+
+// userFriendlyName reads the friendlyName metadata from username's frpc.toml.
+func userFriendlyName(username string) string {
+	info, err := readFRPCProxy(filepath.Join(userServiceDir(username, config.PrimaryServiceName), "frpc.toml"))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(info.Metadatas["friendlyName"])
+}
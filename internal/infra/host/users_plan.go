@@ -0,0 +1,241 @@
+//go:build darwin
+
+package host
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"prism/internal/infra/config"
+	"prism/internal/infra/state"
+)
+
+// PlannedUser describes a user that ProvisionUsers or AddUsers would create.
+// Subdomains are random and only assigned when the user is created.
+type PlannedUser struct {
+	Name string `json:"name"`
+	Port int    `json:"port"`
+	// UID is the fixed UID from globals.service.base_uid, or 0 when macOS
+	// assigns one.
+	UID int `json:"uid,omitempty"`
+	// Conflict explains why creating this user would fail, if it would.
+	Conflict string `json:"conflict,omitempty"`
+}
+
+// PlanUsers computes the users that the next provisioning run would create
+// without creating accounts, downloading the bundle or writing files. The
+// initial setup plan is used when st has no users, otherwise the add-users
+// plan.
+func PlanUsers(ctx context.Context, cfg config.Config, st state.State, userCount int) ([]PlannedUser, error) {
+	if userCount <= 0 {
+		return nil, errors.New("userCount must be positive")
+	}
+
+	machineID := strings.TrimSpace(cfg.Globals.MachineID)
+	if machineID == "" {
+		return nil, errors.New("globals.machine_id is empty")
+	}
+
+	startIndex, err := firstFreeUserIndex(ctx, st, machineID, userCount)
+	if err != nil {
+		return nil, err
+	}
+
+	if last := cfg.Globals.Service.MaxUserPort(); cfg.Globals.Service.StartPort+startIndex+userCount-2 > last {
+		return nil, fmt.Errorf("cannot plan %d users: their ports would exceed %d (globals.service.max_users)", userCount, last)
+	}
+
+	plan := make([]PlannedUser, 0, userCount)
+	for i := 0; i < userCount; i++ {
+		idx := startIndex + i
+		p := PlannedUser{
+			Name: fmt.Sprintf("%s-%d", machineID, idx),
+			Port: cfg.Globals.Service.StartPort + idx - 1,
+		}
+
+		var conflicts []string
+		if exists, err := systemUserExists(ctx, p.Name); err != nil {
+			return nil, fmt.Errorf("check user %s: %w", p.Name, err)
+		} else if exists {
+			conflicts = append(conflicts, "user already exists")
+		}
+		if base := cfg.Globals.Service.BaseUID; base > 0 {
+			p.UID = base + idx - 1
+			if taken, err := uidInUse(ctx, p.UID); err != nil {
+				return nil, fmt.Errorf("check UID %d: %w", p.UID, err)
+			} else if taken {
+				conflicts = append(conflicts, fmt.Sprintf("UID %d already in use", p.UID))
+			}
+		}
+		ports := []int{p.Port}
+		for _, d := range cfg.Globals.Services {
+			ports = append(ports, d.StartPort+idx-1)
+		}
+		for _, inUse := range ScanPorts(ctx, cfg.Globals.Service.BindIP(), ports) {
+			conflicts = append(conflicts, inUse.String()+" already in use")
+		}
+		p.Conflict = strings.Join(conflicts, "; ")
+
+		plan = append(plan, p)
+	}
+	return plan, nil
+}
+
+// PlanSummary is the overview of a plan that is confirmed before any account
+// is created, so a wrong machine_id or start_port is caught first.
+type PlanSummary struct {
+	Count int `json:"count"`
+	// UsernameTemplate is the account name pattern, e.g. "mac01-<n>".
+	UsernameTemplate string `json:"username_template"`
+	FirstPort        int    `json:"first_port"`
+	LastPort         int    `json:"last_port"`
+	// DomainSuffix is the domain each user's random subdomain is added to.
+	DomainSuffix string `json:"domain_suffix"`
+	Scheme       string `json:"scheme"`
+}
+
+// SummarizePlan describes plan with the settings of cfg it was computed from.
+func SummarizePlan(cfg config.Config, plan []PlannedUser) PlanSummary {
+	sum := PlanSummary{
+		Count:            len(plan),
+		UsernameTemplate: strings.TrimSpace(cfg.Globals.MachineID) + "-<n>",
+		DomainSuffix:     cfg.Globals.DomainSuffix,
+		Scheme:           cfg.Globals.Scheme(),
+	}
+	if len(plan) > 0 {
+		sum.FirstPort = plan[0].Port
+		sum.LastPort = plan[len(plan)-1].Port
+	}
+	return sum
+}
+
+// firstFreeUserIndex returns the index of the first of count new users: the
+// one after the highest user in st, moved past any account in the way. Such
+// an account was usually kept by RemoveUser and is not in state, but its name
+// must not be reused.
+func firstFreeUserIndex(ctx context.Context, st state.State, machineID string, count int) (int, error) {
+	first := nextUserIndex(st, machineID)
+	for i := first; i < first+count; i++ {
+		username := fmt.Sprintf("%s-%d", machineID, i)
+		exists, err := systemUserExists(ctx, username)
+		if err != nil {
+			return 0, fmt.Errorf("check user %s: %w", username, err)
+		}
+		if exists {
+			first = i + 1
+		}
+	}
+	return first, nil
+}
+
+// nextUserIndex returns the index after the highest <machineID>-<n> user in st.
+func nextUserIndex(st state.State, machineID string) int {
+	maxIndex := 0
+	prefix := machineID + "-"
+	for _, u := range st.Users {
+		if !strings.HasPrefix(u.Name, prefix) {
+			continue
+		}
+		suf := strings.TrimPrefix(u.Name, prefix)
+		idx, err := strconv.Atoi(suf)
+		if err != nil || idx <= 0 {
+			continue
+		}
+		if idx > maxIndex {
+			maxIndex = idx
+		}
+	}
+	return maxIndex + 1
+}
+
+// RemovalPlan lists what RemoveUser would delete for a user. Account and
+// HomeDir are only deleted when the account is not kept; the LaunchDaemons,
+// the keepalive LaunchAgent and the state entry are removed either way.
+type RemovalPlan struct {
+	Username string `json:"username"`
+	// AccountExists reports whether the macOS account exists.
+	AccountExists bool   `json:"account_exists"`
+	HomeDir       string `json:"home_dir,omitempty"`
+	// LaunchDaemons are the plist paths on disk that would be booted out and
+	// deleted.
+	LaunchDaemons []string `json:"launch_daemons,omitempty"`
+	LaunchAgent   string   `json:"launch_agent,omitempty"`
+	// StateEntry is the user's entry in state, or nil when it has none.
+	StateEntry *state.User `json:"state_entry,omitempty"`
+	// Warnings explain why RemoveUser would refuse or why the plan may not
+	// be what was intended.
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// PlanRemoveUser computes what RemoveUser would delete for username without
+// deleting anything. A user that is not in state or does not match the
+// globals.machine_id prefix is reported in Warnings rather than as an error.
+func PlanRemoveUser(ctx context.Context, cfg config.Config, st state.State, username string) (RemovalPlan, error) {
+	username = strings.TrimSpace(username)
+	if username == "" {
+		return RemovalPlan{}, errors.New("username is empty")
+	}
+	plan := RemovalPlan{Username: username}
+
+	machineID := strings.TrimSpace(cfg.Globals.MachineID)
+	if machineID == "" {
+		plan.Warnings = append(plan.Warnings, "globals.machine_id is empty")
+	} else if !strings.HasPrefix(username, machineID+"-") {
+		plan.Warnings = append(plan.Warnings, fmt.Sprintf("user %s does not belong to machine_id %s", username, machineID))
+	}
+	for i := range st.Users {
+		if st.Users[i].Name == username {
+			u := st.Users[i]
+			plan.StateEntry = &u
+			break
+		}
+	}
+	if plan.StateEntry == nil {
+		plan.Warnings = append(plan.Warnings, fmt.Sprintf("user %s not found in state", username))
+	}
+
+	exists, err := systemUserExists(ctx, username)
+	if err != nil {
+		return plan, fmt.Errorf("check user %s: %w", username, err)
+	}
+	plan.AccountExists = exists
+	if !exists {
+		plan.Warnings = append(plan.Warnings, fmt.Sprintf("macOS account %s does not exist", username))
+	}
+
+	homeDir := filepath.Join(usersDir, username)
+	if _, err := os.Stat(homeDir); err == nil {
+		plan.HomeDir = homeDir
+	}
+
+	var candidates []string
+	if m, err := ReadUserManifest(username); err == nil {
+		candidates = append(candidates, m.LaunchDaemons...)
+	}
+	for _, label := range append(userServerLabels(username), fmt.Sprintf(launchDaemonFRPCLabel, username)) {
+		candidates = append(candidates, filepath.Join(launchDaemonsDir, label+".plist"))
+	}
+	seen := make(map[string]bool, len(candidates))
+	for _, p := range candidates {
+		if seen[p] || filepath.Dir(p) != launchDaemonsDir || !strings.HasSuffix(p, ".plist") {
+			continue
+		}
+		seen[p] = true
+		if _, err := os.Stat(p); err == nil {
+			plan.LaunchDaemons = append(plan.LaunchDaemons, p)
+		}
+	}
+	sort.Strings(plan.LaunchDaemons)
+
+	agent := filepath.Join(homeDir, "Library", "LaunchAgents", KeepaliveLabel+".plist")
+	if _, err := os.Stat(agent); err == nil {
+		plan.LaunchAgent = agent
+	}
+	return plan, nil
+}
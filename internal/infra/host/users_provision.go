@@ -7,17 +7,28 @@ import (
 	"errors"
 	"fmt"
 	"os"
-	"os/exec"
 	"path/filepath"
-	"strconv"
 	"strings"
+	"time"
 
 	"prism/internal/infra/config"
 	"prism/internal/infra/state"
 )
 
 // ProvisionUsers creates macOS users and prepares per-user service directories.
-// Returns updated state and path to secrets file.
+// Returns updated state and where the new passwords went (see ProvisionSecrets).
+//
+// Users that finished before a failure are kept: the returned state holds
+// them even when err is non-nil, and each finished user is passed to the
+// WithStateSaver callback as soon as it is done, so a crash does not orphan
+// its account. Accounts of this run that did not finish are deleted again
+// (see rollbackAccounts), so the re-run does not hit ErrUserExists.
+//
+// userCount is the total for the host. The returned state has SetupPending
+// set to it until the caller finishes the host steps and clears it. When st
+// already has SetupPending, ProvisionUsers resumes that Setup: it retries the
+// bootstrap of users with DaemonsPending and creates only the missing users,
+// reusing the cached bundle.
 func ProvisionUsers(
 	ctx context.Context,
 	cfg config.Config,
@@ -25,82 +36,132 @@ func ProvisionUsers(
 	userCount int,
 	outputDir string,
 	prismPath string,
-) (state.State, string, error) {
+) (state.State, ProvisionSecrets, error) {
 	if userCount <= 0 {
-		return st, "", errors.New("userCount must be positive")
+		return st, ProvisionSecrets{}, errors.New("userCount must be positive")
 	}
 
-	if len(st.Users) > 0 {
-		return st, "", errors.New("users already provisioned; please use the add-users flow instead")
+	done := len(st.Users)
+	if done > 0 && st.SetupPending == 0 {
+		return st, ProvisionSecrets{}, errors.New("users already provisioned; please use the add-users flow instead")
+	}
+	if done > userCount {
+		return st, ProvisionSecrets{}, fmt.Errorf("the unfinished setup already created %d users, more than %d", done, userCount)
 	}
 
 	machineID := strings.TrimSpace(cfg.Globals.MachineID)
 	if machineID == "" {
-		return st, "", errors.New("globals.machine_id is empty")
+		return st, ProvisionSecrets{}, errors.New("globals.machine_id is empty")
 	}
 
 	if outputDir == "" {
-		return st, "", errors.New("outputDir is empty")
+		return st, ProvisionSecrets{}, errors.New("outputDir is empty")
+	}
+
+	st = reclaimPendingUsers(ctx, st)
+	first, err := firstFreeUserIndex(ctx, st, machineID, userCount-done)
+	if err != nil {
+		return st, ProvisionSecrets{}, err
+	}
+	if last := cfg.Globals.Service.MaxUserPort(); cfg.Globals.Service.StartPort+first+userCount-done-2 > last {
+		return st, ProvisionSecrets{}, fmt.Errorf("cannot create %d users: their ports would exceed %d (globals.service.max_users)", userCount, last)
+	}
+	// Check the ports of the users still to be created before any account
+	// is; those of users a resumed setup already provisioned are theirs.
+	if err := checkPortsFree(ctx, cfg, first, userCount-done); err != nil {
+		return st, ProvisionSecrets{}, err
+	}
+
+	if done > 0 {
+		fmt.Printf("[provision] resuming setup: %d of %d users already provisioned\n", done, userCount)
+		st = repairPendingDaemons(ctx, st)
 	}
+	st.SetupPending = userCount
 
-	secretsFile, err := ensureSecretsFile(outputDir)
+	secrets, err := newProvisionSecrets(cfg, outputDir)
 	if err != nil {
-		return st, "", fmt.Errorf("ensure secrets file: %w", err)
+		return st, ProvisionSecrets{}, err
+	}
+	if done == userCount {
+		return st, secrets, nil
 	}
 
 	extractDir, err := ensureServiceArchive(ctx, cfg, outputDir)
 	if err != nil {
-		return st, "", err
+		return st, secrets, err
+	}
+
+	// Resolve the deployed version for the per-user manifests; it is recorded
+	// for auto-update once every user is prepared. A resumed setup keeps the
+	// version its first users got.
+	var bundleVersion string
+	if done > 0 {
+		if m, err := ReadUserManifest(st.Users[0].Name); err == nil {
+			bundleVersion = m.BundleVersion
+		}
+	}
+	if bundleVersion == "" {
+		if bundleVersion, err = latestVersion(ctx, cfg); err != nil {
+			// Log but don't fail provisioning; auto-update will just skip until version is recorded
+			fmt.Printf("[provision] warning: failed to resolve bundle version: %v\n", err)
+		}
 	}
 
-	users := st.Users[:0]
+	assets, err := prepareProvisionAssets(ctx, cfg, outputDir, extractDir, prismPath, bundleVersion)
+	if err != nil {
+		return st, secrets, err
+	}
 
-	for i := 1; i <= userCount; i++ {
+	accounts := make([]newAccount, 0, userCount-done)
+	for i := first; i < first+userCount-done; i++ {
 		username := fmt.Sprintf("%s-%d", machineID, i)
-		localPort := cfg.Globals.Service.StartPort + i - 1
 
 		exists, err := systemUserExists(ctx, username)
 		if err != nil {
-			return st, "", fmt.Errorf("check user %s: %w", username, err)
+			return st, secrets, fmt.Errorf("check user %s: %w", username, err)
 		}
 		if exists {
-			return st, "", fmt.Errorf("user %s already exists; please use the add-users flow instead of initial setup", username)
+			return st, secrets, fmt.Errorf("%w: %s; please use the add-users flow instead of initial setup", ErrUserExists, username)
 		}
 
-		password, err := generatePassword(cfg.Globals.DefaultPassword)
+		userOpts, err := systemUserOptionsFor(ctx, cfg, i)
 		if err != nil {
-			return st, "", fmt.Errorf("generate password for %s: %w", username, err)
-		}
-
-		if err := createSystemUser(ctx, username, password); err != nil {
-			return st, "", err
-		}
-
-		if err := appendPassword(secretsFile, username, password); err != nil {
-			return st, "", fmt.Errorf("save password for %s: %w", username, err)
+			return st, secrets, fmt.Errorf("prepare user %s: %w", username, err)
 		}
 
-		u, err := ensurePerUserFiles(cfg, username, localPort, extractDir, prismPath)
+		password, err := generatePassword(cfg.Globals.DefaultPassword)
 		if err != nil {
-			return st, "", err
+			return st, secrets, fmt.Errorf("generate password for %s: %w", username, err)
 		}
+		accounts = append(accounts, newAccount{username: username, password: password, opts: userOpts})
+	}
 
-		users = append(users, u)
+	st, err = createPendingAccounts(ctx, cfg, st, accounts, &secrets)
+	if err != nil {
+		return st, secrets, err
 	}
 
-	st.Users = users
-	st.Initialized = true
+	st, err = prepareNewUsers(ctx, cfg, st, accounts, cfg.Globals.Service.StartPort+first-1, assets, &secrets)
+	if err != nil {
+		return st, secrets, err
+	}
+
+	if bundleVersion != "" {
+		if err := writeCurrentVersion(outputDir, bundleVersion, len(st.Users)); err != nil {
+			fmt.Printf("[provision] warning: failed to record initial version: %v\n", err)
+		}
+	}
 
-	// Record the deployed version for auto-update tracking
-	if err := RecordInitialVersion(ctx, cfg, outputDir); err != nil {
-		// Log but don't fail provisioning; auto-update will just skip until version is recorded
-		fmt.Printf("[provision] warning: failed to record initial version: %v\n", err)
+	if err := writeProvisionSummary(cfg, st, outputDir, "setup", secrets.Path); err != nil {
+		fmt.Printf("[provision] warning: failed to write provision summary: %v\n", err)
 	}
 
-	return st, secretsFile, nil
+	return st, secrets, nil
 }
 
-// AddUsers appends additional users on an already-initialized host.
+// AddUsers appends additional users on an already-initialized host. Like
+// ProvisionUsers, it returns the users that finished before a failure in
+// state and deletes the accounts of this run that did not finish.
 func AddUsers(
 	ctx context.Context,
 	cfg config.Config,
@@ -108,100 +169,106 @@ func AddUsers(
 	userCount int,
 	outputDir string,
 	prismPath string,
-) (state.State, string, error) {
+) (state.State, ProvisionSecrets, error) {
 	if userCount <= 0 {
-		return st, "", errors.New("userCount must be positive")
+		return st, ProvisionSecrets{}, errors.New("userCount must be positive")
 	}
 
 	if len(st.Users) == 0 {
-		return st, "", errors.New("no existing users in state; please run initial setup before adding users")
+		return st, ProvisionSecrets{}, errors.New("no existing users in state; please run initial setup before adding users")
+	}
+	if st.SetupPending > 0 {
+		return st, ProvisionSecrets{}, errors.New("the initial setup has not finished; run Setup again to resume it before adding users")
 	}
 
 	machineID := strings.TrimSpace(cfg.Globals.MachineID)
 	if machineID == "" {
-		return st, "", errors.New("globals.machine_id is empty")
+		return st, ProvisionSecrets{}, errors.New("globals.machine_id is empty")
 	}
 
 	if outputDir == "" {
-		return st, "", errors.New("outputDir is empty")
+		return st, ProvisionSecrets{}, errors.New("outputDir is empty")
 	}
 
-	secretsFile, err := ensureSecretsFile(outputDir)
+	secrets, err := newProvisionSecrets(cfg, outputDir)
 	if err != nil {
-		return st, "", fmt.Errorf("ensure secrets file: %w", err)
+		return st, ProvisionSecrets{}, err
 	}
 
 	extractDir, err := ensureServiceArchive(ctx, cfg, outputDir)
 	if err != nil {
-		return st, "", err
+		return st, secrets, err
 	}
 
-	maxIndex := 0
-	prefix := machineID + "-"
-	for _, u := range st.Users {
-		if !strings.HasPrefix(u.Name, prefix) {
-			continue
-		}
-		suf := strings.TrimPrefix(u.Name, prefix)
-		idx, err := strconv.Atoi(suf)
-		if err != nil || idx <= 0 {
-			continue
-		}
-		if idx > maxIndex {
-			maxIndex = idx
-		}
+	st = reclaimPendingUsers(ctx, st)
+	startIndex, err := firstFreeUserIndex(ctx, st, machineID, userCount)
+	if err != nil {
+		return st, secrets, err
+	}
+
+	if last := cfg.Globals.Service.MaxUserPort(); cfg.Globals.Service.StartPort+startIndex+userCount-2 > last {
+		return st, secrets, fmt.Errorf("cannot add %d users: their ports would exceed %d (globals.service.max_users)", userCount, last)
 	}
-	startIndex := maxIndex + 1
 
-	users := st.Users
+	bundleVersion, _ := readCurrentVersion(outputDir)
+
+	assets, err := prepareProvisionAssets(ctx, cfg, outputDir, extractDir, prismPath, bundleVersion)
+	if err != nil {
+		return st, secrets, err
+	}
 
+	accounts := make([]newAccount, 0, userCount)
 	for i := 0; i < userCount; i++ {
 		idx := startIndex + i
 		username := fmt.Sprintf("%s-%d", machineID, idx)
-		localPort := cfg.Globals.Service.StartPort + idx - 1
 
 		exists, err := systemUserExists(ctx, username)
 		if err != nil {
-			return st, "", fmt.Errorf("check user %s: %w", username, err)
+			return st, secrets, fmt.Errorf("check user %s: %w", username, err)
 		}
 		if exists {
-			return st, "", fmt.Errorf("user %s already exists; cannot add duplicate user", username)
+			return st, secrets, fmt.Errorf("%w: %s; cannot add duplicate user", ErrUserExists, username)
 		}
 
-		password, err := generatePassword(cfg.Globals.DefaultPassword)
+		userOpts, err := systemUserOptionsFor(ctx, cfg, idx)
 		if err != nil {
-			return st, "", fmt.Errorf("generate password for %s: %w", username, err)
+			return st, secrets, fmt.Errorf("prepare user %s: %w", username, err)
 		}
 
-		if err := createSystemUser(ctx, username, password); err != nil {
-			return st, "", err
-		}
-
-		if err := appendPassword(secretsFile, username, password); err != nil {
-			return st, "", fmt.Errorf("save password for %s: %w", username, err)
-		}
-
-		u, err := ensurePerUserFiles(cfg, username, localPort, extractDir, prismPath)
+		password, err := generatePassword(cfg.Globals.DefaultPassword)
 		if err != nil {
-			return st, "", err
+			return st, secrets, fmt.Errorf("generate password for %s: %w", username, err)
 		}
+		accounts = append(accounts, newAccount{username: username, password: password, opts: userOpts})
+	}
 
-		users = append(users, u)
+	st, err = createPendingAccounts(ctx, cfg, st, accounts, &secrets)
+	if err != nil {
+		return st, secrets, err
 	}
 
-	st.Users = users
-	st.Initialized = true
+	st, err = prepareNewUsers(ctx, cfg, st, accounts, cfg.Globals.Service.StartPort+startIndex-1, assets, &secrets)
+	if err != nil {
+		return st, secrets, err
+	}
 
-	return st, secretsFile, nil
+	if err := writeProvisionSummary(cfg, st, outputDir, "add-users", secrets.Path); err != nil {
+		fmt.Printf("[add-users] warning: failed to write provision summary: %v\n", err)
+	}
+
+	return st, secrets, nil
 }
 
 // RemoveUser deletes a Prism-managed macOS user and removes it from state.
+// With keepAccount, only the user's services are removed; the account and its
+// home directory are left in place.
 func RemoveUser(
 	ctx context.Context,
 	cfg config.Config,
 	st state.State,
 	username string,
 	outputDir string,
+	keepAccount bool,
 ) (state.State, error) {
 	if strings.TrimSpace(username) == "" {
 		return st, errors.New("username is empty")
@@ -232,22 +299,13 @@ func RemoveUser(
 		return st, fmt.Errorf("user %s not found in state", username)
 	}
 
-	homeDir := filepath.Join("/Users", username)
-
-	// Remove LaunchDaemons first (bootout and delete plist files)
-	_ = RemoveUserLaunchDaemons(username)
-
-	cmd := exec.CommandContext(ctx, "sysadminctl",
-		"-deleteUser", username,
-		"-home", homeDir,
-	)
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		return st, fmt.Errorf("delete user %s: %w (output=%s)", username, err, strings.TrimSpace(string(output)))
+	if keepAccount {
+		deprovisionSystemUser(ctx, username)
+		fmt.Printf("[remove-user] kept the account and home directory of %s\n", username)
+	} else if err := deleteSystemUser(ctx, username); err != nil {
+		return st, err
 	}
 
-	_ = os.RemoveAll(homeDir)
-
 	users := make([]state.User, 0, len(st.Users)-1)
 	for i, u := range st.Users {
 		if i == idx {
@@ -262,74 +320,243 @@ func RemoveUser(
 	return st, nil
 }
 
+// UpdateOptions tunes UpdateUserCode. The zero value syncs the bundles and
+// restarts running services, leaving the prism wrapper untouched.
+type UpdateOptions struct {
+	// PrismPath, when non-empty, replaces each user's prism-host copy with
+	// this binary if it is newer and rewrites the prism wrapper.
+	PrismPath string
+	// SkipRestart stages the new code without restarting running services,
+	// so they keep the old code until restarted explicitly. A service whose
+	// LaunchDaemon definition changed is still reloaded.
+	SkipRestart bool
+	// Users limits the update to these users, e.g. to canary a new bundle.
+	// Empty means every user.
+	Users []string
+}
+
+// UpdateResult reports the outcome of UpdateUserCode for each user.
+type UpdateResult struct {
+	// Users maps every selected user to the error that stopped its update,
+	// or nil if it was updated.
+	Users map[string]error
+	// NotRestarted lists users whose code was synced while their running
+	// services were left on the previous version (SkipRestart).
+	NotRestarted []string
+}
+
+// UpdateUserCode syncs the latest service bundles into every selected user's
+// service directory and restarts running services, as tuned by opts. A user
+// that fails does not stop the others; the returned error then wraps
+// ErrPartialUpdate and res.Users holds each user's outcome.
 func UpdateUserCode(
 	ctx context.Context,
 	cfg config.Config,
 	st state.State,
 	outputDir string,
-) (state.State, error) {
+	opts UpdateOptions,
+) (state.State, UpdateResult, error) {
+	var res UpdateResult
 	if len(st.Users) == 0 {
-		return st, errors.New("no existing users in state; nothing to update")
+		return st, res, errors.New("no existing users in state; nothing to update")
 	}
 
 	if strings.TrimSpace(outputDir) == "" {
-		return st, errors.New("outputDir is empty")
+		return st, res, errors.New("outputDir is empty")
+	}
+
+	selected := st.Users
+	if len(opts.Users) > 0 {
+		byName := make(map[string]state.User, len(st.Users))
+		for _, u := range st.Users {
+			byName[u.Name] = u
+		}
+		selected = make([]state.User, 0, len(opts.Users))
+		for _, name := range opts.Users {
+			u, ok := byName[name]
+			if !ok {
+				return st, res, fmt.Errorf("%s is not a Prism user", name)
+			}
+			selected = append(selected, u)
+		}
 	}
 
 	extractDir, err := refreshServiceArchive(ctx, cfg, outputDir)
 	if err != nil {
-		return st, fmt.Errorf("refresh service archive: %w", err)
+		return st, res, fmt.Errorf("refresh service archive: %w", err)
+	}
+	extras, err := refreshExtraServiceBundles(ctx, cfg, outputDir)
+	if err != nil {
+		return st, res, fmt.Errorf("refresh service archive: %w", err)
+	}
+
+	// Resolve the deployed version; it is recorded for auto-update tracking
+	// only after a complete rollout.
+	bundleVersion, err := latestVersion(ctx, cfg)
+	if err != nil {
+		// Log but don't fail update; auto-update will handle version tracking
+		fmt.Printf("[update-code] warning: failed to resolve version: %v\n", err)
+	}
+
+	var prismBinary []byte
+	var prismModTime time.Time
+	if opts.PrismPath != "" {
+		fi, err := os.Stat(opts.PrismPath)
+		if err != nil {
+			return st, res, fmt.Errorf("stat prism binary: %w", err)
+		}
+		prismModTime = fi.ModTime()
+		if prismBinary, err = os.ReadFile(opts.PrismPath); err != nil {
+			return st, res, fmt.Errorf("read prism binary: %w", err)
+		}
 	}
 
 	statuses, err := CheckUserServices(ctx, cfg, st)
 	if err != nil {
-		return st, fmt.Errorf("pre-check services: %w", err)
+		return st, res, fmt.Errorf("pre-check services: %w", err)
 	}
 	statusByUser := make(map[string]UserServiceStatus, len(statuses))
 	for _, s := range statuses {
 		statusByUser[s.Name] = s
 	}
 
-	for _, u := range st.Users {
-		homeDir := filepath.Join("/Users", u.Name)
-		serviceDir := filepath.Join(homeDir, "services", "imsg")
-		fi, err := os.Stat(serviceDir)
+	update := codeUpdate{
+		cfg:           cfg,
+		extractDir:    extractDir,
+		extras:        extras,
+		bundleVersion: bundleVersion,
+		prismBinary:   prismBinary,
+		prismModTime:  prismModTime,
+		skipRestart:   opts.SkipRestart,
+	}
+	res.Users = make(map[string]error, len(selected))
+	var failed []string
+	for _, u := range selected {
+		// Once cancelled or past the deadline, every remaining user would
+		// fail the same way.
+		if err := ctx.Err(); err != nil {
+			return st, res, err
+		}
+		stItem, ok := statusByUser[u.Name]
+		running := ok && stItem.ServiceDirOK && stItem.PortListening
+		setStep(ctx, "update user %s", u.Name)
+		notRestarted, err := update.apply(ctx, u, running)
+		res.Users[u.Name] = err
 		if err != nil {
-			if errors.Is(err, os.ErrNotExist) {
-				return st, fmt.Errorf("service directory %s does not exist for user %s", serviceDir, u.Name)
-			}
-			return st, fmt.Errorf("stat service directory %s: %w", serviceDir, err)
+			failed = append(failed, u.Name)
+			continue
+		}
+		if notRestarted {
+			res.NotRestarted = append(res.NotRestarted, u.Name)
+		}
+	}
+
+	// The recorded version describes the whole host, so only a complete
+	// rollout updates it.
+	if bundleVersion != "" && len(opts.Users) == 0 && len(failed) == 0 {
+		if err := writeCurrentVersion(outputDir, bundleVersion, len(st.Users)); err != nil {
+			fmt.Printf("[update-code] warning: failed to record version: %v\n", err)
 		}
-		if !fi.IsDir() {
-			return st, fmt.Errorf("service path %s exists but is not a directory for user %s", serviceDir, u.Name)
+	}
+	if len(failed) == 0 {
+		if err := pruneServiceCaches(cfg, outputDir); err != nil {
+			fmt.Printf("[update-code] warning: cache cleanup failed: %v\n", err)
 		}
+	}
+
+	st.Initialized = true
+	if len(failed) > 0 {
+		return st, res, fmt.Errorf("%w: %d of %d users (%s)", ErrPartialUpdate, len(failed), len(selected), strings.Join(failed, ", "))
+	}
+	return st, res, nil
+}
+
+// codeUpdate is what UpdateUserCode applies to each selected user.
+type codeUpdate struct {
+	cfg           config.Config
+	extractDir    string
+	extras        []extraServiceBundle
+	bundleVersion string
+	prismBinary   []byte
+	prismModTime  time.Time
+	skipRestart   bool
+}
 
-		if err := syncServiceDir(extractDir, serviceDir); err != nil {
-			return st, fmt.Errorf("sync service directory for %s: %w", u.Name, err)
+// apply syncs the new code into u's service directory and restarts u's
+// services if they are running. It reports whether running services were
+// left on the old code because of skipRestart.
+func (c codeUpdate) apply(ctx context.Context, u state.User, running bool) (notRestarted bool, err error) {
+	cfg := c.cfg
+	serviceDir := userServiceDir(u.Name, config.PrimaryServiceName)
+	fi, err := os.Stat(serviceDir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return false, fmt.Errorf("service directory %s does not exist for user %s", serviceDir, u.Name)
 		}
+		return false, fmt.Errorf("stat service directory %s: %w", serviceDir, err)
+	}
+	if !fi.IsDir() {
+		return false, fmt.Errorf("service path %s exists but is not a directory for user %s", serviceDir, u.Name)
+	}
+
+	if err := syncServiceDir(ctx, c.extractDir, serviceDir); err != nil {
+		return false, fmt.Errorf("sync service directory for %s: %w", u.Name, err)
+	}
+	if err := setFriendlyNameRefresh(serviceDir, cfg.Globals.FRPC.FriendlyNameRefreshMinutes); err != nil {
+		fmt.Printf("[update-code] warning: failed to record friendly name refresh for %s: %v\n", u.Name, err)
+	}
+	if err := setServiceEndpoints(serviceDir, cfg.Globals.Service); err != nil {
+		fmt.Printf("[update-code] warning: failed to record local_ip and health_path for %s: %v\n", u.Name, err)
+	}
 
-		if err := chownRecursive(u.Name, serviceDir); err != nil {
-			return st, fmt.Errorf("chown service directory for %s: %w", u.Name, err)
+	if c.prismBinary != nil {
+		if err := refreshPrismWrapper(cfg.Globals.Service, serviceDir, c.prismBinary, c.prismModTime); err != nil {
+			return false, fmt.Errorf("refresh prism wrapper for %s: %w", u.Name, err)
 		}
+	}
 
-		if stItem, ok := statusByUser[u.Name]; ok && stItem.ServiceDirOK && stItem.PortListening {
-			if err := RestartUserDaemons(u.Name); err != nil {
-				return st, fmt.Errorf("restart services for %s: %w", u.Name, err)
-			}
+	if err := chownRecursive(ctx, u.Name, serviceDir); err != nil {
+		return false, fmt.Errorf("chown service directory for %s: %w", u.Name, err)
+	}
+
+	for _, b := range c.extras {
+		if err := updateExtraUserService(ctx, cfg, u.Name, u.Port, b); err != nil {
+			return false, fmt.Errorf("update services for %s: %w", u.Name, err)
 		}
+	}
+
+	if err := refreshServerLaunchDaemon(ctx, cfg, u.Name, u.Port); err != nil {
+		return false, fmt.Errorf("refresh server LaunchDaemon for %s: %w", u.Name, err)
+	}
 
-		// Update keepalive script and LaunchAgent
-		if err := EnsureKeepaliveService(u.Name); err != nil {
-			fmt.Printf("[update-code] warning: failed to update keepalive for %s: %v\n", u.Name, err)
+	if err := refreshUserManifestVersion(ctx, u.Name, c.bundleVersion); err != nil {
+		fmt.Printf("[update-code] warning: failed to update manifest for %s: %v\n", u.Name, err)
+	}
+
+	if running {
+		if c.skipRestart {
+			notRestarted = true
+		} else if err := RestartUserDaemons(ctx, u.Name); err != nil {
+			return false, fmt.Errorf("restart services for %s: %w", u.Name, err)
 		}
 	}
 
-	// Record the deployed version for auto-update tracking
-	if err := RecordInitialVersion(ctx, cfg, outputDir); err != nil {
-		// Log but don't fail update; auto-update will handle version tracking
-		fmt.Printf("[update-code] warning: failed to record version: %v\n", err)
+	// Update keepalive script and LaunchAgent
+	if err := EnsureKeepaliveService(ctx, u.Name); err != nil {
+		fmt.Printf("[update-code] warning: failed to update keepalive for %s: %v\n", u.Name, err)
 	}
+	return notRestarted, nil
+}
 
-	st.Initialized = true
-	return st, nil
+// refreshPrismWrapper reinstalls serviceDir/prism-host and its wrapper when the
+// installed copy is missing or older than binaryModTime.
+func refreshPrismWrapper(svc config.ServiceConfig, serviceDir string, binary []byte, binaryModTime time.Time) error {
+	fi, err := os.Stat(filepath.Join(serviceDir, "prism-host"))
+	if err == nil && !binaryModTime.After(fi.ModTime()) {
+		return nil
+	}
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return installPrismWrapper(svc, serviceDir, binary)
 }
@@ -0,0 +1,370 @@
+//go:build darwin
+
+package host
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"prism/internal/infra/state"
+)
+
+// wantUsers checks the names and ports of st.Users.
+func wantUsers(t *testing.T, st state.State, want ...string) {
+	t.Helper()
+	var got []string
+	for _, u := range st.Users {
+		got = append(got, fmt.Sprintf("%s:%d", u.Name, u.Port))
+	}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Fatalf("users = %v, want %v", got, want)
+	}
+}
+
+func TestProvisionUsers(t *testing.T) {
+	tests := []struct {
+		name     string
+		st       state.State
+		count    int
+		accounts []string
+		fail     map[string]string
+		batchMin int
+		wantErr  string
+		wantIs   error
+		want     []string
+		// gone are accounts that must not exist afterwards.
+		gone []string
+	}{
+		{
+			name:  "creates users",
+			count: 2,
+			want:  []string{"mac-1:20000", "mac-2:20001"},
+		},
+		{
+			name:     "creates users with dsimport",
+			count:    3,
+			batchMin: 2,
+			want:     []string{"mac-1:20000", "mac-2:20001", "mac-3:20002"},
+		},
+		{
+			name: "resumes unfinished setup",
+			st: state.State{Initialized: true, SetupPending: 3, Users: []state.User{
+				{Name: "mac-1", Port: 20000},
+			}},
+			accounts: []string{"mac-1"},
+			count:    3,
+			want:     []string{"mac-1:20000", "mac-2:20001", "mac-3:20002"},
+		},
+		{
+			name:    "zero users",
+			count:   0,
+			wantErr: "userCount must be positive",
+		},
+		{
+			name:    "already provisioned",
+			st:      state.State{Initialized: true, Users: []state.User{{Name: "mac-1", Port: 20000}}},
+			count:   2,
+			wantErr: "users already provisioned",
+			want:    []string{"mac-1:20000"},
+		},
+		{
+			name:     "skips kept account",
+			count:    2,
+			accounts: []string{"mac-2"},
+			want:     []string{"mac-3:20002", "mac-4:20003"},
+		},
+		{
+			name:     "reclaims accounts of a crashed run",
+			st:       state.State{SetupPending: 2, PendingUsers: []string{"mac-1", "mac-2"}},
+			accounts: []string{"mac-1"},
+			count:    2,
+			want:     []string{"mac-1:20000", "mac-2:20001"},
+		},
+		{
+			name:   "create fails and rolls back",
+			count:  2,
+			fail:   map[string]string{"sysadminctl -addUser mac-2": "Permission denied"},
+			wantIs: ErrPermissionDenied,
+			gone:   []string{"mac-1", "mac-2"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, outputDir := testHost(t)
+			cfg.Globals.Service.BatchCreateMin = tt.batchMin
+			fake := newFakeRunner(t, tt.accounts...)
+			for k, v := range tt.fail {
+				fake.fail[k] = v
+			}
+
+			st, secrets, err := ProvisionUsers(context.Background(), cfg, tt.st, tt.count, outputDir, "")
+			switch {
+			case tt.wantErr != "":
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("ProvisionUsers() error = %v, want %q", err, tt.wantErr)
+				}
+			case tt.wantIs != nil:
+				if !errors.Is(err, tt.wantIs) {
+					t.Fatalf("ProvisionUsers() error = %v, want %v", err, tt.wantIs)
+				}
+			case err != nil:
+				t.Fatalf("ProvisionUsers() error = %v", err)
+			}
+			wantUsers(t, st, tt.want...)
+			for _, name := range tt.gone {
+				if fake.hasAccount(name) {
+					t.Errorf("account %s was not rolled back", name)
+				}
+			}
+			if err != nil {
+				return
+			}
+
+			if len(st.PendingUsers) > 0 {
+				t.Errorf("PendingUsers = %v, want none", st.PendingUsers)
+			}
+			if st.SetupPending != tt.count {
+				t.Errorf("SetupPending = %d, want %d", st.SetupPending, tt.count)
+			}
+			data, err := os.ReadFile(secrets.Path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, u := range st.Users[len(tt.st.Users):] {
+				if !fake.hasAccount(u.Name) {
+					t.Errorf("account %s was not created", u.Name)
+				}
+				if !strings.Contains(string(data), "\n"+u.Name+",") {
+					t.Errorf("secrets CSV has no password for %s", u.Name)
+				}
+				plist := filepath.Join(launchDaemonsDir, fmt.Sprintf(launchDaemonFRPCLabel+".plist", u.Name))
+				if _, err := os.Stat(plist); err != nil {
+					t.Errorf("frpc LaunchDaemon of %s: %v", u.Name, err)
+				}
+				if !fake.called("launchctl bootstrap system " + plist) {
+					t.Errorf("frpc LaunchDaemon of %s was not bootstrapped", u.Name)
+				}
+			}
+		})
+	}
+}
+
+func TestAddUsers(t *testing.T) {
+	existing := state.State{Initialized: true, Users: []state.User{{Name: "mac-1", Port: 20000}}}
+	tests := []struct {
+		name     string
+		st       state.State
+		count    int
+		accounts []string
+		fail     map[string]string
+		wantErr  string
+		wantIs   error
+		want     []string
+		gone     []string
+	}{
+		{
+			name:     "adds after existing users",
+			st:       existing,
+			accounts: []string{"mac-1"},
+			count:    2,
+			want:     []string{"mac-1:20000", "mac-2:20001", "mac-3:20002"},
+		},
+		{
+			name:    "no users yet",
+			count:   1,
+			wantErr: "please run initial setup",
+		},
+		{
+			name:    "setup unfinished",
+			st:      state.State{SetupPending: 3, Users: existing.Users},
+			count:   1,
+			wantErr: "initial setup has not finished",
+			want:    []string{"mac-1:20000"},
+		},
+		{
+			name:     "skips kept accounts",
+			st:       existing,
+			accounts: []string{"mac-1", "mac-2", "mac-4"},
+			count:    2,
+			want:     []string{"mac-1:20000", "mac-5:20004", "mac-6:20005"},
+		},
+		{
+			name:     "create fails and rolls back",
+			st:       existing,
+			accounts: []string{"mac-1"},
+			count:    2,
+			fail:     map[string]string{"sysadminctl -addUser mac-3": "Permission denied"},
+			wantIs:   ErrPermissionDenied,
+			want:     []string{"mac-1:20000"},
+			gone:     []string{"mac-2", "mac-3"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, outputDir := testHost(t)
+			fake := newFakeRunner(t, tt.accounts...)
+			for k, v := range tt.fail {
+				fake.fail[k] = v
+			}
+
+			st, _, err := AddUsers(context.Background(), cfg, tt.st, tt.count, outputDir, "")
+			switch {
+			case tt.wantErr != "":
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("AddUsers() error = %v, want %q", err, tt.wantErr)
+				}
+			case tt.wantIs != nil:
+				if !errors.Is(err, tt.wantIs) {
+					t.Fatalf("AddUsers() error = %v, want %v", err, tt.wantIs)
+				}
+			case err != nil:
+				t.Fatalf("AddUsers() error = %v", err)
+			}
+			wantUsers(t, st, tt.want...)
+			for _, name := range tt.gone {
+				if fake.hasAccount(name) {
+					t.Errorf("account %s was not rolled back", name)
+				}
+			}
+			if !fake.hasAccount("mac-1") && len(tt.accounts) > 0 {
+				t.Error("existing account mac-1 was deleted")
+			}
+		})
+	}
+}
+
+func TestRemoveUser(t *testing.T) {
+	st := state.State{Initialized: true, Users: []state.User{
+		{Name: "mac-1", Port: 20000},
+		{Name: "mac-2", Port: 20001},
+	}}
+	tests := []struct {
+		name        string
+		username    string
+		keepAccount bool
+		fail        map[string]string
+		wantErr     string
+		want        []string
+		wantAccount bool
+	}{
+		{
+			name:     "deletes account",
+			username: "mac-1",
+			want:     []string{"mac-2:20001"},
+		},
+		{
+			name:        "keeps account",
+			username:    "mac-1",
+			keepAccount: true,
+			want:        []string{"mac-2:20001"},
+			wantAccount: true,
+		},
+		{
+			name:        "not in state",
+			username:    "mac-3",
+			wantErr:     "not found in state",
+			want:        []string{"mac-1:20000", "mac-2:20001"},
+			wantAccount: true,
+		},
+		{
+			name:        "other machine",
+			username:    "other-1",
+			wantErr:     "does not belong to machine_id",
+			want:        []string{"mac-1:20000", "mac-2:20001"},
+			wantAccount: true,
+		},
+		{
+			name:        "delete fails",
+			username:    "mac-1",
+			fail:        map[string]string{"sysadminctl -deleteUser mac-1": "Permission denied"},
+			wantErr:     "permission denied",
+			want:        []string{"mac-1:20000", "mac-2:20001"},
+			wantAccount: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, outputDir := testHost(t)
+			fake := newFakeRunner(t, "mac-1", "mac-2")
+			for k, v := range tt.fail {
+				fake.fail[k] = v
+			}
+			home := filepath.Join(usersDir, "mac-1")
+			if err := os.MkdirAll(home, 0o755); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := RemoveUser(context.Background(), cfg, st, tt.username, outputDir, tt.keepAccount)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("RemoveUser() error = %v, want %q", err, tt.wantErr)
+				}
+			} else if err != nil {
+				t.Fatalf("RemoveUser() error = %v", err)
+			}
+			wantUsers(t, got, tt.want...)
+			if fake.hasAccount("mac-1") != tt.wantAccount {
+				t.Errorf("account mac-1 exists = %v, want %v", fake.hasAccount("mac-1"), tt.wantAccount)
+			}
+			if _, err := os.Stat(home); (err == nil) != tt.wantAccount {
+				t.Errorf("home directory exists = %v, want %v", err == nil, tt.wantAccount)
+			}
+		})
+	}
+}
+
+func TestProvisionUsersSavesPendingUsers(t *testing.T) {
+	cfg, outputDir := testHost(t)
+	fake := newFakeRunner(t)
+	var saved []state.State
+	ctx := WithStateSaver(context.Background(), func(st state.State) error {
+		// Record what the accounts looked like when state was saved.
+		st.Initialized = fake.hasAccount("mac-1")
+		saved = append(saved, st)
+		return nil
+	})
+
+	if _, _, err := ProvisionUsers(ctx, cfg, state.State{}, 2, outputDir, ""); err != nil {
+		t.Fatal(err)
+	}
+	if len(saved) == 0 {
+		t.Fatal("state was never saved")
+	}
+	first := saved[0]
+	if first.Initialized || strings.Join(first.PendingUsers, " ") != "mac-1 mac-2" {
+		t.Errorf("first save = %+v, want mac-1 and mac-2 pending before any account exists", first)
+	}
+	if last := saved[len(saved)-1]; len(last.PendingUsers) != 0 || len(last.Users) != 2 {
+		t.Errorf("last save = %+v, want both users and none pending", last)
+	}
+}
+
+func TestProvisionUsersChecksPortsFromFirstFreeIndex(t *testing.T) {
+	cfg, outputDir := testHost(t)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	port := l.Addr().(*net.TCPAddr).Port
+	// mac-1 is kept, so the new user is mac-2 and takes start_port+1.
+	cfg.Globals.Service.StartPort = port - 1
+	fake := newFakeRunner(t, "mac-1")
+
+	_, _, err = ProvisionUsers(context.Background(), cfg, state.State{}, 1, outputDir, "")
+	if !errors.Is(err, ErrPortsInUse) || !strings.Contains(err.Error(), fmt.Sprintf("port %d", port)) {
+		t.Fatalf("ProvisionUsers() error = %v, want %v for port %d", err, ErrPortsInUse, port)
+	}
+	if fake.hasAccount("mac-2") {
+		t.Error("account mac-2 was created despite the port conflict")
+	}
+}
@@ -0,0 +1,68 @@
+//go:build darwin
+
+package host
+
+import (
+	"context"
+	"fmt"
+
+	"prism/internal/infra/state"
+)
+
+// bootstrapNewUser starts the LaunchDaemons of a freshly provisioned user.
+// A failure does not undo the account: the user is returned with
+// DaemonsPending set so RepairUser can retry the bootstrap alone.
+func bootstrapNewUser(ctx context.Context, u state.User) state.User {
+	if err := BootstrapUserLaunchDaemons(ctx, u.Name); err != nil {
+		fmt.Printf("[provision] warning: %s: bootstrap LaunchDaemons: %v; retry with Repair\n", u.Name, err)
+		u.DaemonsPending = true
+	}
+	return u
+}
+
+// repairPendingDaemons retries the bootstrap of every user in st with
+// DaemonsPending, as RepairUser does, and returns the updated state. Users
+// that still fail keep DaemonsPending and are logged.
+func repairPendingDaemons(ctx context.Context, st state.State) state.State {
+	for _, u := range st.Users {
+		if !u.DaemonsPending {
+			continue
+		}
+		setStep(ctx, "bootstrap LaunchDaemons for %s", u.Name)
+		repaired, err := RepairUser(ctx, st, u.Name)
+		if err != nil {
+			fmt.Printf("[provision] warning: %v; retry with Repair\n", err)
+			continue
+		}
+		st = repaired
+	}
+	return st
+}
+
+// RepairUser bootstraps the LaunchDaemons of an existing Prism user again,
+// without touching its account or files, and clears DaemonsPending on
+// success. It returns the updated state.
+func RepairUser(ctx context.Context, st state.State, username string) (state.State, error) {
+	idx := -1
+	for i, u := range st.Users {
+		if u.Name == username {
+			idx = i
+			break
+		}
+	}
+	if idx < 0 {
+		return st, fmt.Errorf("%s is not a Prism user", username)
+	}
+	if err := ctx.Err(); err != nil {
+		return st, err
+	}
+
+	if err := BootstrapUserLaunchDaemons(ctx, username); err != nil {
+		return st, fmt.Errorf("bootstrap LaunchDaemons for %s: %w", username, err)
+	}
+
+	users := append([]state.User(nil), st.Users...)
+	users[idx].DaemonsPending = false
+	st.Users = users
+	return st, nil
+}
@@ -4,10 +4,12 @@ package macos
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
 	"os/signal"
+	"slices"
 	"strings"
 	"syscall"
 	"time"
@@ -25,6 +27,38 @@ type PreflightResult struct {
 	Checks        []Check `json:"checks"`
 	NeedsReboot   bool    `json:"needs_reboot"`
 	RebootSkipped bool    `json:"reboot_skipped"`
+	// Skipped lists the checks left out on purpose (PreflightOptions.Skip).
+	Skipped []string `json:"skipped,omitempty"`
+}
+
+// Names of the checks, as accepted by PreflightOptions.Skip.
+const (
+	CheckArch              = "arch"
+	CheckSIP               = "sip"
+	CheckBootArgs          = "boot_args"
+	CheckLibraryValidation = "library_validation"
+)
+
+// PreflightOptions controls side effects of Preflight.
+type PreflightOptions struct {
+	// AllowReboot lets Preflight reboot the host after changing settings.
+	// When false, it returns with RebootSkipped set and asks the operator to
+	// reboot manually, which is the safe default for automation.
+	AllowReboot bool
+	// Skip lists checks (CheckSIP, ...) that are not run at all. Skipping
+	// one means setup no longer guarantees the host can run the services.
+	Skip []string
+}
+
+// skippedChecks returns the known checks in skip, in the order they run.
+func skippedChecks(skip []string) []string {
+	var out []string
+	for _, name := range []string{CheckArch, CheckSIP, CheckBootArgs, CheckLibraryValidation} {
+		if slices.Contains(skip, name) {
+			out = append(out, name)
+		}
+	}
+	return out
 }
 
 var (
@@ -34,7 +68,6 @@ var (
 		"-arm64e_preview_abi",
 		"ipc_control_port_options=0",
 	}
-	bootArgsValue = strings.Join(requiredBootArgs, " ")
 
 	sipDisableSteps = "1. Restart and hold Command+R to enter Recovery Mode.\n" +
 		"2. Open Terminal from Utilities menu.\n" +
@@ -42,20 +75,153 @@ var (
 		"4. Restart and retry."
 )
 
+// Runner abstracts command execution for testing.
+type Runner interface {
+	Run(ctx context.Context, name string, args ...string) (string, error)
+}
+
+// RunnerFunc adapts a function to the Runner interface.
+type RunnerFunc func(ctx context.Context, name string, args ...string) (string, error)
+
+func (f RunnerFunc) Run(ctx context.Context, name string, args ...string) (string, error) {
+	return f(ctx, name, args...)
+}
+
+type cmdRunner struct{}
+
+func (cmdRunner) Run(ctx context.Context, name string, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, name, args...)
+	// Do not wait forever on pipes held open by a killed child.
+	cmd.WaitDelay = time.Second
+	out, err := cmd.CombinedOutput()
+	return strings.TrimSpace(string(out)), err
+}
+
+// checkTimeout bounds each preflight subprocess so a hung csrutil or nvram
+// fails its check instead of blocking setup forever.
+var checkTimeout = 15 * time.Second
+
+// errCheckTimeout is returned by runCheckCmd when a command exceeds checkTimeout.
+var errCheckTimeout = errors.New("timed out")
+
+// runCheckCmd runs a preflight subprocess through r under its own deadline
+// and returns its trimmed combined output.
+func runCheckCmd(ctx context.Context, r Runner, name string, args ...string) (string, error) {
+	cctx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+
+	out, err := r.Run(cctx, name, args...)
+	if errors.Is(cctx.Err(), context.DeadlineExceeded) && ctx.Err() == nil {
+		err = fmt.Errorf("%s %w after %s", strings.Join(append([]string{name}, args...), " "), errCheckTimeout, checkTimeout)
+	}
+	return strings.TrimSpace(out), err
+}
+
+// timeoutDetail describes a timed-out check for Check.Detail.
+func timeoutDetail(err error) string {
+	return fmt.Sprintf("%v. The command did not respond; check the host and retry.", err)
+}
+
 // containsAll returns missing items from required that are not in s.
 func containsAll(s string, required []string) []string {
+	have := make(map[string]bool)
+	for _, f := range strings.Fields(s) {
+		have[f] = true
+	}
 	var missing []string
 	for _, r := range required {
-		if !strings.Contains(s, r) {
+		if !have[r] {
 			missing = append(missing, r)
 		}
 	}
 	return missing
 }
 
-func checkSIP(ctx context.Context) Check {
-	out, err := exec.CommandContext(ctx, "csrutil", "status").CombinedOutput()
-	outStr := strings.TrimSpace(string(out))
+// parseBootArgs extracts the argument list from `nvram boot-args` output,
+// which has the form "boot-args\t<args>".
+func parseBootArgs(out string) []string {
+	fields := strings.Fields(out)
+	if len(fields) > 0 && fields[0] == "boot-args" {
+		fields = fields[1:]
+	}
+	return fields
+}
+
+// bootArgKey returns the name part of a boot arg ("a=1" -> "a").
+func bootArgKey(arg string) string {
+	if idx := strings.Index(arg, "="); idx >= 0 {
+		return arg[:idx]
+	}
+	return arg
+}
+
+// mergeBootArgs unions existing boot args with required ones. Existing args
+// are kept in order unless a required arg with the same key replaces them;
+// missing required args are appended. It returns the merged list, the
+// required args that were added and the existing args that were preserved.
+func mergeBootArgs(existing, required []string) (merged, added, preserved []string) {
+	requiredByKey := make(map[string]string, len(required))
+	for _, r := range required {
+		requiredByKey[bootArgKey(r)] = r
+	}
+
+	seen := make(map[string]bool)
+	for _, arg := range existing {
+		key := bootArgKey(arg)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		if want, ok := requiredByKey[key]; ok {
+			merged = append(merged, want)
+			if want != arg {
+				added = append(added, want)
+			}
+			continue
+		}
+		merged = append(merged, arg)
+		preserved = append(preserved, arg)
+	}
+	for _, r := range required {
+		if !seen[bootArgKey(r)] {
+			merged = append(merged, r)
+			added = append(added, r)
+		}
+	}
+	return merged, added, preserved
+}
+
+// checkArch reports whether the host is Apple silicon. The service bundle and
+// the -arm64e_preview_abi boot-arg only work there. hw.optional.arm64 is read
+// rather than uname, which reports x86_64 to a process running under Rosetta.
+func checkArch(ctx context.Context, r Runner) Check {
+	const name = "arm64 host"
+	out, err := runCheckCmd(ctx, r, "sysctl", "-n", "hw.optional.arm64")
+	if errors.Is(err, errCheckTimeout) {
+		return Check{Name: name, OK: false, Detail: timeoutDetail(err)}
+	}
+	if err == nil && out == "1" {
+		return Check{Name: name, OK: true, Detail: "arm64"}
+	}
+
+	// Intel Macs do not have hw.optional.arm64 at all.
+	arch, _ := runCheckCmd(ctx, r, "uname", "-m")
+	if arch == "" {
+		arch = "not arm64"
+	}
+	return Check{
+		Name: name,
+		OK:   false,
+		Detail: fmt.Sprintf("This host is %s, Prism expects arm64 (Apple silicon). "+
+			"The service bundle and the -arm64e_preview_abi boot-arg only work on Apple silicon Macs.", arch),
+	}
+}
+
+func checkSIP(ctx context.Context, r Runner) Check {
+	outStr, err := runCheckCmd(ctx, r, "csrutil", "status")
+	if errors.Is(err, errCheckTimeout) {
+		return Check{Name: "SIP disabled", OK: false, Detail: timeoutDetail(err)}
+	}
 
 	if err != nil || !strings.Contains(strings.ToLower(outStr), "disabled") {
 		return Check{
@@ -67,55 +233,107 @@ func checkSIP(ctx context.Context) Check {
 	return Check{Name: "SIP disabled", OK: true, Detail: outStr}
 }
 
-func checkAndFixBootArgs(ctx context.Context) (Check, bool) {
-	out, _ := exec.CommandContext(ctx, "nvram", "boot-args").CombinedOutput()
-	outStr := strings.TrimSpace(string(out))
+func checkAndFixBootArgs(ctx context.Context, r Runner) (Check, bool) {
+	outStr, err := runCheckCmd(ctx, r, "nvram", "boot-args")
+	if errors.Is(err, errCheckTimeout) {
+		return Check{Name: "boot-args", OK: false, Detail: timeoutDetail(err)}, false
+	}
 
-	if missing := containsAll(outStr, requiredBootArgs); len(missing) == 0 {
+	// A missing boot-args variable makes nvram exit non-zero; treat it as empty.
+	var existing []string
+	if err == nil {
+		existing = parseBootArgs(outStr)
+	}
+	if missing := containsAll(strings.Join(existing, " "), requiredBootArgs); len(missing) == 0 {
 		return Check{Name: "boot-args", OK: true, Detail: outStr}, false
 	}
 
-	// Auto-fix
-	fmt.Printf("\n[preflight] Setting boot-args: %s\n", bootArgsValue)
-	if out, err := exec.CommandContext(ctx, "nvram", "boot-args="+bootArgsValue).CombinedOutput(); err != nil {
+	// Auto-fix, keeping any unrelated args already set on this host
+	merged, added, preserved := mergeBootArgs(existing, requiredBootArgs)
+	value := strings.Join(merged, " ")
+	fmt.Printf("\n[preflight] Setting boot-args: %s\n", value)
+	if out, err := runCheckCmd(ctx, r, "nvram", "boot-args="+value); err != nil {
 		return Check{Name: "boot-args", OK: false, Detail: fmt.Sprintf("Failed to set boot-args: %v\nOutput: %s", err, out)}, false
 	}
 
 	// Verify
-	out, _ = exec.CommandContext(ctx, "nvram", "boot-args").CombinedOutput()
-	outStr = strings.TrimSpace(string(out))
-	if missing := containsAll(outStr, requiredBootArgs); len(missing) > 0 {
+	outStr, err = runCheckCmd(ctx, r, "nvram", "boot-args")
+	if errors.Is(err, errCheckTimeout) {
+		return Check{Name: "boot-args", OK: false, Detail: timeoutDetail(err)}, false
+	}
+	if missing := containsAll(strings.Join(parseBootArgs(outStr), " "), requiredBootArgs); len(missing) > 0 {
 		return Check{Name: "boot-args", OK: false, Detail: fmt.Sprintf("Verification failed. Missing: %s", strings.Join(missing, ", "))}, false
 	}
 
-	return Check{Name: "boot-args", OK: true, Detail: "Auto-configured: " + outStr}, true
+	detail := "Auto-configured: " + value + "\nAdded: " + strings.Join(added, " ")
+	if len(preserved) > 0 {
+		detail += "\nPreserved: " + strings.Join(preserved, " ")
+	}
+	return Check{Name: "boot-args", OK: true, Detail: detail}, true
 }
 
-func checkAndFixLibraryValidation(ctx context.Context) (Check, bool) {
+func checkAndFixLibraryValidation(ctx context.Context, r Runner) (Check, bool) {
 	const plist = "/Library/Preferences/com.apple.security.libraryvalidation.plist"
 	const key = "DisableLibraryValidation"
 
-	out, err := exec.CommandContext(ctx, "defaults", "read", plist, key).CombinedOutput()
-	if err == nil && strings.TrimSpace(strings.ToLower(string(out))) == "1" {
+	out, err := runCheckCmd(ctx, r, "defaults", "read", plist, key)
+	if errors.Is(err, errCheckTimeout) {
+		return Check{Name: key, OK: false, Detail: timeoutDetail(err)}, false
+	}
+	if err == nil && strings.ToLower(out) == "1" {
 		return Check{Name: key, OK: true, Detail: "1"}, false
 	}
 
 	// Auto-fix
 	fmt.Printf("\n[preflight] Setting %s: true\n", key)
-	if out, err := exec.CommandContext(ctx, "defaults", "write", plist, key, "-bool", "true").CombinedOutput(); err != nil {
+	if out, err := runCheckCmd(ctx, r, "defaults", "write", plist, key, "-bool", "true"); err != nil {
 		return Check{Name: key, OK: false, Detail: fmt.Sprintf("Failed to set %s: %v\nOutput: %s", key, err, out)}, false
 	}
 
 	// Verify
-	out, _ = exec.CommandContext(ctx, "defaults", "read", plist, key).CombinedOutput()
-	if strings.TrimSpace(strings.ToLower(string(out))) != "1" {
+	out, err = runCheckCmd(ctx, r, "defaults", "read", plist, key)
+	if errors.Is(err, errCheckTimeout) {
+		return Check{Name: key, OK: false, Detail: timeoutDetail(err)}, false
+	}
+	if strings.ToLower(out) != "1" {
 		return Check{Name: key, OK: false, Detail: "Verification failed"}, false
 	}
 
 	return Check{Name: key, OK: true, Detail: "Auto-configured: 1"}, true
 }
 
-func rebootWithCountdown() bool {
+// checkBootArgs reports whether the required boot-args are set, without
+// changing them.
+func checkBootArgs(ctx context.Context, r Runner) Check {
+	outStr, err := runCheckCmd(ctx, r, "nvram", "boot-args")
+	if errors.Is(err, errCheckTimeout) {
+		return Check{Name: "boot-args", OK: false, Detail: timeoutDetail(err)}
+	}
+	var existing []string
+	if err == nil {
+		existing = parseBootArgs(outStr)
+	}
+	if missing := containsAll(strings.Join(existing, " "), requiredBootArgs); len(missing) > 0 {
+		return Check{Name: "boot-args", OK: false, Detail: "Missing: " + strings.Join(missing, ", ")}
+	}
+	return Check{Name: "boot-args", OK: true, Detail: outStr}
+}
+
+// checkLibraryValidation reports whether DisableLibraryValidation is set,
+// without changing it.
+func checkLibraryValidation(ctx context.Context, r Runner) Check {
+	const key = "DisableLibraryValidation"
+	out, err := runCheckCmd(ctx, r, "defaults", "read", "/Library/Preferences/com.apple.security.libraryvalidation.plist", key)
+	if errors.Is(err, errCheckTimeout) {
+		return Check{Name: key, OK: false, Detail: timeoutDetail(err)}
+	}
+	if err != nil || strings.ToLower(out) != "1" {
+		return Check{Name: key, OK: false, Detail: "not set"}
+	}
+	return Check{Name: key, OK: true, Detail: "1"}
+}
+
+func rebootWithCountdown(r Runner) bool {
 	fmt.Println("\n" + strings.Repeat("=", 50))
 	fmt.Println("Settings changed. Rebooting in 10s...")
 	fmt.Println("After reboot, run `sudo ./prism` again.")
@@ -137,22 +355,70 @@ func rebootWithCountdown() bool {
 	}
 
 	fmt.Println("\n\nRebooting...")
-	if err := exec.Command("shutdown", "-r", "now").Run(); err != nil {
+	if _, err := r.Run(context.Background(), "shutdown", "-r", "now"); err != nil {
 		fmt.Printf("Failed to reboot: %v\nPlease reboot manually.\n", err)
 		return true
 	}
 	return false
 }
 
-// Preflight verifies SIP, boot-args, and DisableLibraryValidation.
-func Preflight(ctx context.Context) (PreflightResult, error) {
-	sipCheck := checkSIP(ctx)
-	bootCheck, bootReboot := checkAndFixBootArgs(ctx)
-	libCheck, libReboot := checkAndFixLibraryValidation(ctx)
+// Preflight verifies the host architecture, SIP, boot-args, and
+// DisableLibraryValidation.
+func Preflight(ctx context.Context, opts PreflightOptions) (PreflightResult, error) {
+	return PreflightWithRunner(ctx, opts, cmdRunner{})
+}
+
+// CheckPreflight runs the same checks as Preflight, except those in skip,
+// without fixing anything or rebooting, for read-only status reporting.
+func CheckPreflight(ctx context.Context, skip []string) PreflightResult {
+	r := cmdRunner{}
+	res := PreflightResult{Skipped: skippedChecks(skip)}
+	checks := []struct {
+		name string
+		run  func(context.Context, Runner) Check
+	}{
+		{CheckArch, checkArch},
+		{CheckSIP, checkSIP},
+		{CheckBootArgs, checkBootArgs},
+		{CheckLibraryValidation, checkLibraryValidation},
+	}
+	for _, c := range checks {
+		if !slices.Contains(res.Skipped, c.name) {
+			res.Checks = append(res.Checks, c.run(ctx, r))
+		}
+	}
+	return res
+}
+
+// PreflightWithRunner is Preflight with all subprocess calls routed through r.
+func PreflightWithRunner(ctx context.Context, opts PreflightOptions, r Runner) (PreflightResult, error) {
+	res := PreflightResult{Skipped: skippedChecks(opts.Skip)}
+	if len(res.Skipped) > 0 {
+		fmt.Printf("\n[preflight] WARNING: skipping %s as configured; setup no longer guarantees this host can run the services\n",
+			strings.Join(res.Skipped, ", "))
+	}
+	skipped := func(name string) bool { return slices.Contains(res.Skipped, name) }
 
-	res := PreflightResult{
-		Checks:      []Check{sipCheck, bootCheck, libCheck},
-		NeedsReboot: bootReboot || libReboot,
+	if !skipped(CheckArch) {
+		// Fail fast on the wrong hardware, before any setting is changed.
+		archCheck := checkArch(ctx, r)
+		res.Checks = append(res.Checks, archCheck)
+		if !archCheck.OK {
+			return res, fmt.Errorf("preflight failed: %s", archCheck.Detail)
+		}
+	}
+	if !skipped(CheckSIP) {
+		res.Checks = append(res.Checks, checkSIP(ctx, r))
+	}
+	if !skipped(CheckBootArgs) {
+		bootCheck, reboot := checkAndFixBootArgs(ctx, r)
+		res.Checks = append(res.Checks, bootCheck)
+		res.NeedsReboot = res.NeedsReboot || reboot
+	}
+	if !skipped(CheckLibraryValidation) {
+		libCheck, reboot := checkAndFixLibraryValidation(ctx, r)
+		res.Checks = append(res.Checks, libCheck)
+		res.NeedsReboot = res.NeedsReboot || reboot
 	}
 
 	// Collect failures
@@ -167,8 +433,12 @@ func Preflight(ctx context.Context) (PreflightResult, error) {
 	}
 
 	// Trigger reboot if needed
+	if res.NeedsReboot && !opts.AllowReboot {
+		res.RebootSkipped = true
+		return res, fmt.Errorf("settings changed; reboot manually, then run Prism again")
+	}
 	if res.NeedsReboot {
-		res.RebootSkipped = rebootWithCountdown()
+		res.RebootSkipped = rebootWithCountdown(r)
 		if !res.RebootSkipped {
 			os.Exit(0)
 		}
@@ -0,0 +1,291 @@
+//go:build darwin
+
+package macos
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+const libraryValidationRead = "defaults read /Library/Preferences/com.apple.security.libraryvalidation.plist DisableLibraryValidation"
+
+// fakeHost answers preflight commands from a map of command lines to
+// outputs; a command missing from the map fails. Commands in hang block until
+// their context is done, like a hung csrutil or nvram. With persist, setting
+// boot-args or DisableLibraryValidation changes what is read back.
+type fakeHost struct {
+	out     map[string]string
+	hang    map[string]bool
+	persist bool
+	calls   []string
+}
+
+func (f *fakeHost) Run(ctx context.Context, name string, args ...string) (string, error) {
+	line := strings.Join(append([]string{name}, args...), " ")
+	f.calls = append(f.calls, line)
+	if f.hang[line] {
+		<-ctx.Done()
+		return "", ctx.Err()
+	}
+	out, ok := f.out[line]
+	if !ok {
+		return "", errors.New("exit status 1")
+	}
+	if f.persist {
+		switch {
+		case strings.HasPrefix(line, "nvram boot-args="):
+			f.out["nvram boot-args"] = "boot-args\t" + strings.TrimPrefix(line, "nvram boot-args=")
+		case strings.HasPrefix(line, "defaults write"):
+			f.out[libraryValidationRead] = "1"
+		}
+	}
+	return out, nil
+}
+
+// called reports whether a command line starting with prefix was run.
+func (f *fakeHost) called(prefix string) bool {
+	for _, c := range f.calls {
+		if strings.HasPrefix(c, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// readyHost returns a fakeHost on which every preflight check passes.
+func readyHost() *fakeHost {
+	return &fakeHost{out: map[string]string{
+		"sysctl -n hw.optional.arm64": "1",
+		"csrutil status":              "System Integrity Protection status: disabled.",
+		"nvram boot-args":             "boot-args\t" + strings.Join(requiredBootArgs, " "),
+		libraryValidationRead:         "1",
+	}}
+}
+
+func TestPreflightCheckTimeout(t *testing.T) {
+	prev := checkTimeout
+	t.Cleanup(func() { checkTimeout = prev })
+	checkTimeout = 50 * time.Millisecond
+
+	r := readyHost()
+	r.hang = map[string]bool{"csrutil status": true}
+
+	start := time.Now()
+	res, err := PreflightWithRunner(context.Background(), PreflightOptions{}, r)
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("PreflightWithRunner() took %s with a hung csrutil", elapsed)
+	}
+	if err == nil || !strings.Contains(err.Error(), "SIP disabled") {
+		t.Fatalf("PreflightWithRunner() error = %v, want the SIP check to fail", err)
+	}
+	for _, c := range res.Checks {
+		wantOK := c.Name != "SIP disabled"
+		if c.OK != wantOK {
+			t.Errorf("check %s OK = %v, want %v (%s)", c.Name, c.OK, wantOK, c.Detail)
+		}
+		if !wantOK && !strings.Contains(c.Detail, "csrutil status timed out after 50ms") {
+			t.Errorf("check %s detail = %q, want the timeout", c.Name, c.Detail)
+		}
+	}
+	if len(res.Checks) != 4 {
+		t.Errorf("ran %d checks, want all 4 despite the timeout", len(res.Checks))
+	}
+}
+
+func TestCheckSIP(t *testing.T) {
+	tests := []struct {
+		name   string
+		out    map[string]string
+		wantOK bool
+	}{
+		{"disabled", map[string]string{"csrutil status": "System Integrity Protection status: disabled."}, true},
+		{"enabled", map[string]string{"csrutil status": "System Integrity Protection status: enabled."}, false},
+		{"csrutil fails", nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := checkSIP(context.Background(), &fakeHost{out: tt.out})
+			if c.OK != tt.wantOK {
+				t.Errorf("checkSIP() OK = %v, want %v (%s)", c.OK, tt.wantOK, c.Detail)
+			}
+			if !tt.wantOK && !strings.Contains(c.Detail, "csrutil disable") {
+				t.Errorf("checkSIP() detail = %q, want the steps to disable SIP", c.Detail)
+			}
+		})
+	}
+}
+
+func TestCheckAndFixBootArgs(t *testing.T) {
+	required := strings.Join(requiredBootArgs, " ")
+	tests := []struct {
+		name       string
+		out        map[string]string
+		persist    bool
+		wantOK     bool
+		wantReboot bool
+		wantSet    string
+		wantDetail string
+	}{
+		{
+			name:   "already set",
+			out:    map[string]string{"nvram boot-args": "boot-args\t-v " + required},
+			wantOK: true,
+		},
+		{
+			name:       "unset",
+			out:        map[string]string{"nvram boot-args=" + required: ""},
+			persist:    true,
+			wantOK:     true,
+			wantReboot: true,
+			wantSet:    "nvram boot-args=" + required,
+			wantDetail: "Added: " + required,
+		},
+		{
+			name:       "keeps other args",
+			out:        map[string]string{"nvram boot-args": "boot-args\t-v amfi_get_out_of_my_way=0", "nvram boot-args=-v " + required: ""},
+			persist:    true,
+			wantOK:     true,
+			wantReboot: true,
+			wantSet:    "nvram boot-args=-v " + required,
+			wantDetail: "Preserved: -v",
+		},
+		{
+			name:       "set fails",
+			out:        map[string]string{"nvram boot-args": "boot-args\t-v"},
+			wantDetail: "Failed to set boot-args",
+		},
+		{
+			name:       "verification fails",
+			out:        map[string]string{"nvram boot-args": "boot-args\t-v", "nvram boot-args=-v " + required: ""},
+			wantDetail: "Verification failed",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &fakeHost{out: tt.out, persist: tt.persist}
+			c, reboot := checkAndFixBootArgs(context.Background(), r)
+			if c.OK != tt.wantOK || reboot != tt.wantReboot {
+				t.Errorf("checkAndFixBootArgs() = OK %v, reboot %v; want %v, %v (%s)", c.OK, reboot, tt.wantOK, tt.wantReboot, c.Detail)
+			}
+			if tt.wantSet != "" && !r.called(tt.wantSet) {
+				t.Errorf("boot-args not set with %q; calls: %v", tt.wantSet, r.calls)
+			}
+			if tt.wantSet == "" && tt.wantOK && r.called("nvram boot-args=") {
+				t.Errorf("boot-args were changed although already set; calls: %v", r.calls)
+			}
+			if !strings.Contains(c.Detail, tt.wantDetail) {
+				t.Errorf("detail = %q, want %q", c.Detail, tt.wantDetail)
+			}
+		})
+	}
+}
+
+func TestCheckAndFixLibraryValidation(t *testing.T) {
+	const write = "defaults write /Library/Preferences/com.apple.security.libraryvalidation.plist DisableLibraryValidation -bool true"
+	tests := []struct {
+		name       string
+		out        map[string]string
+		persist    bool
+		wantOK     bool
+		wantReboot bool
+		wantDetail string
+	}{
+		{
+			name:   "already set",
+			out:    map[string]string{libraryValidationRead: "1"},
+			wantOK: true,
+		},
+		{
+			name:       "unset",
+			out:        map[string]string{write: ""},
+			persist:    true,
+			wantOK:     true,
+			wantReboot: true,
+			wantDetail: "Auto-configured: 1",
+		},
+		{
+			name:       "write fails",
+			out:        map[string]string{libraryValidationRead: "0"},
+			wantDetail: "Failed to set DisableLibraryValidation",
+		},
+		{
+			name:       "verification fails",
+			out:        map[string]string{libraryValidationRead: "0", write: ""},
+			wantDetail: "Verification failed",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &fakeHost{out: tt.out, persist: tt.persist}
+			c, reboot := checkAndFixLibraryValidation(context.Background(), r)
+			if c.OK != tt.wantOK || reboot != tt.wantReboot {
+				t.Errorf("checkAndFixLibraryValidation() = OK %v, reboot %v; want %v, %v (%s)", c.OK, reboot, tt.wantOK, tt.wantReboot, c.Detail)
+			}
+			if !strings.Contains(c.Detail, tt.wantDetail) {
+				t.Errorf("detail = %q, want %q", c.Detail, tt.wantDetail)
+			}
+		})
+	}
+}
+
+func TestMergeBootArgs(t *testing.T) {
+	required := []string{"a=1", "-b", "c=0"}
+	tests := []struct {
+		name          string
+		existing      []string
+		wantMerged    string
+		wantAdded     string
+		wantPreserved string
+	}{
+		{
+			name:       "empty",
+			wantMerged: "a=1 -b c=0",
+			wantAdded:  "a=1 -b c=0",
+		},
+		{
+			name:          "preserves unrelated args in order",
+			existing:      []string{"-v", "debug=0x144"},
+			wantMerged:    "-v debug=0x144 a=1 -b c=0",
+			wantAdded:     "a=1 -b c=0",
+			wantPreserved: "-v debug=0x144",
+		},
+		{
+			name:          "replaces a required key with another value",
+			existing:      []string{"a=0", "-v"},
+			wantMerged:    "a=1 -v -b c=0",
+			wantAdded:     "a=1 -b c=0",
+			wantPreserved: "-v",
+		},
+		{
+			name:          "keeps required args already set",
+			existing:      []string{"-b", "-v", "c=0"},
+			wantMerged:    "-b -v c=0 a=1",
+			wantAdded:     "a=1",
+			wantPreserved: "-v",
+		},
+		{
+			name:          "drops duplicate keys",
+			existing:      []string{"-v", "a=1", "-v", "a=2"},
+			wantMerged:    "-v a=1 -b c=0",
+			wantAdded:     "-b c=0",
+			wantPreserved: "-v",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			merged, added, preserved := mergeBootArgs(tt.existing, required)
+			if got := strings.Join(merged, " "); got != tt.wantMerged {
+				t.Errorf("merged = %q, want %q", got, tt.wantMerged)
+			}
+			if got := strings.Join(added, " "); got != tt.wantAdded {
+				t.Errorf("added = %q, want %q", got, tt.wantAdded)
+			}
+			if got := strings.Join(preserved, " "); got != tt.wantPreserved {
+				t.Errorf("preserved = %q, want %q", got, tt.wantPreserved)
+			}
+		})
+	}
+}
@@ -14,18 +14,49 @@ const (
 	defaultStatePath  = "output/state.json"
 )
 
+// Paths given on the command line; they take precedence over the environment.
+var (
+	configOverride string
+	stateOverride  string
+)
+
+// SetOverrides records --config/--state values. Empty values keep the
+// PRISM_CONFIG/PRISM_STATE and default resolution.
+func SetOverrides(configPath, statePath string) {
+	configOverride = strings.TrimSpace(configPath)
+	stateOverride = strings.TrimSpace(statePath)
+}
+
+// OverrideArgs returns the command-line flags that reproduce the current
+// overrides, so child processes such as the host-autoboot daemon use the same
+// files.
+func OverrideArgs() []string {
+	var args []string
+	if configOverride != "" {
+		args = append(args, "--config", makeAbsolute(configOverride))
+	}
+	if stateOverride != "" {
+		args = append(args, "--state", makeAbsolute(stateOverride))
+	}
+	return args
+}
+
 func ConfigPath() string {
-	return resolvePath(envPrismConfig, defaultConfigPath)
+	return resolvePath(configOverride, envPrismConfig, defaultConfigPath)
 }
 
 func StatePath() string {
-	return resolvePath(envPrismState, defaultStatePath)
+	return resolvePath(stateOverride, envPrismState, defaultStatePath)
 }
 
 func SecretsPath() string {
-	state := StatePath()
-	dir := filepath.Dir(state)
-	return filepath.Join(dir, "secrets", "users.csv")
+	return SecretsPathIn(OutputDir())
+}
+
+// SecretsPathIn returns the password file of the output directory outputDir,
+// the directory holding the state file.
+func SecretsPathIn(outputDir string) string {
+	return filepath.Join(outputDir, "secrets", "users.csv")
 }
 
 func OutputDir() string {
@@ -33,7 +64,10 @@ func OutputDir() string {
 	return filepath.Dir(state)
 }
 
-func resolvePath(envKey, defaultRel string) string {
+func resolvePath(override, envKey, defaultRel string) string {
+	if override != "" {
+		return makeAbsolute(override)
+	}
 	if v := strings.TrimSpace(os.Getenv(envKey)); v != "" {
 		return makeAbsolute(v)
 	}
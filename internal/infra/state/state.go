@@ -6,12 +6,22 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 )
 
 // State represents the host-level runtime state.
 type State struct {
 	Initialized bool   `json:"initialized"`
 	Users       []User `json:"users"`
+	// SetupPending is the user count of a Setup run that has not finished:
+	// some of its users, the host autoboot daemon or Fast Login are still
+	// missing. Running Setup again resumes it. Zero once Setup is done.
+	SetupPending int `json:"setup_pending,omitempty"`
+	// PendingUsers are the accounts a provisioning run is creating that are
+	// not in Users yet. They are recorded before the accounts are created,
+	// so the next run can delete those a crash left behind.
+	PendingUsers []string `json:"pending_users,omitempty"`
 }
 
 // User describes a single managed macOS user.
@@ -19,6 +29,27 @@ type User struct {
 	Name      string `json:"name"`
 	Port      int    `json:"port"`
 	Subdomain string `json:"subdomain"`
+	// DaemonsPending is set when the account and its files exist but its
+	// LaunchDaemons could not be bootstrapped; RepairUser retries that.
+	DaemonsPending bool `json:"daemons_pending,omitempty"`
+	// Labels are free-form key/value tags for inventory, e.g. owner team or
+	// purpose. Prism itself does not interpret them.
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// FormatLabels returns labels as "key=value" pairs sorted by key and
+// separated by commas, or "" when there are none.
+func FormatLabels(labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		pairs = append(pairs, k+"="+labels[k])
+	}
+	return strings.Join(pairs, ", ")
 }
 
 // Load reads the state from the given path (returns zero State if not exists).
@@ -3,8 +3,11 @@
 package userinfra
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"net"
 	"net/http"
 	"os"
 	"os/exec"
@@ -21,14 +24,17 @@ type userServiceConfig struct {
 	Username   string `json:"username"`
 	MachineID  string `json:"machine_id"`
 	LocalPort  int    `json:"local_port"`
+	LocalIP    string `json:"local_ip"`
+	HealthPath string `json:"health_path"`
 	FullDomain string `json:"full_domain"`
 	NexusAddr  string `json:"nexus_addr"`
 	FRPCConfig string `json:"frpc_config"`
 }
 
 // Deploy verifies configuration, ensures friendly name, and performs health check.
-// LaunchDaemons should already be created by Host provisioning.
-func Deploy() string {
+// LaunchDaemons should already be created by Host provisioning. Cancelling ctx
+// aborts the health check and the keepalive deployment.
+func Deploy(ctx context.Context) string {
 	home, err := os.UserHomeDir()
 	if err != nil {
 		return fmt.Sprintf("Deploy failed: unable to determine user home directory: %v", err)
@@ -69,14 +75,25 @@ func Deploy() string {
 		return fmt.Sprintf("Deploy failed: could not start server: %v", err)
 	}
 
-	healthURL := fmt.Sprintf("http://localhost:%d/health", cfg.LocalPort)
-	if err := waitForHealth(healthURL, 10*time.Second); err != nil {
+	host := "localhost"
+	if ip := strings.TrimSpace(cfg.LocalIP); ip != "" {
+		host = ip
+	}
+	healthPath := "/health"
+	if p := strings.TrimSpace(cfg.HealthPath); p != "" {
+		healthPath = p
+	}
+	healthURL := "http://" + net.JoinHostPort(host, strconv.Itoa(cfg.LocalPort)) + healthPath
+	if err := waitForHealth(ctx, healthURL, 10*time.Second); err != nil {
+		if errors.Is(err, context.Canceled) {
+			return "Deploy cancelled while waiting for the local health check; the services were started and keep running."
+		}
 		return fmt.Sprintf("Deploy failed: local health check %s did not succeed: %v", healthURL, err)
 	}
 
 	// Deploy keepalive service (now that we know GUI is available)
 	var keepaliveNote string
-	if err := inframacos.EnsureKeepaliveService(u.Username); err != nil {
+	if err := inframacos.EnsureKeepaliveService(ctx, u.Username); err != nil {
 		keepaliveNote = fmt.Sprintf("\nWarning: failed to deploy keepalive: %v", err)
 	} else {
 		keepaliveNote = "\nKeepalive service deployed."
@@ -96,28 +113,53 @@ func Deploy() string {
 	)
 }
 
-func waitForHealth(url string, timeout time.Duration) error {
-	deadline := time.Now().Add(timeout)
+// waitForHealth polls url until it answers 2xx, timeout elapses or ctx is
+// cancelled, and returns the failure of the last attempt that completed
+// rather than the deadline that cut off the one in flight.
+func waitForHealth(ctx context.Context, url string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
 	client := &http.Client{Timeout: 2 * time.Second}
 
+	var lastErr error
 	for {
-		resp, err := client.Get(url) // #nosec G107 -- health endpoint is fixed, not user-controlled
+		err := checkHealth(ctx, client, url)
 		if err == nil {
-			_ = resp.Body.Close()
-			if resp.StatusCode >= 200 && resp.StatusCode < 300 {
-				return nil
-			}
+			return nil
+		}
+		if ctx.Err() == nil {
+			lastErr = err
 		}
 
-		if time.Now().After(deadline) {
-			if err != nil {
-				return err
+		select {
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.Canceled) {
+				return ctx.Err()
+			}
+			if lastErr == nil {
+				return fmt.Errorf("health check %s did not answer within %s: %w", url, timeout, err)
 			}
-			return fmt.Errorf("health check %s returned status %s", url, resp.Status)
+			return lastErr
+		case <-time.After(500 * time.Millisecond):
 		}
+	}
+}
 
-		time.Sleep(500 * time.Millisecond)
+// checkHealth requests url once and fails unless it answers 2xx.
+func checkHealth(ctx context.Context, client *http.Client, url string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req) // #nosec G107 -- health endpoint is fixed, not user-controlled
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("health check %s returned status %s", url, resp.Status)
 	}
+	return nil
 }
 
 func loadUserServiceConfig(serviceDir string) (userServiceConfig, string) {
@@ -165,12 +207,15 @@ func ensureFRPCFriendlyName(path string) (string, string) {
 	friendly := ""
 	friendlyNote := ""
 	if !hasFriendly {
-		friendly = strings.TrimSpace(autoDetectFriendlyName())
-		if friendly != "" {
-			if err := setFRPCFriendlyName(path, friendly); err != nil {
+		if ids := autoDetectIdentities(); len(ids) > 0 {
+			friendly = ids[0]
+			if err := setFRPCFriendlyNames(path, ids); err != nil {
 				return "", fmt.Sprintf("Deploy failed: unable to update frpc friendly name: %v", err)
 			}
 			friendlyNote = fmt.Sprintf("\nDetected friendly name: %s", friendly)
+			if len(ids) > 1 {
+				friendlyNote += fmt.Sprintf(" (all identities: %s)", strings.Join(ids, ", "))
+			}
 		}
 	}
 
@@ -0,0 +1,46 @@
+//go:build darwin
+
+package userinfra
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWaitForHealthReturnsLastAttemptError(t *testing.T) {
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		// Later attempts are still in flight when the deadline fires.
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+
+	err := waitForHealth(context.Background(), srv.URL, 800*time.Millisecond)
+	if err == nil || !strings.Contains(err.Error(), "503") {
+		t.Fatalf("waitForHealth() error = %v, want the 503 of the last completed attempt", err)
+	}
+	if requests.Load() < 2 {
+		t.Fatalf("got %d requests, want a second attempt cut off by the deadline", requests.Load())
+	}
+}
+
+func TestWaitForHealthNoAnswer(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+
+	err := waitForHealth(context.Background(), srv.URL, 200*time.Millisecond)
+	if err == nil || !strings.Contains(err.Error(), "did not answer within 200ms") {
+		t.Fatalf("waitForHealth() error = %v, want a timeout naming the wait", err)
+	}
+}
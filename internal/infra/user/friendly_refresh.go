@@ -0,0 +1,109 @@
+//go:build darwin
+
+package userinfra
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// friendlyRefreshStamp records when the friendly name was last re-detected;
+// its modification time is all that matters.
+const friendlyRefreshStamp = "prism-friendly-name-refresh"
+
+// friendlyNameManualMarker, in the service directory, records that the
+// friendlyName was set by hand, so the scheduled refresh leaves it alone.
+const friendlyNameManualMarker = ".friendly-name-manual"
+
+// RefreshFriendlyName re-detects the iMessage identity and, when it differs
+// from the friendlyName in frpc.toml, writes it and restarts frpc. With ifDue
+// it does nothing unless config.json sets friendly_name_refresh_minutes and
+// that long has passed since the last check, and it stays quiet when the name
+// is unchanged, and it skips a name set by hand with RenameFriendlyName. Without
+// ifDue a detected identity replaces a manual name and the scheduled refresh
+// resumes. The returned line is empty when there is nothing to report.
+func RefreshFriendlyName(ifDue bool) (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("unable to determine user home directory: %w", err)
+	}
+	serviceDir := filepath.Join(home, "services", "imsg")
+
+	var cfg struct {
+		FRPCConfig                 string `json:"frpc_config"`
+		FriendlyNameRefreshMinutes int    `json:"friendly_name_refresh_minutes"`
+	}
+	data, err := os.ReadFile(filepath.Join(serviceDir, "config.json"))
+	if err != nil {
+		return "", fmt.Errorf("read config.json: %w", err)
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return "", fmt.Errorf("parse config.json: %w", err)
+	}
+	frpcPath := cfg.FRPCConfig
+	if frpcPath == "" {
+		frpcPath = filepath.Join(serviceDir, "frpc.toml")
+	}
+
+	stamp := filepath.Join(home, "Library", "Caches", friendlyRefreshStamp)
+	marker := filepath.Join(serviceDir, friendlyNameManualMarker)
+	if ifDue {
+		if cfg.FriendlyNameRefreshMinutes <= 0 {
+			return "", nil
+		}
+		if _, err := os.Stat(marker); err == nil {
+			return "", nil
+		}
+		interval := time.Duration(cfg.FriendlyNameRefreshMinutes) * time.Minute
+		if fi, err := os.Stat(stamp); err == nil && time.Since(fi.ModTime()) < interval {
+			return "", nil
+		}
+	}
+	if err := touchFile(stamp); err != nil {
+		return "", fmt.Errorf("record refresh time: %w", err)
+	}
+
+	current := strings.Join(readFRPCFriendlyNames(frpcPath), ",")
+	ids := autoDetectIdentities()
+	detected := strings.Join(ids, ",")
+	if len(ids) == 0 {
+		return fmt.Sprintf("[friendly-name] no iMessage identity detected; keeping %q", current), nil
+	}
+	if err := os.Remove(marker); err != nil && !os.IsNotExist(err) {
+		return "", fmt.Errorf("clear manual name marker: %w", err)
+	}
+	if detected == current {
+		if ifDue {
+			return "", nil
+		}
+		return fmt.Sprintf("[friendly-name] unchanged (%s)", current), nil
+	}
+
+	if err := setFRPCFriendlyNames(frpcPath, ids); err != nil {
+		return "", fmt.Errorf("update frpc.toml: %w", err)
+	}
+	username, err := currentUsername()
+	if err != nil {
+		return "", fmt.Errorf("friendly name updated, but failed to restart frpc: %w", err)
+	}
+	if err := launchctl("kickstart", "-k", "system/"+fmt.Sprintf(launchDaemonFRPCLabel, username)); err != nil {
+		return "", fmt.Errorf("friendly name updated, but failed to restart frpc: %w", err)
+	}
+	return fmt.Sprintf("[friendly-name] changed from %q to %q; restarted frpc", current, detected), nil
+}
+
+// touchFile creates path if needed and sets its modification time to now.
+func touchFile(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	now := time.Now()
+	if err := os.Chtimes(path, now, now); err == nil {
+		return nil
+	}
+	return os.WriteFile(path, nil, 0o644)
+}
@@ -0,0 +1,32 @@
+//go:build darwin
+
+package userinfra
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRefreshFriendlyNameSkipsManualName(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	serviceDir := filepath.Join(home, "services", "imsg")
+	if err := os.MkdirAll(serviceDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(serviceDir, "config.json"), []byte(`{"friendly_name_refresh_minutes": 1}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(serviceDir, friendlyNameManualMarker), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	line, err := RefreshFriendlyName(true)
+	if err != nil || line != "" {
+		t.Fatalf("RefreshFriendlyName(true) = %q, %v; want a silent skip", line, err)
+	}
+	if _, err := os.Stat(filepath.Join(home, "Library", "Caches", friendlyRefreshStamp)); !os.IsNotExist(err) {
+		t.Errorf("refresh stamp written for a manual name: %v", err)
+	}
+}
@@ -44,43 +44,157 @@ ORDER BY
 LIMIT 1;
 `
 
+// chatDBIdentitiesQuery lists the distinct caller IDs the user has sent
+// iMessages from, in the same priority order as chatDBAccountQuery.
+const chatDBIdentitiesQuery = `
+SELECT my_account FROM (
+  SELECT
+    CASE
+      WHEN destination_caller_id IS NOT NULL AND destination_caller_id != '' THEN destination_caller_id
+      WHEN account LIKE 'P:%' THEN SUBSTR(account, 3)
+      WHEN account LIKE 'E:%' THEN SUBSTR(account, 3)
+      WHEN account LIKE 'e:%' THEN SUBSTR(account, 3)
+      ELSE account
+    END AS my_account,
+    MIN(CASE
+      WHEN destination_caller_id IS NOT NULL AND destination_caller_id != '' THEN 0
+      WHEN account LIKE 'P:%' THEN 1
+      WHEN account LIKE 'E:%' THEN 2
+      WHEN account LIKE 'e:%' THEN 2
+      ELSE 3
+    END) AS rank
+  FROM message
+  WHERE is_from_me = 1
+    AND (
+      (destination_caller_id IS NOT NULL AND destination_caller_id != '')
+      OR (account IS NOT NULL AND account != '')
+    )
+  GROUP BY my_account
+)
+ORDER BY rank
+LIMIT 20;
+`
+
 func hasNonEmptyFriendlyName(path string) bool {
+	return readFRPCFriendlyName(path) != ""
+}
+
+// readFRPCFriendlyName returns the first non-empty friendlyName in the proxies
+// of frpc.toml at path, or "" when there is none or the file is unreadable.
+func readFRPCFriendlyName(path string) string {
 	data, err := os.ReadFile(path)
 	if err != nil {
-		return false
+		return ""
 	}
 
 	tree, err := toml.LoadBytes(data)
 	if err != nil {
-		return false
+		return ""
 	}
 
-	raw := tree.Get("proxies")
-	if raw == nil {
-		return false
+	proxies, ok := tree.Get("proxies").([]*toml.Tree)
+	if !ok {
+		return ""
+	}
+	for _, proxy := range proxies {
+		if proxy == nil {
+			continue
+		}
+		metaTree, ok := proxy.Get("metadatas").(*toml.Tree)
+		if !ok {
+			continue
+		}
+		if val, ok := metaTree.Get("friendlyName").(string); ok && strings.TrimSpace(val) != "" {
+			return strings.TrimSpace(val)
+		}
 	}
+	return ""
+}
 
-	switch v := raw.(type) {
-	case []*toml.Tree:
-		for _, proxy := range v {
+// readFRPCFriendlyNames returns the identities recorded in frpc.toml at path:
+// the friendlyNames list, or just friendlyName when there is no list.
+func readFRPCFriendlyNames(path string) []string {
+	primary := readFRPCFriendlyName(path)
+	if primary == "" {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return []string{primary}
+	}
+	tree, err := toml.LoadBytes(data)
+	if err != nil {
+		return []string{primary}
+	}
+	if proxies, ok := tree.Get("proxies").([]*toml.Tree); ok {
+		for _, proxy := range proxies {
 			if proxy == nil {
 				continue
 			}
-			metaRaw := proxy.Get("metadatas")
-			if metaRaw == nil {
-				continue
-			}
-			metaTree, ok := metaRaw.(*toml.Tree)
+			metaTree, ok := proxy.Get("metadatas").(*toml.Tree)
 			if !ok {
 				continue
 			}
-			if val, ok := metaTree.Get("friendlyName").(string); ok && strings.TrimSpace(val) != "" {
-				return true
+			if val, ok := metaTree.Get("friendlyNames").(string); ok && strings.TrimSpace(val) != "" {
+				return appendIdentities(nil, strings.Split(val, ",")...)
+			}
+		}
+	}
+	return []string{primary}
+}
+
+// autoDetectIdentities returns every phone number and email the user's
+// iMessage account uses, from IMD-IDS-Aliases and chat.db. The first entry
+// is autoDetectFriendlyName; it returns nil when that finds nothing.
+func autoDetectIdentities() []string {
+	primary := strings.TrimSpace(autoDetectFriendlyName())
+	if primary == "" {
+		return nil
+	}
+	ids := []string{primary}
+
+	if out, err := exec.Command("defaults", "read", "com.apple.madrid", "IMD-IDS-Aliases").CombinedOutput(); err == nil {
+		ids = appendIdentities(ids, phoneRe.FindAllString(string(out), -1)...)
+		ids = appendIdentities(ids, emailRe.FindAllString(string(out), -1)...)
+	}
+
+	if home, err := os.UserHomeDir(); err == nil {
+		chatDB := filepath.Join(home, "Library", "Messages", "chat.db")
+		if _, err := os.Stat(chatDB); err == nil {
+			if out, err := exec.Command("sqlite3", chatDB, chatDBIdentitiesQuery).CombinedOutput(); err == nil {
+				for _, line := range strings.Split(string(out), "\n") {
+					if phone := extractPhone(line); phone != "" {
+						ids = appendIdentities(ids, phone)
+					} else if email := extractEmail(line); email != "" {
+						ids = appendIdentities(ids, email)
+					}
+				}
 			}
 		}
 	}
+	return ids
+}
 
-	return false
+// appendIdentities appends the non-empty ids not yet in list, comparing
+// case-insensitively.
+func appendIdentities(list []string, ids ...string) []string {
+	for _, id := range ids {
+		id = strings.TrimSpace(id)
+		if id == "" {
+			continue
+		}
+		dup := false
+		for _, have := range list {
+			if strings.EqualFold(have, id) {
+				dup = true
+				break
+			}
+		}
+		if !dup {
+			list = append(list, id)
+		}
+	}
+	return list
 }
 
 func autoDetectFriendlyName() string {
@@ -164,20 +278,38 @@ func detectFromChatDB(home string) string {
 	return ""
 }
 
+var (
+	phoneRe = regexp.MustCompile(`\+[0-9]{7,15}`)
+	emailRe = regexp.MustCompile(`(?i)[A-Z0-9._%+-]+@[A-Z0-9.-]+\.[A-Z]{2,}`)
+)
+
 func extractPhone(s string) string {
-	re := regexp.MustCompile(`\+[0-9]{7,15}`)
-	return re.FindString(s)
+	return phoneRe.FindString(s)
 }
 
 func extractEmail(s string) string {
-	re := regexp.MustCompile(`(?i)[A-Z0-9._%+-]+@[A-Z0-9.-]+\.[A-Z]{2,}`)
-	return re.FindString(s)
+	return emailRe.FindString(s)
 }
 
 func setFRPCFriendlyName(path, name string) error {
-	if msg := validateFriendlyName(name); msg != "" {
-		return fmt.Errorf("friendly name is invalid: %s", msg)
+	return setFRPCFriendlyNames(path, []string{name})
+}
+
+// setFRPCFriendlyNames writes names[0] as friendlyName and all names,
+// comma-joined, as friendlyNames in every proxy of frpc.toml at path.
+func setFRPCFriendlyNames(path string, names []string) error {
+	if len(names) == 0 {
+		return fmt.Errorf("no friendly name given")
+	}
+	for i, name := range names {
+		if msg := validateFriendlyName(name); msg != "" {
+			return fmt.Errorf("friendly name is invalid: %s", msg)
+		}
+		if i > 0 && strings.ContainsRune(name, ',') {
+			return fmt.Errorf("friendly name %q must not contain commas", name)
+		}
 	}
+	name := names[0]
 
 	data, err := os.ReadFile(path)
 	if err != nil {
@@ -220,6 +352,7 @@ func setFRPCFriendlyName(path, name string) error {
 				meta = mTree
 			}
 			meta.Set("friendlyName", name)
+			meta.Set("friendlyNames", strings.Join(names, ","))
 		}
 	default:
 		return fmt.Errorf("unexpected proxies type in frpc.toml")
@@ -0,0 +1,48 @@
+//go:build darwin
+
+package userinfra
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	inframacos "prism/internal/infra/host"
+)
+
+// KeepaliveStatus reports the state of the current user's keepalive agent.
+func KeepaliveStatus() string {
+	username, err := currentUsername()
+	if err != nil {
+		return fmt.Sprintf("Failed to check keepalive: %v", err)
+	}
+	ks, err := inframacos.CheckKeepalive(context.Background(), username)
+	if err != nil {
+		return fmt.Sprintf("Failed to check keepalive: %v", err)
+	}
+	return "Keepalive: " + ks.Summary() + "."
+}
+
+// StartKeepalive re-deploys the keepalive script and LaunchAgent and
+// (re)starts it in the current GUI session.
+func StartKeepalive() string {
+	username, err := currentUsername()
+	if err != nil {
+		return fmt.Sprintf("Failed to start keepalive: %v", err)
+	}
+	if err := inframacos.EnsureKeepaliveService(context.Background(), username); err != nil {
+		return fmt.Sprintf("Failed to deploy keepalive: %v", err)
+	}
+	if err := launchctl("kickstart", "-k", fmt.Sprintf("gui/%d/%s", os.Getuid(), inframacos.KeepaliveLabel)); err != nil {
+		return fmt.Sprintf("Failed to start keepalive: %v", err)
+	}
+	return "Started keepalive. " + KeepaliveStatus()
+}
+
+// StopKeepalive unloads the keepalive agent until the next login.
+func StopKeepalive() string {
+	if err := launchctl("bootout", fmt.Sprintf("gui/%d/%s", os.Getuid(), inframacos.KeepaliveLabel)); err != nil {
+		return fmt.Sprintf("Failed to stop keepalive: %v", err)
+	}
+	return "Stopped keepalive. It starts again at the next login, or use 'Start keepalive'."
+}
@@ -5,103 +5,368 @@ package userinfra
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
 	"os"
+	"os/exec"
 	"os/user"
 	"path/filepath"
 	"strings"
 	"time"
 )
 
-// GetAPIKey requests a one-time API key from Nexus.
-func GetAPIKey() string {
+const (
+	envNexusToken = "NEXUS_TOKEN"
+
+	defaultKeyCreatePath = "/keys/create"
+	keyListPath          = "/keys/list"
+	keyRevokePath        = "/keys/revoke"
+	defaultNexusTimeout  = 5 * time.Second
+)
+
+// nexusClient holds the per-user Nexus identity and endpoint settings read
+// from the service config.json.
+type nexusClient struct {
+	baseURL       string
+	machineID     string
+	userID        string
+	keyCreatePath string
+	timeout       time.Duration
+	authToken     string
+	clientCert    string
+	clientKey     string
+}
+
+// nexusReply is the common envelope of Nexus key responses.
+type nexusReply struct {
+	OK     bool   `json:"ok"`
+	Reason string `json:"reason"`
+}
+
+// APIKeyInfo describes an active API key as reported by Nexus.
+type APIKeyInfo struct {
+	ID        string `json:"id"`
+	Prefix    string `json:"prefix,omitempty"`
+	CreatedAt string `json:"createdAt,omitempty"`
+}
+
+// nexusEndpoint joins the Nexus base URL with path (or fallback when empty)
+// and verifies the result is an absolute http(s) URL.
+func nexusEndpoint(baseURL, path, fallback string) (string, error) {
+	path = strings.TrimSpace(path)
+	if path == "" {
+		path = fallback
+	}
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	endpoint := baseURL + path
+	u, err := url.Parse(endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", fmt.Errorf("invalid Nexus endpoint %q", endpoint)
+	}
+	return endpoint, nil
+}
+
+func nexusTimeout(seconds int) time.Duration {
+	if seconds <= 0 {
+		return defaultNexusTimeout
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// loadNexusClient reads the current user's service config.json.
+func loadNexusClient() (nexusClient, error) {
 	home, err := os.UserHomeDir()
 	if err != nil {
-		return fmt.Sprintf("Failed to get API key: unable to determine user home directory: %v", err)
+		return nexusClient{}, fmt.Errorf("unable to determine user home directory: %v", err)
 	}
 	serviceDir := filepath.Join(home, "services", "imsg")
 	configPath := filepath.Join(serviceDir, "config.json")
 	data, err := os.ReadFile(configPath)
 	if err != nil {
-		return fmt.Sprintf("Failed to get API key: error reading config.json: %v", err)
+		return nexusClient{}, fmt.Errorf("error reading config.json: %v", err)
 	}
 
 	var cfg struct {
 		Username  string `json:"username"`
 		MachineID string `json:"machine_id"`
 		NexusAddr string `json:"nexus_addr"`
+
+		NexusKeyCreatePath  string `json:"nexus_key_create_path"`
+		NexusTimeoutSeconds int    `json:"nexus_timeout_seconds"`
+		NexusAuthToken      string `json:"nexus_auth_token"`
+		NexusClientCert     string `json:"nexus_client_cert"`
+		NexusClientKey      string `json:"nexus_client_key"`
 	}
 	if err := json.Unmarshal(data, &cfg); err != nil {
-		return fmt.Sprintf("Failed to get API key: error parsing config.json: %v", err)
+		return nexusClient{}, fmt.Errorf("error parsing config.json: %v", err)
 	}
 	baseURL := strings.TrimRight(strings.TrimSpace(cfg.NexusAddr), "/")
 	if baseURL == "" {
-		return "Failed to get API key: config.json is missing nexus_addr."
+		return nexusClient{}, errors.New("config.json is missing nexus_addr.")
 	}
 	if strings.TrimSpace(cfg.MachineID) == "" {
-		return "Failed to get API key: config.json is missing machine_id."
+		return nexusClient{}, errors.New("config.json is missing machine_id.")
 	}
 	if strings.TrimSpace(cfg.Username) == "" {
 		u, err := user.Current()
 		if err != nil || strings.TrimSpace(u.Username) == "" {
-			return "Failed to get API key: config.json is missing username and the system username could not be determined."
+			return nexusClient{}, errors.New("config.json is missing username and the system username could not be determined.")
 		}
 		cfg.Username = u.Username
 	}
 
-	endpoint := baseURL + "/keys/create"
-	payload := struct {
-		MachineID string `json:"machineId"`
-		UserID    string `json:"userId"`
-	}{
-		MachineID: cfg.MachineID,
-		UserID:    cfg.Username,
+	// NEXUS_TOKEN overrides config.json, as it overrides nexus.auth_token
+	// when the host writes config.json.
+	authToken := strings.TrimSpace(os.Getenv(envNexusToken))
+	if authToken == "" {
+		authToken = strings.TrimSpace(cfg.NexusAuthToken)
+	}
+
+	return nexusClient{
+		baseURL:       baseURL,
+		machineID:     cfg.MachineID,
+		userID:        cfg.Username,
+		keyCreatePath: cfg.NexusKeyCreatePath,
+		timeout:       nexusTimeout(cfg.NexusTimeoutSeconds),
+		authToken:     authToken,
+		clientCert:    cfg.NexusClientCert,
+		clientKey:     cfg.NexusClientKey,
+	}, nil
+}
+
+// httpClient returns the HTTP client for Nexus calls, configured for mutual
+// TLS when a client certificate is set.
+func (c nexusClient) httpClient() (*http.Client, error) {
+	client := &http.Client{Timeout: c.timeout}
+	if c.clientCert == "" {
+		return client, nil
 	}
-	body, err := json.Marshal(&payload)
+	cert, err := tls.LoadX509KeyPair(c.clientCert, c.clientKey)
 	if err != nil {
-		return fmt.Sprintf("Failed to get API key: error encoding request: %v", err)
+		return nil, fmt.Errorf("error loading Nexus client certificate: %v", err)
+	}
+	client.Transport = &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		TLSClientConfig: &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			MinVersion:   tls.VersionTLS12,
+		},
+	}
+	return client, nil
+}
+
+// post sends payload as JSON to path and decodes the response into out.
+// The returned error is phrased for display after a "Failed to ...:" prefix.
+func (c nexusClient) post(path, fallback string, payload, out any) error {
+	endpoint, err := nexusEndpoint(c.baseURL, path, fallback)
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("error encoding request: %v", err)
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
 	defer cancel()
 	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
 	if err != nil {
-		return fmt.Sprintf("Failed to get API key: error constructing request: %v", err)
+		return fmt.Errorf("error constructing request: %v", err)
 	}
 	req.Header.Set("Content-Type", "application/json")
+	if c.authToken != "" {
+		req.Header.Set("Authorization", "Bearer "+c.authToken)
+	}
 
-	client := &http.Client{Timeout: 5 * time.Second}
+	client, err := c.httpClient()
+	if err != nil {
+		return err
+	}
 	resp, err := client.Do(req)
 	if err != nil {
-		return fmt.Sprintf("Failed to get API key: error calling Nexus: %v", err)
+		return fmt.Errorf("error calling Nexus: %v", err)
 	}
 	defer func() { _ = resp.Body.Close() }()
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return fmt.Sprintf("Failed to get API key: Nexus returned status %s", resp.Status)
+		return fmt.Errorf("Nexus returned status %s", resp.Status)
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("error decoding response: %v", err)
+	}
+	return nil
+}
+
+func nexusReplyError(r nexusReply) error {
+	if r.OK {
+		return nil
+	}
+	if strings.TrimSpace(r.Reason) == "" {
+		r.Reason = "unknown-error"
+	}
+	return fmt.Errorf("Nexus returned error: %s", r.Reason)
+}
+
+// RequestAPIKey asks Nexus for a new one-time API key and returns the raw key.
+func RequestAPIKey() (string, error) {
+	c, err := loadNexusClient()
+	if err != nil {
+		return "", err
+	}
+
+	payload := struct {
+		MachineID string `json:"machineId"`
+		UserID    string `json:"userId"`
+	}{
+		MachineID: c.machineID,
+		UserID:    c.userID,
 	}
 
 	var decoded struct {
-		OK     bool   `json:"ok"`
-		Reason string `json:"reason"`
+		nexusReply
 		APIKey string `json:"apiKey"`
 	}
-	if err := json.NewDecoder(resp.Body).Decode(&decoded); err != nil {
-		return fmt.Sprintf("Failed to get API key: error decoding response: %v", err)
+	if err := c.post(c.keyCreatePath, defaultKeyCreatePath, &payload, &decoded); err != nil {
+		return "", err
 	}
-	if !decoded.OK {
-		if strings.TrimSpace(decoded.Reason) == "" {
-			decoded.Reason = "unknown-error"
-		}
-		return fmt.Sprintf("Failed to get API key: Nexus returned error: %s", decoded.Reason)
+	if err := nexusReplyError(decoded.nexusReply); err != nil {
+		return "", err
 	}
 	if strings.TrimSpace(decoded.APIKey) == "" {
-		return "Failed to get API key: Nexus returned an empty apiKey."
+		return "", errors.New("Nexus returned an empty apiKey")
 	}
+	return decoded.APIKey, nil
+}
+
+// GetAPIKey requests a one-time API key from Nexus.
+func GetAPIKey() string {
+	key, err := RequestAPIKey()
+	if err != nil {
+		return fmt.Sprintf("Failed to get API key: %v", err)
+	}
+	return FormatAPIKeyStatus(key)
+}
 
+// FormatAPIKeyStatus renders the one-time key notice shown to the user.
+func FormatAPIKeyStatus(key string) string {
 	return fmt.Sprintf(
 		"One-time API key (displayed only once; please copy and store it securely now): %s",
-		decoded.APIKey,
+		key,
 	)
 }
+
+// CopyToClipboard places s on the macOS clipboard via pbcopy.
+func CopyToClipboard(s string) error {
+	cmd := exec.Command("pbcopy")
+	cmd.Stdin = strings.NewReader(s)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("pbcopy: %w (output=%s)", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// RenderQRCode renders s as a terminal QR code using qrencode
+// (brew install qrencode).
+func RenderQRCode(s string) (string, error) {
+	bin, err := exec.LookPath("qrencode")
+	if err != nil {
+		for _, p := range []string{"/opt/homebrew/bin/qrencode", "/usr/local/bin/qrencode"} {
+			if _, statErr := os.Stat(p); statErr == nil {
+				bin, err = p, nil
+				break
+			}
+		}
+	}
+	if err != nil {
+		return "", errors.New("qrencode not found; install it with: brew install qrencode")
+	}
+	out, err := exec.Command(bin, "-t", "UTF8", "-m", "1", s).Output()
+	if err != nil {
+		return "", fmt.Errorf("qrencode: %w", err)
+	}
+	return string(out), nil
+}
+
+// ListAPIKeys lists the active API keys Nexus holds for this user. Key secrets
+// are never returned; only identifiers and metadata.
+func ListAPIKeys() string {
+	c, err := loadNexusClient()
+	if err != nil {
+		return fmt.Sprintf("Failed to list API keys: %v", err)
+	}
+
+	payload := struct {
+		MachineID string `json:"machineId"`
+		UserID    string `json:"userId"`
+	}{
+		MachineID: c.machineID,
+		UserID:    c.userID,
+	}
+
+	var decoded struct {
+		nexusReply
+		Keys []APIKeyInfo `json:"keys"`
+	}
+	if err := c.post(keyListPath, keyListPath, &payload, &decoded); err != nil {
+		return fmt.Sprintf("Failed to list API keys: %v", err)
+	}
+	if err := nexusReplyError(decoded.nexusReply); err != nil {
+		return fmt.Sprintf("Failed to list API keys: %v", err)
+	}
+	if len(decoded.Keys) == 0 {
+		return "No active API keys."
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Active API keys (%d):", len(decoded.Keys))
+	for _, k := range decoded.Keys {
+		line := "\n- " + k.ID
+		if k.Prefix != "" {
+			line += " (" + k.Prefix + "…)"
+		}
+		if k.CreatedAt != "" {
+			line += " created " + k.CreatedAt
+		}
+		b.WriteString(line)
+	}
+	return b.String()
+}
+
+// RevokeAPIKey revokes the API key with the given id.
+func RevokeAPIKey(id string) string {
+	id = strings.TrimSpace(id)
+	if id == "" {
+		return "Failed to revoke API key: key id is empty."
+	}
+
+	c, err := loadNexusClient()
+	if err != nil {
+		return fmt.Sprintf("Failed to revoke API key: %v", err)
+	}
+
+	payload := struct {
+		MachineID string `json:"machineId"`
+		UserID    string `json:"userId"`
+		KeyID     string `json:"keyId"`
+	}{
+		MachineID: c.machineID,
+		UserID:    c.userID,
+		KeyID:     id,
+	}
+
+	var decoded nexusReply
+	if err := c.post(keyRevokePath, keyRevokePath, &payload, &decoded); err != nil {
+		return fmt.Sprintf("Failed to revoke API key: %v", err)
+	}
+	if err := nexusReplyError(decoded); err != nil {
+		return fmt.Sprintf("Failed to revoke API key: %v", err)
+	}
+
+	return fmt.Sprintf("Revoked API key %s.", id)
+}
@@ -0,0 +1,136 @@
+//go:build darwin
+
+package userinfra
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// recentLogLines is how many trailing lines of each log are shown.
+	recentLogLines = 20
+	// recentLogBytes bounds how much of a log is read, so a large log does
+	// not stall the TUI.
+	recentLogBytes = 64 << 10
+)
+
+// errorKeywords mark a log line as an error, matched case-insensitively.
+var errorKeywords = []string{"error", "fail", "fatal", "panic", "exception", "refused", "denied", "timeout", "timed out"}
+
+// LogLine is one line of a log tail; Error is set when it contains an error
+// keyword.
+type LogLine struct {
+	Text  string `json:"text"`
+	Error bool   `json:"error"`
+}
+
+// LogTail is the end of one service log.
+type LogTail struct {
+	Name    string    `json:"name"`
+	Path    string    `json:"path"`
+	Missing bool      `json:"missing,omitempty"`
+	Lines   []LogLine `json:"lines,omitempty"`
+	Err     string    `json:"error,omitempty"`
+}
+
+// RecentErrors reads the tail of the current user's server and frpc error
+// logs.
+func RecentErrors() []LogTail {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return []LogTail{{Name: "logs", Err: err.Error()}}
+	}
+	logsDir := filepath.Join(home, "Library", "Logs")
+
+	var tails []LogTail
+	for _, l := range []struct{ name, file string }{
+		{"Prism server", "imsg-server.err"},
+		{"frpc", "frpc.err"},
+	} {
+		t := LogTail{Name: l.name, Path: filepath.Join(logsDir, l.file)}
+		lines, err := readLogTail(t.Path, recentLogLines)
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+			t.Missing = true
+		case err != nil:
+			t.Err = err.Error()
+		}
+		for _, line := range lines {
+			t.Lines = append(t.Lines, LogLine{Text: line, Error: isErrorLine(line)})
+		}
+		tails = append(tails, t)
+	}
+	return tails
+}
+
+// RecentErrorsStatus summarizes tails for the status line.
+func RecentErrorsStatus(tails []LogTail) string {
+	errs := 0
+	for _, t := range tails {
+		for _, l := range t.Lines {
+			if l.Error {
+				errs++
+			}
+		}
+	}
+	if errs == 0 {
+		return "No error lines in the recent server and frpc logs."
+	}
+	return fmt.Sprintf("Found %d error lines in the recent server and frpc logs; see below.", errs)
+}
+
+// readLogTail returns the last n non-empty lines of path, reading at most
+// recentLogBytes from its end.
+func readLogTail(path string, n int) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	offset := fi.Size() - recentLogBytes
+	if offset < 0 {
+		offset = 0
+	}
+	data, err := io.ReadAll(io.NewSectionReader(f, offset, fi.Size()-offset))
+	if err != nil {
+		return nil, err
+	}
+	if offset > 0 {
+		// Drop the partial line the read started in.
+		if i := bytes.IndexByte(data, '\n'); i >= 0 {
+			data = data[i+1:]
+		}
+	}
+
+	var lines []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if line = strings.TrimRight(line, "\r"); strings.TrimSpace(line) != "" {
+			lines = append(lines, line)
+		}
+	}
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return lines, nil
+}
+
+func isErrorLine(line string) bool {
+	lower := strings.ToLower(line)
+	for _, k := range errorKeywords {
+		if strings.Contains(lower, k) {
+			return true
+		}
+	}
+	return false
+}
@@ -4,6 +4,7 @@ package userinfra
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
@@ -12,18 +13,62 @@ import (
 	"time"
 )
 
+// PermissionState is the classified state of a single macOS permission.
+type PermissionState string
+
+const (
+	PermissionGranted PermissionState = "granted"
+	PermissionDenied  PermissionState = "denied"
+	PermissionUnknown PermissionState = "unknown"
+)
+
+// PermissionCheck describes one permission probed during prewarm.
+type PermissionCheck struct {
+	Name   string          `json:"name"`
+	State  PermissionState `json:"state"`
+	Detail string          `json:"detail,omitempty"`
+}
+
+// PrewarmReport is the structured outcome of PrewarmPermissionsReport.
+type PrewarmReport struct {
+	Checks   []PermissionCheck `json:"checks"`
+	Warnings []string          `json:"warnings,omitempty"`
+	Message  string            `json:"message"`
+}
+
+const (
+	tccServiceAppleEvents   = "kTCCServiceAppleEvents"
+	tccServiceAccessibility = "kTCCServiceAccessibility"
+)
+
+// serverApp is the server app in the user's service directory; TCC grants
+// for it are what the server relies on.
+var serverApp = filepath.Join("services", "imsg", "iMessageKitServer.app")
+
 // PrewarmPermissions performs permission prewarm for the current macOS user.
 func PrewarmPermissions() string {
+	return PrewarmPermissionsReport().Message
+}
+
+// PrewarmPermissionsReport performs permission prewarm and returns a
+// per-permission checklist alongside the human-readable message.
+func PrewarmPermissionsReport() PrewarmReport {
 	home, err := os.UserHomeDir()
 	if err != nil {
-		return fmt.Sprintf("Permission prewarm failed: unable to determine user home directory: %v", err)
+		return PrewarmReport{
+			Message: fmt.Sprintf("Permission prewarm failed: unable to determine user home directory: %v", err),
+		}
 	}
 
-	var warns []string
+	var (
+		warns  []string
+		checks []PermissionCheck
+	)
 
 	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
 	defer cancel()
 
+	libCheck := PermissionCheck{Name: "DisableLibraryValidation", State: PermissionGranted}
 	if out, err := exec.CommandContext(
 		ctx,
 		"defaults",
@@ -31,6 +76,7 @@ func PrewarmPermissions() string {
 		"/Library/Preferences/com.apple.security.libraryvalidation.plist",
 		"DisableLibraryValidation",
 	).CombinedOutput(); err != nil {
+		libCheck.State = PermissionUnknown
 		warns = append(
 			warns,
 			"Unable to read DisableLibraryValidation; please set it to true on "+
@@ -39,6 +85,8 @@ func PrewarmPermissions() string {
 	} else {
 		val := strings.ToLower(strings.TrimSpace(string(out)))
 		if val != "1" && val != "true" {
+			libCheck.State = PermissionDenied
+			libCheck.Detail = val
 			warns = append(
 				warns,
 				fmt.Sprintf(
@@ -48,54 +96,168 @@ func PrewarmPermissions() string {
 			)
 		}
 	}
+	checks = append(checks, libCheck)
 
-	msgDir := filepath.Join(home, "Library", "Messages")
-	if fi, err := os.Stat(msgDir); err != nil || !fi.IsDir() {
-		warns = append(
-			warns,
-			"Could not find ~/Library/Messages; it looks like Messages has not been used yet. "+
-				"Please open Messages with this account and send at least one iMessage so Prism can later detect your phone number or email.",
-		)
-	} else {
-		if _, err := os.ReadDir(msgDir); err != nil {
-			warns = append(
-				warns,
-				"Unable to list ~/Library/Messages; please ensure the terminal/app "+
-					"running Prism has Full Disk Access.",
-			)
-		}
-		chatDB := filepath.Join(msgDir, "chat.db")
-		if f, err := os.Open(chatDB); err != nil {
-			warns = append(
-				warns,
-				"Could not open ~/Library/Messages/chat.db; Messages may not have been used yet. "+
-					"If this is a new iMessage account, open Messages and send at least one iMessage so Prism can later detect your phone number or email.",
-			)
-		} else {
-			buf := make([]byte, 4096)
-			_, _ = f.Read(buf)
-			_ = f.Close()
-		}
+	fdaCheck := checkChatDBAccess(ctx, home)
+	checks = append(checks, fdaCheck)
+	if fdaCheck.State != PermissionGranted {
+		warns = append(warns, fdaCheck.Detail)
 	}
 
-	runOSA := func(desc, script string) {
+	clients := tccClients(ctx, home)
+	runOSA := func(name, service, target, script string) {
+		check := PermissionCheck{Name: name, State: PermissionGranted}
+		// A denied script may still exit 0 and only report the error.
+		var stderr strings.Builder
 		cmd := exec.CommandContext(ctx, "osascript", "-e", script)
-		if err := cmd.Run(); err != nil {
-			warns = append(warns, fmt.Sprintf("%s may not be authorized yet (osascript failed).", desc))
+		cmd.Stderr = &stderr
+		err := cmd.Run()
+		if msg := strings.TrimSpace(stderr.String()); err != nil || msg != "" {
+			check.State = PermissionDenied
+			check.Detail = "osascript failed"
+			if msg != "" {
+				check.Detail += ": " + msg
+			}
+			warns = append(warns, fmt.Sprintf("%s may not be authorized yet (osascript failed).", name))
+		}
+		// The TCC database is authoritative where we can read it.
+		if granted, ok := queryTCC(ctx, home, service, target, clients); ok {
+			if granted {
+				check.State = PermissionGranted
+			} else {
+				check.State = PermissionDenied
+				check.Detail = "not granted in TCC database"
+			}
 		}
+		checks = append(checks, check)
 	}
 
-	runOSA("Messages automation", "tell application \"Messages\"\nactivate\ntry\nget name of first chat\nend try\nend tell")
-	runOSA("System Events accessibility", "tell application \"System Events\"\nset _ to name of first process\nend tell")
+	runOSA("Messages automation", tccServiceAppleEvents, "com.apple.MobileSMS", "tell application \"Messages\"\nactivate\ntry\nget name of first chat\nend try\nend tell")
+	runOSA("System Events accessibility", tccServiceAccessibility, "", "tell application \"System Events\"\nset _ to name of first process\nend tell")
 
 	markerDir := filepath.Join(home, ".prism")
 	markerPath := filepath.Join(markerDir, "perms-prewarmed")
 	_ = os.MkdirAll(markerDir, 0o700)
 	_ = os.WriteFile(markerPath, []byte(time.Now().Format(time.RFC3339)), 0o600)
 
+	report := PrewarmReport{Checks: checks, Warnings: warns}
 	if len(warns) == 0 {
-		return "Permission prewarm completed: checked DisableLibraryValidation and attempted to access Messages and System Events. If you continue to see permission prompts, please grant access in System Settings."
+		report.Message = "Permission prewarm completed: checked DisableLibraryValidation and attempted to access Messages and System Events. If you continue to see permission prompts, please grant access in System Settings."
+	} else {
+		report.Message = "Permission prewarm completed, but some items may require manual attention:\n- " + strings.Join(warns, "\n- ")
 	}
+	return report
+}
+
+// checkChatDBAccess performs a real sqlite3 read of chat.db and distinguishes
+// a missing Full Disk Access grant from a Messages account that was never used.
+func checkChatDBAccess(ctx context.Context, home string) PermissionCheck {
+	check := PermissionCheck{Name: "Full Disk Access"}
+
+	msgDir := filepath.Join(home, "Library", "Messages")
+	chatDB := filepath.Join(msgDir, "chat.db")
+
+	if _, err := os.Stat(msgDir); errors.Is(err, os.ErrNotExist) {
+		check.State = PermissionUnknown
+		check.Detail = "Could not find ~/Library/Messages; it looks like Messages has not been used yet. " +
+			"Please open Messages with this account and send at least one iMessage so Prism can later detect your phone number or email."
+		return check
+	}
+
+	if _, err := os.ReadDir(msgDir); err != nil {
+		check.State = PermissionDenied
+		check.Detail = "Unable to list ~/Library/Messages; please ensure the terminal/app " +
+			"running Prism has Full Disk Access."
+		return check
+	}
+
+	if _, err := os.Stat(chatDB); errors.Is(err, os.ErrNotExist) {
+		check.State = PermissionUnknown
+		check.Detail = "Could not find ~/Library/Messages/chat.db; Messages may not have been used yet. " +
+			"If this is a new iMessage account, open Messages and send at least one iMessage so Prism can later detect your phone number or email."
+		return check
+	}
+
+	out, err := exec.CommandContext(ctx, "sqlite3", "-readonly", chatDB, "SELECT 1 FROM message LIMIT 1;").CombinedOutput()
+	if err != nil {
+		lower := strings.ToLower(string(out))
+		if strings.Contains(lower, "authorization denied") || strings.Contains(lower, "operation not permitted") ||
+			strings.Contains(lower, "unable to open") {
+			check.State = PermissionDenied
+			check.Detail = "Reading ~/Library/Messages/chat.db was denied; please grant Full Disk Access to the terminal/app running Prism."
+			return check
+		}
+		check.State = PermissionUnknown
+		check.Detail = fmt.Sprintf("Reading ~/Library/Messages/chat.db failed: %s", strings.TrimSpace(string(out)))
+		return check
+	}
+
+	if strings.TrimSpace(string(out)) == "" {
+		check.State = PermissionGranted
+		check.Detail = "chat.db is readable but has no messages yet; send at least one iMessage."
+		return check
+	}
+
+	check.State = PermissionGranted
+	return check
+}
+
+// queryTCC looks up whether one of clients has been granted the TCC service,
+// for target when set (the AppleEvents receiver), in the user's or system TCC
+// database. ok is false when neither database is readable (it requires Full
+// Disk Access) or has no matching entry.
+func queryTCC(ctx context.Context, home, service, target string, clients []string) (granted bool, ok bool) {
+	if len(clients) == 0 {
+		return false, false
+	}
+	dbs := []string{
+		filepath.Join(home, "Library", "Application Support", "com.apple.TCC", "TCC.db"),
+		"/Library/Application Support/com.apple.TCC/TCC.db",
+	}
+	quoted := make([]string, len(clients))
+	for i, c := range clients {
+		quoted[i] = sqlQuote(c)
+	}
+	query := "SELECT MAX(auth_value) FROM access WHERE service = " + sqlQuote(service) +
+		" AND client IN (" + strings.Join(quoted, ", ") + ")"
+	if target != "" {
+		query += " AND indirect_object_identifier = " + sqlQuote(target)
+	}
+	query += ";"
+	for _, db := range dbs {
+		out, err := exec.CommandContext(ctx, "sqlite3", "-readonly", db, query).CombinedOutput()
+		if err != nil {
+			continue
+		}
+		val := strings.TrimSpace(string(out))
+		if val == "" {
+			continue
+		}
+		// auth_value 2 = allowed, 3 = limited; 0 = denied.
+		return val == "2" || val == "3", true
+	}
+	return false, false
+}
+
+// tccClients returns the TCC client identifiers of the server: its bundle id
+// and executable path, plus the path of this binary, which runs the checks.
+func tccClients(ctx context.Context, home string) []string {
+	app := filepath.Join(home, serverApp)
+	var clients []string
+	out, err := exec.CommandContext(ctx, "plutil", "-extract", "CFBundleIdentifier", "raw", "-o", "-",
+		filepath.Join(app, "Contents", "Info.plist")).Output()
+	if id := strings.TrimSpace(string(out)); err == nil && id != "" {
+		clients = append(clients, id)
+	}
+	clients = append(clients, filepath.Join(app, "Contents", "MacOS", "iMessageKitServer"))
+	if self, err := os.Executable(); err == nil {
+		clients = append(clients, self)
+	}
+	return clients
+}
 
-	return "Permission prewarm completed, but some items may require manual attention:\n- " + strings.Join(warns, "\n- ")
+// sqlQuote returns s as an SQL string literal. Quotes are doubled, so s
+// cannot end the literal early.
+func sqlQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
 }
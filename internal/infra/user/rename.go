@@ -9,6 +9,7 @@ import (
 )
 
 // RenameFriendlyName updates the friendlyName in frpc.toml and restarts frpc.
+// The name is marked as manual so the scheduled refresh keeps it.
 func RenameFriendlyName(name string) string {
 	if msg := validateFriendlyName(name); msg != "" {
 		return fmt.Sprintf("Failed to update friendly name: %s", msg)
@@ -18,10 +19,13 @@ func RenameFriendlyName(name string) string {
 	if err != nil {
 		return fmt.Sprintf("Failed to update friendly name: unable to determine user home directory: %v", err)
 	}
-	frpcPath := filepath.Join(home, "services", "imsg", "frpc.toml")
-	if err := setFRPCFriendlyName(frpcPath, name); err != nil {
+	serviceDir := filepath.Join(home, "services", "imsg")
+	if err := setFRPCFriendlyName(filepath.Join(serviceDir, "frpc.toml"), name); err != nil {
 		return fmt.Sprintf("Failed to update friendly name: %v", err)
 	}
+	if err := os.WriteFile(filepath.Join(serviceDir, friendlyNameManualMarker), nil, 0o644); err != nil {
+		return fmt.Sprintf("Friendly name updated, but the scheduled refresh may replace it: %v", err)
+	}
 
 	username, err := currentUsername()
 	if err != nil {
@@ -0,0 +1,45 @@
+// Package layout fits TUI text to the width of the terminal.
+package layout
+
+import (
+	"strings"
+
+	"github.com/charmbracelet/lipgloss"
+)
+
+const (
+	// DefaultWidth is assumed until the terminal reports its size.
+	DefaultWidth = 80
+	// minWidth keeps wrapped text readable on very narrow terminals.
+	minWidth = 20
+)
+
+// Width returns the terminal width w, or DefaultWidth when it is not known
+// yet.
+func Width(w int) int {
+	if w <= 0 {
+		return DefaultWidth
+	}
+	return w
+}
+
+// Wrap word-wraps s into lines that fit in width columns once indented by
+// indent columns. Words longer than a line are broken, existing line breaks
+// are kept, and the lines are returned without indentation or styling.
+func Wrap(s string, width, indent int) []string {
+	w := max(Width(width)-indent, minWidth)
+	lines := strings.Split(lipgloss.NewStyle().Width(w).Render(s), "\n")
+	for i, l := range lines {
+		lines[i] = strings.TrimRight(l, " ")
+	}
+	return lines
+}
+
+// Truncate shortens s to width columns, ending it with "..." when it was cut.
+func Truncate(s string, width int) string {
+	w := max(Width(width), minWidth)
+	if lipgloss.Width(s) <= w {
+		return s
+	}
+	return lipgloss.NewStyle().MaxWidth(w-3).Render(s) + "..."
+}
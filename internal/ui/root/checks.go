@@ -0,0 +1,242 @@
+package root
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/charmbracelet/lipgloss"
+
+	"prism/internal/ui/layout"
+)
+
+const (
+	// checksReservedLines approximates the menu, status and footer drawn
+	// around the check results; the results viewport gets the rest.
+	checksReservedLines = 34
+	// minChecksHeight keeps the results usable on very small terminals.
+	minChecksHeight = 8
+
+	checksHint = "n next failing check  •  e expand/collapse  •  PgUp/PgDn scroll"
+)
+
+// checkStyles are the styles the check results are rendered with. The zero
+// value renders plain text, which Update uses to size the viewport.
+type checkStyles struct {
+	subtle, ok, fail, title, selected lipgloss.Style
+}
+
+// checkEntry is one preflight step or dependency in the results list.
+type checkEntry struct {
+	label  string
+	ok     bool
+	detail string
+	// summary is shown after the label when the detail is collapsed.
+	summary string
+	// expanded is whether the detail is shown before any toggle.
+	expanded bool
+}
+
+// checkEntries lists the preflight steps followed by the dependencies of the
+// last init run. The first failing preflight step and failing dependencies
+// are expanded by default; other failing steps are blocked by it.
+func (m Model) checkEntries() []checkEntry {
+	if m.initResult == nil {
+		return nil
+	}
+	var entries []checkEntry
+	focus := -1
+	for i, c := range m.initResult.Preflight.Checks {
+		e := checkEntry{label: fmt.Sprintf("Step %d: %s", i+1, c.Name), ok: c.OK, detail: strings.TrimSpace(c.Detail)}
+		if !c.OK {
+			if focus == -1 {
+				focus = i
+				e.expanded = true
+			} else {
+				e.summary = "Blocked by previous steps"
+			}
+		}
+		entries = append(entries, e)
+	}
+	for _, it := range m.initResult.Deps.Items {
+		e := checkEntry{label: string(it.Name), ok: it.OK, detail: strings.TrimSpace(it.Detail), expanded: !it.OK}
+		if it.OK {
+			e.summary, _, _ = strings.Cut(e.detail, "\n")
+			if e.summary == "" {
+				e.summary = "Ready"
+			}
+		}
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+// checkExpanded reports whether the detail of entry i is shown.
+func (m Model) checkExpanded(entries []checkEntry, i int) bool {
+	return entries[i].expanded != m.checkToggled[i]
+}
+
+// checksSection renders the preflight and dependency results and returns the
+// line each entry starts on, so navigation can scroll to it.
+func (m Model) checksSection(st checkStyles) (string, []int) {
+	entries := m.checkEntries()
+	checks := m.initResult.Preflight.Checks
+	var b strings.Builder
+	lines := 0
+	write := func(s string) {
+		b.WriteString(s + "\n")
+		lines++
+	}
+	starts := make([]int, len(entries))
+
+	if len(checks) > 0 {
+		passed := 0
+		for _, c := range checks {
+			if c.OK {
+				passed++
+			}
+		}
+		headerStyle := st.subtle
+		if passed == len(checks) {
+			headerStyle = st.ok
+		}
+		write("  " + headerStyle.Render(fmt.Sprintf("Preflight checks – %d/%d passed", passed, len(checks))))
+		if passed != len(checks) {
+			write("  " + st.subtle.Render("The first failing step below is blocking setup."))
+		}
+	}
+	if skipped := m.initResult.Preflight.Skipped; len(skipped) > 0 {
+		write("  " + st.fail.Render(fmt.Sprintf("Skipped by globals.preflight.skip: %s. Setup no longer guarantees this host can run the services.", strings.Join(skipped, ", "))))
+	}
+
+	for i, e := range entries {
+		if i == len(checks) {
+			deps := entries[len(checks):]
+			ready := 0
+			for _, d := range deps {
+				if d.ok {
+					ready++
+				}
+			}
+			headerStyle := st.subtle
+			if ready == len(deps) {
+				headerStyle = st.ok
+			}
+			write("")
+			write("  " + st.title.Render("Dependencies"))
+			write("  " + headerStyle.Render(fmt.Sprintf("Dependencies — %d/%d ready", ready, len(deps))))
+			if ready != len(deps) {
+				write("  " + st.subtle.Render("Some dependencies below are blocking setup."))
+			}
+		}
+		starts[i] = lines
+
+		cursor := "  "
+		if i == m.checkCursor {
+			cursor = st.selected.Render("› ")
+		}
+		expanded := m.checkExpanded(entries, i)
+		label := e.label
+		if e.summary != "" && !expanded {
+			label += " – " + e.summary
+		}
+		switch {
+		case e.ok:
+			write(cursor + st.ok.Render("[✓] "+label))
+		case e.summary != "" && !expanded:
+			write(cursor + st.subtle.Render("[ ] "+label))
+		default:
+			write(cursor + st.fail.Render("[!] "+label))
+		}
+		if expanded && e.detail != "" {
+			for _, l := range layout.Wrap(e.detail, m.width, 4) {
+				write("    " + st.subtle.Render(l))
+			}
+		}
+	}
+	return b.String(), starts
+}
+
+// resetChecks selects the first failing check of a new init result and
+// collapses everything to its default.
+func (m *Model) resetChecks() {
+	m.checkToggled = map[int]bool{}
+	m.checkCursor = -1
+	for i, e := range m.checkEntries() {
+		if !e.ok {
+			m.checkCursor = i
+			break
+		}
+	}
+	m.checksView.GotoTop()
+	m.syncChecksView()
+}
+
+// syncChecksView sizes the viewport and gives it the current line count so
+// scrolling stays within the results.
+func (m *Model) syncChecksView() {
+	m.checksView.Width = m.width
+	m.checksView.Height = max(m.height-checksReservedLines, minChecksHeight)
+	if m.initResult == nil {
+		m.checksView.SetContent("")
+		return
+	}
+	content, _ := m.checksSection(checkStyles{})
+	m.checksView.SetContent(strings.TrimSuffix(content, "\n"))
+}
+
+// updateForChecksKey handles the keys of the check results. It reports
+// whether key was one of them.
+func (m Model) updateForChecksKey(key string) (Model, bool) {
+	entries := m.checkEntries()
+	if len(entries) == 0 {
+		return m, false
+	}
+	switch key {
+	case "n":
+		next := -1
+		for step := 1; step <= len(entries); step++ {
+			i := (m.checkCursor + step + len(entries)) % len(entries)
+			if !entries[i].ok {
+				next = i
+				break
+			}
+		}
+		if next == -1 {
+			m.status = "All preflight checks and dependencies passed."
+			return m, true
+		}
+		m.checkCursor = next
+		m.syncChecksView()
+		_, starts := m.checksSection(checkStyles{})
+		m.checksView.SetYOffset(starts[next])
+	case "e":
+		if m.checkCursor < 0 {
+			m.checkCursor = 0
+		}
+		if m.checkToggled == nil {
+			m.checkToggled = map[int]bool{}
+		}
+		m.checkToggled[m.checkCursor] = !m.checkToggled[m.checkCursor]
+		m.syncChecksView()
+	case "pgdown":
+		m.checksView.PageDown()
+	case "pgup":
+		m.checksView.PageUp()
+	default:
+		return m, false
+	}
+	return m, true
+}
+
+// checksViewContent renders the results, through the viewport when they do
+// not fit on the screen.
+func (m Model) checksViewContent(st checkStyles) string {
+	content, _ := m.checksSection(st)
+	if m.height == 0 || strings.Count(content, "\n") <= m.checksView.Height {
+		return content
+	}
+	vp := m.checksView
+	vp.SetContent(strings.TrimSuffix(content, "\n"))
+	percent := fmt.Sprintf("  %3.f%% – %s", vp.ScrollPercent()*100, checksHint)
+	return vp.View() + "\n" + st.subtle.Render(percent) + "\n"
+}
@@ -1,13 +1,18 @@
 package root
 
 import (
+	"errors"
 	"fmt"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/charmbracelet/bubbles/viewport"
 	tea "github.com/charmbracelet/bubbletea"
 
 	"prism/internal/control/host"
+	"prism/internal/infra/state"
 )
 
 // Model is the root TUI model.
@@ -18,6 +23,21 @@ type Model struct {
 	initResult  *host.Result
 	initErr     error
 
+	// lastAction is the menu item that was last run; Esc returns the cursor
+	// to it. confirmDismiss is set after a first Esc warned that dismissing
+	// would hide passwords that are shown only once.
+	lastAction     int
+	confirmDismiss bool
+
+	// checksView scrolls the preflight and dependency results. checkCursor
+	// is the selected check (-1 for none) and checkToggled holds the checks
+	// whose detail was expanded or collapsed from its default.
+	checksView   viewport.Model
+	checkCursor  int
+	checkToggled map[int]bool
+	// width and height are the terminal size, zero until reported.
+	width, height int
+
 	awaitUserCount       bool
 	userCountInput       string
 	provisionRunning     bool
@@ -25,14 +45,81 @@ type Model struct {
 	provisionErr         error
 	provisionKind        provisionKind
 	awaitRemoveSelection bool
+	awaitPlanConfirm     bool
+	planRunning          bool
+	plan                 []host.PlannedUser
+	planSummary          host.PlanSummary
+	planCount            int
 	removeIndex          int
 	lastRemovedUser      string
+	// awaitResumeConfirm asks before resuming the unfinished Setup that
+	// initResult.Resume describes.
+	awaitResumeConfirm bool
+	// awaitRemoveMode asks whether the selected user's account and home are
+	// deleted or kept; removeKeptAccount records the choice.
+	awaitRemoveMode   bool
+	removeKeptAccount bool
+	// removePlan (or removePlanErr) lists what removing the selected user
+	// deletes; it is shown while awaitRemoveMode asks for the choice.
+	removePlanRunning bool
+	removePlan        *host.RemovalPlan
+	removePlanErr     error
+	// awaitUpdateConfirm shows updatePreview (or previewErr) before "Update
+	// user code" runs; updateRefreshWrapper records whether w started it.
+	awaitUpdateConfirm   bool
+	previewRunning       bool
+	updatePreview        *host.UpdatePreview
+	previewErr           error
+	updateRefreshWrapper bool
+	// repairRunning is set while daemon bootstrap is retried for users left
+	// with DaemonsPending.
+	repairRunning bool
+	// awaitSessionSelection lists the users for "Open user session";
+	// sessionIndex is the highlighted one and sessionRunning is set while
+	// its session is being opened.
+	awaitSessionSelection bool
+	sessionIndex          int
+	sessionRunning        bool
+	// download is the latest bundle download progress of the running
+	// provisioning flow, if it is downloading.
+	download *host.DownloadProgress
 
 	servicesRunning bool
 	servicesErr     error
 	services        []host.ServiceStatus
+
+	// frpcRefreshRunning is set while every user's frpc.toml is rewritten
+	// from the current config; frpcRefresh and frpcRefreshErr hold the
+	// outcome.
+	frpcRefreshRunning bool
+	frpcRefresh        *host.FRPCRefreshResult
+	frpcRefreshErr     error
+
+	// restartRunning is set while the unhealthy users listed in the service
+	// status are restarted (u).
+	restartRunning bool
+
+	// Watch mode re-runs the service check every servicesWatchInterval.
+	// watchSeq identifies the current watch session; serviceChanges holds the
+	// last health transition seen for each user.
+	watching       bool
+	watchSeq       int
+	lastRefresh    time.Time
+	serviceChanges map[string]string
+
+	// lastUpdate is the recorded bundle version; nil until one is known.
+	lastUpdate *host.VersionInfo
+
+	// maintenance is the host's maintenance mode; nil until it is known.
+	// awaitMaintenanceConfirm asks for y before entering or leaving it.
+	maintenance             *host.MaintenanceInfo
+	awaitMaintenanceConfirm bool
+	maintenanceRunning      bool
 }
 
+// servicesWatchInterval is how often watch mode refreshes service status.
+const servicesWatchInterval = 5 * time.Second
+
 type provisionKind int
 
 const (
@@ -42,6 +129,7 @@ const (
 	provisionKindView
 	provisionKindUpdate
 	provisionKindRemove
+	provisionKindSession
 )
 
 type initDoneMsg struct {
@@ -54,9 +142,73 @@ type provisionDoneMsg struct {
 	err    error
 }
 
+type downloadProgressMsg struct {
+	progress host.DownloadProgress
+	ch       chan host.DownloadProgress
+}
+
+type planDoneMsg struct {
+	plan    []host.PlannedUser
+	summary host.PlanSummary
+	count   int
+	err     error
+}
+
+type previewDoneMsg struct {
+	preview host.UpdatePreview
+	err     error
+}
+
+type removePlanDoneMsg struct {
+	plan host.RemovalPlan
+	err  error
+}
+
+type repairDoneMsg struct {
+	// state is the state after the last successful repair, nil if none
+	// succeeded.
+	state  *state.State
+	failed []string
+}
+
+type sessionDoneMsg struct {
+	user string
+	err  error
+}
+
 type servicesDoneMsg struct {
 	statuses []host.ServiceStatus
 	err      error
+	seq      int
+}
+
+type frpcRefreshDoneMsg struct {
+	result host.FRPCRefreshResult
+	err    error
+}
+
+type restartDoneMsg struct {
+	result host.UnhealthyRestartResult
+	err    error
+}
+
+type maintenanceMsg struct {
+	info host.MaintenanceInfo
+	err  error
+}
+
+type maintenanceDoneMsg struct {
+	entered bool
+	err     error
+}
+
+type servicesTickMsg struct {
+	seq int
+}
+
+type lastUpdateMsg struct {
+	info host.VersionInfo
+	err  error
 }
 
 // New creates a new root model.
@@ -66,7 +218,7 @@ func New() Model {
 
 // Init implements tea.Model.
 func (m Model) Init() tea.Cmd {
-	return nil
+	return tea.Batch(runLastUpdateCmd(), runMaintenanceCmd())
 }
 
 // Update implements tea.Model.
@@ -74,19 +226,93 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	switch msg := msg.(type) {
 	case tea.KeyMsg:
 		return m.updateForKeyMsg(msg)
+	case tea.WindowSizeMsg:
+		m.width, m.height = msg.Width, msg.Height
+		m.syncChecksView()
+		return m, nil
 	case initDoneMsg:
 		return m.updateForInitDoneMsg(msg)
 	case provisionDoneMsg:
 		return m.updateForProvisionDoneMsg(msg)
+	case downloadProgressMsg:
+		if m.provisionRunning {
+			m.download = &msg.progress
+		}
+		return m, waitForDownloadProgress(msg.ch)
+	case planDoneMsg:
+		return m.updateForPlanDoneMsg(msg)
+	case previewDoneMsg:
+		return m.updateForPreviewDoneMsg(msg)
+	case removePlanDoneMsg:
+		return m.updateForRemovePlanDoneMsg(msg)
+	case repairDoneMsg:
+		return m.updateForRepairDoneMsg(msg)
+	case sessionDoneMsg:
+		m.sessionRunning = false
+		if msg.err != nil {
+			m.status = fmt.Sprintf("Could not open the session of %s: %v", msg.user, msg.err)
+		} else {
+			m.status = fmt.Sprintf("Opened the Screen Sharing session of %s. Select another user or press q to go back.", msg.user)
+		}
+		return m, nil
 	case servicesDoneMsg:
 		return m.updateForServicesDoneMsg(msg)
+	case frpcRefreshDoneMsg:
+		return m.updateForFRPCRefreshDoneMsg(msg)
+	case restartDoneMsg:
+		return m.updateForRestartDoneMsg(msg)
+	case maintenanceMsg:
+		if msg.err != nil {
+			m.maintenance = nil
+			return m, nil
+		}
+		m.maintenance = &msg.info
+		return m, nil
+	case maintenanceDoneMsg:
+		m.maintenanceRunning = false
+		switch {
+		case msg.err != nil && msg.entered:
+			m.status = fmt.Sprintf("Entered maintenance, but some daemons could not be stopped: %v", msg.err)
+		case msg.err != nil:
+			m.status = fmt.Sprintf("Could not start every user's daemons: %v", msg.err)
+		case msg.entered:
+			m.status = "Entered maintenance. Every user's daemons are stopped and stay stopped across reboots."
+		default:
+			m.status = "Left maintenance. Every user's daemons were started again."
+		}
+		return m, runMaintenanceCmd()
+	case servicesTickMsg:
+		if !m.watching || msg.seq != m.watchSeq {
+			return m, nil
+		}
+		return m, runServicesCmd(m.watchSeq, false)
+	case lastUpdateMsg:
+		if msg.err != nil || msg.info.Tag == "" {
+			m.lastUpdate = nil
+			return m, nil
+		}
+		m.lastUpdate = &msg.info
+		return m, nil
 	default:
 		return m, nil
 	}
 }
 
 func (m Model) updateForKeyMsg(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
-	if m.initRunning || m.provisionRunning || m.servicesRunning {
+	if m.watching {
+		switch msg.String() {
+		case "ctrl+c":
+			return m, tea.Quit
+		case "q", "esc":
+			m.watching = false
+			m.servicesRunning = false
+			m.watchSeq++
+			m.status = "Stopped watching service status."
+		}
+		return m, nil
+	}
+
+	if m.busy() {
 		switch msg.String() {
 		case "q", "esc", "ctrl+c":
 			return m, tea.Quit
@@ -94,11 +320,29 @@ func (m Model) updateForKeyMsg(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 		return m, nil
 	}
 
+	if m.awaitMaintenanceConfirm {
+		m.awaitMaintenanceConfirm = false
+		if msg.String() != "y" {
+			m.status = "Maintenance mode unchanged."
+			return m, nil
+		}
+		enter := m.maintenance == nil || !m.maintenance.Active
+		m.maintenanceRunning = true
+		if enter {
+			m.status = "Stopping every user's daemons and entering maintenance. Please wait..."
+		} else {
+			m.status = "Starting every user's daemons and leaving maintenance. Please wait..."
+		}
+		return m, runMaintenanceToggleCmd(enter)
+	}
+
 	if m.awaitUserCount {
 		key := msg.String()
 		switch key {
-		case "q", "esc", "ctrl+c":
+		case "q", "ctrl+c":
 			return m, tea.Quit
+		case "esc":
+			return m.backToMenu(), nil
 		case "enter":
 			input := strings.TrimSpace(m.userCountInput)
 			if input == "" {
@@ -115,6 +359,49 @@ func (m Model) updateForKeyMsg(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 				return m, nil
 			}
 			m.awaitUserCount = false
+			m.planRunning = true
+			m.plan = nil
+			m.provisionErr = nil
+			m.status = fmt.Sprintf("Planning %d Prism users...", n)
+			return m, runPlanCmd(n)
+		case "backspace", "ctrl+h":
+			if len(m.userCountInput) > 0 {
+				m.userCountInput = m.userCountInput[:len(m.userCountInput)-1]
+			}
+			return m, nil
+		default:
+			if len(key) == 1 && key[0] >= '0' && key[0] <= '9' {
+				m.userCountInput += key
+				return m, nil
+			}
+		}
+	}
+
+	if m.awaitPlanConfirm {
+		switch msg.String() {
+		case "ctrl+c":
+			return m, tea.Quit
+		case "q", "esc", "n":
+			m.awaitPlanConfirm = false
+			m.plan = nil
+			m.status = "Provisioning cancelled; no users were created."
+			return m, nil
+		case "enter", "y":
+			for _, p := range m.plan {
+				if p.Conflict != "" {
+					m.status = fmt.Sprintf("Cannot proceed: %s (%s). Press q to cancel.", p.Name, p.Conflict)
+					return m, nil
+				}
+			}
+			// Setup creates the first accounts from machine_id and start_port;
+			// a stray Enter must not confirm a plan nobody has read.
+			if m.provisionKind == provisionKindInitial && msg.String() != "y" {
+				m.status = fmt.Sprintf("Press y to create these %d users, q to cancel.", m.planCount)
+				return m, nil
+			}
+			n := m.planCount
+			m.awaitPlanConfirm = false
+			m.plan = nil
 			m.provisionRunning = true
 			m.provisionErr = nil
 			m.provisionResult = nil
@@ -124,17 +411,89 @@ func (m Model) updateForKeyMsg(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 			}
 			m.status = fmt.Sprintf("Creating Prism runtime for %d users. Please wait...", n)
 			return m, runProvisionCmd(n)
-		case "backspace", "ctrl+h":
-			if len(m.userCountInput) > 0 {
-				m.userCountInput = m.userCountInput[:len(m.userCountInput)-1]
+		}
+		return m, nil
+	}
+
+	if m.awaitResumeConfirm && m.initResult != nil && m.initResult.Resume != nil {
+		switch msg.String() {
+		case "ctrl+c":
+			return m, tea.Quit
+		case "q", "esc", "n":
+			m.awaitResumeConfirm = false
+			m.status = "Setup was not resumed; nothing was changed."
+			return m, nil
+		case "enter", "y":
+			n := m.initResult.Resume.UsersTarget
+			m.awaitResumeConfirm = false
+			m.provisionRunning = true
+			m.provisionErr = nil
+			m.provisionResult = nil
+			m.status = fmt.Sprintf("Resuming Setup of %d Prism users. Please wait...", n)
+			return m, runProvisionCmd(n)
+		}
+		return m, nil
+	}
+
+	if m.awaitUpdateConfirm {
+		switch msg.String() {
+		case "ctrl+c":
+			return m, tea.Quit
+		case "q", "esc", "n":
+			m.awaitUpdateConfirm = false
+			m.updatePreview = nil
+			m.previewErr = nil
+			m.status = "Update cancelled; no user code was changed."
+			return m, nil
+		case "enter", "y":
+			m.awaitUpdateConfirm = false
+			m.updatePreview = nil
+			m.previewErr = nil
+			if m.updateRefreshWrapper {
+				m.status = "Updating Prism user code and the per-user prism wrapper for all users. Please wait..."
+			} else {
+				m.status = "Updating Prism user code for all users. Please wait..."
 			}
+			m.provisionKind = provisionKindUpdate
+			m.provisionRunning = true
+			m.provisionErr = nil
+			m.provisionResult = nil
+			return m, runUpdateUsersCodeCmd(m.updateRefreshWrapper)
+		}
+		return m, nil
+	}
+
+	if m.provisionKind == provisionKindRemove && m.provisionResult != nil && m.awaitRemoveMode {
+		if m.removeIndex < 0 || m.removeIndex >= len(m.provisionResult.State.Users) {
+			m.awaitRemoveMode = false
 			return m, nil
-		default:
-			if len(key) == 1 && key[0] >= '0' && key[0] <= '9' {
-				m.userCountInput += key
-				return m, nil
+		}
+		u := m.provisionResult.State.Users[m.removeIndex]
+		switch msg.String() {
+		case "q", "esc", "ctrl+c":
+			m.awaitRemoveMode = false
+			m.removePlan = nil
+			m.removePlanErr = nil
+			m.status = "Use ↑/↓ to select a Prism user to delete, then press Enter to confirm; press q to cancel."
+			return m, nil
+		case "d", "k":
+			keep := msg.String() == "k"
+			m.awaitRemoveMode = false
+			m.removePlan = nil
+			m.removePlanErr = nil
+			m.awaitRemoveSelection = false
+			m.provisionRunning = true
+			m.provisionErr = nil
+			if keep {
+				m.status = fmt.Sprintf("Removing the services of Prism user %s (keeping the account and its data). Please wait...", u.Name)
+			} else {
+				m.status = fmt.Sprintf("Removing Prism user %s and its services. Please wait...", u.Name)
 			}
+			m.lastRemovedUser = u.Name
+			m.removeKeptAccount = keep
+			return m, runRemoveUserCmd(u.Name, keep)
 		}
+		return m, nil
 	}
 
 	if m.provisionKind == provisionKindRemove && m.provisionResult != nil && m.awaitRemoveSelection {
@@ -171,17 +530,58 @@ func (m Model) updateForKeyMsg(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 				return m, nil
 			}
 			u := m.provisionResult.State.Users[m.removeIndex]
-			m.awaitRemoveSelection = false
-			m.provisionRunning = true
-			m.provisionErr = nil
-			m.status = fmt.Sprintf("Removing Prism user %s and its services. Please wait...", u.Name)
-			m.lastRemovedUser = u.Name
-			return m, runRemoveUserCmd(u.Name)
+			m.removePlanRunning = true
+			m.status = fmt.Sprintf("Checking what removing %s deletes...", u.Name)
+			return m, runPlanRemoveUserCmd(u.Name)
 		}
 	}
 
+	if m.provisionKind == provisionKindSession && m.provisionResult != nil && m.awaitSessionSelection {
+		users := m.provisionResult.State.Users
+		switch msg.String() {
+		case "q", "esc", "ctrl+c":
+			m.status = "Open user session cancelled."
+			m.awaitSessionSelection = false
+			m.provisionKind = provisionKindNone
+		case "up", "k":
+			if m.sessionIndex > 0 {
+				m.sessionIndex--
+			}
+		case "down", "j":
+			if m.sessionIndex < len(users)-1 {
+				m.sessionIndex++
+			}
+		case "enter", " ":
+			if m.sessionIndex < 0 || m.sessionIndex >= len(users) {
+				return m, nil
+			}
+			u := users[m.sessionIndex]
+			m.sessionRunning = true
+			m.status = fmt.Sprintf("Opening the Screen Sharing session of %s. Please wait...", u.Name)
+			return m, runOpenSessionCmd(u.Name)
+		}
+		return m, nil
+	}
+
+	if msg.String() != "esc" {
+		m.confirmDismiss = false
+	}
+
 	switch msg.String() {
-	case "q", "esc", "ctrl+c":
+	case "esc":
+		if m.busy() {
+			return m, nil
+		}
+		if !m.hasResults() {
+			return m, tea.Quit
+		}
+		if m.provisionResult != nil && m.provisionResult.SecretsPath == "" && len(m.provisionResult.Passwords) > 0 && !m.confirmDismiss {
+			m.confirmDismiss = true
+			m.status = "The passwords below are shown only once. Press Esc again to dismiss them and return to the menu."
+			return m, nil
+		}
+		return m.backToMenu(), nil
+	case "q", "ctrl+c":
 		return m, tea.Quit
 	case "up", "k":
 		if m.cursor > 0 {
@@ -189,11 +589,39 @@ func (m Model) updateForKeyMsg(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 		}
 		return m, nil
 	case "down", "j":
-		if m.cursor < 6 {
+		if m.cursor < 10 {
 			m.cursor++
 		}
 		return m, nil
+	case "r":
+		if m.provisionResult == nil || m.awaitRemoveSelection {
+			return m, nil
+		}
+		pending := pendingDaemonUsers(m.provisionResult.State)
+		if len(pending) == 0 {
+			return m, nil
+		}
+		m.repairRunning = true
+		m.status = fmt.Sprintf("Retrying LaunchDaemon bootstrap for %s. Please wait...", strings.Join(pending, ", "))
+		return m, runRepairUsersCmd(pending)
+	case "u":
+		if len(m.services) == 0 || unhealthyCount(m.services) == 0 {
+			return m, nil
+		}
+		m.restartRunning = true
+		m.status = fmt.Sprintf("Restarting the services of %d unhealthy Prism users; healthy users are left running...", unhealthyCount(m.services))
+		return m, runRestartUnhealthyCmd()
+	case "w":
+		if m.cursor != 3 {
+			return m, nil
+		}
+		m.lastAction = m.cursor
+		return m.startUpdatePreview(true)
+	case "n", "e", "pgup", "pgdown":
+		m, _ = m.updateForChecksKey(msg.String())
+		return m, nil
 	case "enter", " ":
+		m.lastAction = m.cursor
 		switch m.cursor {
 		case 0:
 			m.status = "Initializing host environment (including preflight checks)..."
@@ -215,27 +643,54 @@ func (m Model) updateForKeyMsg(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 			m.provisionErr = nil
 			return m, runViewUsersCmd()
 		case 3:
-			m.status = "Updating Prism user code for all users. Please wait..."
-			m.provisionKind = provisionKindUpdate
-			m.provisionRunning = true
-			m.provisionErr = nil
-			m.provisionResult = nil
-			return m, runUpdateUsersCodeCmd()
+			return m.startUpdatePreview(false)
 		case 4:
 			m.status = "Checking service status for all Prism users..."
 			m.servicesRunning = true
 			m.servicesErr = nil
 			m.services = nil
-			return m, runServicesCmd()
+			return m, runServicesCmd(m.watchSeq, true)
 		case 5:
+			m.status = fmt.Sprintf("Watching service status (refresh every %s). Press q to stop.", servicesWatchInterval)
+			m.watching = true
+			m.watchSeq++
+			m.servicesRunning = true
+			m.servicesErr = nil
+			m.services = nil
+			m.serviceChanges = map[string]string{}
+			return m, runServicesCmd(m.watchSeq, false)
+		case 6:
+			m.status = "Loading current Prism user list to select a user session to open..."
+			m.provisionKind = provisionKindSession
+			m.provisionErr = nil
+			m.provisionResult = nil
+			m.provisionRunning = true
+			m.awaitSessionSelection = false
+			return m, runViewUsersCmd()
+		case 7:
 			m.status = "Loading current Prism user list to select a user to remove..."
 			m.provisionKind = provisionKindRemove
 			m.provisionErr = nil
 			m.provisionResult = nil
 			m.provisionRunning = true
 			m.awaitRemoveSelection = false
+			m.awaitRemoveMode = false
 			m.lastRemovedUser = ""
 			return m, runViewUsersCmd()
+		case 8:
+			m.status = "Rewriting every Prism user's frpc.toml from the current config..."
+			m.frpcRefreshRunning = true
+			m.frpcRefresh = nil
+			m.frpcRefreshErr = nil
+			return m, runRefreshFRPCCmd()
+		case 9:
+			m.awaitMaintenanceConfirm = true
+			if m.maintenance != nil && m.maintenance.Active {
+				m.status = "Press y to start every user's daemons and leave maintenance; any other key cancels."
+			} else {
+				m.status = "Press y to stop every user's daemons and keep them stopped across reboots; any other key cancels."
+			}
+			return m, nil
 		default:
 			return m, tea.Quit
 		}
@@ -244,14 +699,55 @@ func (m Model) updateForKeyMsg(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 	return m, nil
 }
 
+// busy reports whether an action is still running; its results would
+// reappear if they were dismissed now.
+func (m Model) busy() bool {
+	return m.initRunning || m.provisionRunning || m.planRunning || m.previewRunning || m.removePlanRunning ||
+		m.repairRunning || m.sessionRunning || m.servicesRunning || m.watching || m.frpcRefreshRunning || m.restartRunning || m.maintenanceRunning
+}
+
+// hasResults reports whether a finished action left output below the menu.
+func (m Model) hasResults() bool {
+	return m.initResult != nil || m.initErr != nil || m.provisionResult != nil || m.provisionErr != nil ||
+		len(m.services) > 0 || m.servicesErr != nil || m.frpcRefresh != nil || m.frpcRefreshErr != nil || m.awaitUserCount
+}
+
+// backToMenu dismisses the output of the last action and returns the cursor
+// to its menu item.
+func (m Model) backToMenu() Model {
+	m.initResult, m.initErr = nil, nil
+	m.resetChecks()
+	m.awaitUserCount = false
+	m.userCountInput = ""
+	m.awaitResumeConfirm = false
+	m.provisionResult, m.provisionErr = nil, nil
+	m.provisionKind = provisionKindNone
+	m.download = nil
+	m.lastRemovedUser = ""
+	m.services, m.servicesErr = nil, nil
+	m.serviceChanges = nil
+	m.frpcRefresh, m.frpcRefreshErr = nil, nil
+	m.confirmDismiss = false
+	m.cursor = m.lastAction
+	m.status = ""
+	return m
+}
+
 func (m Model) updateForInitDoneMsg(msg initDoneMsg) (tea.Model, tea.Cmd) {
 	m.initRunning = false
 	m.initResult = &msg.result
 	m.initErr = msg.err
+	m.resetChecks()
 
-	if msg.err != nil {
+	if errors.Is(msg.err, host.ErrPermissionDenied) {
+		m.status = "Prism needs root privileges to manage this host. Quit and re-run with: sudo ./prism"
+		m.awaitUserCount = false
+	} else if msg.err != nil {
 		m.status = "Environment is not ready. Please follow the Preflight and Dependencies hints below, then retry."
 		m.awaitUserCount = false
+	} else if r := msg.result.Resume; r != nil {
+		m.status = fmt.Sprintf("A previous Setup stopped after %d of %d users. Press Enter to resume it, q to cancel.", r.UsersDone, r.UsersTarget)
+		m.awaitResumeConfirm = true
 	} else if msg.result.AlreadyInitialized {
 		m.status = "This host is already initialized; no further setup is required."
 	} else {
@@ -266,9 +762,19 @@ func (m Model) updateForInitDoneMsg(msg initDoneMsg) (tea.Model, tea.Cmd) {
 
 func (m Model) updateForProvisionDoneMsg(msg provisionDoneMsg) (tea.Model, tea.Cmd) {
 	m.provisionRunning = false
+	m.download = nil
 	m.provisionResult = &msg.result
 	m.provisionErr = msg.err
 
+	if errors.Is(msg.err, host.ErrPartialUpdate) {
+		// Some users were updated; show them alongside the failures.
+		m.provisionErr = nil
+		failed := failedUpdates(msg.result.UpdateResults)
+		m.status = fmt.Sprintf("Updated Prism user code for %d of %d users; %d failed. See below for details.",
+			len(msg.result.UpdateResults)-len(failed), len(msg.result.UpdateResults), len(failed))
+		return m, runLastUpdateCmd()
+	}
+
 	if msg.err != nil {
 		m.status = "An error occurred while creating or updating Prism users. See the User provisioning section below for details."
 	} else {
@@ -278,7 +784,11 @@ func (m Model) updateForProvisionDoneMsg(msg provisionDoneMsg) (tea.Model, tea.C
 		} else {
 			switch m.provisionKind {
 			case provisionKindAdd:
-				m.status = fmt.Sprintf("Added %d Prism users to this host. Passwords are stored in %s.", n, msg.result.SecretsPath)
+				if msg.result.SecretsPath == "" {
+					m.status = fmt.Sprintf("Added %d Prism users to this host. Passwords were not stored; record them from the list below before leaving this screen.", n)
+				} else {
+					m.status = fmt.Sprintf("Added %d Prism users to this host. Passwords are stored in %s.", n, msg.result.SecretsPath)
+				}
 			case provisionKindView:
 				m.status = fmt.Sprintf("There are currently %d Prism users. Password records are located at %s.", n, msg.result.SecretsPath)
 			case provisionKindRemove:
@@ -293,8 +803,21 @@ func (m Model) updateForProvisionDoneMsg(msg provisionDoneMsg) (tea.Model, tea.C
 					m.status = "Use ↑/↓ to select a Prism user to delete, then press Enter to confirm; press q to cancel."
 				} else {
 					m.awaitRemoveSelection = false
-					m.status = fmt.Sprintf("Deleted Prism user %s. There are now %d users.", m.lastRemovedUser, n)
+					if m.removeKeptAccount {
+						m.status = fmt.Sprintf("Removed the services of Prism user %s; its account and home directory were kept. There are now %d users.", m.lastRemovedUser, n)
+					} else {
+						m.status = fmt.Sprintf("Deleted Prism user %s. There are now %d users.", m.lastRemovedUser, n)
+					}
+				}
+			case provisionKindSession:
+				m.awaitSessionSelection = true
+				if m.sessionIndex >= n {
+					m.sessionIndex = n - 1
 				}
+				if m.sessionIndex < 0 {
+					m.sessionIndex = 0
+				}
+				m.status = "Use ↑/↓ to select a Prism user, then press Enter to open their Screen Sharing session; press q to cancel."
 			case provisionKindUpdate:
 				m.status = fmt.Sprintf("Updated Prism user code for %d users.", n)
 			default:
@@ -303,10 +826,168 @@ func (m Model) updateForProvisionDoneMsg(msg provisionDoneMsg) (tea.Model, tea.C
 		}
 	}
 
+	if pending := pendingDaemonUsers(msg.result.State); msg.err == nil && len(pending) > 0 && !m.awaitRemoveSelection && !m.awaitSessionSelection {
+		m.status += fmt.Sprintf(" The LaunchDaemons of %s did not start; press r to retry.", strings.Join(pending, ", "))
+	}
+
+	if msg.err == nil && m.provisionKind != provisionKindView && m.provisionKind != provisionKindRemove && m.provisionKind != provisionKindSession {
+		return m, runLastUpdateCmd()
+	}
 	return m, nil
 }
 
+func (m Model) updateForPlanDoneMsg(msg planDoneMsg) (tea.Model, tea.Cmd) {
+	m.planRunning = false
+	if msg.err != nil {
+		m.provisionErr = msg.err
+		m.status = "Could not plan the new Prism users. See details below."
+		return m, nil
+	}
+
+	m.plan = msg.plan
+	m.planSummary = msg.summary
+	m.planCount = msg.count
+	m.awaitPlanConfirm = true
+	if m.provisionKind == provisionKindInitial {
+		m.status = fmt.Sprintf("Review the plan for %d users below. Press y to create them, q to cancel.", msg.count)
+		return m, nil
+	}
+	m.status = fmt.Sprintf("Review the plan for %d users below. Press Enter (or y) to proceed, q to cancel.", msg.count)
+	return m, nil
+}
+
+func (m Model) updateForRemovePlanDoneMsg(msg removePlanDoneMsg) (tea.Model, tea.Cmd) {
+	m.removePlanRunning = false
+	m.awaitRemoveMode = true
+	m.removePlan = nil
+	m.removePlanErr = msg.err
+	if msg.err == nil {
+		m.removePlan = &msg.plan
+	}
+	m.status = fmt.Sprintf("Remove %s: press d to delete the account and home directory, k to keep the account and its data (services only), q to go back.", msg.plan.Username)
+	return m, nil
+}
+
+func (m Model) updateForRepairDoneMsg(msg repairDoneMsg) (tea.Model, tea.Cmd) {
+	m.repairRunning = false
+	if msg.state != nil && m.provisionResult != nil {
+		// Keep the rest of the result, e.g. passwords shown only once.
+		m.provisionResult.State = *msg.state
+	}
+	if len(msg.failed) > 0 {
+		m.status = "Retry failed for " + strings.Join(msg.failed, "; ") + ". Press r to try again."
+	} else {
+		m.status = "LaunchDaemons bootstrapped; all users are running."
+	}
+	return m, nil
+}
+
+// startUpdatePreview fetches the release comparison that is confirmed before
+// "Update user code" runs.
+func (m Model) startUpdatePreview(refreshWrapper bool) (tea.Model, tea.Cmd) {
+	m.status = "Comparing the deployed service bundle with the latest release..."
+	m.previewRunning = true
+	m.updatePreview = nil
+	m.previewErr = nil
+	m.updateRefreshWrapper = refreshWrapper
+	return m, runPreviewUpdateCmd()
+}
+
+func (m Model) updateForPreviewDoneMsg(msg previewDoneMsg) (tea.Model, tea.Cmd) {
+	m.previewRunning = false
+	m.awaitUpdateConfirm = true
+	m.previewErr = msg.err
+	switch {
+	case msg.err != nil:
+		m.status = "Could not compare with the latest release. Press Enter (or y) to update anyway, q to cancel."
+	case msg.preview.UpdateAvailable():
+		m.updatePreview = &msg.preview
+		m.status = "Review the release below. Press Enter (or y) to update all users, q to cancel."
+	default:
+		m.updatePreview = &msg.preview
+		m.status = msg.preview.Summary() + " Press Enter (or y) to re-sync the current bundle anyway, q to cancel."
+	}
+	return m, nil
+}
+
+func (m Model) updateForFRPCRefreshDoneMsg(msg frpcRefreshDoneMsg) (tea.Model, tea.Cmd) {
+	m.frpcRefreshRunning = false
+	m.frpcRefreshErr = msg.err
+	if msg.result.Users != nil {
+		m.frpcRefresh = &msg.result
+	}
+
+	switch {
+	case errors.Is(msg.err, host.ErrPartialFRPCRefresh):
+		failed := failedUpdates(msg.result.Users)
+		m.status = fmt.Sprintf("Refreshed frpc.toml for %d of %d users; %d failed. See below for details.",
+			len(msg.result.Users)-len(failed), len(msg.result.Users), len(failed))
+	case msg.err != nil:
+		m.status = "Could not refresh the frpc configs. See below for details."
+	default:
+		m.status = fmt.Sprintf("Refreshed frpc.toml for %d users; restarted frpc for %d running users.",
+			len(msg.result.Users), len(msg.result.Restarted))
+	}
+	return m, nil
+}
+
+func (m Model) updateForRestartDoneMsg(msg restartDoneMsg) (tea.Model, tea.Cmd) {
+	m.restartRunning = false
+	res := msg.result
+	switch {
+	case res.Restarted == nil:
+		m.status = fmt.Sprintf("Could not restart the unhealthy users: %v", msg.err)
+	case len(res.Restarted) == 0:
+		m.status = fmt.Sprintf("All %d Prism users are healthy again; nothing was restarted.", len(res.Healthy))
+	default:
+		failed := failedUpdates(res.Restarted)
+		restarted := make([]string, 0, len(res.Restarted))
+		for name, err := range res.Restarted {
+			if err == nil {
+				restarted = append(restarted, name)
+			}
+		}
+		sort.Strings(restarted)
+		m.status = fmt.Sprintf("Restarted %d of %d unhealthy users (%s); %d healthy users were left running.",
+			len(restarted), len(res.Restarted), strings.Join(restarted, ", "), len(res.Healthy))
+		if len(failed) > 0 {
+			m.status += fmt.Sprintf(" Restart failed for %s.", strings.Join(failed, ", "))
+		}
+		m.status += " Select Services status to re-check once they have started."
+	}
+	return m, nil
+}
+
+// unhealthyCount returns how many of statuses are not healthy.
+func unhealthyCount(statuses []host.ServiceStatus) int {
+	n := 0
+	for _, s := range statuses {
+		if !s.Healthy() {
+			n++
+		}
+	}
+	return n
+}
+
 func (m Model) updateForServicesDoneMsg(msg servicesDoneMsg) (tea.Model, tea.Cmd) {
+	if msg.seq != m.watchSeq {
+		// Result of a watch session that has since been stopped.
+		return m, nil
+	}
+
+	if m.watching {
+		m.servicesRunning = false
+		m.servicesErr = msg.err
+		m.lastRefresh = time.Now()
+		if msg.err == nil {
+			m.recordServiceTransitions(msg.statuses)
+			m.services = msg.statuses
+		}
+		m.status = fmt.Sprintf("Watching service status (last refresh %s, every %s). Press q to stop.",
+			m.lastRefresh.Format("15:04:05"), servicesWatchInterval)
+		return m, servicesWatchTickCmd(m.watchSeq)
+	}
+
 	m.servicesRunning = false
 	m.services = msg.statuses
 	m.servicesErr = msg.err
@@ -321,3 +1002,53 @@ func (m Model) updateForServicesDoneMsg(msg servicesDoneMsg) (tea.Model, tea.Cmd
 
 	return m, nil
 }
+
+// recordServiceTransitions compares the next statuses with the current ones
+// and remembers, per user, when it last became unhealthy or recovered.
+func (m *Model) recordServiceTransitions(next []host.ServiceStatus) {
+	if len(m.services) == 0 {
+		return
+	}
+	prev := make(map[string]bool, len(m.services))
+	for _, s := range m.services {
+		prev[s.Name] = s.Healthy()
+	}
+	at := m.lastRefresh.Format("15:04:05")
+	for _, s := range next {
+		was, ok := prev[s.Name]
+		if !ok {
+			continue
+		}
+		switch now := s.Healthy(); {
+		case was && !now:
+			m.serviceChanges[s.Name] = "became unhealthy at " + at
+		case !was && now:
+			m.serviceChanges[s.Name] = "recovered at " + at
+		}
+	}
+}
+
+// pendingDaemonUsers returns the users in st whose LaunchDaemons still need
+// bootstrapping.
+func pendingDaemonUsers(st state.State) []string {
+	var pending []string
+	for _, u := range st.Users {
+		if u.DaemonsPending {
+			pending = append(pending, u.Name)
+		}
+	}
+	return pending
+}
+
+// failedUpdates returns the users in results whose update failed, sorted by
+// name.
+func failedUpdates(results map[string]error) []string {
+	var failed []string
+	for name, err := range results {
+		if err != nil {
+			failed = append(failed, name)
+		}
+	}
+	sort.Strings(failed)
+	return failed
+}
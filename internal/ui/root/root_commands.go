@@ -2,7 +2,9 @@ package root
 
 import (
 	"context"
+	"fmt"
 	"os"
+	"time"
 
 	tea "github.com/charmbracelet/bubbletea"
 
@@ -16,6 +18,8 @@ import (
 func runInitCmd() tea.Cmd {
 	return func() tea.Msg {
 		init := host.NewInitializer(paths.ConfigPath(), paths.StatePath())
+		// The interactive TUI keeps the automatic reboot with its countdown.
+		init.AllowReboot = true
 		res, err := init.Run(context.Background())
 		return initDoneMsg{result: res, err: err}
 	}
@@ -24,23 +28,71 @@ func runInitCmd() tea.Cmd {
 // runProvisionCmd runs the user provisioning flow in a separate goroutine and
 // returns a Bubble Tea command that yields a provisionDoneMsg when complete.
 func runProvisionCmd(userCount int) tea.Cmd {
-	return func() tea.Msg {
-		init := host.NewInitializer(paths.ConfigPath(), paths.StatePath())
-		prismPath, _ := os.Executable()
+	return withDownloadProgress(func(init *host.Initializer) tea.Msg {
+		init.AutobootArgs = paths.OverrideArgs()
+		prismPath, err := os.Executable()
+		if err != nil {
+			return provisionDoneMsg{err: fmt.Errorf("locate prism binary: %w", err)}
+		}
 		res, err := init.Provision(context.Background(), userCount, prismPath)
 		return provisionDoneMsg{result: res, err: err}
+	})
+}
+
+// withDownloadProgress runs fn with an Initializer that forwards bundle
+// download progress to the UI as downloadProgressMsg values, alongside the
+// message fn itself returns.
+func withDownloadProgress(fn func(init *host.Initializer) tea.Msg) tea.Cmd {
+	ch := make(chan host.DownloadProgress, 1)
+	run := func() tea.Msg {
+		defer close(ch)
+		init := host.NewInitializer(paths.ConfigPath(), paths.StatePath())
+		init.OnDownloadProgress = func(p host.DownloadProgress) {
+			// Keep only the latest report if the UI falls behind.
+			select {
+			case <-ch:
+			default:
+			}
+			ch <- p
+		}
+		return fn(init)
+	}
+	return tea.Batch(run, waitForDownloadProgress(ch))
+}
+
+// waitForDownloadProgress yields the next progress report from ch, or nothing
+// once the flow that feeds it has finished.
+func waitForDownloadProgress(ch chan host.DownloadProgress) tea.Cmd {
+	return func() tea.Msg {
+		p, ok := <-ch
+		if !ok {
+			return nil
+		}
+		return downloadProgressMsg{progress: p, ch: ch}
+	}
+}
+
+// runPlanCmd computes the users the next provisioning run would create and
+// returns a planDoneMsg so the UI can ask for confirmation.
+func runPlanCmd(userCount int) tea.Cmd {
+	return func() tea.Msg {
+		init := host.NewInitializer(paths.ConfigPath(), paths.StatePath())
+		plan, summary, err := init.PlanUsersSummary(context.Background(), userCount)
+		return planDoneMsg{plan: plan, summary: summary, count: userCount, err: err}
 	}
 }
 
 // runAddUsersCmd runs the "add users" flow in a separate goroutine and
 // returns a Bubble Tea command that yields a provisionDoneMsg when complete.
 func runAddUsersCmd(userCount int) tea.Cmd {
-	return func() tea.Msg {
-		init := host.NewInitializer(paths.ConfigPath(), paths.StatePath())
-		prismPath, _ := os.Executable()
+	return withDownloadProgress(func(init *host.Initializer) tea.Msg {
+		prismPath, err := os.Executable()
+		if err != nil {
+			return provisionDoneMsg{err: fmt.Errorf("locate prism binary: %w", err)}
+		}
 		res, err := init.AddUsers(context.Background(), userCount, prismPath)
 		return provisionDoneMsg{result: res, err: err}
-	}
+	})
 }
 
 // runViewUsersCmd only loads the current state and wraps it into a
@@ -56,34 +108,158 @@ func runViewUsersCmd() tea.Cmd {
 	}
 }
 
-func runUpdateUsersCodeCmd() tea.Cmd {
+// runUpdateUsersCodeCmd updates every user's service code. With
+// refreshWrapper the per-user prism-host copy is refreshed from the running
+// binary as well.
+func runUpdateUsersCodeCmd(refreshWrapper bool) tea.Cmd {
+	return withDownloadProgress(func(init *host.Initializer) tea.Msg {
+		var opts host.UpdateOptions
+		if refreshWrapper {
+			prismPath, err := os.Executable()
+			if err != nil {
+				return provisionDoneMsg{err: fmt.Errorf("locate prism binary: %w", err)}
+			}
+			opts.PrismPath = prismPath
+		}
+		res, err := init.UpdateUserCode(context.Background(), opts)
+		return provisionDoneMsg{result: res, err: err}
+	})
+}
+
+// runPreviewUpdateCmd compares the deployed bundle with the latest release
+// and returns a previewDoneMsg so the UI can ask for confirmation.
+func runPreviewUpdateCmd() tea.Cmd {
+	return func() tea.Msg {
+		init := host.NewInitializer(paths.ConfigPath(), paths.StatePath())
+		p, err := init.PreviewUpdate(context.Background())
+		return previewDoneMsg{preview: p, err: err}
+	}
+}
+
+// runRepairUsersCmd retries the LaunchDaemon bootstrap of each named user and
+// returns a repairDoneMsg with the resulting state and any failures.
+func runRepairUsersCmd(names []string) tea.Cmd {
 	return func() tea.Msg {
 		init := host.NewInitializer(paths.ConfigPath(), paths.StatePath())
-		res, err := init.UpdateUserCode(context.Background())
-		return provisionDoneMsg{result: res, err: err}
+		var msg repairDoneMsg
+		for _, name := range names {
+			st, err := init.RepairUser(context.Background(), name)
+			if err != nil {
+				msg.failed = append(msg.failed, fmt.Sprintf("%s: %v", name, err))
+				continue
+			}
+			msg.state = &st
+		}
+		return msg
+	}
+}
+
+// runLastUpdateCmd reads the recorded bundle version so the menu can show when
+// the users were last updated.
+func runLastUpdateCmd() tea.Cmd {
+	return func() tea.Msg {
+		init := host.NewInitializer(paths.ConfigPath(), paths.StatePath())
+		info, err := init.LastUpdate(context.Background())
+		return lastUpdateMsg{info: info, err: err}
 	}
 }
 
 // runServicesCmd runs the services status inspection and returns a
-// servicesDoneMsg for the UI to render.
-func runServicesCmd() tea.Cmd {
+// servicesDoneMsg for the UI to render. seq ties the result to the watch
+// session that requested it so stale refreshes can be dropped. probeTunnels
+// also checks each user's public URL, which watch mode leaves out.
+func runServicesCmd(seq int, probeTunnels bool) tea.Cmd {
+	return func() tea.Msg {
+		init := host.NewInitializer(paths.ConfigPath(), paths.StatePath())
+		statuses, err := init.UserServiceStatuses(context.Background(), probeTunnels)
+		return servicesDoneMsg{statuses: statuses, err: err, seq: seq}
+	}
+}
+
+// runRefreshFRPCCmd rewrites every user's frpc.toml from the current config
+// and returns a frpcRefreshDoneMsg.
+func runRefreshFRPCCmd() tea.Cmd {
+	return func() tea.Msg {
+		init := host.NewInitializer(paths.ConfigPath(), paths.StatePath())
+		res, err := init.RefreshFRPCConfigs(context.Background())
+		return frpcRefreshDoneMsg{result: res, err: err}
+	}
+}
+
+// runRestartUnhealthyCmd restarts the services of the users whose service
+// check is not healthy and returns a restartDoneMsg.
+func runRestartUnhealthyCmd() tea.Cmd {
+	return func() tea.Msg {
+		init := host.NewInitializer(paths.ConfigPath(), paths.StatePath())
+		res, err := init.RestartUnhealthyUsers(context.Background())
+		return restartDoneMsg{result: res, err: err}
+	}
+}
+
+// runMaintenanceCmd reads the host's maintenance mode for the menu header.
+func runMaintenanceCmd() tea.Cmd {
 	return func() tea.Msg {
 		init := host.NewInitializer(paths.ConfigPath(), paths.StatePath())
-		statuses, err := init.UserServiceStatuses(context.Background())
-		return servicesDoneMsg{statuses: statuses, err: err}
+		info, err := init.Maintenance(context.Background())
+		return maintenanceMsg{info: info, err: err}
+	}
+}
+
+// runMaintenanceToggleCmd enters maintenance mode, or leaves it when enter is
+// false, and returns a maintenanceDoneMsg.
+func runMaintenanceToggleCmd(enter bool) tea.Cmd {
+	return func() tea.Msg {
+		init := host.NewInitializer(paths.ConfigPath(), paths.StatePath())
+		var err error
+		if enter {
+			err = init.EnterMaintenance(context.Background(), "")
+		} else {
+			err = init.ExitMaintenance(context.Background())
+		}
+		return maintenanceDoneMsg{entered: enter, err: err}
+	}
+}
+
+// servicesWatchTickCmd waits one watch interval and then yields a
+// servicesTickMsg that triggers the next status refresh.
+func servicesWatchTickCmd(seq int) tea.Cmd {
+	return tea.Tick(servicesWatchInterval, func(time.Time) tea.Msg {
+		return servicesTickMsg{seq: seq}
+	})
+}
+
+// runPlanRemoveUserCmd lists what removing username would delete and returns
+// a removePlanDoneMsg so the UI can show it before asking for confirmation.
+func runPlanRemoveUserCmd(username string) tea.Cmd {
+	return func() tea.Msg {
+		init := host.NewInitializer(paths.ConfigPath(), paths.StatePath())
+		plan, err := init.PlanRemoveUser(context.Background(), username)
+		plan.Username = username
+		return removePlanDoneMsg{plan: plan, err: err}
 	}
 }
 
 // runRemoveUserCmd removes a single Prism user account and its service
-// directory, then returns the updated state wrapped in a ProvisionResult so
-// that the User provisioning section can render it consistently.
-func runRemoveUserCmd(username string) tea.Cmd {
+// directory (or only its services with keepAccount), then returns the updated
+// state wrapped in a ProvisionResult so that the User provisioning section can
+// render it consistently.
+func runRemoveUserCmd(username string, keepAccount bool) tea.Cmd {
 	return func() tea.Msg {
 		init := host.NewInitializer(paths.ConfigPath(), paths.StatePath())
-		st, err := init.RemoveUser(context.Background(), username)
+		st, err := init.RemoveUser(context.Background(), username, keepAccount)
 		if err != nil {
 			return provisionDoneMsg{err: err}
 		}
 		return provisionDoneMsg{result: host.ProvisionResult{State: st, SecretsPath: paths.SecretsPath()}}
 	}
 }
+
+// runOpenSessionCmd opens the Screen Sharing session of a single Prism user
+// and returns a sessionDoneMsg.
+func runOpenSessionCmd(username string) tea.Cmd {
+	return func() tea.Msg {
+		init := host.NewInitializer(paths.ConfigPath(), paths.StatePath())
+		err := init.OpenUserSession(context.Background(), username)
+		return sessionDoneMsg{user: username, err: err}
+	}
+}
@@ -1,10 +1,16 @@
 package root
 
 import (
+	"errors"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/charmbracelet/lipgloss"
+
+	"prism/internal/control/host"
+	"prism/internal/infra/state"
+	"prism/internal/ui/layout"
 )
 
 const footerHint = "↑/k up  •  ↓/j down  •  Enter select  •  q quit"
@@ -48,28 +54,54 @@ func (m Model) View() string {
 		},
 		{
 			title: "Update user code",
-			desc:  "Download latest service bundle and update all Prism users",
+			desc:  "Download latest service bundle and update all Prism users (w: also refresh the prism wrapper)",
 		},
 		{
 			title: "Services status",
 			desc:  "Check service status for each Prism user",
 		},
+		{
+			title: "Watch services",
+			desc:  "Live-refresh service status and highlight changes",
+		},
+		{
+			title: "Open user session",
+			desc:  "Open one Prism user's Screen Sharing session over the admin SSH tunnel",
+		},
 		{
 			title: "Remove user",
 			desc:  "Remove a Prism user and its services",
 		},
+		{
+			title: "Refresh frpc configs",
+			desc:  "Rewrite every user's frpc.toml from prism.json and restart running frpc",
+		},
+		{
+			title: "Maintenance mode",
+			desc:  "Stop every user's daemons and keep them stopped across reboots",
+		},
 		{
 			title: "Quit",
 			desc:  "Exit Prism",
 		},
 	}
+	if m.maintenance != nil && m.maintenance.Active {
+		items[9].desc = "Start every user's daemons again and leave maintenance"
+	}
 
 	var b strings.Builder
 
 	// Title capsule
 	b.WriteString(titleStyle.Render(" Prism ") + "\n\n")
 
-	b.WriteString(countStyle.Render(fmt.Sprintf("  %d items", len(items))) + "\n\n")
+	b.WriteString(countStyle.Render(fmt.Sprintf("  %d items", len(items))) + "\n")
+	if m.lastUpdate != nil {
+		b.WriteString(subtleText.Render("  "+lastUpdateLine(*m.lastUpdate)) + "\n")
+	}
+	if m.maintenance != nil && m.maintenance.Active {
+		b.WriteString(checkFailStyle.Render("  "+m.maintenance.String()) + "\n")
+	}
+	b.WriteString("\n")
 
 	// Menu items
 	for i, it := range items {
@@ -81,19 +113,21 @@ func (m Model) View() string {
 		}
 
 		title := inactiveTitle.Render(it.title)
-		desc := inactiveDesc.Render(it.desc)
 		if selected {
 			title = activeTitle.Render(it.title)
-			desc = activeDesc.Render(it.desc)
 		}
 
 		b.WriteString(border + title + "\n")
-		b.WriteString("  " + desc + "\n\n")
+		descStyle := inactiveDesc
+		if selected {
+			descStyle = activeDesc
+		}
+		b.WriteString(m.wrapLines(it.desc, "  ", descStyle) + "\n")
 	}
 
 	// Status line
 	if m.status != "" {
-		b.WriteString(statusStyle.Render(m.status) + "\n")
+		b.WriteString(statusStyle.Width(layout.Width(m.width)).Render(m.status) + "\n")
 	}
 
 	// Show errors prominently first, before technical details
@@ -109,164 +143,81 @@ func (m Model) View() string {
 			title = "[x] Remove user failed"
 		case provisionKindUpdate:
 			title = "[x] Update user code failed"
+		case provisionKindSession:
+			title = "[x] Failed to load users"
 		}
 		b.WriteString(checkFailStyle.Render("  "+title) + "\n")
 
 		// Show a user-friendly error message instead of technical stack trace
-		errorMsg := m.provisionErr.Error()
-		if strings.Contains(errorMsg, "permission denied") {
+		switch {
+		case errors.Is(m.provisionErr, host.ErrPermissionDenied):
 			b.WriteString("  " + subtleText.Render("Permission denied. Please run with sudo:") + "\n")
 			b.WriteString("  " + accentBorder.Render("sudo ./prism") + "\n")
-		} else if strings.Contains(errorMsg, "download archive") {
+		case errors.Is(m.provisionErr, host.ErrDownloadFailed):
 			b.WriteString("  " + subtleText.Render("Failed to download service bundle. Check your internet connection and GitHub token.") + "\n")
-		} else if strings.Contains(errorMsg, "user") && strings.Contains(errorMsg, "already exists") {
+		case errors.Is(m.provisionErr, host.ErrUserExists):
 			b.WriteString("  " + subtleText.Render("User already exists. Use 'Add users' instead of 'Setup'.") + "\n")
-		} else {
+		case errors.Is(m.provisionErr, host.ErrPasswordPolicy):
+			b.WriteString("  " + subtleText.Render("macOS rejected the password under this Mac's password policy. Set a stronger globals.default_password in prism.json.") + "\n")
+		case errors.Is(m.provisionErr, host.ErrTimeout):
+			b.WriteString("  " + subtleText.Render("The run exceeded globals.service.provision_timeout_minutes and was stopped:") + "\n")
+			b.WriteString(m.wrapLines(m.provisionErr.Error(), "  ", subtleText))
+		case errors.Is(m.provisionErr, host.ErrPortsInUse):
+			b.WriteString("  " + subtleText.Render("Other software already listens on ports Prism would assign; no accounts were created. Free them or change globals.service.start_port:") + "\n")
+			b.WriteString(m.wrapLines(m.provisionErr.Error(), "  ", subtleText))
+		case errors.Is(m.provisionErr, host.ErrInvalidBundle):
+			b.WriteString("  " + subtleText.Render("The service bundle is incomplete or unsigned; no users were changed. Check the release against globals.service.required_paths:") + "\n")
+			b.WriteString(m.wrapLines(m.provisionErr.Error(), "  ", subtleText))
+		case errors.Is(m.provisionErr, host.ErrHomeDirFailed):
+			b.WriteString("  " + subtleText.Render("macOS could not create the user's home directory. Check free disk space and the permissions of /Users.") + "\n")
+		case errors.Is(m.provisionErr, host.ErrFRPCMissing):
+			b.WriteString("  " + subtleText.Render("frpc is not installed and could not be installed automatically. Run:") + "\n")
+			b.WriteString("  " + accentBorder.Render("brew install frpc") + "\n")
+		default:
 			// For other errors, show a simplified version
-			lines := strings.Split(errorMsg, "\n")
-			mainError := lines[0]
-			if len(mainError) > 80 {
-				mainError = mainError[:77] + "..."
-			}
-			b.WriteString("  " + subtleText.Render(mainError) + "\n")
+			mainError, _, _ := strings.Cut(m.provisionErr.Error(), "\n")
+			b.WriteString("  " + subtleText.Render(layout.Truncate(mainError, layout.Width(m.width)-2)) + "\n")
+		}
+		if m.provisionResult != nil {
+			b.WriteString(passwordsSection(m.provisionResult.Passwords, checkFailStyle, activeTitle))
 		}
 		b.WriteString("\n")
 	}
 
 	// Render Preflight / Dependencies results (if present), but only when not showing errors
-	if m.initResult != nil && m.provisionErr == nil {
-		checks := m.initResult.Preflight.Checks
-		if len(checks) > 0 {
-			b.WriteString("\n")
-
-			total := len(checks)
-			passed := 0
-			for _, c := range checks {
-				if c.OK {
-					passed++
-				}
-			}
-
-			header := fmt.Sprintf("Preflight checks – %d/%d passed", passed, total)
-			headerStyle := subtleText
-			if passed == total {
-				headerStyle = checkOKStyle
-			}
-			b.WriteString("  " + headerStyle.Render(header) + "\n")
-
-			// Use the first failing step as the focus. Expand its details while
-			// keeping other failing steps summarized and passed steps minimal.
-			focus := -1
-			for i, c := range checks {
-				if !c.OK {
-					focus = i
-					break
-				}
-			}
-			if focus != -1 {
-				b.WriteString("  " + subtleText.Render("The first failing step below is blocking setup.") + "\n")
-			}
-
-			for i, c := range checks {
-				stepLabel := fmt.Sprintf("Step %d: %s", i+1, c.Name)
-
-				if c.OK {
-					line := checkOKStyle.Render(fmt.Sprintf("  [✓] %s", stepLabel))
-					b.WriteString(line + "\n")
-					continue
-				}
-
-				// Failing step
-				if i == focus {
-					// Focus step: expand details to draw user attention.
-					line := checkFailStyle.Render(fmt.Sprintf("  [!] %s", stepLabel))
-					b.WriteString(line + "\n")
-					if c.Detail != "" {
-						for _, line := range strings.Split(c.Detail, "\n") {
-							b.WriteString("    " + subtleText.Render(line) + "\n")
-						}
-					}
-					continue
-				}
-
-				// Non-focus failing step: show only a short summary to avoid
-				// overwhelming the user with information.
-				short := "Blocked by previous steps"
-				line := subtleText.Render(fmt.Sprintf("  [ ] %s (%s)", stepLabel, short))
-				b.WriteString(line + "\n")
-			}
-		}
-
-		depsRes := m.initResult.Deps
-		if len(depsRes.Items) > 0 {
-			b.WriteString("\n")
-			b.WriteString("  " + activeTitle.Render("Dependencies") + "\n")
-
-			totalDeps := len(depsRes.Items)
-			readyDeps := 0
-			for _, it := range depsRes.Items {
-				if it.OK {
-					readyDeps++
-				}
-			}
-
-			depsHeader := fmt.Sprintf("Dependencies — %d/%d ready", readyDeps, totalDeps)
-			depsHeaderStyle := subtleText
-			if readyDeps == totalDeps {
-				depsHeaderStyle = checkOKStyle
-			}
-			b.WriteString("  " + depsHeaderStyle.Render(depsHeader) + "\n")
-
-			if readyDeps != totalDeps {
-				b.WriteString("  " + subtleText.Render("Some dependencies below are blocking setup.") + "\n")
-			}
-
-			for _, it := range depsRes.Items {
-				name := string(it.Name)
-				detail := strings.TrimSpace(it.Detail)
-				if idx := strings.Index(detail, "\n"); idx >= 0 {
-					detail = detail[:idx]
-				}
-				if it.OK {
-					if detail == "" {
-						detail = "Ready"
-					}
-					line := checkOKStyle.Render(fmt.Sprintf("  [✓] %s – %s", name, detail))
-					b.WriteString(line + "\n")
-					continue
-				}
-
-				line := checkFailStyle.Render(fmt.Sprintf("  [!] %s", name))
-				b.WriteString(line + "\n")
-				if detail != "" {
-					for _, l := range strings.Split(it.Detail, "\n") {
-						b.WriteString("    " + subtleText.Render(l) + "\n")
-					}
-				}
-			}
-		}
+	if m.initResult != nil && m.provisionErr == nil && len(m.checkEntries()) > 0 {
+		b.WriteString("\n")
+		b.WriteString(m.checksViewContent(checkStyles{
+			subtle:   subtleText,
+			ok:       checkOKStyle,
+			fail:     checkFailStyle,
+			title:    activeTitle,
+			selected: accentBorder,
+		}))
 	}
 
 	// Service status view.
 	if m.servicesRunning || m.servicesErr != nil || len(m.services) > 0 {
 		b.WriteString("\n")
-		b.WriteString("  " + activeTitle.Render("Service status") + "\n")
+		if m.watching {
+			b.WriteString("  " + activeTitle.Render("Service status (watching)") + "\n")
+		} else {
+			b.WriteString("  " + activeTitle.Render("Service status") + "\n")
+		}
 
 		if m.servicesRunning {
 			b.WriteString("  " + subtleText.Render("Checking Prism user service status. Please wait...") + "\n")
 		} else if m.servicesErr != nil {
 			line := checkFailStyle.Render("  [!] Failed to check service status")
 			b.WriteString(line + "\n")
-			for _, l := range strings.Split(m.servicesErr.Error(), "\n") {
-				b.WriteString("    " + subtleText.Render(l) + "\n")
-			}
+			b.WriteString(m.wrapLines(m.servicesErr.Error(), "    ", subtleText))
 		} else if len(m.services) == 0 {
 			b.WriteString("  " + subtleText.Render("There are currently no Prism users.") + "\n")
 		} else {
 			total := len(m.services)
 			healthy := 0
 			for _, s := range m.services {
-				if s.ServiceDirOK && s.PortListening {
+				if s.Healthy() {
 					healthy++
 				}
 			}
@@ -281,21 +232,125 @@ func (m Model) View() string {
 			b.WriteString("  " + headerStyle.Render(header) + "\n")
 
 			for _, s := range m.services {
-				ok := s.ServiceDirOK && s.PortListening
+				ok := s.Healthy()
 				var line string
 				base := fmt.Sprintf("%s • port %d • subdomain %s", s.Name, s.Port, s.Subdomain)
+				for _, svc := range s.Services {
+					base += fmt.Sprintf(" • %s port %d", svc.Name, svc.Port)
+				}
+				base += " • " + s.Keepalive.Summary()
+				tunnelDown := s.TunnelChecked && !s.TunnelReachable
+				if tunnelDown {
+					base += " • tunnel unreachable"
+				}
+				manifestIncomplete := len(s.ManifestMissing) > 0
+				if manifestIncomplete {
+					base += " • manifest incomplete"
+				}
 				if ok {
 					line = checkOKStyle.Render("  [✓] " + base)
 				} else {
 					line = checkFailStyle.Render("  [!] " + base)
 				}
+				if change, changed := m.serviceChanges[s.Name]; changed && m.watching {
+					if ok {
+						line += " " + checkOKStyle.Render("("+change+")")
+					} else {
+						line += " " + activeDesc.Render("("+change+")")
+					}
+				}
 				b.WriteString("  " + line + "\n")
-				if !ok && strings.TrimSpace(s.Detail) != "" {
+				if (!ok || tunnelDown || manifestIncomplete) && strings.TrimSpace(s.Detail) != "" {
 					for _, l := range strings.Split(s.Detail, ";") {
-						b.WriteString("    " + subtleText.Render(strings.TrimSpace(l)) + "\n")
+						b.WriteString(m.wrapLines(strings.TrimSpace(l), "    ", subtleText))
 					}
 				}
 			}
+			if n := total - healthy; n > 0 && !m.watching {
+				if m.restartRunning {
+					b.WriteString("  " + subtleText.Render("Restarting the unhealthy users. Please wait...") + "\n")
+				} else {
+					b.WriteString("  " + subtleText.Render(fmt.Sprintf("Press u to restart the %d unhealthy users without touching healthy ones.", n)) + "\n")
+				}
+			}
+		}
+	}
+
+	// frpc config refresh.
+	if m.frpcRefreshRunning || m.frpcRefresh != nil || m.frpcRefreshErr != nil {
+		b.WriteString("\n")
+		b.WriteString("  " + activeTitle.Render("Refresh frpc configs") + "\n")
+		switch {
+		case m.frpcRefreshRunning:
+			b.WriteString("  " + subtleText.Render("Rewriting frpc.toml for all users. Please wait...") + "\n")
+		case m.frpcRefresh != nil:
+			failed := failedUpdates(m.frpcRefresh.Users)
+			total := len(m.frpcRefresh.Users)
+			if len(failed) == 0 {
+				b.WriteString("  " + checkOKStyle.Render(fmt.Sprintf("[✓] Refreshed frpc.toml for %d Prism users.", total)) + "\n")
+			} else {
+				b.WriteString("  " + checkFailStyle.Render(fmt.Sprintf("Refreshed frpc.toml for %d of %d Prism users.", total-len(failed), total)) + "\n")
+				for _, name := range failed {
+					b.WriteString("  " + checkFailStyle.Render("[x] "+name) + "\n")
+					b.WriteString(m.wrapLines(m.frpcRefresh.Users[name].Error(), "      ", subtleText))
+				}
+			}
+			if len(m.frpcRefresh.Restarted) > 0 {
+				b.WriteString(m.wrapLines("frpc restarted for: "+strings.Join(m.frpcRefresh.Restarted, ", "), "  ", subtleText))
+			} else {
+				b.WriteString("  " + subtleText.Render("No frpc daemon was running; the new files apply on their next start.") + "\n")
+			}
+		default:
+			b.WriteString(m.wrapLines("[!] "+m.frpcRefreshErr.Error(), "    ", checkFailStyle))
+		}
+	}
+
+	// Provisioning plan awaiting confirmation.
+	if m.awaitPlanConfirm || m.planRunning {
+		b.WriteString("\n")
+		b.WriteString("  " + activeTitle.Render("Provisioning plan") + "\n")
+		if m.planRunning {
+			b.WriteString("  " + subtleText.Render("Computing usernames and ports. Please wait...") + "\n")
+		} else {
+			b.WriteString(planSummarySection(m.planSummary, subtleText))
+			for _, p := range m.plan {
+				base := fmt.Sprintf("%s • port %d", p.Name, p.Port)
+				if p.UID > 0 {
+					base += fmt.Sprintf(" • UID %d", p.UID)
+				}
+				if p.Conflict != "" {
+					b.WriteString("  " + checkFailStyle.Render("  [!] "+base+" – "+p.Conflict) + "\n")
+					continue
+				}
+				b.WriteString("  " + subtleText.Render("  • "+base) + "\n")
+			}
+			b.WriteString("  " + subtleText.Render("Subdomains are generated when each user is created.") + "\n")
+		}
+	}
+
+	// Unfinished Setup awaiting confirmation to resume.
+	if m.awaitResumeConfirm && m.initResult != nil && m.initResult.Resume != nil {
+		r := m.initResult.Resume
+		b.WriteString("\n")
+		b.WriteString("  " + activeTitle.Render("Resume setup") + "\n")
+		b.WriteString("  " + subtleText.Render(fmt.Sprintf("%d of %d users are provisioned; %d remain to be created.", r.UsersDone, r.UsersTarget, r.UsersTarget-r.UsersDone)) + "\n")
+		if len(r.DaemonsPending) > 0 {
+			b.WriteString(m.wrapLines("LaunchDaemons bootstrapped again: "+strings.Join(r.DaemonsPending, ", "), "  ", subtleText))
+		}
+		b.WriteString("  " + subtleText.Render("The host autoboot daemon and Fast Login are set up afterwards; the cached service bundle is reused.") + "\n")
+	}
+
+	// Release comparison awaiting confirmation before "Update user code".
+	if m.awaitUpdateConfirm || m.previewRunning {
+		b.WriteString("\n")
+		b.WriteString("  " + activeTitle.Render("Update preview") + "\n")
+		switch {
+		case m.previewRunning:
+			b.WriteString("  " + subtleText.Render("Fetching the latest release. Please wait...") + "\n")
+		case m.previewErr != nil:
+			b.WriteString(m.wrapLines("[!] "+m.previewErr.Error(), "    ", checkFailStyle))
+		case m.updatePreview != nil:
+			b.WriteString(updatePreviewSection(*m.updatePreview, subtleText, checkOKStyle))
 		}
 	}
 
@@ -343,6 +398,9 @@ func (m Model) View() string {
 				msg = "Updating Prism user code for all users. Please wait..."
 			}
 			b.WriteString("  " + subtleText.Render(msg) + "\n")
+			if m.download != nil {
+				b.WriteString("  " + subtleText.Render(downloadProgressText(*m.download)) + "\n")
+			}
 
 		case m.provisionResult != nil && m.provisionErr == nil:
 			n := len(m.provisionResult.State.Users)
@@ -350,25 +408,58 @@ func (m Model) View() string {
 			switch m.provisionKind {
 			case provisionKindAdd:
 				b.WriteString("  " + checkOKStyle.Render("🎉 Successfully added users!") + "\n")
-				b.WriteString("  " + subtleText.Render(fmt.Sprintf("There are now %d users total. Passwords saved to: %s", n, m.provisionResult.SecretsPath)) + "\n")
+				if m.provisionResult.SecretsPath == "" {
+					b.WriteString("  " + subtleText.Render(fmt.Sprintf("There are now %d users total.", n)) + "\n")
+					b.WriteString(passwordsSection(m.provisionResult.Passwords, checkFailStyle, activeTitle))
+				} else {
+					b.WriteString("  " + subtleText.Render(fmt.Sprintf("There are now %d users total. Passwords saved to: %s", n, m.provisionResult.SecretsPath)) + "\n")
+				}
 			case provisionKindView:
 				b.WriteString("  " + checkOKStyle.Render(fmt.Sprintf("📋 Current users (%d total)", n)) + "\n")
 				b.WriteString("  " + subtleText.Render(fmt.Sprintf("Password records: %s", m.provisionResult.SecretsPath)) + "\n")
 			case provisionKindRemove:
-				if m.lastRemovedUser != "" && !m.awaitRemoveSelection {
+				if m.lastRemovedUser != "" && !m.awaitRemoveSelection && m.removeKeptAccount {
+					b.WriteString("  " + checkOKStyle.Render(fmt.Sprintf("🎉 Removed services of %s (account and data kept)", m.lastRemovedUser)) + "\n")
+					b.WriteString("  " + subtleText.Render(fmt.Sprintf("%d users remaining. Home directory: /Users/%s", n, m.lastRemovedUser)) + "\n")
+				} else if m.lastRemovedUser != "" && !m.awaitRemoveSelection {
 					b.WriteString("  " + checkOKStyle.Render(fmt.Sprintf("🎉 Deleted user %s", m.lastRemovedUser)) + "\n")
 					b.WriteString("  " + subtleText.Render(fmt.Sprintf("%d users remaining. Passwords: %s", n, m.provisionResult.SecretsPath)) + "\n")
 				} else {
 					b.WriteString("  " + checkOKStyle.Render(fmt.Sprintf("📋 Select user to remove (%d total)", n)) + "\n")
-					b.WriteString("  " + subtleText.Render("Use ↑/↓ to select, Enter to confirm, q to cancel") + "\n")
+					hint := "Use ↑/↓ to select, Enter to confirm, q to cancel"
+					if m.awaitRemoveMode {
+						hint = "d: delete account and home · k: keep account and data, remove services only · q: back"
+					}
+					b.WriteString("  " + subtleText.Render(hint) + "\n")
+					if m.awaitRemoveMode {
+						b.WriteString(removalPlanSection(m.removePlan, m.removePlanErr, checkFailStyle, subtleText))
+					}
 				}
+			case provisionKindSession:
+				b.WriteString("  " + checkOKStyle.Render(fmt.Sprintf("📋 Select user session to open (%d total)", n)) + "\n")
+				b.WriteString("  " + subtleText.Render("Use ↑/↓ to select, Enter to open, q to cancel") + "\n")
 			case provisionKindUpdate:
-				b.WriteString("  " + checkOKStyle.Render("🎉 Successfully updated user code!") + "\n")
-				b.WriteString("  " + subtleText.Render(fmt.Sprintf("Updated code for %d Prism users.", n)) + "\n")
+				failed := failedUpdates(m.provisionResult.UpdateResults)
+				if len(failed) == 0 {
+					b.WriteString("  " + checkOKStyle.Render("🎉 Successfully updated user code!") + "\n")
+					b.WriteString("  " + subtleText.Render(fmt.Sprintf("Updated code for %d Prism users.", n)) + "\n")
+					break
+				}
+				total := len(m.provisionResult.UpdateResults)
+				b.WriteString("  " + checkFailStyle.Render(fmt.Sprintf("Updated code for %d of %d Prism users.", total-len(failed), total)) + "\n")
+				for _, name := range failed {
+					b.WriteString("  " + checkFailStyle.Render("[x] "+name) + "\n")
+					b.WriteString(m.wrapLines(m.provisionResult.UpdateResults[name].Error(), "      ", subtleText))
+				}
 			default:
 				// Initial setup success
 				b.WriteString("  " + checkOKStyle.Render("🎉 Setup completed successfully!") + "\n")
-				b.WriteString("  " + subtleText.Render(fmt.Sprintf("Created %d users. Passwords saved to: %s", n, m.provisionResult.SecretsPath)) + "\n")
+				if m.provisionResult.SecretsPath == "" {
+					b.WriteString("  " + subtleText.Render(fmt.Sprintf("Created %d users.", n)) + "\n")
+					b.WriteString(passwordsSection(m.provisionResult.Passwords, checkFailStyle, activeTitle))
+				} else {
+					b.WriteString("  " + subtleText.Render(fmt.Sprintf("Created %d users. Passwords saved to: %s", n, m.provisionResult.SecretsPath)) + "\n")
+				}
 				b.WriteString("\n")
 				b.WriteString("  " + activeTitle.Render("Next steps:") + "\n")
 				b.WriteString("  " + subtleText.Render("1. Switch to each user account to start their services") + "\n")
@@ -378,21 +469,187 @@ func (m Model) View() string {
 			b.WriteString("\n")
 			for idx, u := range m.provisionResult.State.Users {
 				prefix := "  • "
-				if m.provisionKind == provisionKindRemove && m.awaitRemoveSelection && idx == m.removeIndex {
+				if m.selectedUserIndex() == idx {
 					prefix = "  ▶ "
 				}
 				line := fmt.Sprintf("%s%s (port %d, subdomain: %s)", prefix, u.Name, u.Port, u.Subdomain)
+				if labels := state.FormatLabels(u.Labels); labels != "" {
+					line += " [" + labels + "]"
+				}
 				style := subtleText
-				if m.provisionKind == provisionKindRemove && m.awaitRemoveSelection && idx == m.removeIndex {
+				if m.selectedUserIndex() == idx {
 					style = activeTitle
 				}
-				b.WriteString(style.Render(line) + "\n")
+				b.WriteString(style.Render(line))
+				if u.DaemonsPending {
+					b.WriteString(" " + checkFailStyle.Render("– LaunchDaemons not started"))
+				}
+				b.WriteString("\n")
+			}
+			if len(pendingDaemonUsers(m.provisionResult.State)) > 0 && !m.awaitRemoveSelection && !m.awaitSessionSelection {
+				if m.repairRunning {
+					b.WriteString("  " + subtleText.Render("Retrying LaunchDaemon bootstrap. Please wait...") + "\n")
+				} else {
+					b.WriteString("  " + subtleText.Render("Press r to retry starting their LaunchDaemons without recreating the accounts.") + "\n")
+				}
 			}
 		}
 	}
 
 	b.WriteString("\n")
-	b.WriteString(footerStyle.Render(footerHint) + "\n")
+	hint := footerHint
+	if m.hasResults() && !m.busy() {
+		hint += "  •  Esc back to menu"
+	}
+	if m.initResult != nil && len(m.checkEntries()) > 0 {
+		hint += "  •  " + checksHint
+	}
+	b.WriteString(footerStyle.Render(hint) + "\n")
+
+	return b.String()
+}
+
+// wrapLines renders s word-wrapped to the terminal width, each line styled
+// with st and prefixed with indent.
+func (m Model) wrapLines(s, indent string, st lipgloss.Style) string {
+	var b strings.Builder
+	for _, l := range layout.Wrap(s, m.width, lipgloss.Width(indent)) {
+		b.WriteString(indent + st.Render(l) + "\n")
+	}
+	return b.String()
+}
+
+// lastUpdateLine formats the recorded version, e.g.
+// "Last updated: v1.2.3 at 2024-05-01 10:30 (8 users)".
+func lastUpdateLine(info host.VersionInfo) string {
+	line := "Last updated: " + info.Tag
+	if t, err := time.Parse(time.RFC3339, info.UpdatedAt); err == nil {
+		line += " at " + t.Local().Format("2006-01-02 15:04")
+	}
+	if info.UsersUpdated > 0 {
+		line += fmt.Sprintf(" (%d users)", info.UsersUpdated)
+	}
+	return line
+}
+
+// passwordsSection renders passwords that were not written to disk
+// (globals.service.store_secrets is false), warning that they cannot be
+// recovered once the screen is left.
+func passwordsSection(passwords []host.UserPassword, warnStyle, itemStyle lipgloss.Style) string {
+	if len(passwords) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteString("  " + warnStyle.Render("Passwords were NOT saved to disk and cannot be recovered later. Record them now:") + "\n")
+	for _, p := range passwords {
+		b.WriteString("  " + itemStyle.Render(fmt.Sprintf("%s  %s", p.Username, p.Password)) + "\n")
+	}
+	return b.String()
+}
+
+// downloadProgressText describes bundle download progress, showing only the
+// bytes received when the total size is unknown.
+func downloadProgressText(p host.DownloadProgress) string {
+	if p.Total <= 0 {
+		return fmt.Sprintf("Downloading service bundle: %s", formatMB(p.Done))
+	}
+	return fmt.Sprintf("Downloading service bundle: %s of %s (%d%%)", formatMB(p.Done), formatMB(p.Total), p.Done*100/p.Total)
+}
+
+func formatMB(n int64) string {
+	return fmt.Sprintf("%.1f MB", float64(n)/(1<<20))
+}
 
+// maxPreviewNoteLines caps how many lines of release notes the update preview
+// shows.
+const maxPreviewNoteLines = 15
+
+// updatePreviewSection renders the version delta and, when an update is
+// available, the release name and notes.
+func updatePreviewSection(p host.UpdatePreview, subtle, ok lipgloss.Style) string {
+	var b strings.Builder
+	b.WriteString("  " + ok.Render("  "+p.Summary()) + "\n")
+	if !p.UpdateAvailable() {
+		return b.String()
+	}
+	title := p.ReleaseName
+	if title == "" {
+		title = p.Latest
+	}
+	if p.PublishedAt != "" {
+		title += " (published " + p.PublishedAt + ")"
+	}
+	b.WriteString("  " + subtle.Render("  "+title) + "\n")
+	if p.Notes == "" {
+		b.WriteString("  " + subtle.Render("  No release notes.") + "\n")
+		return b.String()
+	}
+	lines := strings.Split(p.Notes, "\n")
+	if len(lines) > maxPreviewNoteLines {
+		lines = append(lines[:maxPreviewNoteLines], fmt.Sprintf("... (%d more lines; see %s)", len(lines)-maxPreviewNoteLines, p.URL))
+	}
+	for _, l := range lines {
+		b.WriteString("  " + subtle.Render("    "+strings.TrimRight(l, "\r ")) + "\n")
+	}
 	return b.String()
 }
+
+// planSummarySection renders the overview shown above the planned users.
+func planSummarySection(s host.PlanSummary, subtle lipgloss.Style) string {
+	var b strings.Builder
+	b.WriteString("  " + subtle.Render(fmt.Sprintf("  %d users named %s", s.Count, s.UsernameTemplate)) + "\n")
+	if s.Count > 0 {
+		b.WriteString("  " + subtle.Render(fmt.Sprintf("  Ports %d-%d", s.FirstPort, s.LastPort)) + "\n")
+	}
+	b.WriteString("  " + subtle.Render(fmt.Sprintf("  URLs %s://<random>.%s", s.Scheme, s.DomainSuffix)) + "\n")
+	return b.String()
+}
+
+// removalPlanSection lists what removing a user deletes, with the warnings
+// first so a user outside state or machine_id is noticed before confirming.
+func removalPlanSection(p *host.RemovalPlan, err error, warnStyle, subtle lipgloss.Style) string {
+	var b strings.Builder
+	if err != nil {
+		b.WriteString("  " + warnStyle.Render(fmt.Sprintf("Could not list what will be deleted: %v", err)) + "\n")
+		return b.String()
+	}
+	if p == nil {
+		return ""
+	}
+	for _, w := range p.Warnings {
+		b.WriteString("  " + warnStyle.Render("⚠ "+w) + "\n")
+	}
+	b.WriteString("  " + subtle.Render("  Deleted with d only:") + "\n")
+	if p.AccountExists {
+		b.WriteString("  " + subtle.Render("    macOS account "+p.Username) + "\n")
+	}
+	if p.HomeDir != "" {
+		b.WriteString("  " + subtle.Render("    home directory "+p.HomeDir) + "\n")
+	}
+	if !p.AccountExists && p.HomeDir == "" {
+		b.WriteString("  " + subtle.Render("    nothing") + "\n")
+	}
+	b.WriteString("  " + subtle.Render("  Deleted with d or k:") + "\n")
+	for _, path := range p.LaunchDaemons {
+		b.WriteString("  " + subtle.Render("    "+path) + "\n")
+	}
+	if p.LaunchAgent != "" {
+		b.WriteString("  " + subtle.Render("    "+p.LaunchAgent) + "\n")
+	}
+	if e := p.StateEntry; e != nil {
+		b.WriteString("  " + subtle.Render(fmt.Sprintf("    state entry %s (port %d, subdomain %s)", e.Name, e.Port, e.Subdomain)) + "\n")
+	}
+	return b.String()
+}
+
+// selectedUserIndex returns the index of the user highlighted in the remove or
+// open-session selection list, or -1 when no list is being selected from.
+func (m Model) selectedUserIndex() int {
+	switch {
+	case m.provisionKind == provisionKindRemove && m.awaitRemoveSelection:
+		return m.removeIndex
+	case m.provisionKind == provisionKindSession && m.awaitSessionSelection:
+		return m.sessionIndex
+	}
+	return -1
+}
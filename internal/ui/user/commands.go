@@ -1,6 +1,9 @@
 package user
 
 import (
+	"context"
+	"fmt"
+
 	tea "github.com/charmbracelet/bubbletea"
 
 	userinfra "prism/internal/infra/user"
@@ -8,13 +11,30 @@ import (
 
 func runGetAPIKeyCmd() tea.Cmd {
 	return func() tea.Msg {
-		return getKeyDoneMsg{status: userinfra.GetAPIKey()}
+		key, err := userinfra.RequestAPIKey()
+		if err != nil {
+			return getKeyDoneMsg{status: fmt.Sprintf("Failed to get API key: %v", err)}
+		}
+		return getKeyDoneMsg{status: userinfra.FormatAPIKeyStatus(key), key: key}
+	}
+}
+
+func runListAPIKeysCmd() tea.Cmd {
+	return func() tea.Msg {
+		return keysDoneMsg{status: userinfra.ListAPIKeys()}
+	}
+}
+
+func runRevokeAPIKeyCmd(id string) tea.Cmd {
+	return func() tea.Msg {
+		return keysDoneMsg{status: userinfra.RevokeAPIKey(id)}
 	}
 }
 
 func runPrewarmPermissionsCmd() tea.Cmd {
 	return func() tea.Msg {
-		return prewarmDoneMsg{status: userinfra.PrewarmPermissions()}
+		report := userinfra.PrewarmPermissionsReport()
+		return prewarmDoneMsg{status: report.Message, checks: report.Checks}
 	}
 }
 
@@ -24,9 +44,9 @@ func runRenameFriendlyCmd(name string) tea.Cmd {
 	}
 }
 
-func runDeployCmd() tea.Cmd {
+func runDeployCmd(ctx context.Context) tea.Cmd {
 	return func() tea.Msg {
-		return deployDoneMsg{status: userinfra.Deploy()}
+		return deployDoneMsg{status: userinfra.Deploy(ctx)}
 	}
 }
 
@@ -53,3 +73,27 @@ func runRestartFRPCCmd() tea.Cmd {
 		return stopDoneMsg{status: userinfra.RestartFRPC()}
 	}
 }
+
+func runKeepaliveStatusCmd() tea.Cmd {
+	return func() tea.Msg {
+		return stopDoneMsg{status: userinfra.KeepaliveStatus()}
+	}
+}
+
+func runStartKeepaliveCmd() tea.Cmd {
+	return func() tea.Msg {
+		return stopDoneMsg{status: userinfra.StartKeepalive()}
+	}
+}
+
+func runStopKeepaliveCmd() tea.Cmd {
+	return func() tea.Msg {
+		return stopDoneMsg{status: userinfra.StopKeepalive()}
+	}
+}
+
+func runRecentErrorsCmd() tea.Cmd {
+	return func() tea.Msg {
+		return logsDoneMsg{tails: userinfra.RecentErrors()}
+	}
+}
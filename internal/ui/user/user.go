@@ -1,10 +1,13 @@
 package user
 
 import (
+	"context"
 	"fmt"
 	"strings"
 
 	tea "github.com/charmbracelet/bubbletea"
+
+	userinfra "prism/internal/infra/user"
 )
 
 // Model is the per-user TUI model.
@@ -15,6 +18,25 @@ type Model struct {
 	busy        bool
 	renaming    bool
 	renameInput string
+	revoking    bool
+	revokeInput string
+
+	// cancelDeploy aborts the running "Deploy / start services" action; nil
+	// when no deploy runs.
+	cancelDeploy context.CancelFunc
+
+	// width is the terminal width, zero until reported.
+	width int
+
+	permissionChecks []userinfra.PermissionCheck
+	// recentLogs is the output of the last "View recent errors" action.
+	recentLogs []userinfra.LogTail
+
+	// apiKey is the one-time key from the last "Get API key" action. It is
+	// kept only until the next menu action so it can be copied or shown as a
+	// QR code.
+	apiKey   string
+	apiKeyQR string
 }
 
 // New creates a new user-mode model.
@@ -32,6 +54,9 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	switch msg := msg.(type) {
 	case tea.KeyMsg:
 		return m.updateForKeyMsg(msg)
+	case tea.WindowSizeMsg:
+		m.width = msg.Width
+		return m, nil
 	case stopDoneMsg:
 		m.busy = false
 		m.status = msg.status
@@ -43,15 +68,31 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	case prewarmDoneMsg:
 		m.busy = false
 		m.status = msg.status
+		m.permissionChecks = msg.checks
 		return m, nil
 	case getKeyDoneMsg:
 		m.busy = false
 		m.status = msg.status
+		m.apiKey = msg.key
+		m.apiKeyQR = ""
 		return m, nil
 	case deployDoneMsg:
 		m.busy = false
+		if m.cancelDeploy != nil {
+			m.cancelDeploy()
+			m.cancelDeploy = nil
+		}
 		m.status = msg.status
 		return m, nil
+	case keysDoneMsg:
+		m.busy = false
+		m.status = msg.status
+		return m, nil
+	case logsDoneMsg:
+		m.busy = false
+		m.status = userinfra.RecentErrorsStatus(msg.tails)
+		m.recentLogs = msg.tails
+		return m, nil
 	}
 
 	return m, nil
@@ -60,7 +101,19 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 func (m Model) updateForKeyMsg(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 	if m.busy {
 		switch msg.String() {
-		case "q", "esc", "ctrl+c":
+		case "esc":
+			// Esc aborts a deploy stuck waiting for its health check
+			// without leaving the TUI.
+			if m.cancelDeploy != nil {
+				m.cancelDeploy()
+				m.status = "Cancelling deploy..."
+				return m, nil
+			}
+			return m, tea.Quit
+		case "q", "ctrl+c":
+			if m.cancelDeploy != nil {
+				m.cancelDeploy()
+			}
 			return m, tea.Quit
 		}
 		return m, nil
@@ -99,6 +152,59 @@ func (m Model) updateForKeyMsg(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 		}
 	}
 
+	if m.revoking {
+		key := msg.String()
+		switch key {
+		case "esc":
+			m.revoking = false
+			m.revokeInput = ""
+			m.status = "Cancelled API key revocation."
+			return m, nil
+		case "enter":
+			id := strings.TrimSpace(m.revokeInput)
+			if id == "" {
+				m.status = "Please enter the id of the API key to revoke."
+				return m, nil
+			}
+			m.revoking = false
+			m.busy = true
+			m.status = fmt.Sprintf("Revoking API key %s...", id)
+			return m, runRevokeAPIKeyCmd(id)
+		case "backspace", "ctrl+h":
+			if len(m.revokeInput) > 0 {
+				runes := []rune(m.revokeInput)
+				m.revokeInput = string(runes[:len(runes)-1])
+			}
+			return m, nil
+		default:
+			r := []rune(key)
+			if len(r) == 1 && r[0] > ' ' {
+				m.revokeInput += key
+			}
+			return m, nil
+		}
+	}
+
+	if m.apiKey != "" {
+		switch msg.String() {
+		case "c":
+			if err := userinfra.CopyToClipboard(m.apiKey); err != nil {
+				m.status = fmt.Sprintf("Failed to copy API key: %v", err)
+			} else {
+				m.status = userinfra.FormatAPIKeyStatus(m.apiKey) + "\nCopied to clipboard."
+			}
+			return m, nil
+		case "r":
+			qr, err := userinfra.RenderQRCode(m.apiKey)
+			if err != nil {
+				m.status = fmt.Sprintf("Failed to render QR code: %v", err)
+				return m, nil
+			}
+			m.apiKeyQR = qr
+			return m, nil
+		}
+	}
+
 	switch msg.String() {
 	case "q", "esc", "ctrl+c":
 		return m, tea.Quit
@@ -108,11 +214,15 @@ func (m Model) updateForKeyMsg(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 		}
 		return m, nil
 	case "down", "j":
-		if m.cursor < 8 {
+		if m.cursor < 14 {
 			m.cursor++
 		}
 		return m, nil
 	case "enter", " ":
+		// The one-time key is only kept until the next action.
+		m.apiKey = ""
+		m.apiKeyQR = ""
+		m.recentLogs = nil
 		switch m.cursor {
 		case 0:
 			m.busy = true
@@ -124,30 +234,57 @@ func (m Model) updateForKeyMsg(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 			return m, runGetAPIKeyCmd()
 		case 2:
 			m.busy = true
-			m.status = "Deploying and starting the local Prism server and frpc..."
-			return m, runDeployCmd()
+			m.status = "Listing active API keys from Nexus..."
+			return m, runListAPIKeysCmd()
 		case 3:
+			m.revoking = true
+			m.revokeInput = ""
+			m.status = "Enter the id of the API key to revoke, then press Enter to confirm (Esc to cancel)."
+			return m, nil
+		case 4:
+			ctx, cancel := context.WithCancel(context.Background())
+			m.busy = true
+			m.cancelDeploy = cancel
+			m.status = "Deploying and starting the local Prism server and frpc... (Esc to cancel)"
+			return m, runDeployCmd(ctx)
+		case 5:
 			m.busy = true
 			m.status = "Stopping the local Prism server and frpc..."
 			return m, runStopAllServicesCmd()
-		case 4:
+		case 6:
 			m.busy = true
 			m.status = "Starting the local Prism server and frpc..."
 			return m, runStartAllServicesCmd()
-		case 5:
+		case 7:
 			m.busy = true
 			m.status = "Restarting the local Prism server..."
 			return m, runRestartServerCmd()
-		case 6:
+		case 8:
 			m.busy = true
 			m.status = "Restarting frpc..."
 			return m, runRestartFRPCCmd()
-		case 7:
+		case 9:
+			m.busy = true
+			m.status = "Checking the keepalive agent..."
+			return m, runKeepaliveStatusCmd()
+		case 10:
+			m.busy = true
+			m.status = "Deploying and starting the keepalive agent..."
+			return m, runStartKeepaliveCmd()
+		case 11:
+			m.busy = true
+			m.status = "Stopping the keepalive agent..."
+			return m, runStopKeepaliveCmd()
+		case 12:
+			m.busy = true
+			m.status = "Reading the recent server and frpc error logs..."
+			return m, runRecentErrorsCmd()
+		case 13:
 			m.renaming = true
 			m.renameInput = ""
 			m.status = "Enter a new friendly name, then press Enter to confirm (Esc to cancel)."
 			return m, nil
-		case 8:
+		case 14:
 			return m, tea.Quit
 		}
 	}
@@ -161,10 +298,12 @@ type stopDoneMsg struct {
 
 type prewarmDoneMsg struct {
 	status string
+	checks []userinfra.PermissionCheck
 }
 
 type getKeyDoneMsg struct {
 	status string
+	key    string
 }
 
 type deployDoneMsg struct {
@@ -174,3 +313,11 @@ type deployDoneMsg struct {
 type renameDoneMsg struct {
 	status string
 }
+
+type keysDoneMsg struct {
+	status string
+}
+
+type logsDoneMsg struct {
+	tails []userinfra.LogTail
+}
@@ -5,6 +5,9 @@ import (
 	"strings"
 
 	"github.com/charmbracelet/lipgloss"
+
+	userinfra "prism/internal/infra/user"
+	"prism/internal/ui/layout"
 )
 
 const footerHint = "↑/k up  •  ↓/j down  •  Enter select  •  q quit"
@@ -25,6 +28,8 @@ func (m Model) View() string {
 	activeDesc := lipgloss.NewStyle().Foreground(lipgloss.Color("#F472B6"))    // Soft Pink
 	inactiveDesc := subtleText
 	statusStyle := subtleText.MarginTop(1).PaddingLeft(2)
+	checkOKStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("#22c55e"))   // Bright Green
+	checkFailStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("#EB6F92")) // Rose (Low Sat Red)
 	footerStyle := subtleText.MarginTop(1).PaddingLeft(2)
 
 	items := []struct {
@@ -33,11 +38,17 @@ func (m Model) View() string {
 	}{
 		{"Prewarm permissions", "Prewarm local permissions (Messages/System Events/Automation)"},
 		{"Get API key", "Request a one-time API key from Nexus (displayed once)"},
+		{"List API keys", "List active API keys registered with Nexus"},
+		{"Revoke API key", "Revoke an API key by id (e.g. a leaked key)"},
 		{"Deploy / start services", "Deploy or start the local Prism server and frpc"},
 		{"Stop all services", "Stop the local Prism server and frpc"},
 		{"Start all services", "Start the local Prism server and frpc (after stop)"},
 		{"Restart server", "Restart the local Prism server"},
 		{"Restart frpc", "Restart the local frpc"},
+		{"Keepalive status", "Check whether the iMessage keepalive agent is running"},
+		{"Start keepalive", "Re-deploy and start the iMessage keepalive agent"},
+		{"Stop keepalive", "Stop the iMessage keepalive agent until the next login"},
+		{"View recent errors", "Show the end of the server and frpc error logs"},
 		{"Rename friendly name", "Update the friendly name and restart frpc"},
 		{"Quit", "Exit Prism (does not change current service state)"},
 	}
@@ -53,21 +64,64 @@ func (m Model) View() string {
 			border = accentBorder.Render("│ ")
 		}
 		title := inactiveTitle.Render(it.title)
-		desc := inactiveDesc.Render(it.desc)
+		descStyle := inactiveDesc
 		if selected {
 			title = activeTitle.Render(it.title)
-			desc = activeDesc.Render(it.desc)
+			descStyle = activeDesc
 		}
 		b.WriteString(border + title + "\n")
-		b.WriteString("  " + desc + "\n\n")
+		b.WriteString(m.wrapLines(it.desc, "  ", descStyle) + "\n")
 	}
 
 	if m.status != "" {
-		b.WriteString(statusStyle.Render(m.status) + "\n")
+		b.WriteString(statusStyle.Width(layout.Width(m.width)).Render(m.status) + "\n")
+	}
+	if m.apiKey != "" {
+		b.WriteString("  " + subtleText.Render("c copy to clipboard  •  r show QR code") + "\n")
+		if m.apiKeyQR != "" {
+			b.WriteString("\n")
+			for _, l := range strings.Split(strings.TrimRight(m.apiKeyQR, "\n"), "\n") {
+				b.WriteString("  " + l + "\n")
+			}
+		}
+	}
+	if len(m.permissionChecks) > 0 {
+		b.WriteString("\n  " + activeTitle.Render("Permissions") + "\n")
+		for _, c := range m.permissionChecks {
+			switch c.State {
+			case userinfra.PermissionGranted:
+				b.WriteString(checkOKStyle.Render("  [✓] "+c.Name) + "\n")
+			case userinfra.PermissionDenied:
+				b.WriteString(checkFailStyle.Render("  [!] "+c.Name) + "\n")
+			default:
+				b.WriteString(subtleText.Render("  [?] "+c.Name) + "\n")
+			}
+		}
+	}
+	for _, t := range m.recentLogs {
+		b.WriteString("\n  " + activeTitle.Render(t.Name) + " " + subtleText.Render(t.Path) + "\n")
+		switch {
+		case t.Err != "":
+			b.WriteString(m.wrapLines("Could not read the log: "+t.Err, "  ", checkFailStyle))
+		case t.Missing:
+			b.WriteString(subtleText.Render("  (no log yet)") + "\n")
+		case len(t.Lines) == 0:
+			b.WriteString(subtleText.Render("  (empty)") + "\n")
+		}
+		for _, l := range t.Lines {
+			if l.Error {
+				b.WriteString(m.wrapLines(l.Text, "  ", checkFailStyle))
+			} else {
+				b.WriteString(m.wrapLines(l.Text, "  ", subtleText))
+			}
+		}
 	}
-	if m.renaming {
+	if m.renaming || m.revoking {
 		prompt := subtleText.Render("  Current input: ")
 		val := m.renameInput
+		if m.revoking {
+			val = m.revokeInput
+		}
 		if val == "" {
 			val = "_"
 		}
@@ -80,3 +134,13 @@ func (m Model) View() string {
 
 	return b.String()
 }
+
+// wrapLines renders s word-wrapped to the terminal width, each line styled
+// with st and prefixed with indent.
+func (m Model) wrapLines(s, indent string, st lipgloss.Style) string {
+	var b strings.Builder
+	for _, l := range layout.Wrap(s, m.width, lipgloss.Width(indent)) {
+		b.WriteString(indent + st.Render(l) + "\n")
+	}
+	return b.String()
+}